@@ -1,10 +1,58 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/cli"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/frontend"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/tor"
+	"github.com/scherzma/Skunk/cmd/skunk/node"
 )
 
 // main is the entry point of the application.
+// Without arguments it starts the chat, otherwise it runs the given command.
 func main() {
-	frontend.RunFrontend()
+	if len(os.Args) > 1 {
+		if err := cli.Run(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := runChat(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runChat builds the node, connected to Tor as the SKUNK_TOR_* environment variables configure it,
+// and starts the frontend with its storage
+func runChat() error {
+	torConfig, err := tor.ConfigFromEnvironment(tor.DefaultConfig())
+	if err != nil {
+		return err
+	}
+
+	n, err := node.New(node.Config{Network: networkAdapter.NewAdapter(torConfig)})
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	model := frontend.InitialModel()
+	model.ContactStore = n.Storage
+	model.MessageStore = n.Storage
+	model.OwnAddress = n.Peer.Address
+	model.ChatActions = n.ChatToNetwork
+	if n.Peer.Address != "" {
+		model.InviteTokenIssuer = n.ChatToNetwork.CreateInviteToken
+	}
+	if err := model.SetEncryption(n.Storage); err != nil {
+		return err
+	}
+
+	return frontend.RunFrontend(model)
 }
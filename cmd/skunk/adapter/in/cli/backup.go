@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/frontend"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/scherzma/Skunk/cmd/skunk/backup"
+)
+
+// runBackup runs skunk backup export and skunk backup import
+func runBackup(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: skunk backup export|import [options] <file>")
+	}
+
+	switch args[0] {
+	case "export":
+		return runBackupExport(args[1:])
+	case "import":
+		return runBackupImport(args[1:])
+	default:
+		return fmt.Errorf("unknown backup command %q, use export or import", args[0])
+	}
+}
+
+// profileFlags adds the paths of the profile as flags
+func profileFlags(flags *flag.FlagSet) *backup.Profile {
+	profile := backup.DefaultProfile()
+	flags.StringVar(&profile.DatabasePath, "db", profile.DatabasePath, "database of the profile")
+	flags.StringVar(&profile.FileDir, "files", profile.FileDir, "directory of the received files")
+	flags.StringVar(&profile.TorDataDir, "tor", profile.TorDataDir, "Tor data directory with the identity key")
+	return &profile
+}
+
+func runBackupExport(args []string) error {
+	flags := flag.NewFlagSet("skunk backup export", flag.ContinueOnError)
+	profile := profileFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: skunk backup export [options] <file>\n\nWrites the identity key, the database with the contacts and the received files to an encrypted file.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the backup file is missing")
+	}
+
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	passphrase, err := readPassphrase("Choose a passphrase for the backup: ")
+	if err != nil {
+		return err
+	}
+	repeated, err := readPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return err
+	}
+	if len(passphrase) < frontend.MinPassphraseLength {
+		return fmt.Errorf("the passphrase has to be at least %d characters long", frontend.MinPassphraseLength)
+	}
+	if passphrase != repeated {
+		return errors.New("the passphrases don't match")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup.Export(*profile, passphrase, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	if !manifest.HasIdentity() {
+		fmt.Printf("No identity key in %s, the backup doesn't contain the onion address\n", profile.TorDataDir)
+	}
+	fmt.Printf("Backed up %d files to %s\n", len(manifest.Files), path)
+	return nil
+}
+
+func runBackupImport(args []string) error {
+	flags := flag.NewFlagSet("skunk backup import", flag.ContinueOnError)
+	profile := profileFlags(flags)
+	replace := flags.Bool("replace", false, "replace the profile with the backup instead of merging the backup into it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: skunk backup import [options] <file>\n\nMerges an encrypted backup into the profile, or replaces the profile with it.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the backup file is missing")
+	}
+
+	passphrase, err := readPassphrase("Passphrase of the backup: ")
+	if err != nil {
+		return err
+	}
+
+	options := backup.ImportOptions{Mode: backup.MERGE}
+	if *replace {
+		options.Mode = backup.REPLACE
+	}
+
+	manifest, err := importBackup(*profile, passphrase, flags.Arg(0), options)
+	// Encrypted databases can only be merged with their passphrase, it is asked for when it is needed
+	if errors.Is(err, store.ErrWrongPassphrase) && options.Mode == backup.MERGE && options.DatabasePassphrase == "" {
+		options.DatabasePassphrase, err = readPassphrase("Passphrase of the messages: ")
+		if err != nil {
+			return err
+		}
+		manifest, err = importBackup(*profile, passphrase, flags.Arg(0), options)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d files of the backup from %s\n", len(manifest.Files), time.Unix(manifest.Created, 0).Format("2006-01-02 15:04"))
+	return nil
+}
+
+// importBackup imports the backup file into the profile
+func importBackup(profile backup.Profile, passphrase, path string, options backup.ImportOptions) (backup.Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return backup.Manifest{}, err
+	}
+	defer file.Close()
+
+	return backup.Import(profile, passphrase, file, options)
+}
@@ -0,0 +1,63 @@
+// Package cli runs the commands of skunk that work without the terminal interface, like backups and transcripts.
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const usage = `Usage:
+  skunk                    start the chat
+  skunk backup export      write an encrypted backup of the profile
+  skunk backup import      restore an encrypted backup into the profile
+  skunk export <chat>      write the transcript of a chat as JSON, Markdown or HTML
+  skunk retention <chat>   show or change how much of a chat is kept
+
+Run a command with -h to see its options.`
+
+// Run runs the command given by the arguments, without the program name
+func Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "backup":
+		return runBackup(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "retention":
+		return runRetention(args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
+
+// stdin is shared by all prompts, a buffered reader would otherwise swallow the following lines of piped input
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase asks for a passphrase, it isn't shown when it is typed into a terminal
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if term.IsTerminal(os.Stdin.Fd()) {
+		passphrase, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return string(passphrase), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/scherzma/Skunk/cmd/skunk/node"
+)
+
+// runExport runs skunk export, it writes the transcript of a chat
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("skunk export", flag.ContinueOnError)
+	databasePath := flags.String("db", node.DefaultDatabasePath, "database of the profile")
+	formatName := flags.String("format", "md", "format of the transcript: json, md or html")
+	from := flags.String("from", "", "first day that is exported, YYYY-MM-DD")
+	to := flags.String("to", "", "last day that is exported, YYYY-MM-DD")
+	output := flags.String("o", "", "file the transcript is written to, the standard output if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: skunk export [options] <chat>\n\nWrites the transcript of the chat with the id or name.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the chat is missing")
+	}
+
+	options := c_service.TranscriptOptions{}
+	var err error
+	options.Format, err = c_service.ParseTranscriptFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	options.From, options.To, err = c_service.ParseTranscriptRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	adapter, err := openDatabase(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer adapter.Close()
+
+	chatID, err := findChat(adapter, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return c_service.ExportTranscript(adapter, chatID, options, w)
+}
+
+// openDatabase opens the existing database of the profile, an encrypted database is unlocked with a passphrase
+func openDatabase(path string) (*storageSQLiteAdapter.StorageSQLiteAdapter, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no database at %s", path)
+	}
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(path)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := adapter.IsEncrypted()
+	if err == nil && encrypted {
+		var passphrase string
+		passphrase, err = readPassphrase("Passphrase of the messages: ")
+		if err == nil {
+			err = adapter.Unlock(passphrase)
+		}
+	}
+	if err != nil {
+		adapter.Close()
+		return nil, err
+	}
+	return adapter, nil
+}
+
+// findChat returns the id of the chat with the id or the name, a name has to be unique
+func findChat(storage store.DisplayStoragePort, chat string) (string, error) {
+	chats, err := storage.GetChats()
+	if err != nil {
+		return "", err
+	}
+
+	var named []string
+	for _, c := range chats {
+		if c.ChatId == chat {
+			return c.ChatId, nil
+		}
+		if c.ChatName == chat {
+			named = append(named, c.ChatId)
+		}
+	}
+
+	switch len(named) {
+	case 0:
+		return "", fmt.Errorf("there is no chat %q", chat)
+	case 1:
+		return named[0], nil
+	default:
+		return "", fmt.Errorf("there are %d chats named %q, use the id of the chat", len(named), chat)
+	}
+}
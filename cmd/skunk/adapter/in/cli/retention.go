@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/node"
+)
+
+// runRetention runs skunk retention, it shows or changes the retention policy of a chat
+func runRetention(args []string) error {
+	flags := flag.NewFlagSet("skunk retention", flag.ContinueOnError)
+	databasePath := flags.String("db", node.DefaultDatabasePath, "database of the profile")
+	days := flags.Int("days", 0, "keep the messages of the last days, 0 keeps messages of any age")
+	messages := flags.Int("messages", 0, "keep the newest messages and files, 0 keeps any number")
+	size := flags.String("size", "0", "keep the newest files up to the size, like 50MB, 0 keeps files of any size")
+	off := flags.Bool("off", false, "remove the retention policy, the chat keeps everything from now on")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: skunk retention [options] <chat>\n\n"+
+			"Shows the retention policy of the chat with the id or name, or changes the limits given as options.\n"+
+			"The running node prunes the messages and files the policy doesn't keep, pruned messages are not synced again.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the chat is missing")
+	}
+
+	adapter, err := openDatabase(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer adapter.Close()
+
+	chatID, err := findChat(adapter, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	policy, err := adapter.GetRetentionPolicy(chatID)
+	if err != nil {
+		return err
+	}
+
+	// Only the limits that are given change, the others are kept
+	changed := false
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "days":
+			policy.MaxAge = int64(time.Duration(*days) * 24 * time.Hour)
+		case "messages":
+			policy.MaxMessages = *messages
+		case "size":
+			policy.MaxAttachmentSize, err = p_model.ParseSize(*size)
+		case "off":
+			if *off {
+				policy.MaxAge, policy.MaxMessages, policy.MaxAttachmentSize = 0, 0, 0
+			}
+		default:
+			return
+		}
+		changed = true
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		err = p_model.ValidateRetentionPolicy(policy)
+		if err != nil {
+			return err
+		}
+		err = adapter.SetRetentionPolicy(chatID, policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("The chat %s %s.\n", flags.Arg(0), p_model.DescribeRetentionPolicy(policy))
+
+	pruned, err := adapter.GetPrunedRange(chatID)
+	if err != nil {
+		return err
+	}
+	if pruned.Before > 0 {
+		fmt.Printf("Messages before %s were pruned.\n", time.Unix(0, pruned.Before).Format("2006-01-02 15:04"))
+	}
+	if pruned.FilesBefore > pruned.Before {
+		fmt.Printf("Files before %s were pruned.\n", time.Unix(0, pruned.FilesBefore).Format("2006-01-02 15:04"))
+	}
+	return nil
+}
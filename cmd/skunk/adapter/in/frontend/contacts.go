@@ -0,0 +1,322 @@
+package frontend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// Inputs of the contact form.
+const (
+	contactNickname = iota
+	contactAddress
+	contactNotes
+)
+
+// newContactForm returns the inputs of the contact form.
+func newContactForm() []textinput.Model {
+	nickname := textinput.New()
+	nickname.Placeholder = "Enter a nickname..."
+	nickname.CharLimit = p_model.MaxContactNicknameLength
+	nickname.Width = 40
+
+	address := textinput.New()
+	address.Placeholder = "Enter OnionID..."
+	address.CharLimit = 62
+	address.Width = 100
+
+	notes := textinput.New()
+	notes.Placeholder = "Notes..."
+	notes.CharLimit = p_model.MaxContactNotesLength
+	notes.Width = 100
+
+	return []textinput.Model{nickname, address, notes}
+}
+
+// LoadContacts replaces the contacts with the ones in the ContactStore.
+func (m *Model) LoadContacts() error {
+	if m.ContactStore == nil {
+		return nil
+	}
+
+	contacts, err := m.ContactStore.GetContacts()
+	if err != nil {
+		return err
+	}
+
+	m.Contacts = map[string]store.Contact{}
+	for _, contact := range contacts {
+		m.Contacts[contact.Address] = contact
+	}
+	return nil
+}
+
+// sortedContacts returns the contacts sorted by nickname.
+func (m Model) sortedContacts() []store.Contact {
+	contacts := make([]store.Contact, 0, len(m.Contacts))
+	for _, contact := range m.Contacts {
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		a, b := strings.ToLower(contacts[i].Nickname), strings.ToLower(contacts[j].Nickname)
+		if a == b {
+			return contacts[i].Address < contacts[j].Address
+		}
+		return a < b
+	})
+	return contacts
+}
+
+// contactSuggestions returns the contacts whose nickname starts with the prefix, blocked contacts are never suggested.
+func (m Model) contactSuggestions(prefix string) []store.Contact {
+	prefix = strings.ToLower(SanitizeInput(prefix))
+	suggestions := []store.Contact{}
+	for _, contact := range m.sortedContacts() {
+		if !contact.Blocked && strings.HasPrefix(strings.ToLower(contact.Nickname), prefix) {
+			suggestions = append(suggestions, contact)
+		}
+	}
+	return suggestions
+}
+
+// findContact returns the contact with the address or nickname, or the only contact whose nickname starts with name.
+func (m Model) findContact(name string) (store.Contact, bool) {
+	name = SanitizeInput(name)
+	if name == "" {
+		return store.Contact{}, false
+	}
+
+	if contact, exists := m.Contacts[name]; exists && !contact.Blocked {
+		return contact, true
+	}
+
+	suggestions := m.contactSuggestions(name)
+	for _, contact := range suggestions {
+		if strings.EqualFold(contact.Nickname, name) {
+			return contact, true
+		}
+	}
+	if len(suggestions) == 1 {
+		return suggestions[0], true
+	}
+	return store.Contact{}, false
+}
+
+// resolveContact returns the address of the contact that matches name, or name itself if no contact matches.
+func (m Model) resolveContact(name string) string {
+	if contact, found := m.findContact(name); found {
+		return contact.Address
+	}
+	return name
+}
+
+// completeContact completes the OnionID of /invite and /dm in the chat input from the contacts.
+func (m *Model) completeContact() {
+	for _, command := range []string{"/invite ", "/dm "} {
+		argument, found := strings.CutPrefix(m.input.Value(), command)
+		if !found || strings.Contains(argument, " ") {
+			continue
+		}
+		if contact, found := m.findContact(argument); found {
+			m.input.SetValue(command + contact.Address + " ")
+			m.input.CursorEnd()
+		}
+	}
+}
+
+// contactSuggestionLine lists the contacts that match the OnionID being typed after /invite or /dm.
+func (m Model) contactSuggestionLine() string {
+	for _, command := range []string{"/invite ", "/dm "} {
+		argument, found := strings.CutPrefix(m.input.Value(), command)
+		if !found || strings.Contains(argument, " ") {
+			continue
+		}
+		return suggestionLine(m.contactSuggestions(argument), "Tab to complete")
+	}
+	return ""
+}
+
+// suggestionLine lists the nicknames of the contacts with a hint how to use them.
+func suggestionLine(contacts []store.Contact, hint string) string {
+	if len(contacts) == 0 {
+		return ""
+	}
+
+	nicknames := []string{}
+	for _, contact := range contacts {
+		nicknames = append(nicknames, contact.Nickname)
+	}
+	return fmt.Sprintf("Contacts: %s (%s)\n", strings.Join(nicknames, ", "), hint)
+}
+
+// contactName returns the nickname of the contact with the address, or the address if it is not a contact.
+func (m Model) contactName(address string) string {
+	if contact, exists := m.Contacts[address]; exists {
+		return fmt.Sprintf("%s (%s)", contact.Nickname, address)
+	}
+	return address
+}
+
+// HandleContactForm opens the contact form for a new contact, or for the selected contact if edit is true.
+func (m *Model) HandleContactForm(edit bool) (tea.Model, tea.Cmd) {
+	m.contactForm = newContactForm()
+	m.editedContact = ""
+
+	contacts := m.sortedContacts()
+	if edit {
+		if m.contactCursor >= len(contacts) {
+			return m, nil
+		}
+		contact := contacts[m.contactCursor]
+		m.editedContact = contact.Address
+		m.contactForm[contactNickname].SetValue(contact.Nickname)
+		m.contactForm[contactAddress].SetValue(contact.Address)
+		m.contactForm[contactNotes].SetValue(contact.Notes)
+	}
+
+	m.contactField = contactNickname
+	m.contactForm[contactNickname].Focus()
+	m.currentScreen = screenContactForm
+	return m, textinput.Blink
+}
+
+// HandleContactSave adds or changes the contact entered in the contact form.
+func (m *Model) HandleContactSave() (tea.Model, tea.Cmd) {
+	contact, err := p_model.NewContact(
+		m.contactForm[contactNickname].Value(),
+		SanitizeInput(m.contactForm[contactAddress].Value()),
+		SanitizeInput(m.contactForm[contactNotes].Value()),
+	)
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+
+	if edited, exists := m.Contacts[m.editedContact]; exists {
+		contact.Blocked = edited.Blocked
+		// A different address has a different key, it has to be verified again
+		contact.Verified = edited.Verified && edited.Address == contact.Address
+	}
+
+	if m.editedContact != "" && m.editedContact != contact.Address {
+		if cmd := m.removeContact(m.editedContact); cmd != nil {
+			return m, cmd
+		}
+	}
+	if cmd := m.saveContact(contact); cmd != nil {
+		return m, cmd
+	}
+
+	m.editedContact = ""
+	m.currentScreen = screenContacts
+	m.TempMessage = fmt.Sprintf("Contact %s saved!", contact.Nickname)
+	m.TempMessageExpire = time.Now().Add(10 * time.Second)
+	return m, m.ClearTempMessage()
+}
+
+// HandleContactToggle marks the selected contact as verified or blocked, or undoes it.
+func (m *Model) HandleContactToggle(blocked bool) (tea.Model, tea.Cmd) {
+	contacts := m.sortedContacts()
+	if m.contactCursor >= len(contacts) {
+		return m, nil
+	}
+
+	contact := contacts[m.contactCursor]
+	if blocked {
+		contact.Blocked = !contact.Blocked
+	} else {
+		contact.Verified = !contact.Verified
+	}
+	return m, m.saveContact(contact)
+}
+
+// HandleContactRemove removes the selected contact.
+func (m *Model) HandleContactRemove() (tea.Model, tea.Cmd) {
+	contacts := m.sortedContacts()
+	if m.contactCursor >= len(contacts) {
+		return m, nil
+	}
+
+	cmd := m.removeContact(contacts[m.contactCursor].Address)
+	if m.contactCursor > 0 && m.contactCursor >= len(m.Contacts) {
+		m.contactCursor--
+	}
+	return m, cmd
+}
+
+// saveContact keeps the contact and stores it in the ContactStore, the command prints the error if it couldn't be stored.
+func (m *Model) saveContact(contact store.Contact) tea.Cmd {
+	if m.ContactStore != nil {
+		if err := m.ContactStore.SaveContact(contact); err != nil {
+			return tea.Printf("Error: %v", err)
+		}
+	}
+	m.Contacts[contact.Address] = contact
+	return nil
+}
+
+// removeContact removes the contact and deletes it from the ContactStore, the command prints the error if it couldn't be deleted.
+func (m *Model) removeContact(address string) tea.Cmd {
+	if m.ContactStore != nil {
+		if err := m.ContactStore.RemoveContact(address); err != nil {
+			return tea.Printf("Error: %v", err)
+		}
+	}
+	delete(m.Contacts, address)
+	return nil
+}
+
+// ContactsView returns the view for the contacts screen.
+func (m Model) ContactsView() string {
+	s := "Contacts:\n\n"
+
+	contacts := m.sortedContacts()
+	for i, contact := range contacts {
+		cursor := " "
+		if i == m.contactCursor {
+			cursor = ">"
+		}
+		s += fmt.Sprintf("%s %s - %s", cursor, contact.Nickname, contact.Address)
+		if contact.Verified {
+			s += " [verified]"
+		}
+		if contact.Blocked {
+			s += " [blocked]"
+		}
+		s += "\n"
+	}
+
+	if len(contacts) == 0 {
+		s += "No contacts yet\n"
+	} else if m.contactCursor < len(contacts) {
+		// Compare the public key with the peer to verify the contact
+		selected := contacts[m.contactCursor]
+		s += fmt.Sprintf("\nPublic key: %s\n", selected.PublicKey)
+		if selected.Notes != "" {
+			s += fmt.Sprintf("Notes: %s\n", selected.Notes)
+		}
+	}
+
+	s += "\nPress n to add a contact, Enter to edit, v to mark as verified, b to block or unblock, Backspace to remove, ESC to return."
+	return s
+}
+
+// ContactFormView returns the view for adding or editing a contact.
+func (m Model) ContactFormView() string {
+	s := "Add a contact:\n\n"
+	if m.editedContact != "" {
+		s = fmt.Sprintf("Edit %s:\n\n", m.Contacts[m.editedContact].Nickname)
+	}
+
+	s += fmt.Sprintf("Nickname: %s\n", m.contactForm[contactNickname].View())
+	s += fmt.Sprintf("OnionID: %s\n", m.contactForm[contactAddress].View())
+	s += fmt.Sprintf("Notes: %s\n", m.contactForm[contactNotes].View())
+	s += "\nPress Tab to switch input, Enter to save, ESC to return."
+	return s
+}
@@ -3,14 +3,17 @@ package frontend
 import (
 	"errors"
 	"fmt"
-	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
 )
 
 // OperationType represents different types of operations that can be performed.
@@ -27,6 +30,33 @@ const (
 	SET_USERNAME
 	TEST_MESSAGE
 	LOAD_MESSAGES
+	REMOVE_MEMBER
+	BAN_MEMBER
+	UPDATE_CHAT_METADATA
+	EDIT_MESSAGE
+	DELETE_MESSAGE
+	REACT
+	UNREACT
+	UPDATE_CHAT_SETTINGS
+	TYPING_STARTED
+	TYPING_STOPPED
+	PRESENCE
+)
+
+// Presence states of PRESENCE messages. The state is sent as Content, the time the user was last seen as Timestamp.
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// TypingTimeout is how long a user is shown as typing without a new TYPING_STARTED message.
+const TypingTimeout = 6 * time.Second
+
+// Chat metadata fields of UPDATE_CHAT_METADATA messages. The field is sent as ReceiverID, the new value as Content.
+const (
+	MetadataName        = "name"
+	MetadataTopic       = "topic"
+	MetadataDescription = "description"
 )
 
 // FrontendMessage represents a message in the frontend.
@@ -40,6 +70,9 @@ type FrontendMessage struct {
 	ReceiverAddress string
 	ChatID          string
 	Operation       OperationType
+	Edited          bool   // Set on SEND_MESSAGE messages that were edited
+	Deleted         bool   // Set on SEND_MESSAGE messages that were deleted
+	ReplyTo         string // Id of the message a SEND_MESSAGE message replies to
 }
 
 // Define various screens in the application.
@@ -52,8 +85,36 @@ const (
 	screenUsername
 	screenTestUser
 	screenCreateChat
+	screenInviteLink
+	screenJoinToken
+	screenContacts
+	screenContactForm
+	screenPassphrase
+	screenSearch
 )
 
+// TokenIssuer creates a signed invite link for a chat.
+type TokenIssuer func(chatID string) (string, error)
+
+// ChatActions sends what the user does with the chats to the other peers.
+type ChatActions interface {
+	AcceptInvitation(invitationId string) error
+	DeclineInvitation(invitationId string) error
+	JoinChatWithToken(uri string) error
+}
+
+// messageReactions maps a message Id to the emoji used to react to it and the users that reacted with it.
+type messageReactions map[string]map[string][]string
+
+// typingUsers maps a ChatID to the users that are typing in the chat and when they were last seen typing.
+type typingUsers map[string]map[string]time.Time
+
+// userPresence maps a user to the last PRESENCE message received from them.
+type userPresence map[string]FrontendMessage
+
+// TypingTimeoutMsg is sent when a typing indicator may have expired.
+type TypingTimeoutMsg struct{}
+
 // TempMsgTimeoutMsg represents a timeout message for temporary messages.
 type TempMsgTimeoutMsg struct{}
 
@@ -62,11 +123,19 @@ type Model struct {
 	currentScreen     screen                       // Current screen
 	Chats             map[string][]FrontendMessage // Map from ChatID to messages
 	chatNames         map[string]string            // Map from ChatID to chat names
+	chatTopics        map[string]string            // Map from ChatID to chat topics
+	chatDescriptions  map[string]string            // Map from ChatID to chat descriptions
+	messageExpiry     map[string]time.Duration     // Map from ChatID to the time after which messages disappear
 	invites           []FrontendMessage            // List of invites
 	cursor            int                          // Cursor for selecting Chats or invites
 	focus             string                       // Can be 'Chats' or 'invites'
 	CurrentChat       string                       // Currently selected chat
 	inChatDetail      bool                         // Whether we are in chat details
+	threadRoot        string                       // Id of the message whose thread is shown, empty for the whole chat
+	reactions         messageReactions             // Reactions to the messages of all chats
+	typing            typingUsers                  // Users that are typing in each chat
+	presence          userPresence                 // Whether users are online and when they were last seen
+	showMembers       bool                         // Whether the member list is shown in the chat
 	input             textinput.Model              // User input for messages
 	usernameInput     textinput.Model              // User input for setting the username
 	Usernames         map[string]string            // Map from ChatID to username
@@ -74,6 +143,30 @@ type Model struct {
 	createChatInput   textinput.Model              // User input for creating chat (invitees)
 	chatNameInput     textinput.Model              // User input for creating chat (chat name)
 	chatInvitees      []string                     // List of invitees for the new chat
+	joinTokenInput    textinput.Model              // User input for joining a chat with an invite link
+	inviteLink        string                       // Invite link of the current chat
+	Contacts          map[string]store.Contact     // Map from onion address to contact
+	ContactStore      store.ContactStoragePort     // Persists the contacts, nil if they are only kept in memory
+	contactCursor     int                          // Cursor for selecting contacts
+	contactForm       []textinput.Model            // User inputs for adding or editing a contact
+	contactField      int                          // Focused input of the contact form
+	editedContact     string                       // Address of the edited contact, empty when adding one
+	Encryption        store.EncryptionStoragePort  // Encrypts the database, nil if the frontend doesn't ask for a passphrase
+	encrypted         bool                         // Whether the database has a passphrase
+	passphraseForm    []textinput.Model            // User inputs for unlocking the database or changing its passphrase
+	passphraseField   int                          // Focused input of the passphrase form
+	MessageStore      store.DisplayStoragePort     // Searches the stored messages, nil if they can't be searched
+	searchQuery       string                       // Words of the last search
+	searchResults     []store.SearchResult         // Messages found by the last search, newest first
+	searchCursor      int                          // Cursor for selecting a search result
+	highlighted       string                       // Id of the message a search result jumped to
+	historyLoaded     map[string]bool              // Chats whose newest stored messages were loaded
+	historyOldest     map[string]string            // Map from ChatID to the oldest loaded stored message, empty if all are loaded
+	pendingChanges    map[string]FrontendMessage   // Edits and deletions of messages that are not loaded yet, by message Id
+	ExportDir         string                       // Directory /export writes the transcripts to, the working directory if empty
+	InviteTokenIssuer TokenIssuer                  // Creates signed invite links, nil while offline
+	ChatActions       ChatActions                  // Sends the invitation responses and joins to the other peers, nil if they only change the view
+	OwnAddress        string                       // Onion address of this peer, empty while offline
 	TempMessage       string                       // Temporary message
 	TempMessageExpire time.Time                    // Expiry time for the temporary message
 }
@@ -106,19 +199,36 @@ func InitialModel() Model {
 	cn.CharLimit = 30
 	cn.Width = 40
 
+	jt := textinput.New()
+	jt.Placeholder = "Paste a skunk:// invite link..."
+	jt.CharLimit = 1024
+	jt.Width = 100
+
 	return Model{
-		currentScreen:   screenIntro,
-		Chats:           map[string][]FrontendMessage{},
-		chatNames:       map[string]string{},
-		invites:         []FrontendMessage{},
-		focus:           "Chats",
-		input:           ti,
-		usernameInput:   ui,
-		Usernames:       make(map[string]string),
-		testUserInput:   tu,
-		createChatInput: ci,
-		chatNameInput:   cn,
-		chatInvitees:    []string{},
+		currentScreen:    screenIntro,
+		Chats:            map[string][]FrontendMessage{},
+		chatNames:        map[string]string{},
+		chatTopics:       map[string]string{},
+		chatDescriptions: map[string]string{},
+		messageExpiry:    map[string]time.Duration{},
+		reactions:        messageReactions{},
+		typing:           typingUsers{},
+		presence:         userPresence{},
+		invites:          []FrontendMessage{},
+		focus:            "Chats",
+		input:            ti,
+		usernameInput:    ui,
+		Usernames:        make(map[string]string),
+		testUserInput:    tu,
+		createChatInput:  ci,
+		chatNameInput:    cn,
+		chatInvitees:     []string{},
+		joinTokenInput:   jt,
+		Contacts:         map[string]store.Contact{},
+		contactForm:      newContactForm(),
+		historyLoaded:    map[string]bool{},
+		historyOldest:    map[string]string{},
+		pendingChanges:   map[string]FrontendMessage{},
 	}
 }
 
@@ -187,6 +297,8 @@ func (m *Model) HandleCommand(command, argument string) (tea.Model, tea.Cmd) {
 		delete(m.Chats, m.CurrentChat)
 		delete(m.chatNames, m.CurrentChat)
 		delete(m.Usernames, m.CurrentChat)
+		delete(m.historyLoaded, m.CurrentChat)
+		delete(m.historyOldest, m.CurrentChat)
 		m.CurrentChat = ""
 		return m, nil
 	case "/invite":
@@ -194,10 +306,147 @@ func (m *Model) HandleCommand(command, argument string) (tea.Model, tea.Cmd) {
 		if err != nil {
 			return m, tea.Printf("Error: %v", err)
 		}
+		argument = m.resolveContact(argument)
 		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], argument, argument, "", "", INVITE_TO_CHAT)
 		m.invites = append(m.invites, msg)
 		m.Chats[m.CurrentChat] = append(m.Chats[m.CurrentChat], msg)
 		return m, tea.Printf("Invited %s to the chat", argument)
+	case "/invitelink":
+		if m.InviteTokenIssuer == nil {
+			return m, tea.Printf("Error: invite links can only be created while online")
+		}
+		link, err := m.InviteTokenIssuer(m.CurrentChat)
+		if err != nil {
+			return m, tea.Printf("Error: %v", err)
+		}
+		m.inviteLink = link
+		m.currentScreen = screenInviteLink
+		m.input.SetValue("")
+		return m, nil
+	case "/kick", "/ban":
+		argument, err = ValidateInput(argument, 56)
+		if err != nil {
+			return m, tea.Printf("Error: %v", err)
+		}
+		if command == "/ban" {
+			msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], argument, "", "", "", BAN_MEMBER)
+		} else {
+			msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], argument, "", "", "", REMOVE_MEMBER)
+		}
+	case "/rename":
+		argument, err = ValidateInput(argument, 30)
+		if err != nil || argument == "" {
+			return m, tea.Printf("Error: chat name has to be between 1 and 30 characters long")
+		}
+		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], MetadataName, argument, "", "", UPDATE_CHAT_METADATA)
+		m.applyChatMetadata(msg)
+	case "/topic":
+		argument, err = ValidateInput(argument, 256)
+		if err != nil {
+			return m, tea.Printf("Error: %v", err)
+		}
+		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], MetadataTopic, argument, "", "", UPDATE_CHAT_METADATA)
+		m.applyChatMetadata(msg)
+	case "/description":
+		argument, err = ValidateInput(argument, 1024)
+		if err != nil {
+			return m, tea.Printf("Error: %v", err)
+		}
+		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], MetadataDescription, argument, "", "", UPDATE_CHAT_METADATA)
+		m.applyChatMetadata(msg)
+	case "/members":
+		m.showMembers = !m.showMembers
+		m.input.SetValue("")
+		return m, nil
+	case "/disappear":
+		expiry := time.Duration(0)
+		if argument != "off" {
+			expiry, err = time.ParseDuration(argument)
+			if err != nil || expiry < p_model.MinMessageExpiry || expiry > p_model.MaxMessageExpiry {
+				return m, tea.Printf("Error: /disappear needs a duration between %v and %v or off", p_model.MinMessageExpiry, p_model.MaxMessageExpiry)
+			}
+		}
+		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], "", expiry.String(), "", "", UPDATE_CHAT_SETTINGS)
+		m.applyChatSettings(msg)
+	case "/edit", "/delete":
+		index := m.lastOwnMessage()
+		if index < 0 {
+			return m, tea.Printf("Error: there is no message to %s", strings.TrimPrefix(command, "/"))
+		}
+		target := m.Chats[m.CurrentChat][index].Id
+		if command == "/delete" {
+			m.applyMessageChange(CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], target, "", "", "", DELETE_MESSAGE))
+			m.input.SetValue("")
+			return m, nil
+		}
+		argument, err = ValidateInput(argument, 256)
+		if err != nil || argument == "" {
+			return m, tea.Printf("Error: the edited message must not be empty")
+		}
+		m.applyMessageChange(CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], target, argument, "", "", EDIT_MESSAGE))
+		m.input.SetValue("")
+		return m, nil
+	case "/reply", "/thread":
+		parent, rest, found := m.messageByNumber(argument)
+		if !found {
+			return m, tea.Printf("Error: %s needs the number of a message", command)
+		}
+		if command == "/thread" {
+			m.threadRoot = parent.Id
+			m.input.SetValue("")
+			return m, nil
+		}
+		argument, err = ValidateInput(rest, 256)
+		if err != nil || argument == "" {
+			return m, tea.Printf("Error: the reply must not be empty")
+		}
+		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], "", argument, "", "", SEND_MESSAGE)
+		msg.ReplyTo = parent.Id
+	case "/react":
+		target, emoji, found := m.messageByNumber(argument)
+		if !found {
+			return m, tea.Printf("Error: /react needs the number of a message")
+		}
+		emoji = SanitizeInput(emoji)
+		if emoji == "" || len(emoji) > 32 {
+			return m, tea.Printf("Error: /react needs an emoji")
+		}
+
+		// Reacting with the same emoji again removes the reaction
+		op := REACT
+		for _, user := range m.reactions[target.Id][emoji] {
+			if user == m.Usernames[m.CurrentChat] {
+				op = UNREACT
+			}
+		}
+		m.applyReaction(CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], target.Id, emoji, "", "", op))
+		m.input.SetValue("")
+		return m, nil
+	case "/dm":
+		if m.OwnAddress == "" {
+			return m, tea.Printf("Error: direct messages can only be sent while online")
+		}
+		address, text, _ := strings.Cut(argument, " ")
+		address, err = ValidateInput(m.resolveContact(address), 62)
+		if err != nil || address == "" {
+			return m, tea.Printf("Error: /dm needs the OnionAddress of the user")
+		}
+		text, err = ValidateInput(text, 256)
+		if err != nil || text == "" {
+			return m, tea.Printf("Error: the message must not be empty")
+		}
+
+		// Both peers derive the same chat from their addresses, the first message creates it
+		chatID := p_model.DirectChatID(m.OwnAddress, address)
+		if _, exists := m.chatNames[chatID]; !exists {
+			m.chatNames[chatID] = address
+		}
+		if _, exists := m.Usernames[chatID]; !exists {
+			m.Usernames[chatID] = m.Usernames[m.CurrentChat]
+		}
+		m.CurrentChat = chatID
+		m.threadRoot = ""
+		msg = CreateMessage(chatID, m.Usernames[chatID], address, text, m.OwnAddress, address, SEND_MESSAGE)
 	case "/sendfile":
 		argument, err = ValidateInput(argument, 256)
 		if err != nil {
@@ -216,8 +465,13 @@ func (m *Model) HandleCommand(command, argument string) (tea.Model, tea.Cmd) {
 		} else {
 			msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], "", "Invalid username: "+argument, "", "", SEND_MESSAGE)
 		}
+	case "/search", "/searchall":
+		return m.HandleSearch(command == "/searchall", argument)
 	case "/loadmessages":
-		msg = CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], "", "Loading more messages...", "", "", LOAD_MESSAGES)
+		m.input.SetValue("")
+		return m.HandleLoadMessages()
+	case "/export":
+		return m.HandleExport(argument)
 	default:
 		argument, err = ValidateInput(argument, 256)
 		if err != nil {
@@ -231,6 +485,280 @@ func (m *Model) HandleCommand(command, argument string) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// ReceiveMessage adds a message from the network to its chat. Changes of the chat metadata are shown immediately,
+// edits and deletions change the message they refer to.
+func (m *Model) ReceiveMessage(msg FrontendMessage) {
+	switch msg.Operation {
+	case EDIT_MESSAGE, DELETE_MESSAGE:
+		m.applyMessageChange(msg)
+		return
+	case REACT, UNREACT:
+		m.applyReaction(msg)
+		return
+	case UPDATE_CHAT_METADATA:
+		m.applyChatMetadata(msg)
+	case UPDATE_CHAT_SETTINGS:
+		m.applyChatSettings(msg)
+	case TYPING_STARTED, TYPING_STOPPED:
+		m.applyTyping(msg, time.Now())
+		return
+	case PRESENCE:
+		m.presence[msg.SenderID] = msg
+		return
+	case SEND_MESSAGE:
+		delete(m.typing[msg.ChatID], msg.SenderID)
+	}
+	// The first message of a direct chat creates it, it is named after the other user
+	if _, exists := m.chatNames[msg.ChatID]; !exists && p_model.IsDirectChatID(msg.ChatID) {
+		m.chatNames[msg.ChatID] = msg.SenderAddress
+		if msg.SenderAddress == "" {
+			m.chatNames[msg.ChatID] = msg.SenderID
+		}
+	}
+	m.Chats[msg.ChatID] = append(m.Chats[msg.ChatID], msg)
+	m.removeExpiredMessages(msg.ChatID, time.Now())
+}
+
+// applyMessageChange edits or deletes the message with the id in ReceiverID.
+// Changes of messages that are not loaded yet are applied when the messages are loaded, a deletion wins over edits.
+func (m *Model) applyMessageChange(msg FrontendMessage) {
+	for i, chatMessage := range m.Chats[msg.ChatID] {
+		if chatMessage.Id != msg.ReceiverID || chatMessage.Deleted {
+			continue
+		}
+		if msg.Operation == DELETE_MESSAGE {
+			m.Chats[msg.ChatID][i].Content = ""
+			m.Chats[msg.ChatID][i].Deleted = true
+		} else {
+			m.Chats[msg.ChatID][i].Content = msg.Content
+			m.Chats[msg.ChatID][i].Edited = true
+		}
+		return
+	}
+
+	if pending, exists := m.pendingChanges[msg.ReceiverID]; !exists || pending.Operation != DELETE_MESSAGE {
+		m.pendingChanges[msg.ReceiverID] = msg
+	}
+}
+
+// applyReaction adds or removes the reaction of the sender to the message with the id in ReceiverID.
+func (m *Model) applyReaction(msg FrontendMessage) {
+	if m.reactions[msg.ReceiverID] == nil {
+		m.reactions[msg.ReceiverID] = map[string][]string{}
+	}
+
+	users := []string{}
+	for _, user := range m.reactions[msg.ReceiverID][msg.Content] {
+		if user != msg.SenderID {
+			users = append(users, user)
+		}
+	}
+	if msg.Operation == REACT {
+		users = append(users, msg.SenderID)
+	}
+
+	if len(users) == 0 {
+		delete(m.reactions[msg.ReceiverID], msg.Content)
+	} else {
+		m.reactions[msg.ReceiverID][msg.Content] = users
+	}
+}
+
+// reactionLine renders the number of reactions with each emoji, most used emoji first.
+func (m Model) reactionLine(messageID string) string {
+	emojis := []string{}
+	for emoji := range m.reactions[messageID] {
+		emojis = append(emojis, emoji)
+	}
+	if len(emojis) == 0 {
+		return ""
+	}
+
+	sort.Slice(emojis, func(i, j int) bool {
+		countI, countJ := len(m.reactions[messageID][emojis[i]]), len(m.reactions[messageID][emojis[j]])
+		if countI != countJ {
+			return countI > countJ
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	counts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		counts[i] = fmt.Sprintf("%s %d", emoji, len(m.reactions[messageID][emoji]))
+	}
+	return "      " + strings.Join(counts, "  ") + "\n"
+}
+
+// messageByNumber parses "<Number> <Text>" and returns the SEND_MESSAGE message with the number shown in the chat
+// and the text after the number.
+func (m *Model) messageByNumber(argument string) (FrontendMessage, string, bool) {
+	parts := strings.SplitN(argument, " ", 2)
+	number, err := strconv.Atoi(parts[0])
+	if err != nil || number < 1 || number > len(m.Chats[m.CurrentChat]) || m.Chats[m.CurrentChat][number-1].Operation != SEND_MESSAGE {
+		return FrontendMessage{}, "", false
+	}
+
+	rest := ""
+	if len(parts) > 1 {
+		rest = parts[1]
+	}
+	return m.Chats[m.CurrentChat][number-1], rest, true
+}
+
+// lastOwnMessage returns the index of the last message the user sent to the current chat, -1 if there is none.
+func (m *Model) lastOwnMessage() int {
+	messages := m.Chats[m.CurrentChat]
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Operation == SEND_MESSAGE && !messages[i].Deleted && messages[i].SenderID == m.Usernames[m.CurrentChat] {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyChatMetadata updates the name, topic or description of the chat.
+func (m *Model) applyChatMetadata(msg FrontendMessage) {
+	switch msg.ReceiverID {
+	case MetadataName:
+		m.chatNames[msg.ChatID] = msg.Content
+	case MetadataTopic:
+		m.chatTopics[msg.ChatID] = msg.Content
+	case MetadataDescription:
+		m.chatDescriptions[msg.ChatID] = msg.Content
+	}
+}
+
+// applyChatSettings sets the time after which messages of the chat disappear, the duration is sent as Content.
+func (m *Model) applyChatSettings(msg FrontendMessage) {
+	expiry, err := time.ParseDuration(msg.Content)
+	if err != nil {
+		return
+	}
+
+	if expiry == 0 {
+		delete(m.messageExpiry, msg.ChatID)
+	} else {
+		m.messageExpiry[msg.ChatID] = expiry
+	}
+	m.removeExpiredMessages(msg.ChatID, time.Now())
+}
+
+// removeExpiredMessages removes messages and files that are older than the message expiry of the chat.
+func (m *Model) removeExpiredMessages(chatID string, now time.Time) {
+	expiry, exists := m.messageExpiry[chatID]
+	if !exists {
+		return
+	}
+
+	messages := []FrontendMessage{}
+	for _, msg := range m.Chats[chatID] {
+		if (msg.Operation == SEND_MESSAGE || msg.Operation == SEND_FILE) && time.Unix(msg.Timestamp, 0).Add(expiry).Before(now) {
+			delete(m.reactions, msg.Id)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	m.Chats[chatID] = messages
+}
+
+// applyTyping shows or hides the typing indicator of the sender. Typing messages are not added to the chat.
+func (m *Model) applyTyping(msg FrontendMessage, now time.Time) {
+	if msg.Operation == TYPING_STOPPED {
+		delete(m.typing[msg.ChatID], msg.SenderID)
+		return
+	}
+
+	if m.typing[msg.ChatID] == nil {
+		m.typing[msg.ChatID] = map[string]time.Time{}
+	}
+	m.typing[msg.ChatID][msg.SenderID] = now
+}
+
+// typingLine renders who is typing in the chat, indicators older than TypingTimeout have expired.
+func (m Model) typingLine(chatID string, now time.Time) string {
+	users := []string{}
+	for user, since := range m.typing[chatID] {
+		if now.Sub(since) < TypingTimeout {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+
+	switch len(users) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…\n", users[0])
+	case 2:
+		return fmt.Sprintf("%s and %s are typing…\n", users[0], users[1])
+	default:
+		return "Several people are typing…\n"
+	}
+}
+
+// chatMembers returns the users that joined the chat or wrote in it and did not leave, sorted by name.
+func (m Model) chatMembers(chatID string) []string {
+	members := map[string]bool{}
+	if username := m.Usernames[chatID]; username != "" {
+		members[username] = true
+	}
+
+	for _, msg := range m.Chats[chatID] {
+		switch msg.Operation {
+		case CREATE_CHAT, JOIN_CHAT, SEND_MESSAGE, SEND_FILE:
+			members[msg.SenderID] = true
+		case LEAVE_CHAT:
+			delete(members, msg.SenderID)
+		case REMOVE_MEMBER, BAN_MEMBER:
+			delete(members, msg.ReceiverID)
+		}
+	}
+
+	names := []string{}
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presenceOf describes whether the user is online or when they were last seen.
+func (m Model) presenceOf(chatID, user string, now time.Time) string {
+	if user == m.Usernames[chatID] {
+		return PresenceOnline
+	}
+
+	presence, known := m.presence[user]
+	if !known || presence.Timestamp == 0 {
+		return PresenceOffline
+	}
+
+	lastSeen := time.Unix(presence.Timestamp, 0)
+	if presence.Content == PresenceOnline && now.Sub(lastSeen) < p_model.PresenceTimeout {
+		return PresenceOnline
+	}
+
+	switch since := now.Sub(lastSeen); {
+	case since < time.Minute:
+		return "last seen just now"
+	case since < time.Hour:
+		return fmt.Sprintf("last seen %d minutes ago", int(since.Minutes()))
+	case since < 24*time.Hour:
+		return fmt.Sprintf("last seen %d hours ago", int(since.Hours()))
+	default:
+		return "last seen " + lastSeen.Format("2006-01-02")
+	}
+}
+
+// membersView renders the members of the chat with their presence.
+func (m Model) membersView(chatID string, now time.Time) string {
+	s := "Members:\n"
+	for _, member := range m.chatMembers(chatID) {
+		s += fmt.Sprintf("  %s - %s\n", member, m.presenceOf(chatID, member, now))
+	}
+	return s
+}
+
 // HandleEnter handles the Enter key press.
 func (m *Model) HandleEnter() (tea.Model, tea.Cmd) {
 	input := strings.TrimSpace(m.input.Value())
@@ -245,6 +773,7 @@ func (m *Model) HandleEnter() (tea.Model, tea.Cmd) {
 			return m.HandleCommand(command, argument)
 		} else {
 			msg := CreateMessage(m.CurrentChat, m.Usernames[m.CurrentChat], "", input, "", "", SEND_MESSAGE)
+			msg.ReplyTo = m.threadRoot // Messages written in a thread reply to its first message
 			m.Chats[m.CurrentChat] = append(m.Chats[m.CurrentChat], msg)
 			m.input.SetValue("")
 		}
@@ -258,6 +787,14 @@ func (m *Model) HandleInviteAccept() (tea.Model, tea.Cmd) {
 	chatID := invitation.ChatID
 	chatName := chatID
 
+	if m.ChatActions != nil {
+		if err := m.ChatActions.AcceptInvitation(invitation.Id); err != nil {
+			m.TempMessage = fmt.Sprintf("Error accepting invitation: %v", err)
+			m.TempMessageExpire = time.Now().Add(10 * time.Second)
+			return m, m.ClearTempMessage()
+		}
+	}
+
 	if _, exists := m.chatNames[chatID]; !exists {
 		m.chatNames[chatID] = chatName
 		m.Chats[chatID] = []FrontendMessage{}
@@ -277,6 +814,14 @@ func (m *Model) HandleInviteAccept() (tea.Model, tea.Cmd) {
 
 // HandleInviteDecline handles declining an invite.
 func (m *Model) HandleInviteDecline() (tea.Model, tea.Cmd) {
+	if m.ChatActions != nil {
+		if err := m.ChatActions.DeclineInvitation(m.invites[m.cursor].Id); err != nil {
+			m.TempMessage = fmt.Sprintf("Error declining invitation: %v", err)
+			m.TempMessageExpire = time.Now().Add(10 * time.Second)
+			return m, m.ClearTempMessage()
+		}
+	}
+
 	m.invites = append(m.invites[:m.cursor], m.invites[m.cursor+1:]...)
 	if len(m.invites) == 0 {
 		m.cursor = 0
@@ -289,9 +834,43 @@ func (m *Model) HandleInviteDecline() (tea.Model, tea.Cmd) {
 	return m, m.ClearTempMessage()
 }
 
+// HandleJoinToken handles joining a chat with a pasted invite link.
+func (m *Model) HandleJoinToken() (tea.Model, tea.Cmd) {
+	token, err := p_model.ParseInviteToken(m.joinTokenInput.Value())
+	if err == nil {
+		err = token.Verify(time.Now().UnixNano())
+	}
+	if err != nil {
+		m.TempMessage = fmt.Sprintf("Invalid invite link: %v", err)
+		m.TempMessageExpire = time.Now().Add(10 * time.Second)
+		return m, m.ClearTempMessage()
+	}
+
+	if m.ChatActions != nil {
+		if err := m.ChatActions.JoinChatWithToken(m.joinTokenInput.Value()); err != nil {
+			m.TempMessage = fmt.Sprintf("Error joining chat: %v", err)
+			m.TempMessageExpire = time.Now().Add(10 * time.Second)
+			return m, m.ClearTempMessage()
+		}
+	}
+
+	if _, exists := m.chatNames[token.ChatID]; !exists {
+		m.chatNames[token.ChatID] = token.ChatName
+		m.Chats[token.ChatID] = []FrontendMessage{}
+	}
+
+	m.joinTokenInput.SetValue("")
+	m.joinTokenInput.Blur()
+	m.currentScreen = screenChats
+	m.focus = "Chats"
+	m.TempMessage = fmt.Sprintf("Joined chat %s with invite link", token.ChatName)
+	m.TempMessageExpire = time.Now().Add(10 * time.Second)
+	return m, m.ClearTempMessage()
+}
+
 // HandleChatSection handles selecting a chat.
 func (m *Model) HandleChatSection() (tea.Model, tea.Cmd) {
-	chatIDs := GetSortedChatIDs(m.chatNames)
+	chatIDs := m.sortedChatIDs()
 	if m.cursor < len(chatIDs) {
 		m.CurrentChat = chatIDs[m.cursor]
 		if _, exists := m.Usernames[m.CurrentChat]; !exists {
@@ -300,6 +879,9 @@ func (m *Model) HandleChatSection() (tea.Model, tea.Cmd) {
 		} else {
 			m.inChatDetail = true
 			m.input.Focus()
+			if err := m.loadLatestMessages(m.CurrentChat); err != nil {
+				return m, tea.Printf("Error: %v", err)
+			}
 			return m, textinput.Blink
 		}
 	}
@@ -314,14 +896,26 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch m.currentScreen {
 		case screenIntro:
+			if m.passphraseForm != nil {
+				switch msg.Type {
+				case tea.KeyEnter:
+					return m.HandleUnlock()
+				case tea.KeyCtrlC, tea.KeyEsc:
+					return m, tea.Quit
+				}
+				return m, m.updatePassphraseForm(msg)
+			}
 			if msg.String() == "enter" || msg.String() == " " {
 				m.currentScreen = screenChats
 			}
 		case screenChats:
 			switch msg.Type {
 			case tea.KeyCtrlC, tea.KeyEsc:
-				if m.inChatDetail {
+				if m.inChatDetail && m.threadRoot != "" {
+					m.threadRoot = ""
+				} else if m.inChatDetail {
 					m.inChatDetail = false
+					m.highlighted = ""
 					m.input.Blur()
 				} else {
 					return m, tea.Quit
@@ -341,8 +935,22 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.currentScreen = screenTestUser
 					m.testUserInput.Focus()
 				}
-			case tea.KeyUp:
-				if !m.inChatDetail && m.cursor > 0 {
+				if msg.String() == "j" && !m.inChatDetail {
+					m.currentScreen = screenJoinToken
+					m.joinTokenInput.Focus()
+				}
+				if msg.String() == "a" && !m.inChatDetail {
+					m.currentScreen = screenContacts
+				}
+				if msg.String() == "p" && !m.inChatDetail {
+					return m.HandlePassphraseForm()
+				}
+			case tea.KeyUp, tea.KeyPgUp:
+				// Scrolling up in the chat loads older messages
+				if m.inChatDetail && m.MessageStore != nil {
+					return m.HandleLoadMessages()
+				}
+				if !m.inChatDetail && msg.Type == tea.KeyUp && m.cursor > 0 {
 					m.cursor--
 				}
 			case tea.KeyDown:
@@ -359,6 +967,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					if len(m.invites) > 0 {
 						m.cursor = 0
 					}
+				} else {
+					m.completeContact()
+					return m, nil
 				}
 			case tea.KeyEnter:
 				if m.inChatDetail {
@@ -395,6 +1006,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					joinMsg := CreateMessage(m.CurrentChat, username, "", fmt.Sprintf("%s joined the chat", username), "", "", JOIN_CHAT)
 					m.Chats[m.CurrentChat] = append(m.Chats[m.CurrentChat], joinMsg)
 					m.usernameInput.SetValue("")
+					if err := m.loadLatestMessages(m.CurrentChat); err != nil {
+						return m, tea.Printf("Error: %v", err)
+					}
 					return m, textinput.Blink
 				} else {
 					return m, tea.Printf("Invalid username. Please enter a single word with up to 20 characters.")
@@ -424,6 +1038,95 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			var cmd tea.Cmd
 			m.testUserInput, cmd = m.testUserInput.Update(msg)
 			cmds = append(cmds, cmd)
+		case screenInviteLink:
+			if msg.Type == tea.KeyEsc {
+				m.inviteLink = ""
+				m.currentScreen = screenChats
+			}
+		case screenJoinToken:
+			switch msg.Type {
+			case tea.KeyEnter:
+				return m.HandleJoinToken()
+			case tea.KeyEsc:
+				m.joinTokenInput.SetValue("")
+				m.joinTokenInput.Blur()
+				m.currentScreen = screenChats
+			}
+
+			var cmd tea.Cmd
+			m.joinTokenInput, cmd = m.joinTokenInput.Update(msg)
+			cmds = append(cmds, cmd)
+		case screenContacts:
+			switch msg.Type {
+			case tea.KeyUp:
+				if m.contactCursor > 0 {
+					m.contactCursor--
+				}
+			case tea.KeyDown:
+				if m.contactCursor < len(m.Contacts)-1 {
+					m.contactCursor++
+				}
+			case tea.KeyEnter:
+				return m.HandleContactForm(true)
+			case tea.KeyBackspace:
+				return m.HandleContactRemove()
+			case tea.KeyRunes:
+				switch msg.String() {
+				case "n":
+					return m.HandleContactForm(false)
+				case "v":
+					return m.HandleContactToggle(false)
+				case "b":
+					return m.HandleContactToggle(true)
+				}
+			case tea.KeyEsc:
+				m.currentScreen = screenChats
+			}
+		case screenContactForm:
+			switch msg.Type {
+			case tea.KeyEnter:
+				return m.HandleContactSave()
+			case tea.KeyTab:
+				m.contactForm[m.contactField].Blur()
+				m.contactField = (m.contactField + 1) % len(m.contactForm)
+				m.contactForm[m.contactField].Focus()
+				return m, nil
+			case tea.KeyEsc:
+				m.editedContact = ""
+				m.currentScreen = screenContacts
+				return m, nil
+			}
+
+			var cmd tea.Cmd
+			m.contactForm[m.contactField], cmd = m.contactForm[m.contactField].Update(msg)
+			cmds = append(cmds, cmd)
+		case screenPassphrase:
+			switch msg.Type {
+			case tea.KeyEnter:
+				return m.HandlePassphraseChange()
+			case tea.KeyEsc:
+				m.passphraseForm = nil
+				m.currentScreen = screenChats
+				return m, nil
+			}
+
+			cmds = append(cmds, m.updatePassphraseForm(msg))
+		case screenSearch:
+			switch msg.Type {
+			case tea.KeyUp:
+				if m.searchCursor > 0 {
+					m.searchCursor--
+				}
+			case tea.KeyDown:
+				if m.searchCursor < len(m.searchResults)-1 {
+					m.searchCursor++
+				}
+			case tea.KeyEnter:
+				return m.HandleSearchJump()
+			case tea.KeyEsc:
+				m.currentScreen = screenChats
+				m.input.Focus()
+			}
 		case screenCreateChat:
 			switch msg.Type {
 			case tea.KeyCtrlC:
@@ -456,7 +1159,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case tea.KeyEnter:
 				if m.createChatInput.Focused() {
-					invitee := strings.TrimSpace(m.createChatInput.Value())
+					invitee := m.resolveContact(m.createChatInput.Value())
 					if invitee != "" {
 						m.chatInvitees = append(m.chatInvitees, invitee)
 						m.createChatInput.SetValue("")
@@ -478,6 +1181,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.chatNameInput, cmd = m.chatNameInput.Update(msg)
 			cmds = append(cmds, cmd)
 		}
+	case FrontendMessage:
+		m.ReceiveMessage(msg)
+		if msg.Operation == TYPING_STARTED {
+			cmds = append(cmds, tea.Tick(TypingTimeout, func(time.Time) tea.Msg {
+				return TypingTimeoutMsg{}
+			}))
+		}
+	case TypingTimeoutMsg:
+		// The view hides expired indicators, it only has to be rendered again
 	case TempMsgTimeoutMsg:
 		if time.Now().After(m.TempMessageExpire) {
 			m.TempMessage = ""
@@ -513,7 +1225,7 @@ func (m Model) View() string {
 	}
 	switch m.currentScreen {
 	case screenIntro:
-		return m.IntroView()
+		return m.IntroView() + tempMsg
 	case screenChats:
 		return m.ChatsView() + tempMsg
 	case screenHelp:
@@ -524,13 +1236,25 @@ func (m Model) View() string {
 		return m.TestUserView() + tempMsg
 	case screenCreateChat:
 		return m.CreateChatView()
+	case screenInviteLink:
+		return m.InviteLinkView()
+	case screenJoinToken:
+		return m.JoinTokenView() + tempMsg
+	case screenContacts:
+		return m.ContactsView() + tempMsg
+	case screenContactForm:
+		return m.ContactFormView()
+	case screenPassphrase:
+		return m.PassphraseView()
+	case screenSearch:
+		return m.SearchView()
 	}
 	return ""
 }
 
 // IntroView returns the view for the intro screen.
 func (m Model) IntroView() string {
-	return `
+	s := `
 .▄▄ · ▄ •▄ ▄• ▄▌ ▐ ▄ ▄ •▄ 
 ▐█ ▀. █▌▄▌▪█▪██▌•█▌▐██▌▄▌▪     ^...^
 ▄▀▀▀█▄▐▀▀▄·█▌▐█▌▐█▐▐▌▐▀▀▄·    <_* *_>   
@@ -539,8 +1263,11 @@ func (m Model) IntroView() string {
 
 Welcome to Skunk, a cutting-edge peer-to-peer communication platform that enables secure and private messaging with powerful encryption.
 
-Press Enter to continue...
 `
+	if m.passphraseForm != nil {
+		return s + m.unlockView()
+	}
+	return s + "Press Enter to continue...\n"
 }
 
 // ChatsView returns the view for the Chats screen.
@@ -560,16 +1287,30 @@ func (m Model) ChatsView() string {
 	s += "║            Chats                                           ║\n"
 	s += "╠════════════════════════════════════════════════════════════╣\n"
 
-	chatIDs := GetSortedChatIDs(m.chatNames)
+	chatIDs := m.sortedChatIDs()
+	directChatsStart := m.directChatsStart()
 
 	for i, chatID := range chatIDs {
+		if i == directChatsStart {
+			s += "╠════════════════════════════════════════════════════════════╣\n"
+			s += "║            Direct messages                                 ║\n"
+			s += "╠════════════════════════════════════════════════════════════╣\n"
+		}
+
 		cursor := " "
 		if i == m.cursor && m.focus == "Chats" {
 			cursor = ">"
 		}
-		padding := len(" " + cursor + " " + m.chatNames[chatID])
+		entry := m.chatNames[chatID]
+		if topic := m.chatTopics[chatID]; topic != "" {
+			entry += " - " + topic
+		}
+		if len(entry) > 55 {
+			entry = entry[:52] + "..."
+		}
+		padding := len(" " + cursor + " " + entry)
 		spaces := 60 - padding
-		s += fmt.Sprintf("║ %s %s%*s║\n", cursor, m.chatNames[chatID], spaces, "")
+		s += fmt.Sprintf("║ %s %s%*s║\n", cursor, entry, spaces, "")
 	}
 
 	s += "╠════════════════════════════════════════════════════════════╣\n"
@@ -591,20 +1332,40 @@ func (m Model) ChatsView() string {
 	}
 
 	s += "╚════════════════════════════════════════════════════════════╝\n"
-	s += "\nPress Enter to open the chat, Tab to switch between Chats and invites, Backspace to decline an invite, h for help, t to test connection, c to create a chat, and q to quit."
+	s += "\nPress Enter to open the chat, Tab to switch between Chats and invites, Backspace to decline an invite, h for help, t to test connection, c to create a chat, j to join with an invite link, a to manage contacts, and q to quit."
+	if m.Encryption != nil {
+		s += " Press p to change the passphrase."
+	}
 
 	return s
 }
 
 // ChatDetailView returns the view for the chat detail screen.
 func (m Model) ChatDetailView() string {
-	s := fmt.Sprintf("Chat: %s\n\n", m.chatNames[m.CurrentChat])
+	if m.threadRoot != "" {
+		return m.ThreadView()
+	}
 
-	for _, msg := range m.Chats[m.CurrentChat] {
+	s := fmt.Sprintf("Chat: %s\n", m.chatNames[m.CurrentChat])
+	if topic := m.chatTopics[m.CurrentChat]; topic != "" {
+		s += fmt.Sprintf("Topic: %s\n", topic)
+	}
+	if description := m.chatDescriptions[m.CurrentChat]; description != "" {
+		s += fmt.Sprintf("%s\n", description)
+	}
+	if expiry, exists := m.messageExpiry[m.CurrentChat]; exists {
+		s += fmt.Sprintf("Messages disappear after %v\n", expiry)
+	}
+	if m.showMembers {
+		s += m.membersView(m.CurrentChat, time.Now())
+	}
+	s += "\n"
+
+	for i, msg := range m.Chats[m.CurrentChat] {
 		timeString := time.Unix(msg.Timestamp, 0).Format("2006-01-02 15:04:05")
 		switch msg.Operation {
 		case SEND_MESSAGE:
-			s += fmt.Sprintf("[%s] %s: %s\n", timeString, msg.SenderID, msg.Content)
+			s += m.messageLine(i, msg)
 		case CREATE_CHAT:
 			s += fmt.Sprintf("[%s] Chat created by %s with ChatID %s\n", timeString, msg.SenderID, msg.ChatID)
 		case JOIN_CHAT:
@@ -613,6 +1374,18 @@ func (m Model) ChatDetailView() string {
 			s += fmt.Sprintf("[%s] User %s has left chat %s\n", timeString, msg.SenderID, msg.ChatID)
 		case INVITE_TO_CHAT:
 			s += fmt.Sprintf("[%s] User %s has been invited by %s\n", timeString, msg.ReceiverID, msg.SenderID)
+		case REMOVE_MEMBER:
+			s += fmt.Sprintf("[%s] User %s has been removed by %s\n", timeString, msg.ReceiverID, msg.SenderID)
+		case BAN_MEMBER:
+			s += fmt.Sprintf("[%s] User %s has been banned by %s\n", timeString, msg.ReceiverID, msg.SenderID)
+		case UPDATE_CHAT_METADATA:
+			s += fmt.Sprintf("[%s] %s changed the chat %s to %s\n", timeString, msg.SenderID, msg.ReceiverID, msg.Content)
+		case UPDATE_CHAT_SETTINGS:
+			if msg.Content == "0s" {
+				s += fmt.Sprintf("[%s] %s turned disappearing messages off\n", timeString, msg.SenderID)
+			} else {
+				s += fmt.Sprintf("[%s] %s set messages to disappear after %s\n", timeString, msg.SenderID, msg.Content)
+			}
 		case SEND_FILE:
 			s += fmt.Sprintf("[%s] User %s has sent a file in chat %s\n", timeString, msg.SenderID, msg.ChatID)
 		case SET_USERNAME:
@@ -624,22 +1397,130 @@ func (m Model) ChatDetailView() string {
 		}
 	}
 
-	s += fmt.Sprintf("\n%s", m.input.View())
+	s += fmt.Sprintf("\n%s%s%s", m.typingLine(m.CurrentChat, time.Now()), m.contactSuggestionLine(), m.input.View())
 	s += "\nPress Enter to send the message, ESC to go back."
 
 	return s
 }
 
+// ThreadView returns the view for a message and all replies to it.
+func (m Model) ThreadView() string {
+	s := fmt.Sprintf("Thread in %s\n\n", m.chatNames[m.CurrentChat])
+
+	root := m.messageIndex(m.CurrentChat, m.threadRoot)
+	if root < 0 {
+		s += "The message has not arrived yet.\n"
+	} else {
+		s += m.messageLine(root, m.Chats[m.CurrentChat][root])
+	}
+
+	for _, reply := range m.replies(m.CurrentChat, m.threadRoot) {
+		s += "  " + m.messageLine(reply, m.Chats[m.CurrentChat][reply])
+	}
+
+	s += fmt.Sprintf("\n%s", m.input.View())
+	s += "\nPress Enter to reply, ESC to go back to the chat."
+
+	return s
+}
+
+// messageLine renders a SEND_MESSAGE message with its number, the quoted parent of a reply and the number of replies.
+func (m Model) messageLine(index int, msg FrontendMessage) string {
+	s := ""
+	if msg.ReplyTo != "" {
+		s += fmt.Sprintf("    ┆ %s\n", m.quote(msg.ChatID, msg.ReplyTo))
+	}
+
+	text := msg.Content
+	if msg.Deleted {
+		text = "message deleted"
+	} else if msg.Edited {
+		text += " (edited)"
+	}
+
+	if msg.Id == m.highlighted {
+		s += "» "
+	}
+
+	timeString := time.Unix(msg.Timestamp, 0).Format("2006-01-02 15:04:05")
+	s += fmt.Sprintf("#%d [%s] %s: %s", index+1, timeString, msg.SenderID, text)
+
+	if replies := len(m.replies(msg.ChatID, msg.Id)); replies == 1 {
+		s += " (1 reply)"
+	} else if replies > 1 {
+		s += fmt.Sprintf(" (%d replies)", replies)
+	}
+
+	return s + "\n" + m.reactionLine(msg.Id)
+}
+
+// quote returns a short version of the message for replies. Replies can arrive before the message they quote.
+func (m Model) quote(chatID, messageID string) string {
+	index := m.messageIndex(chatID, messageID)
+	if index < 0 {
+		return "reply to a message that has not arrived yet"
+	}
+
+	parent := m.Chats[chatID][index]
+	if parent.Deleted {
+		return fmt.Sprintf("#%d %s: message deleted", index+1, parent.SenderID)
+	}
+
+	text := parent.Content
+	if len(text) > 50 {
+		text = text[:47] + "..."
+	}
+	return fmt.Sprintf("#%d %s: %s", index+1, parent.SenderID, text)
+}
+
+// messageIndex returns the index of the message in the chat, -1 if it is unknown.
+func (m Model) messageIndex(chatID, messageID string) int {
+	for i, msg := range m.Chats[chatID] {
+		if msg.Id == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+// replies returns the indices of all replies to the message, in the order they were received.
+func (m Model) replies(chatID, messageID string) []int {
+	var replies []int
+	for i, msg := range m.Chats[chatID] {
+		if msg.Operation == SEND_MESSAGE && msg.ReplyTo == messageID {
+			replies = append(replies, i)
+		}
+	}
+	return replies
+}
+
 // helpView returns the view for the help screen.
 func (m Model) helpView() string {
 	return `
 Available commands in the chat:
 
   /leave - Leave a chat
-  /invite <OnionID> - Invite a user to a chat
+  /invite <OnionID> - Invite a user to a chat, Tab completes the OnionID of a contact
+  /invitelink - Show a one-time invite link and QR code for the chat
+  /kick <OnionID> - Remove a member from the chat (admins and owners)
+  /ban <OnionID> - Remove a member and prevent them from joining again (admins and owners)
+  /rename <ChatName> - Rename the chat (admins and owners)
+  /topic <Topic> - Set the topic of the chat, empty to clear it (admins and owners)
+  /description <Description> - Set the description of the chat (admins and owners)
+  /members - Show or hide the members of the chat and whether they are online
+  /disappear <Duration> - Remove messages after the duration, e.g. 24h, or off (admins and owners)
+  /reply <Number> <Message> - Reply to the message with the number
+  /thread <Number> - Show the message with the number and all replies to it
+  /react <Number> <Emoji> - React to the message with the number, again to remove the reaction
+  /edit <Message> - Edit your last message
+  /delete - Delete your last message
+  /dm <OnionAddress> <Message> - Send a direct message to a user or contact, no invitation needed
   /sendfile <FilePath> - Send a file in a chat (still WIP)
   /setusername <NewUsername> - Set or change the user's username
-  /loadmessages - Loads 50 more messages of the chat if they exist
+  /search <Words> - Search the messages of the chat, from:<SenderID> only searches the messages of one sender
+  /searchall <Words> - Search the messages of all chats
+  /loadmessages - Loads 50 older messages of the chat if they exist, also Up or PgUp
+  /export [json|md|html] [From] [To] - Save the messages of the chat to a file, optionally only the days From to To (YYYY-MM-DD)
 
 Press ESC to return to the main menu.
 `
@@ -655,14 +1536,35 @@ func (m Model) TestUserView() string {
 	return fmt.Sprintf("Test connection to OnionID:\n\n%s\n\nPress Enter to test, ESC to return.", m.testUserInput.View())
 }
 
+// InviteLinkView returns the view for the invite link screen.
+func (m Model) InviteLinkView() string {
+	s := fmt.Sprintf("Invite link for %s (valid once):\n\n%s\n\n", m.chatNames[m.CurrentChat], m.inviteLink)
+
+	qrCode, err := RenderQRCode(m.inviteLink)
+	if err != nil {
+		s += fmt.Sprintf("Could not render QR code: %v\n", err)
+	} else {
+		s += qrCode
+	}
+
+	s += "\nPress ESC to return."
+	return s
+}
+
+// JoinTokenView returns the view for the join with invite link screen.
+func (m Model) JoinTokenView() string {
+	return fmt.Sprintf("Join a chat with an invite link:\n\n%s\n\nPress Enter to join, ESC to return.", m.joinTokenInput.View())
+}
+
 // CreateChatView returns the view for the create chat screen.
 func (m Model) CreateChatView() string {
 	s := "Create a new chat:\n\n"
 	s += fmt.Sprintf("\nInvitee: %s\n", m.createChatInput.View())
+	s += suggestionLine(m.contactSuggestions(m.createChatInput.Value()), "Enter adds the only match")
 	s += fmt.Sprintf("Chat Name: %s\n", m.chatNameInput.View())
 	s += "Invitees:\n"
 	for _, invitee := range m.chatInvitees {
-		s += fmt.Sprintf("- %s\n", invitee)
+		s += fmt.Sprintf("- %s\n", m.contactName(invitee))
 	}
 	s += "\nPress Enter to add invitee, Ctrl+I to switch input, Ctrl+C to create chat, ESC to return."
 
@@ -679,6 +1581,31 @@ func GetSortedChatIDs(chatNames map[string]string) []string {
 	return chatIDs
 }
 
+// sortedChatIDs returns the sorted IDs of the group chats followed by the sorted IDs of the direct chats,
+// the order in which ChatsView lists them.
+func (m Model) sortedChatIDs() []string {
+	groupChatIDs := []string{}
+	directChatIDs := []string{}
+	for _, chatID := range GetSortedChatIDs(m.chatNames) {
+		if p_model.IsDirectChatID(chatID) {
+			directChatIDs = append(directChatIDs, chatID)
+		} else {
+			groupChatIDs = append(groupChatIDs, chatID)
+		}
+	}
+	return append(groupChatIDs, directChatIDs...)
+}
+
+// directChatsStart returns the position of the first direct chat in sortedChatIDs.
+func (m Model) directChatsStart() int {
+	for i, chatID := range m.sortedChatIDs() {
+		if p_model.IsDirectChatID(chatID) {
+			return i
+		}
+	}
+	return len(m.chatNames)
+}
+
 // TestConnection simulates testing a connection to an OnionID.
 // Still WIP
 func TestConnection(onionID string) bool {
@@ -693,11 +1620,11 @@ func (m Model) ClearTempMessage() tea.Cmd {
 	})
 }
 
-// RunFrontend starts the frontend application
-func RunFrontend() {
-	p := tea.NewProgram(InitialModel())
+// RunFrontend starts the frontend application with the model, like InitialModel() with the stores of the node set
+func RunFrontend(model Model) error {
+	p := tea.NewProgram(model)
 	if err := p.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "Oh no, something went wrong: %s\n", err)
-		os.Exit(1)
+		return fmt.Errorf("oh no, something went wrong: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,157 @@
+package frontend
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// MessagePageSize is the number of stored messages that are loaded at once.
+const MessagePageSize = 50
+
+// historyOperations maps the stored operations that are shown in the chat history to the operations of the frontend.
+// Changes of the chat state, like its metadata and roles, are not loaded into the history.
+var historyOperations = map[network.OperationType]OperationType{
+	network.SEND_MESSAGE:   SEND_MESSAGE,
+	network.EDIT_MESSAGE:   EDIT_MESSAGE,
+	network.DELETE_MESSAGE: DELETE_MESSAGE,
+	network.JOIN_CHAT:      JOIN_CHAT,
+	network.LEAVE_CHAT:     LEAVE_CHAT,
+	network.SEND_FILE:      SEND_FILE,
+}
+
+// historyMessage converts a stored message to a FrontendMessage, false if it is not shown in the history.
+func (m Model) historyMessage(message network.Message) (FrontendMessage, bool) {
+	operation, shown := historyOperations[message.Operation]
+	if !shown {
+		return FrontendMessage{}, false
+	}
+
+	var content struct {
+		MessageID string `json:"messageId"`
+		Message   string `json:"message"`
+		ReplyTo   string `json:"replyTo"`
+	}
+	json.Unmarshal([]byte(message.Content), &content)
+
+	sender := message.SenderID
+	if username, err := m.MessageStore.GetUsername(message.SenderID, message.ChatID); err == nil && username != "" {
+		sender = username
+	}
+
+	msg := FrontendMessage{
+		Id:              message.Id,
+		Timestamp:       time.Unix(0, message.Timestamp).Unix(),
+		Content:         content.Message,
+		SenderID:        sender,
+		ReceiverID:      message.ReceiverID,
+		SenderAddress:   message.SenderAddress,
+		ReceiverAddress: message.ReceiverAddress,
+		ChatID:          message.ChatID,
+		Operation:       operation,
+		ReplyTo:         content.ReplyTo,
+	}
+	switch operation {
+	case EDIT_MESSAGE, DELETE_MESSAGE:
+		msg.ReceiverID = content.MessageID
+	case JOIN_CHAT:
+		msg.Content = fmt.Sprintf("%s joined the chat", sender)
+	}
+	return msg, true
+}
+
+// loadLatestMessages loads the newest page of the stored messages of the chat when it is opened the first time.
+func (m *Model) loadLatestMessages(chatID string) error {
+	if m.MessageStore == nil || m.historyLoaded[chatID] {
+		return nil
+	}
+
+	messages, err := m.MessageStore.GetChatMessagePage(store.MessagePage{ChatID: chatID, Limit: MessagePageSize})
+	if err != nil {
+		return err
+	}
+
+	m.historyLoaded[chatID] = true
+	m.addHistory(chatID, messages)
+	return nil
+}
+
+// loadOlderMessages loads the page of stored messages before the oldest loaded one, false if there are no older messages.
+func (m *Model) loadOlderMessages(chatID string) (bool, error) {
+	if !m.historyLoaded[chatID] {
+		return true, m.loadLatestMessages(chatID)
+	}
+	if m.historyOldest[chatID] == "" {
+		return false, nil
+	}
+
+	messages, err := m.MessageStore.GetChatMessagePage(store.MessagePage{ChatID: chatID, Before: m.historyOldest[chatID], Limit: MessagePageSize})
+	if err != nil {
+		return false, err
+	}
+	m.addHistory(chatID, messages)
+	return len(messages) > 0, nil
+}
+
+// addHistory puts a page of stored messages in front of the loaded messages of the chat.
+// Edits and deletions are applied to the messages they refer to, also if those are only loaded with a later page.
+func (m *Model) addHistory(chatID string, messages []network.Message) {
+	if len(messages) < MessagePageSize {
+		m.historyOldest[chatID] = ""
+	} else {
+		m.historyOldest[chatID] = messages[0].Id
+	}
+
+	loaded := map[string]bool{}
+	for _, msg := range m.Chats[chatID] {
+		loaded[msg.Id] = true
+	}
+
+	page := []FrontendMessage{}
+	changes := []FrontendMessage{}
+	for _, message := range messages {
+		msg, shown := m.historyMessage(message)
+		if !shown || loaded[msg.Id] {
+			continue
+		}
+		if msg.Operation == EDIT_MESSAGE || msg.Operation == DELETE_MESSAGE {
+			changes = append(changes, msg)
+			continue
+		}
+		page = append(page, msg)
+	}
+	m.Chats[chatID] = append(page, m.Chats[chatID]...)
+
+	for _, change := range changes {
+		m.applyMessageChange(change)
+	}
+	for _, msg := range page {
+		if change, pending := m.pendingChanges[msg.Id]; pending {
+			delete(m.pendingChanges, msg.Id)
+			m.applyMessageChange(change)
+		}
+	}
+}
+
+// HandleLoadMessages loads older messages of the current chat.
+func (m *Model) HandleLoadMessages() (tea.Model, tea.Cmd) {
+	if m.MessageStore == nil {
+		return m, tea.Printf("Error: older messages can only be loaded while they are stored")
+	}
+
+	loaded, err := m.loadOlderMessages(m.CurrentChat)
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+	if !loaded {
+		m.TempMessage = "There are no older messages"
+		m.TempMessageExpire = time.Now().Add(10 * time.Second)
+		return m, m.ClearTempMessage()
+	}
+	return m, nil
+}
@@ -0,0 +1,155 @@
+package frontend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// MinPassphraseLength is the minimum length of a new passphrase.
+const MinPassphraseLength = 8
+
+// newPassphraseForm returns hidden inputs with the placeholders, the first one is focused.
+func newPassphraseForm(placeholders ...string) []textinput.Model {
+	form := make([]textinput.Model, len(placeholders))
+	for i, placeholder := range placeholders {
+		form[i] = textinput.New()
+		form[i].Placeholder = placeholder
+		form[i].EchoMode = textinput.EchoPassword
+		form[i].CharLimit = 256
+		form[i].Width = 40
+	}
+	form[0].Focus()
+	return form
+}
+
+// SetEncryption uses the encryption of the database, the intro screen asks for the passphrase before the chats are shown.
+// A database without a passphrase gets one, an encrypted database is unlocked with it.
+func (m *Model) SetEncryption(encryption store.EncryptionStoragePort) error {
+	encrypted, err := encryption.IsEncrypted()
+	if err != nil {
+		return err
+	}
+
+	m.Encryption = encryption
+	m.encrypted = encrypted
+	if encrypted {
+		m.passphraseForm = newPassphraseForm("Enter your passphrase...")
+	} else {
+		m.passphraseForm = newPassphraseForm("Choose a passphrase...", "Repeat the passphrase...")
+	}
+	m.passphraseField = 0
+	return nil
+}
+
+// validateNewPassphrase checks the length of a new passphrase and that it was repeated correctly.
+func validateNewPassphrase(passphrase, repeated string) error {
+	if len(passphrase) < MinPassphraseLength {
+		return fmt.Errorf("the passphrase has to be at least %d characters long", MinPassphraseLength)
+	}
+	if passphrase != repeated {
+		return errors.New("the passphrases don't match")
+	}
+	return nil
+}
+
+// HandleUnlock unlocks the database with the entered passphrase, or encrypts it if it has no passphrase yet.
+func (m *Model) HandleUnlock() (tea.Model, tea.Cmd) {
+	var err error
+	if m.encrypted {
+		err = m.Encryption.Unlock(m.passphraseForm[0].Value())
+	} else {
+		err = validateNewPassphrase(m.passphraseForm[0].Value(), m.passphraseForm[1].Value())
+		if err == nil {
+			err = m.Encryption.SetPassphrase(m.passphraseForm[0].Value())
+		}
+	}
+
+	if err != nil {
+		for i := range m.passphraseForm {
+			m.passphraseForm[i].SetValue("")
+		}
+		m.TempMessage = fmt.Sprintf("Error: %v", err)
+		m.TempMessageExpire = time.Now().Add(10 * time.Second)
+		return m, m.ClearTempMessage()
+	}
+
+	m.encrypted = true
+	m.passphraseForm = nil
+	m.currentScreen = screenChats
+	if err := m.LoadContacts(); err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+	return m, nil
+}
+
+// HandlePassphraseForm opens the form for changing the passphrase.
+func (m *Model) HandlePassphraseForm() (tea.Model, tea.Cmd) {
+	if m.Encryption == nil {
+		return m, nil
+	}
+
+	m.passphraseForm = newPassphraseForm("Enter your current passphrase...", "Choose a new passphrase...", "Repeat the new passphrase...")
+	m.passphraseField = 0
+	m.currentScreen = screenPassphrase
+	return m, textinput.Blink
+}
+
+// HandlePassphraseChange encrypts the database again with the new passphrase.
+func (m *Model) HandlePassphraseChange() (tea.Model, tea.Cmd) {
+	err := validateNewPassphrase(m.passphraseForm[1].Value(), m.passphraseForm[2].Value())
+	if err == nil {
+		err = m.Encryption.ChangePassphrase(m.passphraseForm[0].Value(), m.passphraseForm[1].Value())
+	}
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+
+	m.passphraseForm = nil
+	m.currentScreen = screenChats
+	m.TempMessage = "Passphrase changed!"
+	m.TempMessageExpire = time.Now().Add(10 * time.Second)
+	return m, m.ClearTempMessage()
+}
+
+// updatePassphraseForm moves the focus with Tab and passes all other keys to the focused input.
+func (m *Model) updatePassphraseForm(msg tea.KeyMsg) tea.Cmd {
+	if msg.Type == tea.KeyTab {
+		m.passphraseForm[m.passphraseField].Blur()
+		m.passphraseField = (m.passphraseField + 1) % len(m.passphraseForm)
+		m.passphraseForm[m.passphraseField].Focus()
+		return nil
+	}
+
+	var cmd tea.Cmd
+	m.passphraseForm[m.passphraseField], cmd = m.passphraseForm[m.passphraseField].Update(msg)
+	return cmd
+}
+
+// unlockView returns the passphrase prompt of the intro screen.
+func (m Model) unlockView() string {
+	if m.encrypted {
+		return fmt.Sprintf("Your messages are encrypted, enter your passphrase to unlock them:\n\n%s\n\nPress Enter to unlock, ESC to quit.", m.passphraseForm[0].View())
+	}
+
+	s := "Choose a passphrase to encrypt your messages and contacts on this device. It can't be recovered if you forget it.\n\n"
+	s += fmt.Sprintf("Passphrase: %s\n", m.passphraseForm[0].View())
+	s += fmt.Sprintf("Repeat: %s\n", m.passphraseForm[1].View())
+	s += "\nPress Tab to switch input, Enter to encrypt, ESC to quit."
+	return s
+}
+
+// PassphraseView returns the view for changing the passphrase.
+func (m Model) PassphraseView() string {
+	s := "Change your passphrase:\n\n"
+	s += fmt.Sprintf("Current passphrase: %s\n", m.passphraseForm[0].View())
+	s += fmt.Sprintf("New passphrase: %s\n", m.passphraseForm[1].View())
+	s += fmt.Sprintf("Repeat: %s\n", m.passphraseForm[2].View())
+	s += "\nPress Tab to switch input, Enter to change the passphrase, ESC to return."
+	return s
+}
@@ -0,0 +1,47 @@
+package frontend
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the number of light modules around the QR code, required by most scanners.
+const qrQuietZone = 2
+
+// RenderQRCode renders text as a QR code for the terminal.
+// Two rows of modules are combined into one line using half block characters.
+func RenderQRCode(text string) (string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return "", err
+	}
+
+	// dark modules are drawn as spaces on a light background, since most terminals are dark
+	isLight := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+
+	var sb strings.Builder
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := isLight(x, y), isLight(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
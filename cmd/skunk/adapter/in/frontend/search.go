@@ -0,0 +1,117 @@
+package frontend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// MaxSearchResults is the maximum number of messages a search shows.
+const MaxSearchResults = 50
+
+// searchSenderPrefix filters the search by the SenderID that follows it, e.g. from:<SenderID>.
+const searchSenderPrefix = "from:"
+
+// HandleSearch searches the stored messages of the current chat, or of all chats if all is true, and shows the results.
+func (m *Model) HandleSearch(all bool, argument string) (tea.Model, tea.Cmd) {
+	if m.MessageStore == nil {
+		return m, tea.Printf("Error: the messages can only be searched while they are stored")
+	}
+
+	query := store.SearchQuery{Limit: MaxSearchResults}
+	words := []string{}
+	for _, word := range strings.Fields(argument) {
+		if sender, found := strings.CutPrefix(word, searchSenderPrefix); found && sender != "" {
+			query.SenderID = sender
+			continue
+		}
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return m, tea.Printf("Error: /search needs the words to search for")
+	}
+	query.Text = strings.Join(words, " ")
+	if !all {
+		query.ChatID = m.CurrentChat
+	}
+
+	results, err := m.MessageStore.SearchMessages(query)
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+
+	m.input.SetValue("")
+	if len(results) == 0 {
+		m.TempMessage = fmt.Sprintf("No messages found for %s", query.Text)
+		m.TempMessageExpire = time.Now().Add(10 * time.Second)
+		return m, m.ClearTempMessage()
+	}
+
+	m.searchQuery = query.Text
+	m.searchResults = results
+	m.searchCursor = 0
+	m.input.Blur()
+	m.currentScreen = screenSearch
+	return m, nil
+}
+
+// HandleSearchJump opens the chat of the selected result and highlights the message.
+func (m *Model) HandleSearchJump() (tea.Model, tea.Cmd) {
+	message := m.searchResults[m.searchCursor].Message
+
+	m.CurrentChat = message.ChatID
+	m.threadRoot = ""
+	m.highlighted = message.Id
+	m.inChatDetail = true
+	m.currentScreen = screenChats
+	m.input.Focus()
+
+	// Older pages are loaded until the message is found
+	for m.MessageStore != nil && m.messageIndex(message.ChatID, message.Id) < 0 {
+		loaded, err := m.loadOlderMessages(message.ChatID)
+		if err != nil {
+			return m, tea.Printf("Error: %v", err)
+		}
+		if !loaded {
+			break
+		}
+	}
+
+	if m.messageIndex(message.ChatID, message.Id) < 0 {
+		m.TempMessage = "The message could not be loaded"
+		m.TempMessageExpire = time.Now().Add(10 * time.Second)
+		return m, m.ClearTempMessage()
+	}
+	return m, nil
+}
+
+// SearchView returns the view for the search results.
+func (m Model) SearchView() string {
+	s := fmt.Sprintf("Messages containing %s:\n\n", m.searchQuery)
+
+	for i, result := range m.searchResults {
+		cursor := " "
+		if i == m.searchCursor {
+			cursor = ">"
+		}
+
+		chatName := m.chatNames[result.Message.ChatID]
+		if chatName == "" {
+			chatName = result.Message.ChatID
+		}
+		sender := result.Message.SenderID
+		if result.Message.SenderAddress != "" {
+			sender = m.contactName(result.Message.SenderAddress)
+		}
+
+		timeString := time.Unix(0, result.Message.Timestamp).Format("2006-01-02 15:04:05")
+		s += fmt.Sprintf("%s [%s] %s - %s: %s\n", cursor, timeString, chatName, sender, result.Snippet)
+	}
+
+	s += "\nPress Enter to jump to the message, ESC to return to the chat."
+	return s
+}
@@ -0,0 +1,91 @@
+package frontend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+)
+
+// HandleExport writes the transcript of the current chat to a new file in ExportDir.
+// The argument is the optional format, markdown by default, followed by the optional first and last day.
+func (m *Model) HandleExport(argument string) (tea.Model, tea.Cmd) {
+	if m.MessageStore == nil {
+		return m, tea.Printf("Error: the messages can only be exported while they are stored")
+	}
+
+	options := c_service.TranscriptOptions{Format: c_service.TRANSCRIPT_MARKDOWN}
+	fields := strings.Fields(argument)
+	if len(fields) > 0 {
+		if format, err := c_service.ParseTranscriptFormat(fields[0]); err == nil {
+			options.Format = format
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 2 {
+		return m, tea.Printf("Error: /export takes the format, the first and the last day")
+	}
+	fields = append(fields, "", "")
+
+	var err error
+	options.From, options.To, err = c_service.ParseTranscriptRange(fields[0], fields[1])
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+
+	path, err := m.exportTranscript(options)
+	if err != nil {
+		return m, tea.Printf("Error: %v", err)
+	}
+
+	m.input.SetValue("")
+	m.TempMessage = fmt.Sprintf("Chat exported to %s", path)
+	m.TempMessageExpire = time.Now().Add(10 * time.Second)
+	return m, m.ClearTempMessage()
+}
+
+// exportTranscript writes the transcript to a file named after the chat, existing files are not overwritten.
+func (m *Model) exportTranscript(options c_service.TranscriptOptions) (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, m.chatNames[m.CurrentChat])
+	if strings.Trim(name, "_") == "" {
+		name = "chat"
+	}
+
+	var file *os.File
+	var path string
+	var err error
+	for counter := 0; ; counter++ {
+		path = filepath.Join(m.ExportDir, fmt.Sprintf("%s.%s", name, options.Format))
+		if counter > 0 {
+			path = filepath.Join(m.ExportDir, fmt.Sprintf("%s_%d.%s", name, counter, options.Format))
+		}
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = c_service.ExportTranscript(m.MessageStore, m.CurrentChat, options, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
@@ -1,9 +1,9 @@
 package networkAdapter
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
-	"sync"
 	"time"
 
 	cretztor "github.com/cretz/bine/tor"
@@ -14,35 +14,19 @@ import (
 	"github.com/scherzma/Skunk/cmd/skunk/util"
 )
 
-// Constants for configuration of Tor
-const (
-	SocksPort            = "9055"
-	LocalPort            = "1111"
-	RemotePort           = "2222"
-	ReusePrivateKey      = true // reuse private key for constant onion address
-	DeleteDataDirOnClose = false
-	UseEmbedded          = true // use embedded tor process
-)
-
-var (
-	networkAdapter *NetworkAdapter
-	once           sync.Once
-)
-
 // NetworkAdapter connects the main logic to the tor peer network
 type NetworkAdapter struct {
 	subscriber network.NetworkObserver // subscriber observing network messages
 	peer       *peer.Peer
 	tor        *tor.Tor
+	torConfig  tor.TorConfig // configuration of the Tor service, started when the observer subscribes
 }
 
-func NewAdapter() *NetworkAdapter {
-	// singleton
-	once.Do(func() {
-		networkAdapter = &NetworkAdapter{}
-	})
-
-	return networkAdapter
+// NewAdapter creates an adapter that isn't connected yet, Tor is started with the configuration when the observer subscribes,
+// like tor.DefaultConfig() or a running tor daemon from tor.ConfigFromEnvironment.
+// Every adapter runs its own Tor service, adapters that are subscribed at the same time need different ports and data dirs.
+func NewAdapter(torConfig tor.TorConfig) *NetworkAdapter {
+	return &NetworkAdapter{torConfig: torConfig}
 }
 
 func (n *NetworkAdapter) SubscribeToNetwork(observer network.NetworkObserver) error {
@@ -54,13 +38,14 @@ func (n *NetworkAdapter) SubscribeToNetwork(observer network.NetworkObserver) er
 		return fmt.Errorf("network services are already running")
 	}
 
-	torInstance, onionService, err := startTor()
+	torInstance, onionService, err := startTor(n.torConfig)
 	if err != nil {
 		return err
 	}
 
-	peerInstance, err := startPeer(onionService)
+	peerInstance, err := startPeer(onionService, observer, n.torConfig, torInstance.SocksAddress())
 	if err != nil {
+		torInstance.StopTor()
 		return err
 	}
 
@@ -76,11 +61,11 @@ func (n *NetworkAdapter) SubscribeToNetwork(observer network.NetworkObserver) er
 		Id:              util.UUID(),
 		Timestamp:       util.CurrentTimeMillis(),
 		Content:         fmt.Sprintf(`"%s"`, n.peer.Address),
-		FromUser:        "",
 		SenderAddress:   "",
 		ReceiverAddress: "",
 		ChatID:          "",
 		Operation:       network.NETWORK_ONLINE,
+		Local:           true,
 	}
 	n.SendNetworkMessageToSubscriber(message)
 	return nil
@@ -112,8 +97,18 @@ func (n *NetworkAdapter) UnsubscribeFromNetwork() error {
 	return nil
 }
 
-// SendMessageToNetworkPeer sends a message to a specified network peer
-func (n *NetworkAdapter) SendMessageToNetworkPeer(address string, message network.Message) error {
+// IdentityKey returns the private key of the onion address, nil while the adapter is not subscribed
+func (n *NetworkAdapter) IdentityKey() ed25519.PrivateKey {
+	if n.tor == nil {
+		return nil
+	}
+	return n.tor.IdentityKey()
+}
+
+// SendMessageToNetworkPeer sends a message to the peer at its receiver address
+func (n *NetworkAdapter) SendMessageToNetworkPeer(message network.Message) error {
+	address := message.ReceiverAddress
+
 	// connect to peer if not already connected
 	if !n.peer.IsConnectedTo(address) {
 		err := n.peer.Connect(address)
@@ -124,11 +119,11 @@ func (n *NetworkAdapter) SendMessageToNetworkPeer(address string, message networ
 				Id:              util.UUID(),
 				Timestamp:       util.CurrentTimeMillis(),
 				Content:         "",
-				FromUser:        "",
 				SenderAddress:   n.peer.Address,
 				ReceiverAddress: address,
 				ChatID:          "",
 				Operation:       network.USER_OFFLINE,
+				Local:           true,
 			}
 			n.SendNetworkMessageToSubscriber(message)
 			return nil
@@ -159,12 +154,12 @@ func (n *NetworkAdapter) SendNetworkMessageToSubscriber(message network.Message)
 
 // readNetworkMessages reads messages from the network and forwards them to the subscriber
 func (n *NetworkAdapter) readNetworkMessages() {
-	messageCh := make(chan string)
+	messageCh := make(chan peer.ReceivedMessage)
 	errorCh := make(chan error)
 
 	// read messages asynchronously; terminate on unsubscribe
 	// also closes messageCh and errorCh
-	go n.peer.ReadMessages(messageCh, errorCh)
+	go n.peer.ReadMessagesWithAddress(messageCh, errorCh)
 
 	// handle incoming messages and errors
 	for {
@@ -174,10 +169,12 @@ func (n *NetworkAdapter) readNetworkMessages() {
 				return
 			}
 			message := network.Message{}
-			err := json.Unmarshal([]byte(msg), &message)
+			err := json.Unmarshal([]byte(msg.Content), &message)
 			if err != nil {
 				continue // optionally we could handle incorrect formatted messages
 			}
+			// the sender can write any address into the message, the connection tells where it came from
+			message.SenderAddress = msg.Address
 			n.SendNetworkMessageToSubscriber(message)
 		case err, ok := <-errorCh:
 			if !ok {
@@ -188,28 +185,19 @@ func (n *NetworkAdapter) readNetworkMessages() {
 				Id:              util.UUID(),
 				Timestamp:       util.CurrentTimeMillis(),
 				Content:         "",
-				FromUser:        "",
 				SenderAddress:   n.peer.Address,
 				ReceiverAddress: err.Error(), // error contains the address
 				ChatID:          "",
 				Operation:       network.USER_OFFLINE,
+				Local:           true,
 			}
 			n.SendNetworkMessageToSubscriber(message)
 		}
 	}
 }
 
-// startTor initializes and starts a Tor service.
-func startTor() (*tor.Tor, *cretztor.OnionService, error) {
-	conf := tor.TorConfig{
-		SocksPort:            SocksPort,
-		LocalPort:            LocalPort,
-		RemotePort:           RemotePort,
-		ReusePrivateKey:      ReusePrivateKey,
-		DeleteDataDirOnClose: DeleteDataDirOnClose,
-		UseEmbedded:          UseEmbedded,
-	}
-
+// startTor initializes and starts a Tor service, or attaches to the tor daemon, with a copy of the configuration.
+func startTor(conf tor.TorConfig) (*tor.Tor, *cretztor.OnionService, error) {
 	torInstance, err := tor.NewTor(&conf)
 	if err != nil {
 		return nil, nil, err
@@ -228,12 +216,16 @@ func startTor() (*tor.Tor, *cretztor.OnionService, error) {
 	return torInstance, onionService, nil
 }
 
-// startPeer initializes a peer network connection using the provided OnionService
-func startPeer(onionService *cretztor.OnionService) (*peer.Peer, error) {
-	peerInstance, err := peer.NewPeer(onionService.ID+".onion", LocalPort, RemotePort, "127.0.0.1:"+SocksPort)
+// startPeer initializes a peer network connection using the provided OnionService and the socks proxy of Tor
+// If the observer validates peers, connections to and from the peers it rejects are refused.
+func startPeer(onionService *cretztor.OnionService, observer network.NetworkObserver, conf tor.TorConfig, socksAddress string) (*peer.Peer, error) {
+	peerInstance, err := peer.NewPeer(onionService.ID+".onion", conf.LocalPort, conf.RemotePort, socksAddress)
 	if err != nil {
 		return nil, err
 	}
+	if validator, ok := observer.(network.PeerValidator); ok {
+		peerInstance.Allowed = validator.ValidatePeer
+	}
 	peerInstance.Listen(onionService)
 
 	return peerInstance, err
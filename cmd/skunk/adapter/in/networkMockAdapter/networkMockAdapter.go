@@ -1,29 +1,24 @@
 package networkMockAdapter
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
-	"sync"
 
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 )
 
-// NetworkMockAdapter is a mock adapter for the network
+// MockConnection is a mock adapter for the network
 // It implements the NetworkConnection interface
-var (
-	mockConnection *MockConnection // singleton for testing purposes
-	once           sync.Once
-)
-
 type MockConnection struct {
 	subscriber network.NetworkObserver
 	LastSent   network.Message
+	Address    string             // onion address the mock goes online with, it stays offline if empty
+	Key        ed25519.PrivateKey // private key of the onion address
 }
 
-func GetMockConnection() *MockConnection {
-	once.Do(func() {
-		mockConnection = &MockConnection{}
-	})
-	return mockConnection
+func NewMockConnection() *MockConnection {
+	return &MockConnection{}
 }
 
 // SubscribeToNetwork is a mock function for the network
@@ -33,7 +28,25 @@ func (m *MockConnection) SubscribeToNetwork(observer network.NetworkObserver) er
 	}
 
 	m.subscriber = observer
-	return nil
+	if m.Address == "" {
+		return nil
+	}
+
+	// like the network adapter, tell the subscriber its address once it is online
+	content, err := json.Marshal(m.Address)
+	if err != nil {
+		return err
+	}
+	return observer.Notify(network.Message{
+		Content:   string(content),
+		Operation: network.NETWORK_ONLINE,
+		Local:     true,
+	})
+}
+
+// IdentityKey returns the private key of the onion address
+func (m *MockConnection) IdentityKey() ed25519.PrivateKey {
+	return m.Key
 }
 
 // UnsubscribeFromNetwork is a mock function for the network
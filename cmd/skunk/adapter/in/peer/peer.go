@@ -42,6 +42,7 @@ type Peer struct {
 	Port       string                     // Port on which the peer listens for incoming connections.
 	Address    string                     // Address specifies the complete websocket address: ws://Hostname:Port
 	ProxyAddr  string                     // ProxyAddr specifies the address of SOCKS5 proxy, if used for connections.
+	Allowed    func(address string) bool  // Allowed decides which addresses the peer connects to and accepts connections from, nil allows all.
 }
 
 // NewPeer initializes a new Peer instance with the given network settings.
@@ -161,6 +162,10 @@ func (p *Peer) Connect(address string) error {
 		return fmt.Errorf("peer is already connected to address: %s", address)
 	}
 
+	if !p.isAllowed(address) {
+		return fmt.Errorf("connecting to address is not allowed: %s", address)
+	}
+
 	dialer := websocket.Dialer{
 		HandshakeTimeout: connWait,
 	}
@@ -214,8 +219,27 @@ func (p *Peer) readMessage(conn *websocket.Conn, address string) (string, error)
 	return string(messageBytes), nil
 }
 
+// ReceivedMessage is a message together with the address of the connection it was read from
+type ReceivedMessage struct {
+	Address string // address that was dialed, or the address the connecting peer listens on
+	Content string
+}
+
 // ReadMessages starts readMessage for every conn in readConns in the readRate interval.
 func (p *Peer) ReadMessages(messageCh chan<- string, errorCh chan<- error) {
+	receivedCh := make(chan ReceivedMessage)
+	go func() {
+		defer close(messageCh)
+		for received := range receivedCh {
+			messageCh <- received.Content
+		}
+	}()
+
+	p.ReadMessagesWithAddress(receivedCh, errorCh)
+}
+
+// ReadMessagesWithAddress is ReadMessages, every message comes with the address of its connection
+func (p *Peer) ReadMessagesWithAddress(messageCh chan<- ReceivedMessage, errorCh chan<- error) {
 	// WaitGroup is needed to ensure that we only close the channels when we have finished reading from every connection
 	var wg sync.WaitGroup
 
@@ -252,7 +276,7 @@ func (p *Peer) ReadMessages(messageCh chan<- string, errorCh chan<- error) {
 							errorCh <- errOffline
 						} else if msg != "" { // "" can happen when an error occurs when reading from
 							// the conn but the error is not due to the conn being closed.
-							messageCh <- msg
+							messageCh <- ReceivedMessage{Address: addr, Content: msg}
 						}
 					}(addr, conn)
 				}
@@ -310,6 +334,12 @@ func (p *Peer) IsConnectedTo(address string) bool {
 // handler is the HTTP request handler for upgrading incoming requests to websocket connections.
 // It accepts a websocket connection and adds it to the pool of read connections.
 func (p *Peer) handler(w http.ResponseWriter, r *http.Request) {
+	// Refused peers get the same answer as a peer that isn't available, so blocked peers don't learn that they were blocked
+	if !p.isAllowed(r.Header.Get("X-Peer-Address")) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("failed to upgrade incoming connection: %v", err)
@@ -322,6 +352,11 @@ func (p *Peer) handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// isAllowed checks if the peer may connect to the address or accept a connection from it.
+func (p *Peer) isAllowed(address string) bool {
+	return p.Allowed == nil || p.Allowed(address)
+}
+
 // handleNewConnection adds a newly established websocket connection to the readConns map.
 // It ensures that the total number of connections does not exceed the maximum allowed.
 func (p *Peer) handleNewConnection(conn *websocket.Conn, address string) error {
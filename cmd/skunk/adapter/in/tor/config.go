@@ -0,0 +1,129 @@
+package tor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultDataDir is the data directory if the configuration doesn't name one
+const DefaultDataDir = "tor-data"
+
+// systemSocksPort is the socks port of a tor daemon installed on the system
+const systemSocksPort = "9050"
+
+// DefaultConfig starts the embedded tor process with a constant onion address
+func DefaultConfig() TorConfig {
+	return TorConfig{
+		DataDir:              DefaultDataDir,
+		SocksPort:            "9055",
+		LocalPort:            "1111",
+		RemotePort:           "2222",
+		DeleteDataDirOnClose: false,
+		ReusePrivateKey:      true, // reuse private key for constant onion address
+		UseEmbedded:          embeddedCreator != nil,
+	}
+}
+
+// ConfigFromEnvironment overrides the configuration with the SKUNK_TOR_* environment variables:
+// SKUNK_TOR_DATA_DIR, SKUNK_TOR_SOCKS_PORT, SKUNK_TOR_LOCAL_PORT, SKUNK_TOR_REMOTE_PORT, SKUNK_TOR_REUSE_KEY, SKUNK_TOR_EMBEDDED
+// and, to attach to a running tor daemon, SKUNK_TOR_CONTROL_PORT, SKUNK_TOR_CONTROL_PASSWORD and SKUNK_TOR_COOKIE_FILE.
+// With a control port the socks port of the daemon is used, unless SKUNK_TOR_SOCKS_PORT names another one.
+func ConfigFromEnvironment(config TorConfig) (TorConfig, error) {
+	if controlPort, ok := os.LookupEnv("SKUNK_TOR_CONTROL_PORT"); ok {
+		config.ControlPort = controlPort
+		config.SocksPort = ""
+		config.UseEmbedded = false
+	}
+
+	values := map[string]*string{
+		"SKUNK_TOR_DATA_DIR":         &config.DataDir,
+		"SKUNK_TOR_SOCKS_PORT":       &config.SocksPort,
+		"SKUNK_TOR_LOCAL_PORT":       &config.LocalPort,
+		"SKUNK_TOR_REMOTE_PORT":      &config.RemotePort,
+		"SKUNK_TOR_CONTROL_PASSWORD": &config.ControlPassword,
+		"SKUNK_TOR_COOKIE_FILE":      &config.CookieFile,
+	}
+	for name, field := range values {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	flags := map[string]*bool{
+		"SKUNK_TOR_REUSE_KEY": &config.ReusePrivateKey,
+		"SKUNK_TOR_EMBEDDED":  &config.UseEmbedded,
+	}
+	for name, field := range flags {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return TorConfig{}, fmt.Errorf("%s has to be true or false, not %q", name, value)
+			}
+			*field = parsed
+		}
+	}
+
+	return config, nil
+}
+
+// Attach checks if the configuration attaches to a running tor daemon instead of starting tor
+func (c *TorConfig) Attach() bool {
+	return c.ControlPort != ""
+}
+
+// validate checks the ports and that the options fit to either starting tor or attaching to a running daemon
+func (c *TorConfig) validate() error {
+	if c.LocalPort == "" {
+		return fmt.Errorf("no local port given")
+	}
+	if c.RemotePort == "" {
+		return fmt.Errorf("no remote port given")
+	}
+
+	ports := []struct{ name, port string }{
+		{"socks port", c.SocksPort},
+		{"local port", c.LocalPort},
+		{"remote port", c.RemotePort},
+		{"control port", c.ControlPort},
+	}
+	for _, p := range ports {
+		if p.port == "" {
+			continue
+		}
+		number, err := strconv.Atoi(p.port)
+		if err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("invalid %s %q, ports are numbers from 1 to 65535", p.name, p.port)
+		}
+	}
+
+	if c.SocksPort != "" && (c.LocalPort == c.SocksPort || c.RemotePort == c.SocksPort) {
+		return fmt.Errorf("ports for hiddenservice can't match tor socks port")
+	}
+
+	if c.Attach() {
+		if c.UseEmbedded {
+			return fmt.Errorf("can't use the embedded tor process and attach to a control port")
+		}
+		if c.ControlPort == c.LocalPort || c.ControlPort == c.SocksPort {
+			return fmt.Errorf("the control port can't match the local port or the socks port")
+		}
+		return nil
+	}
+
+	// tor is started by Skunk
+	if c.SocksPort == "" {
+		return fmt.Errorf("no tor socks port provided")
+	}
+	if c.SocksPort == systemSocksPort {
+		return fmt.Errorf("can't use %s as tor socks port, it belongs to the tor daemon of the system", systemSocksPort)
+	}
+	if c.ControlPassword != "" || c.CookieFile != "" {
+		return fmt.Errorf("a control password or cookie file needs a control port")
+	}
+	if c.UseEmbedded && embeddedCreator == nil {
+		return fmt.Errorf("skunk was built without the embedded tor process (nolibtor), attach to a control port or use the tor executable")
+	}
+
+	return nil
+}
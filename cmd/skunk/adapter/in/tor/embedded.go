@@ -0,0 +1,11 @@
+//go:build !nolibtor
+
+package tor
+
+import (
+	"github.com/ipsn/go-libtor"
+)
+
+// embeddedCreator starts the tor process embedded in Skunk (go-libtor).
+// Build with -tags nolibtor to leave it out, Skunk then needs the tor executable or a running tor daemon.
+var embeddedCreator = libtor.Creator
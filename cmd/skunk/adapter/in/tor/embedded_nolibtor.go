@@ -0,0 +1,10 @@
+//go:build nolibtor
+
+package tor
+
+import (
+	"github.com/cretz/bine/process"
+)
+
+// embeddedCreator is nil, Skunk was built without the embedded tor process
+var embeddedCreator process.Creator
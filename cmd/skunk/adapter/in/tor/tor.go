@@ -4,22 +4,33 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cretz/bine/control"
 	"github.com/cretz/bine/tor"
-	"github.com/ipsn/go-libtor"
 )
 
+// PrivateKeyFile is the file in the data directory that holds the seed of the hidden service key
+const PrivateKeyFile = "hidden_service_private_key"
+
 // TorConfig holds configuration parameters for a Tor instance.
+// Tor is started by Skunk, unless a ControlPort is given, then Skunk attaches to the tor daemon listening on it.
 type TorConfig struct {
-	DataDir              string // directory for storing tor data files.
-	SocksPort            string // SOCKS5 proxy port for tor connection.
+	DataDir              string // directory for storing tor data files and the private key.
+	SocksPort            string // SOCKS5 proxy port for tor connection, asked from the daemon if empty when attaching.
 	LocalPort            string // local port for incoming connections.
 	RemotePort           string // remote port for hiddenservice.
+	ControlPort          string // control port of a running tor daemon (127.0.0.1) to attach to instead of starting tor.
+	ControlPassword      string // password of the control port, cookie authentication is used if empty.
+	CookieFile           string // authentication cookie of the daemon, if empty the daemon names it.
 	DeleteDataDirOnClose bool   // flag to delete data directory on closing tor.
 	ReusePrivateKey      bool   // flag to reuse the private key across sessions.
 	UseEmbedded          bool   // use the embedded tor process (go-libtor)
@@ -30,44 +41,38 @@ type Tor struct {
 	torConfig   *TorConfig // configuration for the tor instance.
 	torInstance *tor.Tor   // the tor instance.
 	onion       *tor.OnionService
+	identityKey ed25519.PrivateKey // key of the onion address
 }
 
 // NewTor initializes a new tor instance with the provided configuration.
 func NewTor(torConfig *TorConfig) (*Tor, error) {
 	// basic validation of configuration paramters.
-	if torConfig.SocksPort == "" {
-		return nil, fmt.Errorf("no tor socks port provided")
-	}
-	if torConfig.SocksPort == "9050" {
-		return nil, fmt.Errorf("can't use 9050 as tor socks port")
-	}
-	if torConfig.LocalPort == "" {
-		return nil, fmt.Errorf("no local port given")
-	}
-	if torConfig.RemotePort == "" {
-		return nil, fmt.Errorf("no remote port given")
-	}
-	if torConfig.LocalPort == torConfig.SocksPort || torConfig.RemotePort == torConfig.SocksPort {
-		return nil, fmt.Errorf("ports for hiddenservice can't match tor socks port")
+	if err := torConfig.validate(); err != nil {
+		return nil, err
 	}
 
 	if torConfig.DataDir == "" {
-		torConfig.DataDir = "tor-data"
+		torConfig.DataDir = DefaultDataDir
 	}
 
 	return &Tor{
 		torConfig:   torConfig,
 		torInstance: nil,
 		onion:       nil,
+		identityKey: nil,
 	}, nil
 }
 
-// StarTor starts the tor instance with the configured settings.
+// StarTor starts the tor instance with the configured settings, or attaches to the running tor daemon.
 func (t *Tor) StartTor() error {
 	if t.torInstance != nil {
 		return fmt.Errorf("can't start the same tor instance twice")
 	}
 
+	if t.torConfig.Attach() {
+		return t.attachTor()
+	}
+
 	conf := &tor.StartConf{
 		NoAutoSocksPort: true, // needs to be true to be able to set a custom socks port
 		ExtraArgs:       []string{"--SocksPort", t.torConfig.SocksPort},
@@ -77,7 +82,7 @@ func (t *Tor) StartTor() error {
 
 	// if configured use the go-libtor embedded tor process creator
 	if t.torConfig.UseEmbedded {
-		conf.ProcessCreator = libtor.Creator
+		conf.ProcessCreator = embeddedCreator
 	}
 
 	torInstance, err := tor.Start(nil, conf)
@@ -89,6 +94,95 @@ func (t *Tor) StartTor() error {
 	return nil
 }
 
+// attachTor connects to the control port of the running tor daemon. The daemon keeps running when tor is stopped,
+// only the hidden service is removed with the control connection.
+func (t *Tor) attachTor() error {
+	controlPort, err := strconv.Atoi(t.torConfig.ControlPort)
+	if err != nil {
+		return err
+	}
+
+	textConn, err := textproto.Dial("tcp", net.JoinHostPort("127.0.0.1", t.torConfig.ControlPort))
+	if err != nil {
+		return fmt.Errorf("failed to connect to the tor control port: %v", err)
+	}
+	controlConn := control.NewConn(textConn)
+
+	err = t.authenticate(controlConn)
+	if err != nil {
+		controlConn.Close()
+		return fmt.Errorf("failed to authenticate at the tor control port: %v", err)
+	}
+
+	if t.torConfig.SocksPort == "" {
+		socksPort, err := daemonSocksPort(controlConn)
+		if err != nil {
+			controlConn.Close()
+			return err
+		}
+		t.torConfig.SocksPort = socksPort
+	}
+
+	// the data dir only holds the private key, the daemon has its own
+	err = os.MkdirAll(t.torConfig.DataDir, 0700)
+	if err != nil {
+		controlConn.Close()
+		return err
+	}
+
+	t.torInstance = &tor.Tor{
+		Control:            controlConn,
+		ControlPort:        controlPort,
+		DataDir:            t.torConfig.DataDir,
+		StopProcessOnClose: false,
+	}
+	return nil
+}
+
+// authenticate authenticates with the password or the cookie of the configured cookie file,
+// otherwise with the authentication method and cookie file the daemon names.
+func (t *Tor) authenticate(controlConn *control.Conn) error {
+	if t.torConfig.ControlPassword != "" || t.torConfig.CookieFile == "" {
+		return controlConn.Authenticate(t.torConfig.ControlPassword)
+	}
+
+	cookie, err := os.ReadFile(t.torConfig.CookieFile)
+	if err != nil {
+		return fmt.Errorf("failed to read cookie file: %v", err)
+	}
+	_, err = controlConn.SendRequest("AUTHENTICATE %v", hex.EncodeToString(cookie))
+	if err != nil {
+		return err
+	}
+	controlConn.Authenticated = true
+	return nil
+}
+
+// daemonSocksPort asks the daemon for the port of its first socks listener.
+// The listeners are a list of quoted addresses, which GetInfo can't unquote, so the response is parsed here.
+func daemonSocksPort(controlConn *control.Conn) (string, error) {
+	response, err := controlConn.SendRequest("GETINFO net/listeners/socks")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range response.Data {
+		_, listeners, _ := strings.Cut(line, "=")
+		for _, address := range strings.Fields(listeners) {
+			_, port, err := net.SplitHostPort(strings.Trim(address, `"`))
+			if err == nil {
+				return port, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("the tor daemon has no socks port, configure one or set SocksPort")
+}
+
+// SocksAddress returns the address of the socks proxy, when attached the port of the daemon is known after StartTor
+func (t *Tor) SocksAddress() string {
+	return net.JoinHostPort("127.0.0.1", t.torConfig.SocksPort)
+}
+
 // StartHiddenService starts a hidden service using the current tor instance.
 func (t *Tor) StartHiddenService() (*tor.OnionService, error) {
 	if t.torInstance == nil {
@@ -112,31 +206,12 @@ func (t *Tor) StartHiddenService() (*tor.OnionService, error) {
 		RemotePorts: []int{remotePortInt},
 	}
 
-	// attempt to read the private key if ReusePrivateKey is true
-	if t.torConfig.ReusePrivateKey {
-		privateKeyPath := filepath.Join(t.torConfig.DataDir, "hidden_service_private_key")
-		var privateKey ed25519.PrivateKey
-
-		if _, err := os.Stat(privateKeyPath); err == nil {
-			keyData, readErr := os.ReadFile(privateKeyPath)
-			if readErr != nil {
-				return nil, fmt.Errorf("failed to read private key: %v", readErr)
-			}
-			privateKey = ed25519.PrivateKey(keyData)
-		} else {
-			// generate a new private key
-			_, privKey, genErr := ed25519.GenerateKey(rand.Reader)
-			if genErr != nil {
-				return nil, fmt.Errorf("failed to generate private key: %v", genErr)
-			}
-			privateKey = privKey
-			// save newly generated private key
-			if err := os.WriteFile(privateKeyPath, privKey.Seed(), 0600); err != nil {
-				return nil, fmt.Errorf("failed to save private key: %v", err)
-			}
-		}
-		conf.Key = privateKey
+	// the key is generated here, not by tor, so the onion address can sign what this peer issues
+	privateKey, err := t.privateKey()
+	if err != nil {
+		return nil, err
 	}
+	conf.Key = privateKey
 
 	// wait at most a few minutes to publish the service
 	listenCtx, listenCancel := context.WithTimeout(context.Background(), 3*time.Minute)
@@ -147,10 +222,53 @@ func (t *Tor) StartHiddenService() (*tor.OnionService, error) {
 		return nil, err
 	}
 	t.onion = onion
+	t.identityKey = privateKey
 
 	return onion, nil
 }
 
+// IdentityKey returns the private key of the onion address, nil before the hidden service was started
+func (t *Tor) IdentityKey() ed25519.PrivateKey {
+	return t.identityKey
+}
+
+// privateKey reads the private key of the hidden service from the data directory if ReusePrivateKey is true,
+// otherwise, or if there is none yet, a new key is generated. New keys are only saved to be reused.
+func (t *Tor) privateKey() (ed25519.PrivateKey, error) {
+	privateKeyPath := filepath.Join(t.torConfig.DataDir, PrivateKeyFile)
+	if t.torConfig.ReusePrivateKey {
+		if _, err := os.Stat(privateKeyPath); err == nil {
+			keyData, readErr := os.ReadFile(privateKeyPath)
+			if readErr != nil {
+				return nil, fmt.Errorf("failed to read private key: %v", readErr)
+			}
+			// the file holds the seed, a complete key is accepted too
+			switch len(keyData) {
+			case ed25519.SeedSize:
+				return ed25519.NewKeyFromSeed(keyData), nil
+			case ed25519.PrivateKeySize:
+				return ed25519.PrivateKey(keyData), nil
+			default:
+				return nil, fmt.Errorf("invalid private key in %v", privateKeyPath)
+			}
+		}
+	}
+
+	// generate a new private key
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+
+	if t.torConfig.ReusePrivateKey {
+		// save newly generated private key
+		if err := os.WriteFile(privateKeyPath, privateKey.Seed(), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save private key: %v", err)
+		}
+	}
+	return privateKey, nil
+}
+
 // StopTor stops the tor instance (and hiddenservice) and handles cleanup.
 func (t *Tor) StopTor() error {
 	if t.onion == nil {
@@ -174,5 +292,6 @@ func (t *Tor) StopTor() error {
 
 	t.torInstance = nil
 	t.onion = nil
+	t.identityKey = nil
 	return nil
 }
@@ -0,0 +1,1015 @@
+package storageMemoryAdapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version orders last-writer-wins updates by their timestamp, updates with the same timestamp by their message id
+type version struct {
+	timestamp int64
+	messageID string
+}
+
+// before checks if the version is older than the other one
+func (v version) before(other version) bool {
+	return v.timestamp < other.timestamp || (v.timestamp == other.timestamp && v.messageID < other.messageID)
+}
+
+type chat struct {
+	store.Chat
+	metadata      map[store.ChatMetadataField]version
+	messageExpiry int64
+	expiryVersion version
+}
+
+type member struct {
+	peerID   string
+	username string
+	role     store.ChatRole
+}
+
+type invitation struct {
+	status    store.InvitationStatus
+	expiresAt int64
+	peers     []store.PublicKeyAddress
+}
+
+type edit struct {
+	store.MessageEdit
+	messageID string
+}
+
+type reactionKey struct {
+	messageID string
+	peerID    string
+	emoji     string
+}
+
+type reaction struct {
+	active  bool
+	version version
+}
+
+type blockedPeer struct {
+	address string
+	date    int64
+}
+
+// StorageMemoryAdapter keeps everything in memory and behaves like the SQLite adapter.
+// Nothing is written to disk, so all data is gone when the process ends.
+type StorageMemoryAdapter struct {
+	mutex sync.RWMutex
+
+	chats        map[string]*chat
+	chatOrder    []string
+	members      map[string][]*member // by chat id, members don't need a stored chat
+	bans         map[string]map[string]bool
+	peers        map[string]string // address by public key
+	peerOrder    []string
+	messages     map[string]*network.Message
+	messageOrder []string
+	invitations  map[string]*invitation // by the id of the INVITE_TO_CHAT message
+	inviteTokens map[string]string
+	edits        map[string]*edit // by the id of the EDIT_MESSAGE message
+	deleted      map[string]bool
+	reactions    map[reactionKey]*reaction
+	retention    map[string]store.RetentionPolicy
+	pruned       map[string]store.PrunedRange
+	presence     map[string]store.Presence
+	contacts     map[string]store.Contact
+	blocked      []blockedPeer
+	passphrase   []byte // hash of the passphrase, nil if there is none
+}
+
+// NewStorageMemoryAdapter creates an empty storage
+func NewStorageMemoryAdapter() *StorageMemoryAdapter {
+	return &StorageMemoryAdapter{
+		chats:        map[string]*chat{},
+		members:      map[string][]*member{},
+		bans:         map[string]map[string]bool{},
+		peers:        map[string]string{},
+		messages:     map[string]*network.Message{},
+		invitations:  map[string]*invitation{},
+		inviteTokens: map[string]string{},
+		edits:        map[string]*edit{},
+		deleted:      map[string]bool{},
+		reactions:    map[reactionKey]*reaction{},
+		retention:    map[string]store.RetentionPolicy{},
+		pruned:       map[string]store.PrunedRange{},
+		presence:     map[string]store.Presence{},
+		contacts:     map[string]store.Contact{},
+	}
+}
+
+func (a *StorageMemoryAdapter) ChatCreated(chatName string, chatId string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.createChatIfNotExists(chatId, chatName)
+	return nil
+}
+
+func (a *StorageMemoryAdapter) PeerSetUsername(peerID, chatID, username string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.insertPeerIfNotExists(peerID, "")
+	if _, exists := a.chats[chatID]; !exists {
+		return fmt.Errorf("chat with ID %s does not exist", chatID)
+	}
+
+	if m := a.member(peerID, chatID); m != nil {
+		m.username = username
+	}
+	return nil
+}
+
+func (a *StorageMemoryAdapter) PeerJoinedChat(timestamp int64, peerID, chatID string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.joinChat(peerID, chatID)
+	return nil
+}
+
+func (a *StorageMemoryAdapter) PeerLeftChat(peerID, chatID string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.leaveChat(peerID, chatID)
+	return nil
+}
+
+// SetPeerRole sets the role of a member in a chat
+func (a *StorageMemoryAdapter) SetPeerRole(peerID, chatID string, role store.ChatRole) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	m := a.member(peerID, chatID)
+	if m == nil {
+		return fmt.Errorf("peer %s is not a member of chat %s", peerID, chatID)
+	}
+	m.role = role
+	return nil
+}
+
+// GetPeerRole returns the role of a member in a chat
+func (a *StorageMemoryAdapter) GetPeerRole(peerID, chatID string) (store.ChatRole, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	m := a.member(peerID, chatID)
+	if m == nil {
+		return store.ROLE_MEMBER, fmt.Errorf("peer %s is not a member of chat %s", peerID, chatID)
+	}
+	return m.role, nil
+}
+
+// PeerBannedFromChat removes the peer from the chat and prevents it from joining again
+func (a *StorageMemoryAdapter) PeerBannedFromChat(timestamp int64, peerID, chatID string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.leaveChat(peerID, chatID)
+	if a.bans[chatID] == nil {
+		a.bans[chatID] = map[string]bool{}
+	}
+	a.bans[chatID][peerID] = true
+	return nil
+}
+
+// IsBannedFromChat checks if the peer was banned from the chat
+func (a *StorageMemoryAdapter) IsBannedFromChat(peerID, chatID string) (bool, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.bans[chatID][peerID], nil
+}
+
+// UpdateChatMetadata changes a metadata field of the chat if the update is newer than the stored one (last writer wins).
+// Updates with the same timestamp are ordered by their message id, so all peers keep the same value.
+func (a *StorageMemoryAdapter) UpdateChatMetadata(timestamp int64, messageID, chatID string, field store.ChatMetadataField, value string) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := a.createChatIfNotExists(chatID, "")
+	var target *string
+	switch field {
+	case store.CHAT_NAME:
+		target = &c.ChatName
+	case store.CHAT_TOPIC:
+		target = &c.Topic
+	case store.CHAT_DESCRIPTION:
+		target = &c.Description
+	default:
+		return false, fmt.Errorf("unknown chat metadata field: %d", field)
+	}
+
+	update := version{timestamp, messageID}
+	if !c.metadata[field].before(update) {
+		return false, nil
+	}
+	c.metadata[field] = update
+	*target = value
+	return true, nil
+}
+
+// SetMessageExpiry sets how long messages of the chat are kept if the setting is newer than the stored one (last writer wins).
+// Settings with the same timestamp are ordered by their message id, so all peers keep the same value.
+func (a *StorageMemoryAdapter) SetMessageExpiry(timestamp int64, messageID, chatID string, expiry int64) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := a.createChatIfNotExists(chatID, "")
+	update := version{timestamp, messageID}
+	if !c.expiryVersion.before(update) {
+		return false, nil
+	}
+	c.expiryVersion = update
+	c.messageExpiry = expiry
+	return true, nil
+}
+
+// GetMessageExpiry returns how long messages of the chat are kept, 0 if they don't disappear or the chat is unknown
+func (a *StorageMemoryAdapter) GetMessageExpiry(chatID string) (int64, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if c, exists := a.chats[chatID]; exists {
+		return c.messageExpiry, nil
+	}
+	return 0, nil
+}
+
+// GetMessageExpiries returns the message expiry of all chats with disappearing messages
+func (a *StorageMemoryAdapter) GetMessageExpiries() (map[string]int64, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	expiries := map[string]int64{}
+	for chatID, c := range a.chats {
+		if c.messageExpiry > 0 {
+			expiries[chatID] = c.messageExpiry
+		}
+	}
+	return expiries, nil
+}
+
+// PurgeMessages removes the messages of the chat with the operations that are older than before.
+// Their edit history and the reactions to them are removed as well.
+func (a *StorageMemoryAdapter) PurgeMessages(chatID string, before int64, operations []network.OperationType) ([]network.Message, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	messages, err := a.chatMessagePage(store.MessagePage{ChatID: chatID})
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []network.Message
+	for _, message := range messages {
+		if message.Timestamp >= before || !containsOperation(operations, message.Operation) {
+			continue
+		}
+
+		a.removeMessage(message.Id)
+		for editID, e := range a.edits {
+			if e.messageID == message.Id || editID == message.Id {
+				delete(a.edits, editID)
+			}
+		}
+		for key := range a.reactions {
+			if key.messageID == message.Id {
+				delete(a.reactions, key)
+			}
+		}
+
+		purged = append(purged, message)
+	}
+
+	return purged, nil
+}
+
+// containsOperation checks if the operation is in the list of operations
+func containsOperation(operations []network.OperationType, operation network.OperationType) bool {
+	for _, o := range operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectChatCreated creates the direct chat with the peer if it doesn't exist yet, both peers are its only members
+func (a *StorageMemoryAdapter) DirectChatCreated(timestamp int64, chatID, peerID, peerAddress string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	c := a.createChatIfNotExists(chatID, "")
+	if c.DirectPeerId == "" {
+		c.DirectPeerId = peerID
+		c.DirectPeerAddress = peerAddress
+	}
+
+	for _, m := range []string{"self", peerID} {
+		a.joinChat(m, chatID)
+	}
+	return nil
+}
+
+func (a *StorageMemoryAdapter) InvitedToChat(messageID string, peers []store.PublicKeyAddress) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	i := a.invitations[messageID]
+	if i == nil {
+		i = &invitation{status: store.INVITATION_PENDING}
+		a.invitations[messageID] = i
+	}
+
+	for _, peer := range peers {
+		if !containsPeer(i.peers, peer) {
+			i.peers = append(i.peers, peer)
+		}
+	}
+	return nil
+}
+
+// containsPeer checks if the peer is in the list of peers
+func containsPeer(peers []store.PublicKeyAddress, peer store.PublicKeyAddress) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerGotInvitedToChat records a pending invitation for the INVITE_TO_CHAT message with the given ID.
+// If the invitation already exists, only its expiry is updated.
+func (a *StorageMemoryAdapter) PeerGotInvitedToChat(messageID string, expiresAt int64) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	i := a.invitations[messageID]
+	if i == nil {
+		i = &invitation{status: store.INVITATION_PENDING}
+		a.invitations[messageID] = i
+	}
+	i.expiresAt = expiresAt
+	return nil
+}
+
+// GetInvitations returns the IDs of all chats the peer has an open or accepted invitation for.
+// Declined, revoked and expired invitations are not returned.
+func (a *StorageMemoryAdapter) GetInvitations(peerID string) ([]string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	now := time.Now().UnixNano()
+	var invitations []string
+	for _, messageID := range a.messageOrder {
+		message := a.messages[messageID]
+		i, exists := a.invitations[messageID]
+		if !exists || message.ReceiverID != peerID || message.Operation != network.INVITE_TO_CHAT {
+			continue
+		}
+
+		switch {
+		case i.status == store.INVITATION_ACCEPTED:
+		case i.status == store.INVITATION_PENDING && (i.expiresAt == 0 || i.expiresAt > now):
+		default:
+			continue
+		}
+		invitations = append(invitations, message.ChatID)
+	}
+
+	return invitations, nil
+}
+
+// GetInvitation returns the invitation belonging to the INVITE_TO_CHAT message with the given ID.
+// Pending invitations whose expiry has passed are reported as expired.
+func (a *StorageMemoryAdapter) GetInvitation(messageID string) (store.Invitation, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	i, exists := a.invitations[messageID]
+	message, stored := a.messages[messageID]
+	if !exists || !stored {
+		return store.Invitation{}, fmt.Errorf("no invitation with message ID %s", messageID)
+	}
+
+	invitation := store.Invitation{
+		MessageId:      messageID,
+		ChatId:         message.ChatID,
+		InviterId:      message.SenderID,
+		InviterAddress: message.SenderAddress,
+		InviteeId:      message.ReceiverID,
+		InviteeAddress: message.ReceiverAddress,
+		Status:         i.status,
+		ExpiresAt:      i.expiresAt,
+	}
+	if invitation.Status == store.INVITATION_PENDING && invitation.ExpiresAt != 0 && invitation.ExpiresAt <= time.Now().UnixNano() {
+		invitation.Status = store.INVITATION_EXPIRED
+	}
+
+	return invitation, nil
+}
+
+// SetInvitationStatus moves a pending invitation to the given status.
+// Invitations that are no longer pending are left untouched, so the first response wins.
+func (a *StorageMemoryAdapter) SetInvitationStatus(messageID string, status store.InvitationStatus) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	i, exists := a.invitations[messageID]
+	if !exists || i.status != store.INVITATION_PENDING {
+		return fmt.Errorf("no pending invitation with message ID %s", messageID)
+	}
+	i.status = status
+	return nil
+}
+
+// InviteTokenUsed records that the invite token with the given secret was used by a peer.
+// Only the first use is recorded, since invite tokens are one-time tokens.
+func (a *StorageMemoryAdapter) InviteTokenUsed(secret string, peerID string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, used := a.inviteTokens[secret]; !used {
+		a.inviteTokens[secret] = peerID
+	}
+	return nil
+}
+
+// GetInviteTokenUser returns the peer that used the invite token with the given secret, or "" if it is unused.
+func (a *StorageMemoryAdapter) GetInviteTokenUser(secret string) (string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.inviteTokens[secret], nil
+}
+
+// GetMissingInternalMessages returns the message IDs from inputMessageIDs that are not stored for the chat
+func (a *StorageMemoryAdapter) GetMissingInternalMessages(chatID string, inputMessageIDs []string) ([]string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var missing []string
+	for _, id := range inputMessageIDs {
+		if message, exists := a.messages[id]; !exists || message.ChatID != chatID {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// GetMissingExternalMessages returns the IDs of the stored messages of the chat that are not in inputMessageIDs
+func (a *StorageMemoryAdapter) GetMissingExternalMessages(chatID string, inputMessageIDs []string) ([]string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	known := make(map[string]bool, len(inputMessageIDs))
+	for _, id := range inputMessageIDs {
+		known[id] = true
+	}
+
+	var missing []string
+	for _, id := range a.messageOrder {
+		if a.messages[id].ChatID == chatID && !known[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (a *StorageMemoryAdapter) StoreMessage(message network.Message) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.insertPeerIfNotExists(message.SenderID, message.SenderAddress)
+	a.insertPeerIfNotExists(message.ReceiverID, message.ReceiverAddress)
+
+	if _, exists := a.messages[message.Id]; exists {
+		return nil
+	}
+	a.messages[message.Id] = &message
+	a.messageOrder = append(a.messageOrder, message.Id)
+
+	// The deletion of a message can arrive before the message itself
+	if a.deleted[message.Id] {
+		a.clearMessageContent(message.Id)
+	}
+	return nil
+}
+
+// replyTo returns the id of the message a SEND_MESSAGE message replies to, "" if it is no reply
+func replyTo(message network.Message) string {
+	if message.Operation != network.SEND_MESSAGE {
+		return ""
+	}
+
+	var content struct {
+		ReplyTo string `json:"replyTo"`
+	}
+	json.Unmarshal([]byte(message.Content), &content)
+	return content.ReplyTo
+}
+
+// MessageEdited adds a new version of the message to its edit history
+func (a *StorageMemoryAdapter) MessageEdited(timestamp int64, editID, messageID, content string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	deleted := a.deleted[messageID]
+	if deleted {
+		content = ""
+	}
+
+	if _, exists := a.edits[editID]; !exists {
+		a.edits[editID] = &edit{MessageEdit: store.MessageEdit{EditId: editID, Content: content, Timestamp: timestamp}, messageID: messageID}
+	}
+	if deleted {
+		a.clearMessageContent(editID)
+	}
+	return nil
+}
+
+// MessageDeleted leaves a tombstone for the message and removes the content of the message and all its edits
+func (a *StorageMemoryAdapter) MessageDeleted(timestamp int64, messageID string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.deleted[messageID] = true
+	for editID, e := range a.edits {
+		if e.messageID == messageID {
+			e.Content = ""
+			a.clearMessageContent(editID)
+		}
+	}
+	a.clearMessageContent(messageID)
+	return nil
+}
+
+// clearMessageContent removes the text of a SEND_MESSAGE or EDIT_MESSAGE message, the message stays valid JSON
+func (a *StorageMemoryAdapter) clearMessageContent(messageID string) {
+	message, exists := a.messages[messageID]
+	if !exists {
+		return
+	}
+
+	// Keep the reference to the edited message, everything else is removed
+	var content struct {
+		MessageID string `json:"messageId,omitempty"`
+		Message   string `json:"message"`
+	}
+	json.Unmarshal([]byte(message.Content), &content)
+	content.Message = ""
+	cleared, _ := json.Marshal(content)
+	message.Content = string(cleared)
+}
+
+// GetMessageEdits returns the edit history of the message, oldest edit first
+func (a *StorageMemoryAdapter) GetMessageEdits(messageID string) ([]store.MessageEdit, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.messageEdits(messageID), nil
+}
+
+func (a *StorageMemoryAdapter) messageEdits(messageID string) []store.MessageEdit {
+	var edits []store.MessageEdit
+	for _, e := range a.edits {
+		if e.messageID == messageID {
+			edits = append(edits, e.MessageEdit)
+		}
+	}
+
+	sort.Slice(edits, func(i, j int) bool {
+		return version{edits[i].Timestamp, edits[i].EditId}.before(version{edits[j].Timestamp, edits[j].EditId})
+	})
+	return edits
+}
+
+// IsMessageDeleted checks if there is a tombstone for the message
+func (a *StorageMemoryAdapter) IsMessageDeleted(messageID string) (bool, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.deleted[messageID], nil
+}
+
+func (a *StorageMemoryAdapter) RetrieveMessage(messageID string) (network.Message, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	message, exists := a.messages[messageID]
+	if !exists {
+		return network.Message{}, fmt.Errorf("message %s is not stored", messageID)
+	}
+	return *message, nil
+}
+
+func (a *StorageMemoryAdapter) GetChats() ([]store.Chat, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var chats []store.Chat
+	for _, chatID := range a.chatOrder {
+		chats = append(chats, a.chats[chatID].Chat)
+	}
+	return chats, nil
+}
+
+func (a *StorageMemoryAdapter) GetUsername(peerID, chatID string) (string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	m := a.member(peerID, chatID)
+	if m == nil {
+		return "", fmt.Errorf("peer %s is not a member of chat %s", peerID, chatID)
+	}
+	return m.username, nil
+}
+
+func (a *StorageMemoryAdapter) GetUsersInChat(chatID string) ([]store.User, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var users []store.User
+	for _, m := range a.members[chatID] {
+		users = append(users, store.User{UserId: m.peerID, Username: m.username, Role: m.role})
+	}
+	return users, nil
+}
+
+func (a *StorageMemoryAdapter) GetPeers() ([]string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return append([]string(nil), a.peerOrder...), nil
+}
+
+// GetChatMessages returns all messages of the chat, oldest message first
+func (a *StorageMemoryAdapter) GetChatMessages(chatID string) ([]network.Message, error) {
+	return a.GetChatMessagePage(store.MessagePage{ChatID: chatID})
+}
+
+// GetChatMessagePage returns the messages of the chat between the cursors, oldest message first.
+// Messages are ordered by their timestamp, messages with the same timestamp by their id.
+func (a *StorageMemoryAdapter) GetChatMessagePage(page store.MessagePage) ([]network.Message, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.chatMessagePage(page)
+}
+
+func (a *StorageMemoryAdapter) chatMessagePage(page store.MessagePage) ([]network.Message, error) {
+	var bounds []version
+	for _, cursor := range []string{page.Before, page.After} {
+		if cursor == "" {
+			bounds = append(bounds, version{})
+			continue
+		}
+		message, exists := a.messages[cursor]
+		if !exists || message.ChatID != page.ChatID {
+			return nil, fmt.Errorf("message %s is not stored in chat %s", cursor, page.ChatID)
+		}
+		bounds = append(bounds, messageVersion(*message))
+	}
+
+	var messages []network.Message
+	for _, id := range a.messageOrder {
+		message := *a.messages[id]
+		if message.ChatID != page.ChatID ||
+			(page.Before != "" && !messageVersion(message).before(bounds[0])) ||
+			(page.After != "" && !bounds[1].before(messageVersion(message))) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sortMessages(messages)
+
+	if page.Limit > 0 && len(messages) > page.Limit {
+		// Without After the newest messages are returned
+		if page.After == "" {
+			messages = messages[len(messages)-page.Limit:]
+		} else {
+			messages = messages[:page.Limit]
+		}
+	}
+	return messages, nil
+}
+
+// messageVersion returns the position of the message in the order of the chat
+func messageVersion(message network.Message) version {
+	return version{message.Timestamp, message.Id}
+}
+
+// sortMessages orders the messages by their timestamp, messages with the same timestamp by their id
+func sortMessages(messages []network.Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messageVersion(messages[i]).before(messageVersion(messages[j]))
+	})
+}
+
+// GetMembershipMessages returns all messages of the chat that change its members or their roles
+func (a *StorageMemoryAdapter) GetMembershipMessages(chatID string) ([]network.Message, error) {
+	messages, err := a.GetChatMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var membershipMessages []network.Message
+	for _, message := range messages {
+		switch message.Operation {
+		case network.JOIN_CHAT, network.LEAVE_CHAT, network.REMOVE_MEMBER, network.BAN_MEMBER, network.SET_ROLE:
+			membershipMessages = append(membershipMessages, message)
+		}
+	}
+
+	return membershipMessages, nil
+}
+
+// PeerReacted adds or removes the reaction of the peer if it is newer than the stored one (last writer wins).
+// Reactions with the same timestamp are ordered by the id of the REACT message.
+func (a *StorageMemoryAdapter) PeerReacted(timestamp int64, reactionID, messageID, peerID, emoji string, add bool) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := reactionKey{messageID, peerID, emoji}
+	update := version{timestamp, reactionID}
+	if stored, exists := a.reactions[key]; exists && !stored.version.before(update) {
+		return false, nil
+	}
+	a.reactions[key] = &reaction{active: add, version: update}
+	return true, nil
+}
+
+// GetReactions returns how many peers reacted with each emoji to the message, most used emoji first
+func (a *StorageMemoryAdapter) GetReactions(messageID string) ([]store.Reaction, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	counts := map[string]int{}
+	for key, r := range a.reactions {
+		if key.messageID == messageID && r.active {
+			counts[key.emoji]++
+		}
+	}
+
+	var reactions []store.Reaction
+	for emoji, count := range counts {
+		reactions = append(reactions, store.Reaction{Emoji: emoji, Count: count})
+	}
+	sort.Slice(reactions, func(i, j int) bool {
+		if reactions[i].Count == reactions[j].Count {
+			return reactions[i].Emoji < reactions[j].Emoji
+		}
+		return reactions[i].Count > reactions[j].Count
+	})
+	return reactions, nil
+}
+
+// GetReplies returns all replies to the message, oldest reply first.
+// Replies are linked by id, so replies that arrived before their parent are found as well.
+func (a *StorageMemoryAdapter) GetReplies(messageID string) ([]network.Message, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var replies []network.Message
+	for _, id := range a.messageOrder {
+		if message := *a.messages[id]; replyTo(message) == messageID && messageID != "" {
+			replies = append(replies, message)
+		}
+	}
+	sortMessages(replies)
+	return replies, nil
+}
+
+// PeerSeen marks the peer as online, the last seen time never goes back
+func (a *StorageMemoryAdapter) PeerSeen(address string, timestamp int64) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	presence := a.presence[address]
+	presence.Address = address
+	presence.Online = true
+	if timestamp > presence.LastSeen {
+		presence.LastSeen = timestamp
+	}
+	a.presence[address] = presence
+	return nil
+}
+
+// PeerOffline marks the peer as offline and keeps the time it was last seen
+func (a *StorageMemoryAdapter) PeerOffline(address string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	presence := a.presence[address]
+	presence.Address = address
+	presence.Online = false
+	a.presence[address] = presence
+	return nil
+}
+
+// GetPresence returns the last known state of the peer, peers that were never seen are offline
+func (a *StorageMemoryAdapter) GetPresence(address string) (store.Presence, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if presence, exists := a.presence[address]; exists {
+		return presence, nil
+	}
+	return store.Presence{Address: address}, nil
+}
+
+// SaveContact adds the contact to the address book or replaces the contact with the same address.
+// The blocked flag of the contact adds its address to the block list or removes it.
+func (a *StorageMemoryAdapter) SaveContact(contact store.Contact) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if contact.Blocked {
+		a.blockPeer(time.Now().UnixNano(), contact.Address)
+	} else {
+		a.unblockPeer(contact.Address)
+	}
+
+	contact.Blocked = false
+	a.contacts[contact.Address] = contact
+	return nil
+}
+
+func (a *StorageMemoryAdapter) RemoveContact(address string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.contacts[address]; !exists {
+		return fmt.Errorf("contact with address %s does not exist", address)
+	}
+	delete(a.contacts, address)
+	return nil
+}
+
+func (a *StorageMemoryAdapter) GetContact(address string) (store.Contact, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	contact, exists := a.contacts[address]
+	if !exists {
+		return store.Contact{}, fmt.Errorf("contact with address %s does not exist", address)
+	}
+	contact.Blocked = a.isBlocked(address)
+	return contact, nil
+}
+
+// GetContacts returns the whole address book sorted by nickname
+func (a *StorageMemoryAdapter) GetContacts() ([]store.Contact, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	contacts := []store.Contact{}
+	for _, contact := range a.contacts {
+		contact.Blocked = a.isBlocked(contact.Address)
+		contacts = append(contacts, contact)
+	}
+
+	sort.Slice(contacts, func(i, j int) bool {
+		first, second := strings.ToLower(contacts[i].Nickname), strings.ToLower(contacts[j].Nickname)
+		if first == second {
+			return contacts[i].Address < contacts[j].Address
+		}
+		return first < second
+	})
+	return contacts, nil
+}
+
+func (a *StorageMemoryAdapter) BlockPeer(timestamp int64, address string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.blockPeer(timestamp, address)
+	return nil
+}
+
+func (a *StorageMemoryAdapter) UnblockPeer(address string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.unblockPeer(address)
+	return nil
+}
+
+func (a *StorageMemoryAdapter) IsBlocked(address string) (bool, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.isBlocked(address), nil
+}
+
+// GetBlockedPeers returns the blocked addresses, the peer blocked first comes first
+func (a *StorageMemoryAdapter) GetBlockedPeers() ([]string, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	blocked := append([]blockedPeer(nil), a.blocked...)
+	sort.Slice(blocked, func(i, j int) bool {
+		if blocked[i].date == blocked[j].date {
+			return blocked[i].address < blocked[j].address
+		}
+		return blocked[i].date < blocked[j].date
+	})
+
+	addresses := []string{}
+	for _, peer := range blocked {
+		addresses = append(addresses, peer.address)
+	}
+	return addresses, nil
+}
+
+// blockPeer adds the address to the block list, an address that is already blocked keeps its date
+func (a *StorageMemoryAdapter) blockPeer(timestamp int64, address string) {
+	if !a.isBlocked(address) {
+		a.blocked = append(a.blocked, blockedPeer{address: address, date: timestamp})
+	}
+}
+
+func (a *StorageMemoryAdapter) unblockPeer(address string) {
+	for i, peer := range a.blocked {
+		if peer.address == address {
+			a.blocked = append(a.blocked[:i], a.blocked[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *StorageMemoryAdapter) isBlocked(address string) bool {
+	for _, peer := range a.blocked {
+		if peer.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *StorageMemoryAdapter) createChatIfNotExists(chatID, chatName string) *chat {
+	c, exists := a.chats[chatID]
+	if !exists {
+		c = &chat{Chat: store.Chat{ChatId: chatID, ChatName: chatName}, metadata: map[store.ChatMetadataField]version{}}
+		a.chats[chatID] = c
+		a.chatOrder = append(a.chatOrder, chatID)
+	}
+	return c
+}
+
+func (a *StorageMemoryAdapter) insertPeerIfNotExists(publicKey, address string) {
+	if _, exists := a.peers[publicKey]; !exists {
+		a.peers[publicKey] = address
+		a.peerOrder = append(a.peerOrder, publicKey)
+	}
+}
+
+// member returns the membership of the peer in the chat, nil if it isn't a member
+func (a *StorageMemoryAdapter) member(peerID, chatID string) *member {
+	for _, m := range a.members[chatID] {
+		if m.peerID == peerID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (a *StorageMemoryAdapter) joinChat(peerID, chatID string) {
+	if a.member(peerID, chatID) == nil {
+		a.members[chatID] = append(a.members[chatID], &member{peerID: peerID})
+	}
+}
+
+func (a *StorageMemoryAdapter) leaveChat(peerID, chatID string) {
+	for i, m := range a.members[chatID] {
+		if m.peerID == peerID {
+			a.members[chatID] = append(a.members[chatID][:i], a.members[chatID][i+1:]...)
+			return
+		}
+	}
+}
+
+// removeMessage removes the message and keeps the order of the other messages
+func (a *StorageMemoryAdapter) removeMessage(messageID string) {
+	delete(a.messages, messageID)
+	for i, id := range a.messageOrder {
+		if id == messageID {
+			a.messageOrder = append(a.messageOrder[:i], a.messageOrder[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package storageMemoryAdapter
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// The memory is never written to disk, so nothing has to be encrypted. The passphrase is only kept to answer
+// like an encrypted database, a storage that only lives as long as the process is never locked.
+
+// IsEncrypted checks if the storage has a passphrase
+func (a *StorageMemoryAdapter) IsEncrypted() (bool, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.passphrase != nil, nil
+}
+
+// SetPassphrase sets the passphrase of a storage that has none yet
+func (a *StorageMemoryAdapter) SetPassphrase(passphrase string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.passphrase != nil {
+		return errors.New("the database already has a passphrase")
+	}
+
+	a.passphrase = hashPassphrase(passphrase)
+	return nil
+}
+
+// Unlock checks the passphrase, the storage itself is never locked
+func (a *StorageMemoryAdapter) Unlock(passphrase string) error {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.checkPassphrase(passphrase)
+}
+
+// ChangePassphrase replaces the passphrase if the old one matches
+func (a *StorageMemoryAdapter) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.checkPassphrase(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	a.passphrase = hashPassphrase(newPassphrase)
+	return nil
+}
+
+// checkPassphrase compares the passphrase with the stored one, the caller has to hold the lock
+func (a *StorageMemoryAdapter) checkPassphrase(passphrase string) error {
+	if a.passphrase == nil {
+		return errors.New("the database has no passphrase")
+	}
+	if subtle.ConstantTimeCompare(a.passphrase, hashPassphrase(passphrase)) != 1 {
+		return store.ErrWrongPassphrase
+	}
+	return nil
+}
+
+func hashPassphrase(passphrase string) []byte {
+	hash := sha256.Sum256([]byte(passphrase))
+	return hash[:]
+}
@@ -0,0 +1,109 @@
+package storageMemoryAdapter
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// SetRetentionPolicy sets how much of the chat is kept, a policy without limits removes the policy of the chat
+func (a *StorageMemoryAdapter) SetRetentionPolicy(chatID string, policy store.RetentionPolicy) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if policy == (store.RetentionPolicy{}) {
+		delete(a.retention, chatID)
+	} else {
+		a.retention[chatID] = policy
+	}
+	return nil
+}
+
+// GetRetentionPolicy returns the retention policy of the chat, a policy without limits if the chat has none
+func (a *StorageMemoryAdapter) GetRetentionPolicy(chatID string) (store.RetentionPolicy, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.retention[chatID], nil
+}
+
+// GetRetentionPolicies returns the retention policies of all chats that have one
+func (a *StorageMemoryAdapter) GetRetentionPolicies() (map[string]store.RetentionPolicy, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	policies := make(map[string]store.RetentionPolicy, len(a.retention))
+	for chatID, policy := range a.retention {
+		policies[chatID] = policy
+	}
+	return policies, nil
+}
+
+// MessagesPruned extends the pruned range of the chat, bounds that are older than the stored ones are ignored
+func (a *StorageMemoryAdapter) MessagesPruned(chatID string, pruned store.PrunedRange) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	stored := a.pruned[chatID]
+	if pruned.Before > stored.Before {
+		stored.Before = pruned.Before
+	}
+	if pruned.FilesBefore > stored.FilesBefore {
+		stored.FilesBefore = pruned.FilesBefore
+	}
+	a.pruned[chatID] = stored
+	return nil
+}
+
+// GetPrunedRange returns which messages of the chat were pruned, an empty range if nothing was pruned
+func (a *StorageMemoryAdapter) GetPrunedRange(chatID string) (store.PrunedRange, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.pruned[chatID], nil
+}
+
+// RemoveOrphanedPeers removes the peers that no message, membership, ban or reaction refers to
+// and the invited peers of invitations whose message is gone. It returns how many were removed.
+func (a *StorageMemoryAdapter) RemoveOrphanedPeers() (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	referenced := map[string]bool{}
+	for _, message := range a.messages {
+		referenced[message.SenderID] = true
+		referenced[message.ReceiverID] = true
+	}
+	for _, members := range a.members {
+		for _, m := range members {
+			referenced[m.peerID] = true
+		}
+	}
+	for _, bans := range a.bans {
+		for peerID := range bans {
+			referenced[peerID] = true
+		}
+	}
+	for key := range a.reactions {
+		referenced[key.peerID] = true
+	}
+
+	removed := 0
+	peerOrder := a.peerOrder[:0]
+	for _, publicKey := range a.peerOrder {
+		if referenced[publicKey] {
+			peerOrder = append(peerOrder, publicKey)
+			continue
+		}
+		delete(a.peers, publicKey)
+		removed++
+	}
+	a.peerOrder = peerOrder
+
+	for messageID, i := range a.invitations {
+		if _, exists := a.messages[messageID]; !exists {
+			removed += len(i.peers)
+			i.peers = nil
+		}
+	}
+
+	return removed, nil
+}
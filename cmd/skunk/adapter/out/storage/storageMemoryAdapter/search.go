@@ -0,0 +1,125 @@
+package storageMemoryAdapter
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"sort"
+	"strings"
+)
+
+// Markers of the matching words in search snippets and the number of words around them, the same as in the SQLite adapter
+const (
+	snippetStart    = "["
+	snippetEnd      = "]"
+	snippetEllipsis = "..."
+	snippetWords    = 10
+)
+
+// SearchMessages returns the SEND_MESSAGE messages whose newest text contains all words of the query, ignoring case, newest first
+func (a *StorageMemoryAdapter) SearchMessages(query store.SearchQuery) ([]store.SearchResult, error) {
+	words := strings.Fields(strings.ToLower(query.Text))
+	if len(words) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var messages []network.Message
+	for _, id := range a.messageOrder {
+		message := *a.messages[id]
+		if message.Operation != network.SEND_MESSAGE || a.deleted[id] ||
+			(query.ChatID != "" && message.ChatID != query.ChatID) || (query.SenderID != "" && message.SenderID != query.SenderID) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Timestamp == messages[j].Timestamp {
+			return messages[i].Id < messages[j].Id
+		}
+		return messages[i].Timestamp > messages[j].Timestamp
+	})
+
+	results := []store.SearchResult{}
+	for _, message := range messages {
+		text := messageText(message.Content)
+		if edits := a.messageEdits(message.Id); len(edits) > 0 {
+			text = edits[len(edits)-1].Content
+		}
+		if !containsWords(strings.ToLower(text), words) {
+			continue
+		}
+
+		results = append(results, store.SearchResult{Message: message, Snippet: snippetOf(text, words)})
+		if len(results) == query.Limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// messageText returns the text of the content of a SEND_MESSAGE message
+func messageText(content string) string {
+	var message struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal([]byte(content), &message)
+	return message.Message
+}
+
+// containsWords checks if the text contains all words
+func containsWords(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// snippetOf returns the words of the text around the first match with the matching words in brackets
+func snippetOf(text string, words []string) string {
+	textWords := strings.Fields(text)
+
+	first := -1
+	for i, textWord := range textWords {
+		if containsAny(strings.ToLower(textWord), words) {
+			if first < 0 {
+				first = i
+			}
+			textWords[i] = snippetStart + textWord + snippetEnd
+		}
+	}
+
+	start := first - snippetWords/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(textWords) {
+		end = len(textWords)
+	}
+
+	snippet := strings.Join(textWords[start:end], " ")
+	if start > 0 {
+		snippet = snippetEllipsis + snippet
+	}
+	if end < len(textWords) {
+		snippet += snippetEllipsis
+	}
+	return snippet
+}
+
+// containsAny checks if the text contains one of the words
+func containsAny(text string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
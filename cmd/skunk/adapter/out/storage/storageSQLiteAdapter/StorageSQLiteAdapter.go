@@ -1,58 +1,65 @@
 package storageSQLiteAdapter
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
-	"log"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type StorageSQLiteAdapter struct {
-	db *sql.DB
+	db           *sql.DB
+	keyMutex     sync.RWMutex
+	encrypted    bool        // the database has a passphrase
+	aead         cipher.AEAD // encrypts the sensitive columns, nil while the database is locked or not encrypted
+	searchModule string      // module of the full-text index of the messages, empty if this SQLite build can't use it
 }
 
-var (
-	instance *StorageSQLiteAdapter
-	once     sync.Once
-)
+// NewStorageSQLiteAdapter opens the database and upgrades its schema to the newest version.
+// Databases from a newer version of Skunk are refused. Encrypted databases are locked until Unlock is called.
+// Deleted content is overwritten (secure_delete), so removed and encrypted values don't stay in the free pages of the file.
+func NewStorageSQLiteAdapter(dbPath string) (*StorageSQLiteAdapter, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_secure_delete=on")
+	if err != nil {
+		return nil, err
+	}
 
-// NewStorageSQLiteAdapter creates a new instance of StorageSQLiteAdapter and initializes the database
-func newStorageSQLiteAdapter(dbPath string) *StorageSQLiteAdapter {
-	db, err := sql.Open("sqlite3", dbPath)
+	err = migrate(db)
 	if err != nil {
-		log.Fatal(err)
+		db.Close()
+		return nil, err
 	}
 
-	// Create tables if they don't exist
 	adapter := &StorageSQLiteAdapter{db: db}
-	adapter.createTables()
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM Encryption)").Scan(&adapter.encrypted)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	return adapter
-}
+	adapter.searchModule, err = searchIndexModule(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-// GetInstance returns the singleton instance of StorageSQLiteAdapter
-func GetInstance(dbPath string) *StorageSQLiteAdapter {
-	once.Do(func() {
-		instance = newStorageSQLiteAdapter(dbPath)
-	})
-	return instance
+	return adapter, nil
 }
 
-func (a *StorageSQLiteAdapter) createTables() {
-	sqlCommands := "CREATE TABLE IF NOT EXISTS Chats (\n    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Chats_pk PRIMARY KEY,\n    name VARCHAR(40) NOT NULL\n);\n\nCREATE TABLE IF NOT EXISTS Peers (\n    peer_id INTEGER NOT NULL CONSTRAINT Peers_pk PRIMARY KEY AUTOINCREMENT,\n    public_key VARCHAR(1024) NOT NULL,\n    address VARCHAR(1024) NOT NULL\n);\n\nCREATE TABLE IF NOT EXISTS ChatMembers (\n    chat_member_id INTEGER NOT NULL CONSTRAINT ChatMembers_pk PRIMARY KEY AUTOINCREMENT,\n    date INTEGER NOT NULL,\n    peer_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,\n    chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,\n    username VARCHAR(50)\n);\n\nCREATE TABLE IF NOT EXISTS Messages (\n    message_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_pk PRIMARY KEY,\n    content TEXT,\n    date INTEGER NOT NULL,\n    operation INTEGER NOT NULL,\n    sender_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,\n    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,\n    receiver_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk_2 REFERENCES Peers,\n    sender_address VARCHAR(1024) NOT NULL,\n    receiver_address VARCHAR(1024) NOT NULL\n);\n\nCREATE TABLE IF NOT EXISTS Invitations (\n    invitation_id INTEGER NOT NULL CONSTRAINT Invitations_pk PRIMARY KEY AUTOINCREMENT,\n    invitation_status INTEGER NOT NULL,\n    message_id VARCHAR(1024) NOT NULL CONSTRAINT Invitations_Messages_message_id_fk REFERENCES Messages\n);\n\nCREATE TABLE IF NOT EXISTS PeersInInvitedChat (\n    public_key VARCHAR(1024) NOT NULL,\n    invited_peer_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_pk PRIMARY KEY AUTOINCREMENT,\n    address VARCHAR(1024) NOT NULL,\n    invitation_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_Invitations_invitation_id_fk REFERENCES Invitations\n);\n"
-	_, err := a.db.Exec(sqlCommands)
-	if err != nil {
-		log.Fatal(err)
-	}
+// Close closes the database
+func (a *StorageSQLiteAdapter) Close() error {
+	return a.db.Close()
 }
 
 func (a *StorageSQLiteAdapter) ChatCreated(chatName string, chatId string) error {
-	//TODO implement me
-	panic("implement me")
+	return a.createChatIfNotExists(chatId, chatName)
 }
 
 func (a *StorageSQLiteAdapter) PeerSetUsername(peerID, chatID, username string) error {
@@ -72,6 +79,11 @@ func (a *StorageSQLiteAdapter) PeerSetUsername(peerID, chatID, username string)
 		return fmt.Errorf("chat with ID %s does not exist", chatID)
 	}
 
+	username, err = a.encrypt(username)
+	if err != nil {
+		return err
+	}
+
 	// Update the username for the peer in the specified chat
 	stmt, err := a.db.Prepare(`
         UPDATE ChatMembers
@@ -88,6 +100,11 @@ func (a *StorageSQLiteAdapter) PeerSetUsername(peerID, chatID, username string)
 }
 
 func (a *StorageSQLiteAdapter) SetPeerUsername(username, peerID, chatID string) error {
+	username, err := a.encrypt(username)
+	if err != nil {
+		return err
+	}
+
 	stmt, err := a.db.Prepare("UPDATE ChatMembers SET username = ? WHERE peer_id = ? AND chat_id = ?")
 	if err != nil {
 		return err
@@ -120,7 +137,247 @@ func (a *StorageSQLiteAdapter) PeerLeftChat(peerID, chatID string) error {
 	return err
 }
 
+// SetPeerRole sets the role of a member in a chat
+func (a *StorageSQLiteAdapter) SetPeerRole(peerID, chatID string, role store.ChatRole) error {
+	result, err := a.db.Exec("UPDATE ChatMembers SET role = ? WHERE peer_id = ? AND chat_id = ?", role, peerID, chatID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("peer %s is not a member of chat %s", peerID, chatID)
+	}
+
+	return nil
+}
+
+// GetPeerRole returns the role of a member in a chat
+func (a *StorageSQLiteAdapter) GetPeerRole(peerID, chatID string) (store.ChatRole, error) {
+	var role store.ChatRole
+	err := a.db.QueryRow("SELECT role FROM ChatMembers WHERE peer_id = ? AND chat_id = ?", peerID, chatID).Scan(&role)
+	if err != nil {
+		return store.ROLE_MEMBER, err
+	}
+
+	return role, nil
+}
+
+// PeerBannedFromChat removes the peer from the chat and prevents it from joining again
+func (a *StorageSQLiteAdapter) PeerBannedFromChat(timestamp int64, peerID, chatID string) error {
+	err := a.PeerLeftChat(peerID, chatID)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("INSERT INTO ChatBans (date, peer_id, chat_id) SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM ChatBans WHERE peer_id = ? AND chat_id = ?)",
+		timestamp, peerID, chatID, peerID, chatID)
+	return err
+}
+
+// IsBannedFromChat checks if the peer was banned from the chat
+func (a *StorageSQLiteAdapter) IsBannedFromChat(peerID, chatID string) (bool, error) {
+	var count int
+	err := a.db.QueryRow("SELECT COUNT(*) FROM ChatBans WHERE peer_id = ? AND chat_id = ?", peerID, chatID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// chatMetadataColumns maps the chat metadata fields to their columns in the Chats table.
+// Every column has an _updated and a _message_id column that order the updates.
+var chatMetadataColumns = map[store.ChatMetadataField]string{
+	store.CHAT_NAME:        "name",
+	store.CHAT_TOPIC:       "topic",
+	store.CHAT_DESCRIPTION: "description",
+}
+
+// UpdateChatMetadata changes a metadata field of the chat if the update is newer than the stored one (last writer wins).
+// Updates with the same timestamp are ordered by their message id, so all peers keep the same value.
+func (a *StorageSQLiteAdapter) UpdateChatMetadata(timestamp int64, messageID, chatID string, field store.ChatMetadataField, value string) (bool, error) {
+	column, exists := chatMetadataColumns[field]
+	if !exists {
+		return false, fmt.Errorf("unknown chat metadata field: %d", field)
+	}
+
+	err := a.createChatIfNotExists(chatID, "")
+	if err != nil {
+		return false, err
+	}
+
+	value, err = a.encrypt(value)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := a.db.Exec(fmt.Sprintf(
+		"UPDATE Chats SET %[1]s = ?, %[1]s_updated = ?, %[1]s_message_id = ? WHERE chat_id = ? AND (%[1]s_updated < ? OR (%[1]s_updated = ? AND %[1]s_message_id < ?))", column),
+		value, timestamp, messageID, chatID, timestamp, timestamp, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// SetMessageExpiry sets how long messages of the chat are kept if the setting is newer than the stored one (last writer wins).
+// Settings with the same timestamp are ordered by their message id, so all peers keep the same value.
+func (a *StorageSQLiteAdapter) SetMessageExpiry(timestamp int64, messageID, chatID string, expiry int64) (bool, error) {
+	err := a.createChatIfNotExists(chatID, "")
+	if err != nil {
+		return false, err
+	}
+
+	result, err := a.db.Exec(
+		"UPDATE Chats SET message_expiry = ?, message_expiry_updated = ?, message_expiry_message_id = ? WHERE chat_id = ? AND (message_expiry_updated < ? OR (message_expiry_updated = ? AND message_expiry_message_id < ?))",
+		expiry, timestamp, messageID, chatID, timestamp, timestamp, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetMessageExpiry returns how long messages of the chat are kept, 0 if they don't disappear or the chat is unknown
+func (a *StorageSQLiteAdapter) GetMessageExpiry(chatID string) (int64, error) {
+	var expiry int64
+	err := a.db.QueryRow("SELECT message_expiry FROM Chats WHERE chat_id = ?", chatID).Scan(&expiry)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return expiry, err
+}
+
+// GetMessageExpiries returns the message expiry of all chats with disappearing messages
+func (a *StorageSQLiteAdapter) GetMessageExpiries() (map[string]int64, error) {
+	rows, err := a.db.Query("SELECT chat_id, message_expiry FROM Chats WHERE message_expiry > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expiries := map[string]int64{}
+	for rows.Next() {
+		var chatID string
+		var expiry int64
+		err := rows.Scan(&chatID, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		expiries[chatID] = expiry
+	}
+
+	return expiries, nil
+}
+
+// PurgeMessages removes the messages of the chat with the operations that are older than before.
+// Their edit history and the reactions to them are removed as well.
+func (a *StorageSQLiteAdapter) PurgeMessages(chatID string, before int64, operations []network.OperationType) ([]network.Message, error) {
+	messages, err := a.GetChatMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var purged []network.Message
+	for _, message := range messages {
+		if message.Timestamp >= before || !containsOperation(operations, message.Operation) {
+			continue
+		}
+
+		// The indexed text is found by the rowid of the message, so it is removed before the message
+		if a.searchModule != "" {
+			_, err = tx.Exec("DELETE FROM MessageSearch WHERE rowid = (SELECT rowid FROM Messages WHERE message_id = ?)", message.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = tx.Exec("DELETE FROM Messages WHERE message_id = ?", message.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec("DELETE FROM MessageEdits WHERE message_id = ? OR edit_message_id = ?", message.Id, message.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec("DELETE FROM Reactions WHERE message_id = ?", message.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		purged = append(purged, message)
+	}
+
+	return purged, tx.Commit()
+}
+
+// containsOperation checks if the operation is in the list of operations
+func containsOperation(operations []network.OperationType, operation network.OperationType) bool {
+	for _, o := range operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectChatCreated creates the direct chat with the peer if it doesn't exist yet, both peers are its only members
+func (a *StorageSQLiteAdapter) DirectChatCreated(timestamp int64, chatID, peerID, peerAddress string) error {
+	err := a.createChatIfNotExists(chatID, "")
+	if err != nil {
+		return err
+	}
+
+	peerAddress, err = a.encrypt(peerAddress)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("UPDATE Chats SET direct_peer_id = ?, direct_peer_address = ? WHERE chat_id = ? AND direct_peer_id IS NULL", peerID, peerAddress, chatID)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range []string{"self", peerID} {
+		err = a.PeerJoinedChat(timestamp, member, chatID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (a *StorageSQLiteAdapter) CreateChat(chatID, name string) error {
+	name, err := a.encrypt(name)
+	if err != nil {
+		return err
+	}
+
 	stmt, err := a.db.Prepare("INSERT INTO Chats (chat_id, name) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM Chats WHERE chat_id = ?)")
 	if err != nil {
 		return err
@@ -149,14 +406,19 @@ func (a *StorageSQLiteAdapter) InvitedToChat(messageID string, peers []store.Pub
 		return err
 	}
 
-	stmt, err = tx.Prepare("INSERT INTO PeersInInvitedChat (public_key, address, invitation_id) SELECT ?, ?, (SELECT invitation_id FROM Invitations WHERE message_id = ?) WHERE NOT EXISTS (SELECT 1 FROM PeersInInvitedChat WHERE public_key = ? AND address = ? AND invitation_id = (SELECT invitation_id FROM Invitations WHERE message_id = ?))")
+	// The address is encrypted, so a peer is invited once per invitation by its public key
+	stmt, err = tx.Prepare("INSERT INTO PeersInInvitedChat (public_key, address, invitation_id) SELECT ?, ?, (SELECT invitation_id FROM Invitations WHERE message_id = ?) WHERE NOT EXISTS (SELECT 1 FROM PeersInInvitedChat WHERE public_key = ? AND invitation_id = (SELECT invitation_id FROM Invitations WHERE message_id = ?))")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, peer := range peers {
-		_, err = stmt.Exec(peer.PublicKey, peer.Address, messageID, peer.PublicKey, peer.Address, messageID)
+		address, err := a.encrypt(peer.Address)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(peer.PublicKey, address, messageID, peer.PublicKey, messageID)
 		if err != nil {
 			return err
 		}
@@ -166,13 +428,21 @@ func (a *StorageSQLiteAdapter) InvitedToChat(messageID string, peers []store.Pub
 	return err
 }
 
-func (a *StorageSQLiteAdapter) PeerGotInvitedToChat(peerId string, chatId string) error {
-	// Implement the logic to store a peer getting invited to a chat in the database
-	// ...
-	return nil
+// PeerGotInvitedToChat records a pending invitation for the INVITE_TO_CHAT message with the given ID.
+// If the invitation already exists, only its expiry is updated.
+func (a *StorageSQLiteAdapter) PeerGotInvitedToChat(messageID string, expiresAt int64) error {
+	_, err := a.db.Exec("INSERT INTO Invitations (invitation_status, message_id, expires) SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM Invitations WHERE message_id = ?)",
+		store.INVITATION_PENDING, messageID, expiresAt, messageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("UPDATE Invitations SET expires = ? WHERE message_id = ?", expiresAt, messageID)
+	return err
 }
 
-// TODO: Rework
+// GetInvitations returns the IDs of all chats the peer has an open or accepted invitation for.
+// Declined, revoked and expired invitations are not returned.
 func (a *StorageSQLiteAdapter) GetInvitations(peerID string) ([]string, error) {
 	rows, err := a.db.Query(`
 		SELECT m.chat_id
@@ -180,7 +450,9 @@ func (a *StorageSQLiteAdapter) GetInvitations(peerID string) ([]string, error) {
          JOIN Invitations i ON i.message_id = m.message_id
          JOIN Peers p ON m.receiver_peer_id = p.peer_id
 		WHERE p.public_key = ? AND m.operation = ?
-	`, peerID, network.INVITE_TO_CHAT)
+		  AND i.invitation_status IN (?, ?)
+		  AND (i.expires = 0 OR i.expires > ? OR i.invitation_status = ?)
+	`, peerID, network.INVITE_TO_CHAT, store.INVITATION_PENDING, store.INVITATION_ACCEPTED, time.Now().UnixNano(), store.INVITATION_ACCEPTED)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +471,80 @@ func (a *StorageSQLiteAdapter) GetInvitations(peerID string) ([]string, error) {
 	return invitations, nil
 }
 
+// GetInvitation returns the invitation belonging to the INVITE_TO_CHAT message with the given ID.
+// Pending invitations whose expiry has passed are reported as expired.
+func (a *StorageSQLiteAdapter) GetInvitation(messageID string) (store.Invitation, error) {
+	row := a.db.QueryRow(`
+		SELECT i.message_id, m.chat_id, ps.public_key, m.sender_address, pr.public_key, m.receiver_address, i.invitation_status, i.expires
+		FROM Invitations i
+		 JOIN Messages m ON i.message_id = m.message_id
+		 JOIN Peers ps ON m.sender_peer_id = ps.peer_id
+		 JOIN Peers pr ON m.receiver_peer_id = pr.peer_id
+		WHERE i.message_id = ?
+	`, messageID)
+
+	var invitation store.Invitation
+	err := row.Scan(
+		&invitation.MessageId, &invitation.ChatId, &invitation.InviterId, &invitation.InviterAddress,
+		&invitation.InviteeId, &invitation.InviteeAddress, &invitation.Status, &invitation.ExpiresAt,
+	)
+	if err != nil {
+		return store.Invitation{}, err
+	}
+
+	err = a.decrypt(&invitation.InviterAddress, &invitation.InviteeAddress)
+	if err != nil {
+		return store.Invitation{}, err
+	}
+
+	if invitation.Status == store.INVITATION_PENDING && invitation.ExpiresAt != 0 && invitation.ExpiresAt <= time.Now().UnixNano() {
+		invitation.Status = store.INVITATION_EXPIRED
+	}
+
+	return invitation, nil
+}
+
+// SetInvitationStatus moves a pending invitation to the given status.
+// Invitations that are no longer pending are left untouched, so the first response wins.
+func (a *StorageSQLiteAdapter) SetInvitationStatus(messageID string, status store.InvitationStatus) error {
+	result, err := a.db.Exec("UPDATE Invitations SET invitation_status = ? WHERE message_id = ? AND invitation_status = ?",
+		status, messageID, store.INVITATION_PENDING)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("no pending invitation with message ID %s", messageID)
+	}
+
+	return nil
+}
+
+// InviteTokenUsed records that the invite token with the given secret was used by a peer.
+// Only the first use is recorded, since invite tokens are one-time tokens.
+func (a *StorageSQLiteAdapter) InviteTokenUsed(secret string, peerID string) error {
+	_, err := a.db.Exec("INSERT INTO InviteTokens (secret, peer_id) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM InviteTokens WHERE secret = ?)", secret, peerID, secret)
+	return err
+}
+
+// GetInviteTokenUser returns the peer that used the invite token with the given secret, or "" if it is unused.
+func (a *StorageSQLiteAdapter) GetInviteTokenUser(secret string) (string, error) {
+	var peerID string
+	err := a.db.QueryRow("SELECT peer_id FROM InviteTokens WHERE secret = ?", secret).Scan(&peerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return peerID, nil
+}
+
 // GetMissingInternalMessages retrieves the message IDs from inputMessageIDs that are not present in the Messages table for the specified chatID.
 func (a *StorageSQLiteAdapter) GetMissingInternalMessages(chatID string, inputMessageIDs []string) ([]string, error) {
 	if len(inputMessageIDs) == 0 {
@@ -248,12 +594,15 @@ func difference(inputMessageIDs, existingMessageIDs []string) []string {
 }
 
 func (a *StorageSQLiteAdapter) GetMissingExternalMessages(chatID string, inputMessageIDs []string) ([]string, error) {
-	query := "SELECT message_id FROM Messages WHERE chat_id = ? AND message_id NOT IN (SELECT message_id FROM Messages WHERE chat_id = ? AND message_id IN (?" + strings.Repeat(",?", len(inputMessageIDs)-1) + "))"
-	args := make([]interface{}, len(inputMessageIDs)+2)
-	args[0] = chatID
-	args[1] = chatID
-	for i, id := range inputMessageIDs {
-		args[i+2] = id
+	// A peer without messages is missing all messages of the chat
+	query := "SELECT message_id FROM Messages WHERE chat_id = ?"
+	args := []interface{}{chatID}
+	if len(inputMessageIDs) > 0 {
+		query += " AND message_id NOT IN (SELECT message_id FROM Messages WHERE chat_id = ? AND message_id IN (?" + strings.Repeat(",?", len(inputMessageIDs)-1) + "))"
+		args = append(args, chatID)
+		for _, id := range inputMessageIDs {
+			args = append(args, id)
+		}
 	}
 
 	rows, err := a.db.Query(query, args...)
@@ -289,8 +638,8 @@ func (a *StorageSQLiteAdapter) StoreMessage(message network.Message) error {
 	}
 
 	stmt, err := a.db.Prepare(`
-        INSERT INTO Messages (message_id, date, content, sender_peer_id, receiver_peer_id, sender_address, receiver_address, chat_id, operation)
-        SELECT ?, ?, ?, (SELECT peer_id FROM Peers WHERE public_key = ?), (SELECT peer_id FROM Peers WHERE public_key = ?), ?, ?, ?, ?
+        INSERT INTO Messages (message_id, date, content, sender_peer_id, receiver_peer_id, sender_address, receiver_address, chat_id, operation, reply_to)
+        SELECT ?, ?, ?, (SELECT peer_id FROM Peers WHERE public_key = ?), (SELECT peer_id FROM Peers WHERE public_key = ?), ?, ?, ?, ?, ?
         WHERE NOT EXISTS (SELECT 1 FROM Messages WHERE message_id = ?)
     `)
 	if err != nil {
@@ -298,14 +647,190 @@ func (a *StorageSQLiteAdapter) StoreMessage(message network.Message) error {
 	}
 	defer stmt.Close()
 
+	content, err := a.encrypt(message.Content)
+	if err != nil {
+		return err
+	}
+	senderAddress, err := a.encrypt(message.SenderAddress)
+	if err != nil {
+		return err
+	}
+	receiverAddress, err := a.encrypt(message.ReceiverAddress)
+	if err != nil {
+		return err
+	}
+
 	_, err = stmt.Exec(
-		message.Id, message.Timestamp, message.Content, message.SenderID, message.ReceiverID,
-		message.SenderAddress, message.ReceiverAddress, message.ChatID, message.Operation, message.Id,
+		message.Id, message.Timestamp, content, message.SenderID, message.ReceiverID,
+		senderAddress, receiverAddress, message.ChatID, message.Operation, replyTo(message), message.Id,
 	)
+	if err != nil {
+		return err
+	}
+
+	err = a.updateSearchIndex(message.Id)
+	if err != nil {
+		return err
+	}
+
+	// The deletion of a message can arrive before the message itself
+	deleted, err := a.IsMessageDeleted(message.Id)
+	if err != nil || !deleted {
+		return err
+	}
+	return a.clearMessageContent(message.Id)
+}
+
+// replyTo returns the id of the message a SEND_MESSAGE message replies to, nil if it is no reply
+func replyTo(message network.Message) interface{} {
+	if message.Operation != network.SEND_MESSAGE {
+		return nil
+	}
+
+	var content struct {
+		ReplyTo string `json:"replyTo"`
+	}
+	if json.Unmarshal([]byte(message.Content), &content) != nil || content.ReplyTo == "" {
+		return nil
+	}
+
+	return content.ReplyTo
+}
+
+// MessageEdited adds a new version of the message to its edit history
+func (a *StorageSQLiteAdapter) MessageEdited(timestamp int64, editID, messageID, content string) error {
+	deleted, err := a.IsMessageDeleted(messageID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		content = ""
+	}
+
+	content, err = a.encrypt(content)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("INSERT INTO MessageEdits (edit_message_id, message_id, date, content) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM MessageEdits WHERE edit_message_id = ?)",
+		editID, messageID, timestamp, content, editID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return a.updateSearchIndex(messageID)
+	}
+	return a.clearMessageContent(editID)
+}
+
+// MessageDeleted leaves a tombstone for the message and removes the content of the message and all its edits
+func (a *StorageSQLiteAdapter) MessageDeleted(timestamp int64, messageID string) error {
+	_, err := a.db.Exec("INSERT INTO DeletedMessages (message_id, date) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM DeletedMessages WHERE message_id = ?)",
+		messageID, timestamp, messageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("UPDATE MessageEdits SET content = '' WHERE message_id = ?", messageID)
+	if err != nil {
+		return err
+	}
+
+	edits, err := a.GetMessageEdits(messageID)
+	if err != nil {
+		return err
+	}
+
+	for _, edit := range append(edits, store.MessageEdit{EditId: messageID}) {
+		err = a.clearMessageContent(edit.EditId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.updateSearchIndex(messageID)
+}
+
+// clearMessageContent removes the text of a SEND_MESSAGE or EDIT_MESSAGE message, the message stays valid JSON
+func (a *StorageSQLiteAdapter) clearMessageContent(messageID string) error {
+	var content struct {
+		MessageID string `json:"messageId,omitempty"`
+		Message   string `json:"message"`
+	}
+
+	var stored sql.NullString
+	err := a.db.QueryRow("SELECT content FROM Messages WHERE message_id = ?", messageID).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = a.decrypt(&stored.String)
+	if err != nil {
+		return err
+	}
+
+	// Keep the reference to the edited message, everything else is removed
+	json.Unmarshal([]byte(stored.String), &content)
+	content.Message = ""
+	cleared, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := a.encrypt(string(cleared))
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec("UPDATE Messages SET content = ? WHERE message_id = ?", encrypted, messageID)
 	return err
 }
 
+// GetMessageEdits returns the edit history of the message, oldest edit first
+func (a *StorageSQLiteAdapter) GetMessageEdits(messageID string) ([]store.MessageEdit, error) {
+	rows, err := a.db.Query("SELECT edit_message_id, content, date FROM MessageEdits WHERE message_id = ? ORDER BY date, edit_message_id", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edits []store.MessageEdit
+	for rows.Next() {
+		var edit store.MessageEdit
+		err := rows.Scan(&edit.EditId, &edit.Content, &edit.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		err = a.decrypt(&edit.Content)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+
+	return edits, nil
+}
+
+// IsMessageDeleted checks if there is a tombstone for the message
+func (a *StorageSQLiteAdapter) IsMessageDeleted(messageID string) (bool, error) {
+	var count int
+	err := a.db.QueryRow("SELECT COUNT(*) FROM DeletedMessages WHERE message_id = ?", messageID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
 func (a *StorageSQLiteAdapter) insertPeerIfNotExists(publicKey, address string) error {
+	address, err := a.encrypt(address)
+	if err != nil {
+		return err
+	}
+
 	stmt, err := a.db.Prepare(`
         INSERT INTO Peers (public_key, address)
         SELECT ?, ?
@@ -336,11 +861,16 @@ func (a *StorageSQLiteAdapter) RetrieveMessage(messageID string) (network.Messag
 		return network.Message{}, err
 	}
 
+	err = a.decrypt(&message.Content, &message.SenderAddress, &message.ReceiverAddress)
+	if err != nil {
+		return network.Message{}, err
+	}
+
 	return message, nil
 }
 
 func (a *StorageSQLiteAdapter) GetChats() ([]store.Chat, error) {
-	rows, err := a.db.Query("SELECT chat_id, name FROM Chats")
+	rows, err := a.db.Query("SELECT chat_id, name, topic, description, COALESCE(direct_peer_id, ''), COALESCE(direct_peer_address, '') FROM Chats")
 	if err != nil {
 		return nil, err
 	}
@@ -349,7 +879,11 @@ func (a *StorageSQLiteAdapter) GetChats() ([]store.Chat, error) {
 	var chats []store.Chat
 	for rows.Next() {
 		var chat store.Chat
-		err := rows.Scan(&chat.ChatId, &chat.ChatName)
+		err := rows.Scan(&chat.ChatId, &chat.ChatName, &chat.Topic, &chat.Description, &chat.DirectPeerId, &chat.DirectPeerAddress)
+		if err != nil {
+			return nil, err
+		}
+		err = a.decrypt(&chat.ChatName, &chat.Topic, &chat.Description, &chat.DirectPeerAddress)
 		if err != nil {
 			return nil, err
 		}
@@ -368,11 +902,16 @@ func (a *StorageSQLiteAdapter) GetUsername(peerID, chatID string) (string, error
 		return "", err
 	}
 
+	err = a.decrypt(&username)
+	if err != nil {
+		return "", err
+	}
+
 	return username, nil
 }
 
 func (a *StorageSQLiteAdapter) GetUsersInChat(chatID string) ([]store.User, error) {
-	rows, err := a.db.Query("SELECT peer_id, username FROM ChatMembers WHERE chat_id = ?", chatID)
+	rows, err := a.db.Query("SELECT peer_id, username, role FROM ChatMembers WHERE chat_id = ?", chatID)
 	if err != nil {
 		return nil, err
 	}
@@ -382,7 +921,7 @@ func (a *StorageSQLiteAdapter) GetUsersInChat(chatID string) ([]store.User, erro
 	for rows.Next() {
 		var user store.User
 		var username sql.NullString
-		err := rows.Scan(&user.UserId, &username)
+		err := rows.Scan(&user.UserId, &username, &user.Role)
 		if err != nil {
 			return nil, err
 		}
@@ -392,6 +931,10 @@ func (a *StorageSQLiteAdapter) GetUsersInChat(chatID string) ([]store.User, erro
 		} else {
 			user.Username = "" // or any default value you prefer for NULL usernames
 		}
+		err = a.decrypt(&user.Username)
+		if err != nil {
+			return nil, err
+		}
 		users = append(users, user)
 	}
 
@@ -418,12 +961,158 @@ func (a *StorageSQLiteAdapter) GetPeers() ([]string, error) {
 	return peers, nil
 }
 
+// GetChatMessages returns all messages of the chat, oldest message first
 func (a *StorageSQLiteAdapter) GetChatMessages(chatID string) ([]network.Message, error) {
+	return a.GetChatMessagePage(store.MessagePage{ChatID: chatID})
+}
+
+// GetChatMessagePage returns the messages of the chat between the cursors, oldest message first.
+// Messages are ordered by their timestamp, messages with the same timestamp by their id.
+func (a *StorageSQLiteAdapter) GetChatMessagePage(page store.MessagePage) ([]network.Message, error) {
+	conditions := []string{"m.chat_id = ?"}
+	arguments := []interface{}{page.ChatID}
+	for _, cursor := range []struct {
+		messageID  string
+		comparison string
+	}{{page.Before, "<"}, {page.After, ">"}} {
+		if cursor.messageID == "" {
+			continue
+		}
+
+		var exists bool
+		err := a.db.QueryRow("SELECT EXISTS (SELECT 1 FROM Messages WHERE message_id = ? AND chat_id = ?)", cursor.messageID, page.ChatID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("message %s is not stored in chat %s", cursor.messageID, page.ChatID)
+		}
+
+		conditions = append(conditions, "(m.date, m.message_id) "+cursor.comparison+" (SELECT date, message_id FROM Messages WHERE message_id = ?)")
+		arguments = append(arguments, cursor.messageID)
+	}
+
+	// The newest messages are selected backwards and reversed afterwards
+	backwards := page.After == ""
+	order := "m.date, m.message_id"
+	if backwards {
+		order = "m.date DESC, m.message_id DESC"
+	}
+	limit := page.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := a.db.Query(`
+		SELECT m.message_id, m.content, m.date, m.operation, p.public_key, m.chat_id, p2.public_key, m.sender_address, m.receiver_address
+		FROM Messages m
+		 JOIN Peers p ON m.sender_peer_id = p.peer_id
+		 JOIN Peers p2 ON m.receiver_peer_id = p2.peer_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT ?
+	`, append(arguments, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []network.Message
+	for rows.Next() {
+		var message network.Message
+		err := rows.Scan(
+			&message.Id, &message.Content, &message.Timestamp, &message.Operation, &message.SenderID, &message.ChatID, &message.ReceiverID,
+			&message.SenderAddress, &message.ReceiverAddress,
+		)
+		if err != nil {
+			return nil, err
+		}
+		err = a.decrypt(&message.Content, &message.SenderAddress, &message.ReceiverAddress)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if backwards {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+// GetMembershipMessages returns all messages of the chat that change its members or their roles
+func (a *StorageSQLiteAdapter) GetMembershipMessages(chatID string) ([]network.Message, error) {
+	messages, err := a.GetChatMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var membershipMessages []network.Message
+	for _, message := range messages {
+		switch message.Operation {
+		case network.JOIN_CHAT, network.LEAVE_CHAT, network.REMOVE_MEMBER, network.BAN_MEMBER, network.SET_ROLE:
+			membershipMessages = append(membershipMessages, message)
+		}
+	}
+
+	return membershipMessages, nil
+}
+
+// PeerReacted adds or removes the reaction of the peer if it is newer than the stored one (last writer wins).
+// Reactions with the same timestamp are ordered by the id of the REACT message.
+func (a *StorageSQLiteAdapter) PeerReacted(timestamp int64, reactionID, messageID, peerID, emoji string, add bool) (bool, error) {
+	result, err := a.db.Exec(`
+		INSERT INTO Reactions (message_id, peer_id, emoji, active, date, reaction_message_id) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, peer_id, emoji) DO UPDATE SET active = excluded.active, date = excluded.date, reaction_message_id = excluded.reaction_message_id
+		WHERE Reactions.date < excluded.date OR (Reactions.date = excluded.date AND Reactions.reaction_message_id < excluded.reaction_message_id)
+	`, messageID, peerID, emoji, add, timestamp, reactionID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetReactions returns how many peers reacted with each emoji to the message, most used emoji first
+func (a *StorageSQLiteAdapter) GetReactions(messageID string) ([]store.Reaction, error) {
+	rows, err := a.db.Query("SELECT emoji, COUNT(*) FROM Reactions WHERE message_id = ? AND active = 1 GROUP BY emoji ORDER BY COUNT(*) DESC, emoji", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []store.Reaction
+	for rows.Next() {
+		var reaction store.Reaction
+		err := rows.Scan(&reaction.Emoji, &reaction.Count)
+		if err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+
+	return reactions, nil
+}
+
+// GetReplies returns all replies to the message, oldest reply first.
+// Replies are linked by id, so replies that arrived before their parent are found as well.
+func (a *StorageSQLiteAdapter) GetReplies(messageID string) ([]network.Message, error) {
 	rows, err := a.db.Query(`
 		SELECT m.message_id, m.content, m.date, m.operation, p.public_key, m.chat_id, p2.public_key, m.sender_address, m.receiver_address
 		FROM Messages m, Peers p, Peers p2
-		WHERE chat_id = ? AND m.sender_peer_id = p.peer_id AND m.receiver_peer_id = p2.peer_id
-	`, chatID)
+		WHERE m.reply_to = ? AND m.sender_peer_id = p.peer_id AND m.receiver_peer_id = p2.peer_id
+		ORDER BY m.date, m.message_id
+	`, messageID)
 	if err != nil {
 		return nil, err
 	}
@@ -439,16 +1128,194 @@ func (a *StorageSQLiteAdapter) GetChatMessages(chatID string) ([]network.Message
 		if err != nil {
 			return nil, err
 		}
+		err = a.decrypt(&message.Content, &message.SenderAddress, &message.ReceiverAddress)
+		if err != nil {
+			return nil, err
+		}
 		messages = append(messages, message)
 	}
 
 	return messages, nil
 }
 
+// PeerSeen marks the peer as online, the last seen time never goes back
+func (a *StorageSQLiteAdapter) PeerSeen(address string, timestamp int64) error {
+	_, err := a.db.Exec(`
+		INSERT INTO Presence (address, online, last_seen) VALUES (?, 1, ?)
+		ON CONFLICT (address) DO UPDATE SET online = 1, last_seen = MAX(Presence.last_seen, excluded.last_seen)
+	`, address, timestamp)
+	return err
+}
+
+// PeerOffline marks the peer as offline and keeps the time it was last seen
+func (a *StorageSQLiteAdapter) PeerOffline(address string) error {
+	_, err := a.db.Exec(`
+		INSERT INTO Presence (address, online, last_seen) VALUES (?, 0, 0)
+		ON CONFLICT (address) DO UPDATE SET online = 0
+	`, address)
+	return err
+}
+
+// GetPresence returns the last known state of the peer, peers that were never seen are offline
+func (a *StorageSQLiteAdapter) GetPresence(address string) (store.Presence, error) {
+	presence := store.Presence{Address: address}
+	err := a.db.QueryRow("SELECT online, last_seen FROM Presence WHERE address = ?", address).Scan(&presence.Online, &presence.LastSeen)
+	if err == sql.ErrNoRows {
+		return presence, nil
+	}
+
+	return presence, err
+}
+
+// SaveContact adds the contact to the address book or replaces the contact with the same address.
+// The blocked flag of the contact adds its address to the block list or removes it.
+func (a *StorageSQLiteAdapter) SaveContact(contact store.Contact) error {
+	nickname, err := a.encrypt(contact.Nickname)
+	if err != nil {
+		return err
+	}
+	notes, err := a.encrypt(contact.Notes)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO Contacts (address, nickname, public_key, verified, notes) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (address) DO UPDATE SET nickname = excluded.nickname, public_key = excluded.public_key,
+			verified = excluded.verified, notes = excluded.notes
+	`, contact.Address, nickname, contact.PublicKey, contact.Verified, notes)
+	if err != nil {
+		return err
+	}
+
+	if contact.Blocked {
+		_, err = tx.Exec("INSERT OR IGNORE INTO BlockedPeers (address, date) VALUES (?, ?)", contact.Address, time.Now().UnixNano())
+	} else {
+		_, err = tx.Exec("DELETE FROM BlockedPeers WHERE address = ?", contact.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// contactQuery selects the fields of contacts, a contact is blocked if its address is on the block list
+const contactQuery = `
+	SELECT c.address, c.nickname, c.public_key, c.verified, c.notes, EXISTS (SELECT 1 FROM BlockedPeers b WHERE b.address = c.address)
+	FROM Contacts c`
+
+func (a *StorageSQLiteAdapter) RemoveContact(address string) error {
+	result, err := a.db.Exec("DELETE FROM Contacts WHERE address = ?", address)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("contact with address %s does not exist", address)
+	}
+
+	return nil
+}
+
+func (a *StorageSQLiteAdapter) GetContact(address string) (store.Contact, error) {
+	var contact store.Contact
+	err := a.db.QueryRow(contactQuery+" WHERE c.address = ?", address).Scan(
+		&contact.Address, &contact.Nickname, &contact.PublicKey, &contact.Verified, &contact.Notes, &contact.Blocked)
+	if err == sql.ErrNoRows {
+		return contact, fmt.Errorf("contact with address %s does not exist", address)
+	}
+	if err != nil {
+		return contact, err
+	}
+
+	return contact, a.decrypt(&contact.Nickname, &contact.Notes)
+}
+
+// GetContacts returns the whole address book sorted by nickname.
+// The nicknames may be encrypted, so they are sorted after they are decrypted.
+func (a *StorageSQLiteAdapter) GetContacts() ([]store.Contact, error) {
+	rows, err := a.db.Query(contactQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []store.Contact{}
+	for rows.Next() {
+		var contact store.Contact
+		err := rows.Scan(&contact.Address, &contact.Nickname, &contact.PublicKey, &contact.Verified, &contact.Notes, &contact.Blocked)
+		if err != nil {
+			return nil, err
+		}
+		err = a.decrypt(&contact.Nickname, &contact.Notes)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	sort.Slice(contacts, func(i, j int) bool {
+		first, second := strings.ToLower(contacts[i].Nickname), strings.ToLower(contacts[j].Nickname)
+		if first == second {
+			return contacts[i].Address < contacts[j].Address
+		}
+		return first < second
+	})
+	return contacts, rows.Err()
+}
+
+func (a *StorageSQLiteAdapter) BlockPeer(timestamp int64, address string) error {
+	_, err := a.db.Exec("INSERT OR IGNORE INTO BlockedPeers (address, date) VALUES (?, ?)", address, timestamp)
+	return err
+}
+
+func (a *StorageSQLiteAdapter) UnblockPeer(address string) error {
+	_, err := a.db.Exec("DELETE FROM BlockedPeers WHERE address = ?", address)
+	return err
+}
+
+func (a *StorageSQLiteAdapter) IsBlocked(address string) (bool, error) {
+	var blocked bool
+	err := a.db.QueryRow("SELECT EXISTS (SELECT 1 FROM BlockedPeers WHERE address = ?)", address).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockedPeers returns the blocked addresses, the peer blocked first comes first
+func (a *StorageSQLiteAdapter) GetBlockedPeers() ([]string, error) {
+	rows, err := a.db.Query("SELECT address FROM BlockedPeers ORDER BY date, address")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []string{}
+	for rows.Next() {
+		var address string
+		err := rows.Scan(&address)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
 func (a *StorageSQLiteAdapter) JoinChat(peerID, chatID string, invitationID int) error {
 	// Check if the invitation exists and get the chat name
 	var chatName string
-	err := a.db.QueryRow("SELECT m.chat_id, c.name FROM Invitations i JOIN Messages m ON i.message_id = m.message_id JOIN Chats c ON m.chat_id = c.chat_id WHERE i.invitation_id = ? AND i.invitation_status = 0", invitationID).Scan(&chatID, &chatName)
+	err := a.db.QueryRow("SELECT m.chat_id, c.name FROM Invitations i JOIN Messages m ON i.message_id = m.message_id JOIN Chats c ON m.chat_id = c.chat_id WHERE i.invitation_id = ? AND i.invitation_status = ?", invitationID, store.INVITATION_PENDING).Scan(&chatID, &chatName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("invitation not found or already accepted")
@@ -456,6 +1323,11 @@ func (a *StorageSQLiteAdapter) JoinChat(peerID, chatID string, invitationID int)
 		return err
 	}
 
+	err = a.decrypt(&chatName)
+	if err != nil {
+		return err
+	}
+
 	// Check if the chat exists, and create it if not
 	err = a.createChatIfNotExists(chatID, chatName)
 	if err != nil {
@@ -485,11 +1357,16 @@ func (a *StorageSQLiteAdapter) JoinChat(peerID, chatID string, invitationID int)
 	}
 
 	// Update the invitation status
-	_, err = a.db.Exec("UPDATE Invitations SET invitation_status = 1 WHERE invitation_id = ?", invitationID)
+	_, err = a.db.Exec("UPDATE Invitations SET invitation_status = ? WHERE invitation_id = ?", store.INVITATION_ACCEPTED, invitationID)
 	return err
 }
 
 func (a *StorageSQLiteAdapter) createChatIfNotExists(chatID, chatName string) error {
+	chatName, err := a.encrypt(chatName)
+	if err != nil {
+		return err
+	}
+
 	stmt, err := a.db.Prepare(`
         INSERT INTO Chats (chat_id, name)
         SELECT ?, ?
@@ -0,0 +1,317 @@
+package storageSQLiteAdapter
+
+import (
+	"crypto/cipher"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mergeTable describes how the rows of a table are merged into another database
+type mergeTable struct {
+	name   string
+	keys   []string          // columns that identify a row, a row with the same keys is already there
+	autoID string            // generated id column, it isn't copied but mapped to the id of the row in this database
+	remap  map[string]string // columns that reference the generated id of another table, by that table
+}
+
+// mergeTables are merged in this order, referenced tables come first.
+// Every table of the schema has to be listed, Merge refuses databases with unknown tables.
+var mergeTables = []mergeTable{
+	{name: "Chats", keys: []string{"chat_id"}},
+	{name: "Peers", keys: []string{"public_key"}, autoID: "peer_id"},
+	{name: "ChatMembers", keys: []string{"peer_id", "chat_id"}, autoID: "chat_member_id"},
+	{name: "Messages", keys: []string{"message_id"}, remap: map[string]string{"sender_peer_id": "Peers", "receiver_peer_id": "Peers"}},
+	{name: "Invitations", keys: []string{"message_id"}, autoID: "invitation_id"},
+	{name: "PeersInInvitedChat", keys: []string{"invitation_id", "public_key"}, autoID: "invited_peer_id", remap: map[string]string{"invitation_id": "Invitations"}},
+	{name: "InviteTokens", keys: []string{"secret"}},
+	{name: "ChatBans", keys: []string{"peer_id", "chat_id"}, autoID: "chat_ban_id"},
+	{name: "MessageEdits", keys: []string{"edit_message_id"}},
+	{name: "DeletedMessages", keys: []string{"message_id"}},
+	{name: "Reactions", keys: []string{"message_id", "peer_id", "emoji"}},
+	{name: "Presence", keys: []string{"address"}},
+	{name: "Contacts", keys: []string{"address"}},
+	{name: "BlockedPeers", keys: []string{"address"}},
+	{name: "RetentionPolicies", keys: []string{"chat_id"}},
+	{name: "PrunedRanges", keys: []string{"chat_id"}},
+}
+
+// internalTable checks if the table belongs to SQLite, the schema version, the encryption or the search index.
+// Their rows are never merged.
+func internalTable(table string) bool {
+	return strings.HasPrefix(table, "sqlite_") || strings.HasPrefix(table, "MessageSearch") ||
+		table == "schema_version" || table == "Encryption"
+}
+
+// Snapshot writes a consistent copy of the database to the path, the file must not exist yet.
+// Encrypted columns stay encrypted in the copy.
+func (a *StorageSQLiteAdapter) Snapshot(path string) error {
+	_, err := a.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// Merge copies all rows of the source database that this database doesn't have yet.
+// Rows both databases have keep the values of this database. Deletions of the source are applied to this database
+// and deletions of this database to the copied messages. Both databases have to be unlocked if they are encrypted,
+// the copied values are encrypted with the key of this database.
+func (a *StorageSQLiteAdapter) Merge(source *StorageSQLiteAdapter) error {
+	sourceCipher, err := source.cipherForUse()
+	if err != nil {
+		return err
+	}
+	targetCipher, err := a.cipherForUse()
+	if err != nil {
+		return err
+	}
+
+	err = checkMergeTables(source.db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := map[string]map[int64]int64{}
+	for _, table := range mergeTables {
+		ids[table.name], err = mergeRows(tx, source.db, table, ids, sourceCipher, targetCipher)
+		if err != nil {
+			return fmt.Errorf("merging %s failed: %w", table.name, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return a.applyDeletions()
+}
+
+// checkMergeTables makes sure that every table of the database is known to Merge
+func checkMergeTables(db *sql.DB) error {
+	known := map[string]bool{}
+	for _, table := range mergeTables {
+		known[table.name] = true
+	}
+
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		if !known[table] && !internalTable(table) {
+			return fmt.Errorf("can't merge the unknown table %s", table)
+		}
+	}
+	return rows.Err()
+}
+
+// mergeRows inserts the rows of the table in the source database that aren't in the transaction yet.
+// The encrypted columns are decrypted with the source cipher and encrypted with the target cipher.
+// It returns the ids of the rows in the transaction by their ids in the source, if the table has a generated id.
+func mergeRows(tx *sql.Tx, source *sql.DB, table mergeTable, ids map[string]map[int64]int64, sourceCipher, targetCipher cipher.AEAD) (map[int64]int64, error) {
+	columns, err := tableColumns(source, table.name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The generated id is selected first, so it can be mapped, but isn't inserted
+	var copied []string
+	for _, column := range columns {
+		if column != table.autoID {
+			copied = append(copied, column)
+		}
+	}
+	selected := copied
+	if table.autoID != "" {
+		selected = append([]string{table.autoID}, copied...)
+	}
+
+	rows, err := source.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(selected, ", "), table.name))
+	if err != nil {
+		return nil, err
+	}
+	var stored [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(selected))
+		destinations := make([]interface{}, len(selected))
+		for i := range values {
+			destinations[i] = &values[i]
+		}
+		if err := rows.Scan(destinations...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stored = append(stored, values)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	encrypted := map[string]bool{}
+	for _, column := range encryptedColumns[table.name] {
+		encrypted[column] = true
+	}
+
+	conditions := make([]string, len(table.keys))
+	for i, key := range table.keys {
+		conditions[i] = key + " IS ?"
+	}
+	idColumn := table.autoID
+	if idColumn == "" {
+		idColumn = "rowid"
+	}
+	find := fmt.Sprintf("SELECT %s FROM %s WHERE %s", idColumn, table.name, strings.Join(conditions, " AND "))
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.name, strings.Join(copied, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(copied)), ", "))
+
+	mapped := map[int64]int64{}
+	for _, values := range stored {
+		var sourceID int64
+		if table.autoID != "" {
+			sourceID, _ = values[0].(int64)
+			values = values[1:]
+		}
+
+		row := map[string]interface{}{}
+		for i, column := range copied {
+			value := values[i]
+			if text, ok := value.(string); ok && encrypted[column] {
+				plaintext, err := decryptWith(sourceCipher, text)
+				if err != nil {
+					return nil, err
+				}
+				value, err = encryptWith(targetCipher, plaintext)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if referenced, ok := table.remap[column]; ok && value != nil {
+				id, found := ids[referenced][referencedID(value)]
+				if !found {
+					return nil, fmt.Errorf("%s references a missing row of %s", column, referenced)
+				}
+				value = id
+			}
+			values[i] = value
+			row[column] = value
+		}
+
+		keys := make([]interface{}, len(table.keys))
+		for i, key := range table.keys {
+			keys[i] = row[key]
+		}
+
+		var id int64
+		err = tx.QueryRow(find, keys...).Scan(&id)
+		if err == sql.ErrNoRows {
+			result, insertErr := tx.Exec(insert, values...)
+			if insertErr != nil {
+				return nil, insertErr
+			}
+			id, err = result.LastInsertId()
+		}
+		if err != nil {
+			return nil, err
+		}
+		mapped[sourceID] = id
+	}
+
+	return mapped, nil
+}
+
+// referencedID returns the id a column references, ids in text columns are stored as text
+func referencedID(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case string:
+		id, _ := strconv.ParseInt(v, 10, 64)
+		return id
+	case []byte:
+		id, _ := strconv.ParseInt(string(v), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// tableColumns returns the names of the columns of the table
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// applyDeletions removes the content of all deleted messages and their edits again, so merged messages
+// lose their content if either database deleted them. It updates the search index as well.
+func (a *StorageSQLiteAdapter) applyDeletions() error {
+	rows, err := a.db.Query("SELECT message_id, date FROM DeletedMessages")
+	if err != nil {
+		return err
+	}
+	type deletion struct {
+		messageID string
+		date      int64
+	}
+	var deletions []deletion
+	for rows.Next() {
+		var d deletion
+		if err := rows.Scan(&d.messageID, &d.date); err != nil {
+			rows.Close()
+			return err
+		}
+		deletions = append(deletions, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range deletions {
+		err = a.MessageDeleted(d.date, d.messageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !a.searchIndexed() {
+		return nil
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = indexMessages(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,321 @@
+package storageSQLiteAdapter
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/scherzma/Skunk/cmd/skunk/util"
+	"strings"
+)
+
+// encryptedPrefix marks encrypted values, so they can't be mistaken for plaintext
+const encryptedPrefix = "enc1:"
+
+// passphraseCheck is stored encrypted, a wrong passphrase is detected before any data is decrypted with it
+const passphraseCheck = "skunk"
+
+// encryptedColumns are the columns that are encrypted by table: the content, the names and notes, and the addresses.
+// The ids of the peers are the hosts of their onion addresses, so they identify the peers like the addresses do.
+// The queries join and look up by them, so they stay plaintext and the file shows the contacts and the members of each chat:
+//   - Peers.public_key, ChatMembers.peer_id, Messages.sender_peer_id and Messages.receiver_peer_id, Chats.direct_peer_id
+//   - Reactions.peer_id, ChatBans.peer_id, InviteTokens.peer_id and PeersInInvitedChat.public_key
+//   - Presence.address, Contacts.address, Contacts.public_key and BlockedPeers.address, they are looked up by the address
+//
+// Chat and message ids, timestamps, operations, roles, settings and reactions stay plaintext as well.
+// The received files are not in the database, the peer stores them in its file directory as they are.
+var encryptedColumns = map[string][]string{
+	"Messages":           {"content", "sender_address", "receiver_address"},
+	"MessageEdits":       {"content"},
+	"Chats":              {"name", "topic", "description", "direct_peer_address"},
+	"ChatMembers":        {"username"},
+	"Peers":              {"address"},
+	"PeersInInvitedChat": {"address"},
+	"Contacts":           {"nickname", "notes"},
+}
+
+// encryptWith encrypts the value with a random nonce. Empty values stay empty, without a cipher the value stays plaintext.
+func encryptWith(aead cipher.AEAD, value string) (string, error) {
+	if aead == nil || value == "" {
+		return value, nil
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// decryptWith decrypts a value encrypted by encryptWith, without a cipher the value is returned as it is
+func decryptWith(aead cipher.AEAD, value string) (string, error) {
+	if aead == nil || value == "" {
+		return value, nil
+	}
+
+	encoded, found := strings.CutPrefix(value, encryptedPrefix)
+	if !found {
+		return "", errors.New("stored value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("stored value is too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// cipherForUse returns the cipher for the encrypted columns, nil if the database isn't encrypted
+func (a *StorageSQLiteAdapter) cipherForUse() (cipher.AEAD, error) {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+
+	if a.encrypted && a.aead == nil {
+		return nil, store.ErrDatabaseLocked
+	}
+	return a.aead, nil
+}
+
+// encrypt encrypts a value for an encrypted column
+func (a *StorageSQLiteAdapter) encrypt(value string) (string, error) {
+	aead, err := a.cipherForUse()
+	if err != nil {
+		return "", err
+	}
+	return encryptWith(aead, value)
+}
+
+// decrypt decrypts values read from encrypted columns in place
+func (a *StorageSQLiteAdapter) decrypt(values ...*string) error {
+	aead, err := a.cipherForUse()
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		*value, err = decryptWith(aead, *value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsEncrypted checks if the database has a passphrase
+func (a *StorageSQLiteAdapter) IsEncrypted() (bool, error) {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+
+	return a.encrypted, nil
+}
+
+// SetPassphrase encrypts all sensitive data of a database that has no passphrase yet
+func (a *StorageSQLiteAdapter) SetPassphrase(passphrase string) error {
+	a.keyMutex.Lock()
+	defer a.keyMutex.Unlock()
+
+	if a.encrypted {
+		return errors.New("the database already has a passphrase")
+	}
+
+	return a.reencrypt(nil, passphrase)
+}
+
+// Unlock derives the key from the passphrase, the encrypted data can be used afterwards
+func (a *StorageSQLiteAdapter) Unlock(passphrase string) error {
+	a.keyMutex.Lock()
+	defer a.keyMutex.Unlock()
+
+	if !a.encrypted {
+		return errors.New("the database has no passphrase")
+	}
+
+	aead, err := a.checkPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+
+	a.aead = aead
+	return nil
+}
+
+// ChangePassphrase encrypts all sensitive data again with a key derived from the new passphrase
+func (a *StorageSQLiteAdapter) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	a.keyMutex.Lock()
+	defer a.keyMutex.Unlock()
+
+	if !a.encrypted {
+		return errors.New("the database has no passphrase")
+	}
+
+	aead, err := a.checkPassphrase(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	return a.reencrypt(aead, newPassphrase)
+}
+
+// checkPassphrase derives the key with the stored parameters and checks it against the stored check value
+func (a *StorageSQLiteAdapter) checkPassphrase(passphrase string) (cipher.AEAD, error) {
+	var parameters util.KdfParameters
+	var check string
+	err := a.db.QueryRow("SELECT salt, time, memory, threads, check_value FROM Encryption WHERE id = 1").Scan(
+		&parameters.Salt, &parameters.Time, &parameters.Memory, &parameters.Threads, &check)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := util.DeriveKey(passphrase, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	// GCM authenticates the check value, any other key fails to decrypt it
+	if value, err := decryptWith(aead, check); err != nil || value != passphraseCheck {
+		return nil, store.ErrWrongPassphrase
+	}
+
+	return aead, nil
+}
+
+// reencrypt decrypts all encrypted columns with the old cipher, nil if they are plaintext, and encrypts them with a key
+// derived from the new passphrase with a new salt. Everything happens in one transaction, an error leaves the database as it was.
+// Afterwards the database is vacuumed, so the old values don't stay in the file.
+// The caller has to hold the write lock of the key.
+func (a *StorageSQLiteAdapter) reencrypt(old cipher.AEAD, passphrase string) error {
+	parameters, err := util.NewKdfParameters()
+	if err != nil {
+		return err
+	}
+	aead, err := util.DeriveKey(passphrase, parameters)
+	if err != nil {
+		return err
+	}
+	check, err := encryptWith(aead, passphraseCheck)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The search index contains the plaintext, encrypted databases are searched without it
+	if old == nil && a.searchModule != "" {
+		_, err = tx.Exec("DELETE FROM MessageSearch")
+		if err != nil {
+			return err
+		}
+		err = optimizeSearchIndex(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	for table, columns := range encryptedColumns {
+		err = reencryptTable(tx, table, columns, old, aead)
+		if err != nil {
+			return fmt.Errorf("encrypting %s failed: %w", table, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO Encryption (id, salt, time, memory, threads, check_value) VALUES (1, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET salt = excluded.salt, time = excluded.time, memory = excluded.memory,
+			threads = excluded.threads, check_value = excluded.check_value
+	`, parameters.Salt, parameters.Time, parameters.Memory, parameters.Threads, check)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	a.encrypted = true
+	a.aead = aead
+
+	// The updated rows are written to new pages, VACUUM rewrites the file without the freed ones
+	_, err = a.db.Exec("VACUUM")
+	return err
+}
+
+// reencryptTable encrypts the columns of all rows of the table with the new cipher, NULL values stay NULL
+func reencryptTable(tx *sql.Tx, table string, columns []string, old, next cipher.AEAD) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT rowid, %s FROM %s", strings.Join(columns, ", "), table))
+	if err != nil {
+		return err
+	}
+
+	// All rows are read before they are updated, SQLite can't update a table while it is being read
+	type row struct {
+		rowid  int64
+		values []sql.NullString
+	}
+	var stored []row
+	for rows.Next() {
+		r := row{values: make([]sql.NullString, len(columns))}
+		destinations := []interface{}{&r.rowid}
+		for i := range r.values {
+			destinations = append(destinations, &r.values[i])
+		}
+		if err := rows.Scan(destinations...); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = ?"
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", table, strings.Join(assignments, ", "))
+
+	for _, r := range stored {
+		arguments := []interface{}{}
+		for _, value := range r.values {
+			if !value.Valid {
+				arguments = append(arguments, nil)
+				continue
+			}
+
+			plaintext, err := decryptWith(old, value.String)
+			if err != nil {
+				return err
+			}
+			encrypted, err := encryptWith(next, plaintext)
+			if err != nil {
+				return err
+			}
+			arguments = append(arguments, encrypted)
+		}
+
+		_, err = tx.Exec(update, append(arguments, r.rowid)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
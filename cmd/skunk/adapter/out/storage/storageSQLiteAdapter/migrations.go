@@ -0,0 +1,375 @@
+package storageSQLiteAdapter
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A migration upgrades the schema by one version. The version of a migration is its position in migrations, starting at 1.
+type migration struct {
+	description string
+	steps       []migrationStep
+}
+
+type migrationStep func(tx *sql.Tx) error
+
+// migrations are applied in order and must never be changed or reordered once released, new schema changes get a new migration.
+// Databases from before the schema_version table have version 0 and may already contain parts of any migration,
+// so every step has to skip what already exists.
+var migrations = []migration{
+	{
+		description: "initial schema",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS Chats (
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT Chats_pk PRIMARY KEY,
+				name VARCHAR(40) NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS Peers (
+				peer_id INTEGER NOT NULL CONSTRAINT Peers_pk PRIMARY KEY AUTOINCREMENT,
+				public_key VARCHAR(1024) NOT NULL,
+				address VARCHAR(1024) NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS ChatMembers (
+				chat_member_id INTEGER NOT NULL CONSTRAINT ChatMembers_pk PRIMARY KEY AUTOINCREMENT,
+				date INTEGER NOT NULL,
+				peer_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+				username VARCHAR(50)
+			);
+
+			CREATE TABLE IF NOT EXISTS Messages (
+				message_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_pk PRIMARY KEY,
+				content TEXT,
+				date INTEGER NOT NULL,
+				operation INTEGER NOT NULL,
+				sender_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+				receiver_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk_2 REFERENCES Peers,
+				sender_address VARCHAR(1024) NOT NULL,
+				receiver_address VARCHAR(1024) NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS Invitations (
+				invitation_id INTEGER NOT NULL CONSTRAINT Invitations_pk PRIMARY KEY AUTOINCREMENT,
+				invitation_status INTEGER NOT NULL,
+				message_id VARCHAR(1024) NOT NULL CONSTRAINT Invitations_Messages_message_id_fk REFERENCES Messages
+			);
+
+			CREATE TABLE IF NOT EXISTS PeersInInvitedChat (
+				public_key VARCHAR(1024) NOT NULL,
+				invited_peer_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_pk PRIMARY KEY AUTOINCREMENT,
+				address VARCHAR(1024) NOT NULL,
+				invitation_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_Invitations_invitation_id_fk REFERENCES Invitations
+			);
+		`)},
+	},
+	{
+		description: "invitation expiry",
+		steps: []migrationStep{
+			addColumns("Invitations", "expires INTEGER NOT NULL DEFAULT 0"),
+		},
+	},
+	{
+		description: "invite tokens",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS InviteTokens (
+				secret VARCHAR(64) NOT NULL CONSTRAINT InviteTokens_pk PRIMARY KEY,
+				peer_id VARCHAR(1024) NOT NULL
+			);
+		`)},
+	},
+	{
+		description: "chat roles",
+		steps: []migrationStep{
+			addColumns("ChatMembers", "role INTEGER NOT NULL DEFAULT 0"),
+		},
+	},
+	{
+		description: "chat bans",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS ChatBans (
+				chat_ban_id INTEGER NOT NULL CONSTRAINT ChatBans_pk PRIMARY KEY AUTOINCREMENT,
+				date INTEGER NOT NULL,
+				peer_id VARCHAR(1024) NOT NULL,
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatBans_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE
+			);
+		`)},
+	},
+	{
+		description: "chat metadata",
+		steps: []migrationStep{
+			addColumns("Chats",
+				"name_updated INTEGER NOT NULL DEFAULT 0",
+				"name_message_id VARCHAR(1024) NOT NULL DEFAULT ''",
+				"topic VARCHAR(256) NOT NULL DEFAULT ''",
+				"topic_updated INTEGER NOT NULL DEFAULT 0",
+				"topic_message_id VARCHAR(1024) NOT NULL DEFAULT ''",
+				"description TEXT NOT NULL DEFAULT ''",
+				"description_updated INTEGER NOT NULL DEFAULT 0",
+				"description_message_id VARCHAR(1024) NOT NULL DEFAULT ''",
+			),
+		},
+	},
+	{
+		description: "message edits and deletions",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS MessageEdits (
+				edit_message_id VARCHAR(1024) NOT NULL CONSTRAINT MessageEdits_pk PRIMARY KEY,
+				message_id VARCHAR(1024) NOT NULL,
+				date INTEGER NOT NULL,
+				content TEXT
+			);
+
+			CREATE TABLE IF NOT EXISTS DeletedMessages (
+				message_id VARCHAR(1024) NOT NULL CONSTRAINT DeletedMessages_pk PRIMARY KEY,
+				date INTEGER NOT NULL
+			);
+		`)},
+	},
+	{
+		description: "replies",
+		steps: []migrationStep{
+			addColumns("Messages", "reply_to VARCHAR(1024)"),
+			execute(`CREATE INDEX IF NOT EXISTS Messages_reply_to_index ON Messages (reply_to);`),
+		},
+	},
+	{
+		description: "reactions",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS Reactions (
+				message_id VARCHAR(1024) NOT NULL,
+				peer_id VARCHAR(1024) NOT NULL,
+				emoji VARCHAR(32) NOT NULL,
+				active INTEGER NOT NULL,
+				date INTEGER NOT NULL,
+				reaction_message_id VARCHAR(1024) NOT NULL,
+				CONSTRAINT Reactions_pk PRIMARY KEY (message_id, peer_id, emoji)
+			);
+		`)},
+	},
+	{
+		description: "message expiry",
+		steps: []migrationStep{
+			addColumns("Chats",
+				"message_expiry INTEGER NOT NULL DEFAULT 0",
+				"message_expiry_updated INTEGER NOT NULL DEFAULT 0",
+				"message_expiry_message_id VARCHAR(1024) NOT NULL DEFAULT ''",
+			),
+		},
+	},
+	{
+		description: "presence",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS Presence (
+				address VARCHAR(1024) NOT NULL CONSTRAINT Presence_pk PRIMARY KEY,
+				online INTEGER NOT NULL,
+				last_seen INTEGER NOT NULL
+			);
+		`)},
+	},
+	{
+		description: "direct chats",
+		steps: []migrationStep{
+			addColumns("Chats",
+				"direct_peer_id VARCHAR(1024)",
+				"direct_peer_address VARCHAR(1024)",
+			),
+		},
+	},
+	{
+		description: "contacts",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS Contacts (
+				address VARCHAR(1024) NOT NULL CONSTRAINT Contacts_pk PRIMARY KEY,
+				nickname VARCHAR(40) NOT NULL,
+				public_key VARCHAR(1024) NOT NULL,
+				verified INTEGER NOT NULL DEFAULT 0,
+				notes TEXT NOT NULL DEFAULT ''
+			);
+		`)},
+	},
+	{
+		description: "block list",
+		steps: []migrationStep{
+			execute(`
+				CREATE TABLE IF NOT EXISTS BlockedPeers (
+					address VARCHAR(1024) NOT NULL CONSTRAINT BlockedPeers_pk PRIMARY KEY,
+					date INTEGER NOT NULL
+				);
+			`),
+			moveBlockedContacts,
+		},
+	},
+	{
+		description: "encryption",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS Encryption (
+				id INTEGER NOT NULL CONSTRAINT Encryption_pk PRIMARY KEY CHECK (id = 1),
+				salt BLOB NOT NULL,
+				time INTEGER NOT NULL,
+				memory INTEGER NOT NULL,
+				threads INTEGER NOT NULL,
+				check_value TEXT NOT NULL
+			);
+		`)},
+	},
+	{
+		description: "message search",
+		steps:       []migrationStep{createSearchIndex},
+	},
+	{
+		description: "message pages",
+		steps:       []migrationStep{execute(`CREATE INDEX IF NOT EXISTS Messages_chat_date_index ON Messages (chat_id, date, message_id);`)},
+	},
+	{
+		description: "retention policies",
+		steps: []migrationStep{execute(`
+			CREATE TABLE IF NOT EXISTS RetentionPolicies (
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT RetentionPolicies_pk PRIMARY KEY,
+				max_age INTEGER NOT NULL DEFAULT 0,
+				max_messages INTEGER NOT NULL DEFAULT 0,
+				max_attachment_size INTEGER NOT NULL DEFAULT 0
+			);
+
+			CREATE TABLE IF NOT EXISTS PrunedRanges (
+				chat_id VARCHAR(1024) NOT NULL CONSTRAINT PrunedRanges_pk PRIMARY KEY,
+				pruned_before INTEGER NOT NULL DEFAULT 0,
+				files_pruned_before INTEGER NOT NULL DEFAULT 0
+			);
+		`)},
+	},
+	{
+		// The addresses of invited peers are encrypted now. Without the key the plaintext addresses of an encrypted
+		// database can't be encrypted here, they are removed, nothing reads them.
+		description: "encrypted invitation addresses",
+		steps: []migrationStep{execute(`
+			UPDATE PeersInInvitedChat SET address = ''
+			WHERE address != '' AND address NOT LIKE 'enc1:%' AND EXISTS (SELECT 1 FROM Encryption);
+		`)},
+	},
+}
+
+// SchemaVersion is the newest schema version, databases with a higher version can't be opened
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// migrate upgrades the schema of the database to the newest version.
+// All pending migrations run in one transaction, so a failed upgrade leaves the database unchanged.
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	// Newer versions of Skunk may have changed the meaning of the schema, this version can't know how
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	for i := version; i < len(migrations); i++ {
+		for _, step := range migrations[i].steps {
+			err = step(tx)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", i+1, migrations[i].description, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM schema_version")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (version) VALUES (?)", len(migrations))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execute returns a step that runs the statements
+func execute(statements string) migrationStep {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// addColumns returns a step that adds the columns to the table, each given as "name definition".
+// Columns that already exist are skipped.
+func addColumns(table string, columns ...string) migrationStep {
+	return func(tx *sql.Tx) error {
+		for _, column := range columns {
+			var name string
+			fmt.Sscan(column, &name)
+
+			exists, err := hasColumn(tx, table, name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// hasColumn checks if the table has a column with the name
+func hasColumn(tx *sql.Tx, table string, name string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return false, err
+		}
+		if column == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// moveBlockedContacts moves the blocked flag that the first version of the contacts kept in the Contacts table to the block list
+func moveBlockedContacts(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "Contacts", "blocked")
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO BlockedPeers (address, date) SELECT address, ? FROM Contacts WHERE blocked", time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE Contacts DROP COLUMN blocked")
+	return err
+}
@@ -0,0 +1,116 @@
+package storageSQLiteAdapter
+
+import (
+	"database/sql"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// SetRetentionPolicy sets how much of the chat is kept, a policy without limits removes the policy of the chat
+func (a *StorageSQLiteAdapter) SetRetentionPolicy(chatID string, policy store.RetentionPolicy) error {
+	if policy == (store.RetentionPolicy{}) {
+		_, err := a.db.Exec("DELETE FROM RetentionPolicies WHERE chat_id = ?", chatID)
+		return err
+	}
+
+	_, err := a.db.Exec("INSERT OR REPLACE INTO RetentionPolicies (chat_id, max_age, max_messages, max_attachment_size) VALUES (?, ?, ?, ?)",
+		chatID, policy.MaxAge, policy.MaxMessages, policy.MaxAttachmentSize)
+	return err
+}
+
+// GetRetentionPolicy returns the retention policy of the chat, a policy without limits if the chat has none
+func (a *StorageSQLiteAdapter) GetRetentionPolicy(chatID string) (store.RetentionPolicy, error) {
+	var policy store.RetentionPolicy
+	err := a.db.QueryRow("SELECT max_age, max_messages, max_attachment_size FROM RetentionPolicies WHERE chat_id = ?", chatID).
+		Scan(&policy.MaxAge, &policy.MaxMessages, &policy.MaxAttachmentSize)
+	if err == sql.ErrNoRows {
+		return store.RetentionPolicy{}, nil
+	}
+
+	return policy, err
+}
+
+// GetRetentionPolicies returns the retention policies of all chats that have one
+func (a *StorageSQLiteAdapter) GetRetentionPolicies() (map[string]store.RetentionPolicy, error) {
+	rows, err := a.db.Query("SELECT chat_id, max_age, max_messages, max_attachment_size FROM RetentionPolicies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := map[string]store.RetentionPolicy{}
+	for rows.Next() {
+		var chatID string
+		var policy store.RetentionPolicy
+		err := rows.Scan(&chatID, &policy.MaxAge, &policy.MaxMessages, &policy.MaxAttachmentSize)
+		if err != nil {
+			return nil, err
+		}
+		policies[chatID] = policy
+	}
+
+	return policies, rows.Err()
+}
+
+// MessagesPruned extends the pruned range of the chat, bounds that are older than the stored ones are ignored
+func (a *StorageSQLiteAdapter) MessagesPruned(chatID string, pruned store.PrunedRange) error {
+	_, err := a.db.Exec(`
+		INSERT INTO PrunedRanges (chat_id, pruned_before, files_pruned_before) VALUES (?, ?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET
+			pruned_before = MAX(pruned_before, excluded.pruned_before),
+			files_pruned_before = MAX(files_pruned_before, excluded.files_pruned_before)`,
+		chatID, pruned.Before, pruned.FilesBefore)
+	return err
+}
+
+// GetPrunedRange returns which messages of the chat were pruned, an empty range if nothing was pruned
+func (a *StorageSQLiteAdapter) GetPrunedRange(chatID string) (store.PrunedRange, error) {
+	var pruned store.PrunedRange
+	err := a.db.QueryRow("SELECT pruned_before, files_pruned_before FROM PrunedRanges WHERE chat_id = ?", chatID).
+		Scan(&pruned.Before, &pruned.FilesBefore)
+	if err == sql.ErrNoRows {
+		return store.PrunedRange{}, nil
+	}
+
+	return pruned, err
+}
+
+// RemoveOrphanedPeers removes the peers that no message, membership, ban or reaction refers to
+// and the invited peers of invitations whose message is gone. It returns how many rows were removed.
+func (a *StorageSQLiteAdapter) RemoveOrphanedPeers() (int, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Memberships refer to the public key of the peer, the ones added by accepting an invitation to its id
+	peers, err := tx.Exec(`
+		DELETE FROM Peers WHERE
+			NOT EXISTS (SELECT 1 FROM Messages m WHERE m.sender_peer_id = Peers.peer_id OR m.receiver_peer_id = Peers.peer_id) AND
+			NOT EXISTS (SELECT 1 FROM ChatMembers c WHERE c.peer_id = Peers.public_key OR c.peer_id = Peers.peer_id) AND
+			NOT EXISTS (SELECT 1 FROM ChatBans b WHERE b.peer_id = Peers.public_key) AND
+			NOT EXISTS (SELECT 1 FROM Reactions r WHERE r.peer_id = Peers.public_key)`)
+	if err != nil {
+		return 0, err
+	}
+
+	invitedPeers, err := tx.Exec(`
+		DELETE FROM PeersInInvitedChat WHERE NOT EXISTS (
+			SELECT 1 FROM Invitations i JOIN Messages m ON i.message_id = m.message_id
+			WHERE i.invitation_id = PeersInInvitedChat.invitation_id)`)
+	if err != nil {
+		return 0, err
+	}
+
+	removedPeers, err := peers.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	removedInvitedPeers, err := invitedPeers.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(removedPeers + removedInvitedPeers), tx.Commit()
+}
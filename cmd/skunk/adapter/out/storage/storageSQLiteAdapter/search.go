@@ -0,0 +1,358 @@
+package storageSQLiteAdapter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"strings"
+)
+
+// Markers of the matching words in search snippets and the number of words around them
+const (
+	snippetStart    = "["
+	snippetEnd      = "]"
+	snippetEllipsis = "..."
+	snippetWords    = 10
+)
+
+// executor runs statements on the database or in a transaction
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// availableSearchModule returns the full-text search module of this SQLite build.
+// FTS5 needs the sqlite_fts5 build tag, FTS4 is always available.
+func availableSearchModule(e executor) (string, error) {
+	var fts5 bool
+	err := e.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if err != nil {
+		return "", err
+	}
+	if fts5 {
+		return "fts5", nil
+	}
+	return "fts4", nil
+}
+
+// searchIndexModule returns the module of the MessageSearch index, "" if this SQLite build can't use it.
+// A database indexed with FTS5 can still be opened by a build without it, it is searched without the index then.
+func searchIndexModule(db *sql.DB) (string, error) {
+	var definition string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'MessageSearch'").Scan(&definition)
+	if err != nil {
+		return "", err
+	}
+
+	available, err := availableSearchModule(db)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(strings.ToLower(definition), "fts5") && available != "fts5" {
+		return "", nil
+	}
+	if strings.Contains(strings.ToLower(definition), "fts5") {
+		return "fts5", nil
+	}
+	return "fts4", nil
+}
+
+// createSearchIndex creates the full-text index of the message texts and indexes the stored messages.
+// The rowid of an indexed text is the rowid of its message.
+func createSearchIndex(tx *sql.Tx) error {
+	module, err := availableSearchModule(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS MessageSearch USING %s(text)", module))
+	if err != nil {
+		return err
+	}
+
+	// The index of an encrypted database would contain the plaintext
+	var encrypted bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM Encryption)").Scan(&encrypted)
+	if err != nil || encrypted {
+		return err
+	}
+
+	return indexMessages(tx)
+}
+
+// indexMessages indexes the newest texts of all stored SEND_MESSAGE messages
+func indexMessages(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT message_id FROM Messages WHERE operation = ?", network.SEND_MESSAGE)
+	if err != nil {
+		return err
+	}
+	var messageIDs []string
+	for rows.Next() {
+		var messageID string
+		if err := rows.Scan(&messageID); err != nil {
+			rows.Close()
+			return err
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, messageID := range messageIDs {
+		if err := indexMessage(tx, messageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// optimizeSearchIndex merges the segments of the index. Deleted texts are only marked as deleted in the segments
+// until they are merged, the words of removed messages would stay in the file otherwise.
+func optimizeSearchIndex(e executor) error {
+	_, err := e.Exec("INSERT INTO MessageSearch (MessageSearch) VALUES ('optimize')")
+	return err
+}
+
+// searchIndexed checks if the messages are kept in the search index, encrypted databases are searched without it
+func (a *StorageSQLiteAdapter) searchIndexed() bool {
+	a.keyMutex.RLock()
+	defer a.keyMutex.RUnlock()
+
+	return a.searchModule != "" && !a.encrypted
+}
+
+// updateSearchIndex indexes the newest text of the message, deleted messages are removed from the index
+func (a *StorageSQLiteAdapter) updateSearchIndex(messageID string) error {
+	if !a.searchIndexed() {
+		return nil
+	}
+	return indexMessage(a.db, messageID)
+}
+
+// indexMessage replaces the indexed text of a plaintext SEND_MESSAGE message with its newest text.
+// Messages that didn't arrive yet are indexed when they arrive.
+func indexMessage(e executor, messageID string) error {
+	var rowid int64
+	var content sql.NullString
+	var operation network.OperationType
+	err := e.QueryRow("SELECT rowid, content, operation FROM Messages WHERE message_id = ?", messageID).Scan(&rowid, &content, &operation)
+	if err == sql.ErrNoRows || (err == nil && operation != network.SEND_MESSAGE) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec("DELETE FROM MessageSearch WHERE rowid = ?", rowid)
+	if err != nil {
+		return err
+	}
+
+	var deleted bool
+	err = e.QueryRow("SELECT EXISTS (SELECT 1 FROM DeletedMessages WHERE message_id = ?)", messageID).Scan(&deleted)
+	if err != nil || deleted {
+		return err
+	}
+
+	text := messageText(content.String)
+	err = e.QueryRow("SELECT content FROM MessageEdits WHERE message_id = ? ORDER BY date DESC, edit_message_id DESC LIMIT 1", messageID).Scan(&text)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+
+	_, err = e.Exec("INSERT INTO MessageSearch (rowid, text) VALUES (?, ?)", rowid, text)
+	return err
+}
+
+// messageText returns the text of the content of a SEND_MESSAGE message
+func messageText(content string) string {
+	var message struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal([]byte(content), &message)
+	return message.Message
+}
+
+// SearchMessages returns the SEND_MESSAGE messages that contain all words of the query, newest first.
+// Encrypted databases have no index, their messages are decrypted and searched one by one.
+func (a *StorageSQLiteAdapter) SearchMessages(query store.SearchQuery) ([]store.SearchResult, error) {
+	words := strings.Fields(query.Text)
+	if len(words) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+
+	if a.searchIndexed() {
+		return a.searchIndex(query, words)
+	}
+	return a.searchMessages(query, words)
+}
+
+// searchIndex finds the messages with the full-text index
+func (a *StorageSQLiteAdapter) searchIndex(query store.SearchQuery, words []string) ([]store.SearchResult, error) {
+	// Every word is quoted, so the user can't write FTS queries by accident
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+
+	snippet := fmt.Sprintf("snippet(MessageSearch, '%s', '%s', '%s', -1, %d)", snippetStart, snippetEnd, snippetEllipsis, snippetWords)
+	if a.searchModule == "fts5" {
+		snippet = fmt.Sprintf("snippet(MessageSearch, 0, '%s', '%s', '%s', %d)", snippetStart, snippetEnd, snippetEllipsis, snippetWords)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := a.db.Query(`
+		SELECT m.message_id, m.content, m.date, m.operation, p.public_key, m.chat_id, p2.public_key, m.sender_address, m.receiver_address, `+snippet+`
+		FROM MessageSearch s
+		 JOIN Messages m ON m.rowid = s.rowid
+		 JOIN Peers p ON m.sender_peer_id = p.peer_id
+		 JOIN Peers p2 ON m.receiver_peer_id = p2.peer_id
+		WHERE MessageSearch MATCH ? AND (? = '' OR m.chat_id = ?) AND (? = '' OR p.public_key = ?)
+		ORDER BY m.date DESC, m.message_id
+		LIMIT ?
+	`, strings.Join(quoted, " "), query.ChatID, query.ChatID, query.SenderID, query.SenderID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []store.SearchResult{}
+	for rows.Next() {
+		var result store.SearchResult
+		message := &result.Message
+		err := rows.Scan(
+			&message.Id, &message.Content, &message.Timestamp, &message.Operation, &message.SenderID, &message.ChatID, &message.ReceiverID,
+			&message.SenderAddress, &message.ReceiverAddress, &result.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// searchMessages decrypts the SEND_MESSAGE messages and compares their newest text with the words, ignoring case
+func (a *StorageSQLiteAdapter) searchMessages(query store.SearchQuery, words []string) ([]store.SearchResult, error) {
+	rows, err := a.db.Query(`
+		SELECT m.message_id
+		FROM Messages m
+		 JOIN Peers p ON m.sender_peer_id = p.peer_id
+		WHERE m.operation = ? AND (? = '' OR m.chat_id = ?) AND (? = '' OR p.public_key = ?)
+		  AND NOT EXISTS (SELECT 1 FROM DeletedMessages d WHERE d.message_id = m.message_id)
+		ORDER BY m.date DESC, m.message_id
+	`, network.SEND_MESSAGE, query.ChatID, query.ChatID, query.SenderID, query.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	var messageIDs []string
+	for rows.Next() {
+		var messageID string
+		if err := rows.Scan(&messageID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+
+	results := []store.SearchResult{}
+	for _, messageID := range messageIDs {
+		message, err := a.RetrieveMessage(messageID)
+		if err != nil {
+			return nil, err
+		}
+		edits, err := a.GetMessageEdits(messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		text := messageText(message.Content)
+		if len(edits) > 0 {
+			text = edits[len(edits)-1].Content
+		}
+		if !containsWords(strings.ToLower(text), words) {
+			continue
+		}
+
+		results = append(results, store.SearchResult{Message: message, Snippet: snippetOf(text, words)})
+		if len(results) == query.Limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// containsWords checks if the text contains all words
+func containsWords(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// snippetOf returns the words of the text around the first match with the matching words in brackets, like the snippets of the index
+func snippetOf(text string, words []string) string {
+	textWords := strings.Fields(text)
+
+	first := -1
+	for i, textWord := range textWords {
+		if containsAny(strings.ToLower(textWord), words) {
+			if first < 0 {
+				first = i
+			}
+			textWords[i] = snippetStart + textWord + snippetEnd
+		}
+	}
+
+	start := first - snippetWords/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(textWords) {
+		end = len(textWords)
+	}
+
+	snippet := strings.Join(textWords[start:end], " ")
+	if start > 0 {
+		snippet = snippetEllipsis + snippet
+	}
+	if end < len(textWords) {
+		snippet += snippetEllipsis
+	}
+	return snippet
+}
+
+// containsAny checks if the text contains one of the words
+func containsAny(text string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,26 +2,18 @@ package c_service
 
 import (
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/frontend"
-	"sync"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
 )
 
 type ChatApp struct {
 	frontends []frontend.Frontend
 }
 
-var (
-	chatService *ChatApp
-	once        sync.Once
-)
-
-func GetChatServiceInstance() *ChatApp {
-	once.Do(func() {
-		chatService = &ChatApp{
-			frontends: []frontend.Frontend{},
-		}
-	})
-
-	return chatService
+func NewChatApp() *ChatApp {
+	return &ChatApp{
+		frontends: []frontend.Frontend{},
+	}
 }
 
 // FrontendObserver: Gets notified when a frontend sends a message to the chat
@@ -61,6 +53,11 @@ func (c *ChatApp) ReceiveMessage(senderId string, chatId string, message string)
 	return nil
 }
 
+func (c *ChatApp) ReceiveReply(senderId string, chatId string, message string, replyTo string) error {
+	// TODO: Implement logic to handle a reply to another message
+	return nil
+}
+
 func (c *ChatApp) ReceiveChatInvitation(senderId string, chatId string, chatName string, chatMembers []string) error {
 	// TODO: Implement logic to handle received chat invitation
 	return nil
@@ -85,3 +82,53 @@ func (c *ChatApp) PeerSetsUsername(senderId string, chatId string, username stri
 	// TODO: Implement logic to handle peer setting username
 	return nil
 }
+
+func (c *ChatApp) ReceiveInvitationResponse(senderId string, chatId string, invitationId string, status store.InvitationStatus) error {
+	// TODO: Implement logic to handle an invitation being accepted, declined or revoked
+	return nil
+}
+
+func (c *ChatApp) PeerRoleChanged(senderId string, chatId string, peerId string, role store.ChatRole) error {
+	// TODO: Implement logic to handle a member getting a new role
+	return nil
+}
+
+func (c *ChatApp) PeerRemovedFromChat(senderId string, chatId string, peerId string, banned bool) error {
+	// TODO: Implement logic to handle a member getting kicked or banned
+	return nil
+}
+
+func (c *ChatApp) ChatMetadataChanged(senderId string, chatId string, field store.ChatMetadataField, value string) error {
+	// TODO: Implement logic to handle a changed chat name, topic or description
+	return nil
+}
+
+func (c *ChatApp) MessageEdited(senderId string, chatId string, messageId string, content string) error {
+	// TODO: Implement logic to handle an edited message
+	return nil
+}
+
+func (c *ChatApp) MessageDeleted(senderId string, chatId string, messageId string) error {
+	// TODO: Implement logic to handle a deleted message
+	return nil
+}
+
+func (c *ChatApp) ReactionChanged(senderId string, chatId string, messageId string, emoji string, add bool) error {
+	// TODO: Implement logic to handle an added or removed reaction
+	return nil
+}
+
+func (c *ChatApp) ChatSettingsChanged(senderId string, chatId string, messageExpiry time.Duration) error {
+	// TODO: Implement logic to handle changed chat settings
+	return nil
+}
+
+func (c *ChatApp) PeerTyping(senderId string, chatId string, typing bool) error {
+	// TODO: Implement logic to show or hide the typing indicator
+	return nil
+}
+
+func (c *ChatApp) PresenceChanged(address string, online bool, lastSeen int64) error {
+	// TODO: Implement logic to show the presence of the peer
+	return nil
+}
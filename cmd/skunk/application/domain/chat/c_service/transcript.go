@@ -0,0 +1,366 @@
+package c_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// TranscriptFormat is the file format of an exported chat
+type TranscriptFormat string
+
+const (
+	TRANSCRIPT_JSON     TranscriptFormat = "json"
+	TRANSCRIPT_MARKDOWN TranscriptFormat = "md"
+	TRANSCRIPT_HTML     TranscriptFormat = "html"
+)
+
+// TranscriptDateLayout is the layout of the dates of a transcript range
+const TranscriptDateLayout = "2006-01-02"
+
+// ParseTranscriptFormat returns the format with the name, markdown is accepted for md
+func ParseTranscriptFormat(name string) (TranscriptFormat, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return TRANSCRIPT_JSON, nil
+	case "md", "markdown":
+		return TRANSCRIPT_MARKDOWN, nil
+	case "html":
+		return TRANSCRIPT_HTML, nil
+	default:
+		return "", fmt.Errorf("unknown transcript format %q, use json, md or html", name)
+	}
+}
+
+// ParseTranscriptRange parses the first and the last day of a transcript, both are included. Empty dates leave the range open.
+func ParseTranscriptRange(from, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if from != "" {
+		start, err = time.ParseInLocation(TranscriptDateLayout, from, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start date %q, use YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		end, err = time.ParseInLocation(TranscriptDateLayout, to, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end date %q, use YYYY-MM-DD", to)
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the start date %s is after the end date %s", from, to)
+	}
+	return start, end, nil
+}
+
+// TranscriptOptions selects the format and the messages of a transcript
+type TranscriptOptions struct {
+	Format TranscriptFormat
+	From   time.Time // first time that is exported, the zero time exports from the beginning
+	To     time.Time // messages at or after this time aren't exported, the zero time exports until the end
+}
+
+// Kinds of transcript entries
+const (
+	EntryMessage  = "message"
+	EntryFile     = "file"
+	EntryJoin     = "join"
+	EntryLeave    = "leave"
+	EntryUsername = "username"
+	EntryMetadata = "metadata"
+	EntryRemoved  = "removed"
+)
+
+// TranscriptEntry is a message or an event of an exported chat
+type TranscriptEntry struct {
+	Id       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	SenderID string    `json:"senderId"`
+	Sender   string    `json:"sender"` // username of the sender in the chat, the SenderID if it has none
+	Text     string    `json:"text"`   // text of a message or a description of an event
+	File     string    `json:"file,omitempty"`
+	ReplyTo  string    `json:"replyTo,omitempty"`
+	Edited   bool      `json:"edited,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
+// Transcript is an exported chat with its messages and events, oldest first
+type Transcript struct {
+	ChatID   string            `json:"chatId"`
+	ChatName string            `json:"chatName"`
+	Exported time.Time         `json:"exported"`
+	From     *time.Time        `json:"from,omitempty"`
+	To       *time.Time        `json:"to,omitempty"`
+	Entries  []TranscriptEntry `json:"entries"`
+}
+
+// BuildTranscript reads the stored messages of the chat and resolves the usernames of their senders.
+// Edits and deletions are applied to the messages they change, even when they are outside the range.
+// Like the peer, only the sender of a message may edit or delete it.
+func BuildTranscript(storage store.DisplayStoragePort, chatID string, options TranscriptOptions) (Transcript, error) {
+	transcript := Transcript{ChatID: chatID, ChatName: chatID, Exported: time.Now()}
+	if !options.From.IsZero() {
+		transcript.From = &options.From
+	}
+	if !options.To.IsZero() {
+		transcript.To = &options.To
+	}
+
+	chats, err := storage.GetChats()
+	if err != nil {
+		return Transcript{}, err
+	}
+	for _, chat := range chats {
+		if chat.ChatId == chatID && chat.ChatName != "" {
+			transcript.ChatName = chat.ChatName
+		}
+	}
+
+	messages, err := storage.GetChatMessages(chatID)
+	if err != nil {
+		return Transcript{}, err
+	}
+
+	usernames := map[string]string{}
+	sender := func(peerID string) string {
+		if username, known := usernames[peerID]; known {
+			return username
+		}
+		username, err := storage.GetUsername(peerID, chatID)
+		if err != nil || username == "" {
+			username = peerID
+		}
+		usernames[peerID] = username
+		return username
+	}
+
+	var entries []TranscriptEntry
+	index := map[string]int{}
+	for _, message := range messages {
+		var content struct {
+			MessageID     string `json:"messageId"`
+			Message       string `json:"message"`
+			ReplyTo       string `json:"replyTo"`
+			Username      string `json:"username"`
+			PeerID        string `json:"peerId"`
+			FileName      string `json:"fileName"`
+			FileExtension string `json:"fileExtension"`
+		}
+		json.Unmarshal([]byte(message.Content), &content)
+
+		entry := TranscriptEntry{
+			Id:       message.Id,
+			Time:     time.Unix(0, message.Timestamp),
+			SenderID: message.SenderID,
+			Sender:   sender(message.SenderID),
+		}
+
+		switch message.Operation {
+		case network.SEND_MESSAGE:
+			entry.Kind = EntryMessage
+			entry.Text = content.Message
+			entry.ReplyTo = content.ReplyTo
+		case network.EDIT_MESSAGE, network.DELETE_MESSAGE:
+			i, found := index[content.MessageID]
+			if !found || entries[i].Deleted || entries[i].Kind != EntryMessage || entries[i].SenderID != message.SenderID {
+				continue
+			}
+			if message.Operation == network.DELETE_MESSAGE {
+				entries[i].Deleted = true
+				entries[i].Text = ""
+			} else {
+				entries[i].Edited = true
+				entries[i].Text = content.Message
+			}
+			continue
+		case network.SEND_FILE:
+			entry.Kind = EntryFile
+			entry.File = content.FileName
+			if content.FileExtension != "" {
+				entry.File += "." + content.FileExtension
+			}
+			entry.Text = fmt.Sprintf("%s sent the file %s", entry.Sender, entry.File)
+		case network.JOIN_CHAT:
+			entry.Kind = EntryJoin
+			entry.Text = fmt.Sprintf("%s joined the chat", entry.Sender)
+		case network.LEAVE_CHAT:
+			entry.Kind = EntryLeave
+			entry.Text = fmt.Sprintf("%s left the chat", entry.Sender)
+		case network.SET_USERNAME:
+			entry.Kind = EntryUsername
+			entry.Text = fmt.Sprintf("%s changed their username to %s", message.SenderID, content.Username)
+		case network.REMOVE_MEMBER, network.BAN_MEMBER:
+			entry.Kind = EntryRemoved
+			action := "removed"
+			if message.Operation == network.BAN_MEMBER {
+				action = "banned"
+			}
+			entry.Text = fmt.Sprintf("%s %s %s from the chat", entry.Sender, action, sender(content.PeerID))
+		case network.UPDATE_CHAT_METADATA:
+			var update p_model.ChatMetadataUpdate
+			if json.Unmarshal([]byte(message.Content), &update) != nil {
+				continue
+			}
+			entry.Kind = EntryMetadata
+			entry.Text = metadataText(entry.Sender, update)
+		default:
+			// Invitations, reactions, roles, settings and the sync are no part of the conversation
+			continue
+		}
+
+		index[message.Id] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	for _, entry := range entries {
+		if (options.From.IsZero() || !entry.Time.Before(options.From)) && (options.To.IsZero() || entry.Time.Before(options.To)) {
+			transcript.Entries = append(transcript.Entries, entry)
+		}
+	}
+	return transcript, nil
+}
+
+// metadataText describes the changes of an UPDATE_CHAT_METADATA message
+func metadataText(sender string, update p_model.ChatMetadataUpdate) string {
+	var changes []string
+	if update.Name != nil {
+		changes = append(changes, fmt.Sprintf("renamed the chat to %s", *update.Name))
+	}
+	if update.Topic != nil {
+		changes = append(changes, fmt.Sprintf("set the topic to %s", *update.Topic))
+	}
+	if update.Description != nil {
+		changes = append(changes, fmt.Sprintf("set the description to %s", *update.Description))
+	}
+	return fmt.Sprintf("%s %s", sender, strings.Join(changes, " and "))
+}
+
+// ExportTranscript writes the transcript of the chat in the format of the options
+func ExportTranscript(storage store.DisplayStoragePort, chatID string, options TranscriptOptions, w io.Writer) error {
+	transcript, err := BuildTranscript(storage, chatID, options)
+	if err != nil {
+		return err
+	}
+
+	switch options.Format {
+	case TRANSCRIPT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(transcript)
+	case TRANSCRIPT_MARKDOWN:
+		return writeMarkdownTranscript(transcript, w)
+	case TRANSCRIPT_HTML:
+		return htmlTranscript.Execute(w, transcript)
+	default:
+		return fmt.Errorf("unknown transcript format %q, use json, md or html", options.Format)
+	}
+}
+
+// markdownEscaper escapes the characters that would format the text
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+	"\n", "  \n  ",
+)
+
+// writeMarkdownTranscript writes the entries as a list with a heading for every day
+func writeMarkdownTranscript(transcript Transcript, w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", markdownEscaper.Replace(transcript.ChatName))
+	fmt.Fprintf(&b, "Exported on %s%s\n", transcript.Exported.Format("2006-01-02 15:04"), transcriptRange(transcript))
+
+	day := ""
+	for _, entry := range transcript.Entries {
+		if entryDay := entry.Time.Format(TranscriptDateLayout); entryDay != day {
+			day = entryDay
+			fmt.Fprintf(&b, "\n## %s\n\n", day)
+		}
+
+		clock := entry.Time.Format("15:04:05")
+		if entry.Kind != EntryMessage {
+			fmt.Fprintf(&b, "- %s _%s_\n", clock, markdownEscaper.Replace(entry.Text))
+			continue
+		}
+
+		text := markdownEscaper.Replace(entry.Text)
+		if entry.Deleted {
+			text = "_This message was deleted_"
+		} else if entry.Edited {
+			text += " _(edited)_"
+		}
+		reply := ""
+		if entry.ReplyTo != "" {
+			reply = fmt.Sprintf("↪ %s ", markdownEscaper.Replace(replyPreview(transcript, entry.ReplyTo)))
+		}
+		fmt.Fprintf(&b, "- %s **%s:** %s%s\n", clock, markdownEscaper.Replace(entry.Sender), reply, text)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// transcriptRange describes the range of the transcript, empty if it contains the whole chat
+func transcriptRange(transcript Transcript) string {
+	switch {
+	case transcript.From != nil && transcript.To != nil:
+		return fmt.Sprintf(", from %s to %s", transcript.From.Format(TranscriptDateLayout), transcript.To.AddDate(0, 0, -1).Format(TranscriptDateLayout))
+	case transcript.From != nil:
+		return fmt.Sprintf(", from %s", transcript.From.Format(TranscriptDateLayout))
+	case transcript.To != nil:
+		return fmt.Sprintf(", until %s", transcript.To.AddDate(0, 0, -1).Format(TranscriptDateLayout))
+	}
+	return ""
+}
+
+// replyPreview describes the message a reply refers to
+func replyPreview(transcript Transcript, messageID string) string {
+	for _, entry := range transcript.Entries {
+		if entry.Id == messageID {
+			return fmt.Sprintf("reply to %s:", entry.Sender)
+		}
+	}
+	return "reply:"
+}
+
+// htmlTranscript is a self-contained page, it loads no styles or scripts. The template escapes all texts.
+var htmlTranscript = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"day":    func(t time.Time) string { return t.Format(TranscriptDateLayout) },
+	"clock":  func(t time.Time) string { return t.Format("15:04:05") },
+	"period": transcriptRange,
+	"reply":  replyPreview,
+	"newDay": func(entries []TranscriptEntry, i int) bool {
+		return i == 0 || entries[i-1].Time.Format(TranscriptDateLayout) != entries[i].Time.Format(TranscriptDateLayout)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.ChatName}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #222; }
+h2 { font-size: 1em; color: #666; border-bottom: 1px solid #ddd; margin-top: 2em; }
+.entry { margin: .4em 0; }
+.time { color: #999; font-size: .85em; margin-right: .5em; }
+.sender { font-weight: bold; }
+.event, .note { color: #666; font-style: italic; }
+.reply { color: #666; }
+.text { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.ChatName}}</h1>
+<p class="note">Exported on {{.Exported.Format "2006-01-02 15:04"}}{{period $}}</p>
+{{$t := .}}{{range $i, $e := .Entries}}{{if newDay $t.Entries $i}}<h2>{{day $e.Time}}</h2>
+{{end}}<div class="entry" id="{{$e.Id}}"><span class="time">{{clock $e.Time}}</span>{{if eq $e.Kind "message"}}<span class="sender">{{$e.Sender}}:</span> {{if $e.ReplyTo}}<a class="reply" href="#{{$e.ReplyTo}}">↪ {{reply $t $e.ReplyTo}}</a> {{end}}{{if $e.Deleted}}<span class="note">This message was deleted</span>{{else}}<span class="text">{{$e.Text}}</span>{{if $e.Edited}} <span class="note">(edited)</span>{{end}}{{end}}{{else}}<span class="event">{{$e.Text}}</span>{{end}}</div>
+{{end}}</body>
+</html>
+`))
@@ -1,10 +1,26 @@
 package chat
 
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
+)
+
 type ChatLogic interface {
 	ReceiveMessage(senderId string, chatId string, message string) error
+	ReceiveReply(senderId string, chatId string, message string, replyTo string) error
 	ReceiveChatInvitation(senderId string, chatId string, chatName string, chatMembers []string) error
 	PeerLeavesChat(senderId string, chatId string) error
 	PeerJoinsChat(senderId string, chatId string) error
 	ReceiveFile(senderId string, chatId string, filePath string) error
 	PeerSetsUsername(senderId string, chatId string, username string) error
+	ReceiveInvitationResponse(senderId string, chatId string, invitationId string, status store.InvitationStatus) error
+	PeerRoleChanged(senderId string, chatId string, peerId string, role store.ChatRole) error
+	PeerRemovedFromChat(senderId string, chatId string, peerId string, banned bool) error
+	ChatMetadataChanged(senderId string, chatId string, field store.ChatMetadataField, value string) error
+	MessageEdited(senderId string, chatId string, messageId string, content string) error
+	MessageDeleted(senderId string, chatId string, messageId string) error
+	ReactionChanged(senderId string, chatId string, messageId string, emoji string, add bool) error
+	ChatSettingsChanged(senderId string, chatId string, messageExpiry time.Duration) error // messageExpiry is 0 if messages don't disappear
+	PeerTyping(senderId string, chatId string, typing bool) error                          // typing is false once the peer stopped or the indicator expired
+	PresenceChanged(address string, online bool, lastSeen int64) error                     // lastSeen is 0 if the peer was never seen
 }
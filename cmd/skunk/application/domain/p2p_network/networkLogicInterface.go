@@ -1,11 +1,34 @@
 package p2p_network
 
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
+)
+
 type NetworkLogic interface {
 	CreateChat(chatId string, chatName string) error
 	JoinChat(chatId string) error
 	LeaveChat(chatId string) error
 	InviteToChat(chatId string, peerId string) error
+	AcceptInvitation(invitationId string) error
+	DeclineInvitation(invitationId string) error
+	RevokeInvitation(invitationId string) error
+	CreateInviteToken(chatId string) (string, error)
+	JoinChatWithToken(inviteToken string) error
+	SetRole(chatId string, peerId string, role store.ChatRole) error
+	RemoveMember(chatId string, peerId string) error
+	BanMember(chatId string, peerId string) error
+	UpdateChatMetadata(chatId string, field store.ChatMetadataField, value string) error
+	SetMessageExpiry(chatId string, expiry time.Duration) error
 	SendFileToChat(chatId string, filePath string) error
 	SetUsernameInChat(chatId string, username string) error
 	SendMessageToChat(chatId string, message string) error
+	SendDirectMessage(peerId string, peerAddress string, message string) error
+	BlockPeer(address string) error
+	UnblockPeer(address string) error
+	SetTyping(chatId string, typing bool) error
+	ReplyToMessage(chatId string, replyTo string, message string) error
+	EditMessage(chatId string, messageId string, message string) error
+	DeleteMessage(chatId string, messageId string) error
+	React(chatId string, messageId string, emoji string, add bool) error
 }
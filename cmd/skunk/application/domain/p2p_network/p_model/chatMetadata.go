@@ -0,0 +1,74 @@
+package p_model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// Maximum lengths of the chat metadata.
+const (
+	MaxChatNameLength        = 30
+	MaxChatTopicLength       = 256
+	MaxChatDescriptionLength = 1024
+)
+
+// ChatMetadataUpdate is the content of an UPDATE_CHAT_METADATA message. Fields that are nil are not changed.
+type ChatMetadataUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// NewChatMetadataUpdate creates an update that changes a single field.
+func NewChatMetadataUpdate(field store.ChatMetadataField, value string) (ChatMetadataUpdate, error) {
+	switch field {
+	case store.CHAT_NAME:
+		return ChatMetadataUpdate{Name: &value}, nil
+	case store.CHAT_TOPIC:
+		return ChatMetadataUpdate{Topic: &value}, nil
+	case store.CHAT_DESCRIPTION:
+		return ChatMetadataUpdate{Description: &value}, nil
+	default:
+		return ChatMetadataUpdate{}, fmt.Errorf("unknown chat metadata field: %d", field)
+	}
+}
+
+// Fields returns the fields changed by the update.
+func (u ChatMetadataUpdate) Fields() map[store.ChatMetadataField]string {
+	fields := map[store.ChatMetadataField]string{}
+	if u.Name != nil {
+		fields[store.CHAT_NAME] = *u.Name
+	}
+	if u.Topic != nil {
+		fields[store.CHAT_TOPIC] = *u.Topic
+	}
+	if u.Description != nil {
+		fields[store.CHAT_DESCRIPTION] = *u.Description
+	}
+	return fields
+}
+
+// Validate checks that the update changes at least one field, that the name is not empty
+// and that no field is too long. Topic and description can be cleared.
+func (u ChatMetadataUpdate) Validate() error {
+	if u.Name == nil && u.Topic == nil && u.Description == nil {
+		return errors.New("chat metadata update does not change anything")
+	}
+
+	if u.Name != nil && (strings.TrimSpace(*u.Name) == "" || len(*u.Name) > MaxChatNameLength) {
+		return fmt.Errorf("chat name has to be between 1 and %d characters long", MaxChatNameLength)
+	}
+
+	if u.Topic != nil && len(*u.Topic) > MaxChatTopicLength {
+		return fmt.Errorf("chat topic can't be longer than %d characters", MaxChatTopicLength)
+	}
+
+	if u.Description != nil && len(*u.Description) > MaxChatDescriptionLength {
+		return fmt.Errorf("chat description can't be longer than %d characters", MaxChatDescriptionLength)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package p_model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limits of the message expiry of chats with disappearing messages.
+const (
+	MinMessageExpiry = 30 * time.Second
+	MaxMessageExpiry = 365 * 24 * time.Hour
+)
+
+// ChatSettings is the content of an UPDATE_CHAT_SETTINGS message. Fields that are nil are not changed.
+// Unlike the chat metadata, every peer has to enforce the settings.
+type ChatSettings struct {
+	MessageExpiry *int64 `json:"messageExpiry,omitempty"` // in seconds, 0 turns disappearing messages off
+}
+
+// NewMessageExpirySettings creates settings that only change the message expiry.
+func NewMessageExpirySettings(expiry time.Duration) ChatSettings {
+	seconds := int64(expiry / time.Second)
+	return ChatSettings{MessageExpiry: &seconds}
+}
+
+// Expiry returns the message expiry as a duration.
+func (s ChatSettings) Expiry() time.Duration {
+	if s.MessageExpiry == nil {
+		return 0
+	}
+	return time.Duration(*s.MessageExpiry) * time.Second
+}
+
+// Validate checks that the settings change something and that the message expiry is either off or within the limits.
+func (s ChatSettings) Validate() error {
+	if s.MessageExpiry == nil {
+		return errors.New("chat settings update does not change anything")
+	}
+
+	expiry := s.Expiry()
+	if *s.MessageExpiry != 0 && (expiry < MinMessageExpiry || expiry > MaxMessageExpiry) {
+		return fmt.Errorf("message expiry has to be between %v and %v", MinMessageExpiry, MaxMessageExpiry)
+	}
+
+	return nil
+}
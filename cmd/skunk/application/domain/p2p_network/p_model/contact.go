@@ -0,0 +1,55 @@
+package p_model
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// Maximum lengths of the fields of a contact.
+const (
+	MaxContactNicknameLength = 40
+	MaxContactNotesLength    = 1024
+)
+
+// NewContact creates an unverified contact for the onion address.
+// The public key is part of a v3 onion address, so the user doesn't have to enter it.
+func NewContact(nickname, address, notes string) (store.Contact, error) {
+	publicKey, err := PublicKeyFromOnionAddress(address)
+	if err != nil {
+		return store.Contact{}, err
+	}
+
+	contact := store.Contact{
+		Address:   OnionHost(address),
+		Nickname:  strings.TrimSpace(nickname),
+		PublicKey: hex.EncodeToString(publicKey),
+		Notes:     notes,
+	}
+	return contact, ValidateContact(contact)
+}
+
+// ValidateContact checks that the contact has a nickname, that no field is too long
+// and that the public key belongs to the onion address.
+func ValidateContact(contact store.Contact) error {
+	if strings.TrimSpace(contact.Nickname) == "" || len(contact.Nickname) > MaxContactNicknameLength {
+		return fmt.Errorf("nickname has to be between 1 and %d characters long", MaxContactNicknameLength)
+	}
+
+	if len(contact.Notes) > MaxContactNotesLength {
+		return fmt.Errorf("notes can't be longer than %d characters", MaxContactNotesLength)
+	}
+
+	publicKey, err := PublicKeyFromOnionAddress(contact.Address)
+	if err != nil {
+		return err
+	}
+
+	if contact.PublicKey != hex.EncodeToString(publicKey) {
+		return fmt.Errorf("the public key does not belong to %s", contact.Address)
+	}
+
+	return nil
+}
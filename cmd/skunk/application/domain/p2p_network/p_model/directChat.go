@@ -0,0 +1,26 @@
+package p_model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// DirectChatPrefix marks the ids of direct chats, group chats have random ids.
+const DirectChatPrefix = "direct-"
+
+// DirectChatID returns the id of the direct chat between the peers with the two onion addresses.
+// Both peers derive the same id, so a direct chat needs no invitation.
+func DirectChatID(addressA, addressB string) string {
+	hosts := []string{OnionHost(addressA), OnionHost(addressB)}
+	sort.Strings(hosts)
+
+	hash := sha256.Sum256([]byte(hosts[0] + "\n" + hosts[1]))
+	return DirectChatPrefix + hex.EncodeToString(hash[:])
+}
+
+// IsDirectChatID checks if the chat is a direct chat between two peers.
+func IsDirectChatID(chatID string) bool {
+	return strings.HasPrefix(chatID, DirectChatPrefix)
+}
@@ -0,0 +1,103 @@
+package p_model
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+)
+
+// Identity is the onion address of this peer. This peer is "self" in the storage and in the messages it creates,
+// the other peers know it by the host of its onion address. Messages are translated when they leave or reach the peer.
+// The zero value is a peer that is not online yet, its messages are not translated.
+type Identity struct {
+	mutex   sync.RWMutex
+	address string
+}
+
+// Set sets the onion address once the network connection is online
+func (i *Identity) Set(address string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.address = address
+}
+
+// Address returns the onion address of this peer, empty while offline
+func (i *Identity) Address() string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.address
+}
+
+// ID returns the id the other peers know this peer by, empty while offline
+func (i *Identity) ID() string {
+	address := i.Address()
+	if address == "" {
+		return ""
+	}
+	return OnionHost(address)
+}
+
+// IsSelf checks if the peer id is "self" or the id of this peer
+func (i *Identity) IsSelf(peerID string) bool {
+	return peerID == "self" || (peerID != "" && peerID == i.ID())
+}
+
+// LocalID returns "self" for the id of this peer, the ids of other peers are returned unchanged
+func (i *Identity) LocalID(peerID string) string {
+	if i.IsSelf(peerID) {
+		return "self"
+	}
+	return peerID
+}
+
+// ToNetwork replaces "self" in the sender and in the member a SET_ROLE, REMOVE_MEMBER or BAN_MEMBER message refers to
+// with the id and address of this peer
+func (i *Identity) ToNetwork(message network.Message) network.Message {
+	address := i.Address()
+	if address == "" {
+		return message
+	}
+
+	if message.SenderID == "self" {
+		message.SenderID = OnionHost(address)
+	}
+	if message.SenderAddress == "self" {
+		message.SenderAddress = address
+	}
+	return replaceMember(message, "self", OnionHost(address))
+}
+
+// FromNetwork replaces the id of this peer in the member a SET_ROLE, REMOVE_MEMBER or BAN_MEMBER message refers to with "self"
+func (i *Identity) FromNetwork(message network.Message) network.Message {
+	id := i.ID()
+	if id == "" {
+		return message
+	}
+	return replaceMember(message, id, "self")
+}
+
+// replaceMember replaces the peerId in the content of the messages that refer to a member
+func replaceMember(message network.Message, from, to string) network.Message {
+	switch message.Operation {
+	case network.SET_ROLE, network.REMOVE_MEMBER, network.BAN_MEMBER:
+	default:
+		return message
+	}
+
+	var content map[string]json.RawMessage
+	if json.Unmarshal([]byte(message.Content), &content) != nil {
+		return message
+	}
+
+	var peerID string
+	if json.Unmarshal(content["peerId"], &peerID) != nil || peerID != from {
+		return message
+	}
+
+	content["peerId"], _ = json.Marshal(to)
+	if replaced, err := json.Marshal(content); err == nil {
+		message.Content = string(replaced)
+	}
+	return message
+}
@@ -0,0 +1,177 @@
+package p_model
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// InviteTokenScheme is the URI scheme used for shareable invite tokens.
+const InviteTokenScheme = "skunk://join/"
+
+// onionVersion is the version byte at the end of a v3 onion address.
+const onionVersion = 0x03
+
+// onionChecksumPrefix is hashed with the public key and the version into the checksum of a v3 onion address.
+const onionChecksumPrefix = ".onion checksum"
+
+// InviteToken is a signed, expiring invitation to a chat that can be shared as a link or QR code.
+// Anybody holding the token can use it once to join the chat.
+type InviteToken struct {
+	ChatID         string `json:"chatId"`
+	ChatName       string `json:"chatName"`
+	InviterID      string `json:"inviterId"`
+	InviterAddress string `json:"inviterAddress"`
+	Secret         string `json:"secret"`
+	ExpiresAt      int64  `json:"expiresAt"`
+	Signature      []byte `json:"-"`
+}
+
+// NewInviteToken creates an unsigned token with a fresh one-time secret.
+func NewInviteToken(chatID, chatName, inviterID, inviterAddress string, expiresAt int64) (InviteToken, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return InviteToken{}, fmt.Errorf("failed to generate invite secret: %v", err)
+	}
+
+	return InviteToken{
+		ChatID:         chatID,
+		ChatName:       chatName,
+		InviterID:      inviterID,
+		InviterAddress: inviterAddress,
+		Secret:         hex.EncodeToString(secret),
+		ExpiresAt:      expiresAt,
+	}, nil
+}
+
+// Sign signs the token with the private key belonging to the inviter's onion address.
+func (t *InviteToken) Sign(privateKey ed25519.PrivateKey) error {
+	payload, err := t.payload()
+	if err != nil {
+		return err
+	}
+
+	t.Signature = ed25519.Sign(privateKey, payload)
+	return nil
+}
+
+// Verify checks that the token was signed by the owner of the inviter's onion address and has not expired.
+func (t InviteToken) Verify(now int64) error {
+	publicKey, err := PublicKeyFromOnionAddress(t.InviterAddress)
+	if err != nil {
+		return err
+	}
+
+	payload, err := t.payload()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, payload, t.Signature) {
+		return errors.New("invalid invite token signature")
+	}
+
+	if t.ExpiresAt != 0 && t.ExpiresAt <= now {
+		return errors.New("invite token has expired")
+	}
+
+	return nil
+}
+
+// Encode returns the token as a compact skunk:// URI.
+func (t InviteToken) Encode() (string, error) {
+	payload, err := t.payload()
+	if err != nil {
+		return "", err
+	}
+
+	return InviteTokenScheme + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(t.Signature), nil
+}
+
+// ParseInviteToken decodes a skunk:// URI. The token still has to be verified.
+func ParseInviteToken(uri string) (InviteToken, error) {
+	uri = strings.TrimSpace(uri)
+	if !strings.HasPrefix(uri, InviteTokenScheme) {
+		return InviteToken{}, fmt.Errorf("invite token has to start with %s", InviteTokenScheme)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(uri, InviteTokenScheme), ".")
+	if len(parts) != 2 {
+		return InviteToken{}, errors.New("malformed invite token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return InviteToken{}, fmt.Errorf("malformed invite token payload: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return InviteToken{}, fmt.Errorf("malformed invite token signature: %v", err)
+	}
+
+	var token InviteToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return InviteToken{}, fmt.Errorf("malformed invite token payload: %v", err)
+	}
+	token.Signature = signature
+
+	return token, nil
+}
+
+// PublicKeyFromOnionAddress extracts the ed25519 public key from a v3 onion address.
+// The address may be given with or without the ws:// scheme and port.
+func PublicKeyFromOnionAddress(address string) (ed25519.PublicKey, error) {
+	host := strings.TrimSuffix(OnionHost(address), ".onion")
+
+	// v3 onion address: base32(public key (32 bytes) | checksum (2 bytes) | version (1 byte))
+	decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(host))
+	if err != nil || len(decoded) != ed25519.PublicKeySize+3 {
+		return nil, fmt.Errorf("%s is not a valid v3 onion address", address)
+	}
+
+	if decoded[len(decoded)-1] != onionVersion {
+		return nil, fmt.Errorf("unsupported onion address version: %d", decoded[len(decoded)-1])
+	}
+
+	publicKey := ed25519.PublicKey(decoded[:ed25519.PublicKeySize])
+	checksum := onionChecksum(publicKey)
+	if decoded[ed25519.PublicKeySize] != checksum[0] || decoded[ed25519.PublicKeySize+1] != checksum[1] {
+		return nil, fmt.Errorf("%s has an invalid checksum, it may be mistyped", address)
+	}
+
+	return publicKey, nil
+}
+
+// OnionAddressFromPublicKey returns the v3 onion address of the ed25519 public key, without scheme and port.
+func OnionAddressFromPublicKey(publicKey ed25519.PublicKey) string {
+	checksum := onionChecksum(publicKey)
+	raw := append(append([]byte{}, publicKey...), checksum[0], checksum[1], onionVersion)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(raw)) + ".onion"
+}
+
+// onionChecksum returns the checksum of a v3 onion address: SHA3-256(".onion checksum" | public key | version)[:2]
+func onionChecksum(publicKey ed25519.PublicKey) []byte {
+	hash := sha3.Sum256(append(append([]byte(onionChecksumPrefix), publicKey...), onionVersion))
+	return hash[:2]
+}
+
+// OnionHost returns the host of an onion address that is given with or without the ws:// scheme and port.
+// Peers are identified by it no matter how their address is written.
+func OnionHost(address string) string {
+	host := strings.TrimPrefix(address, "ws://")
+	return strings.SplitN(host, ":", 2)[0]
+}
+
+// payload is the signed part of the token.
+func (t InviteToken) payload() ([]byte, error) {
+	return json.Marshal(t)
+}
@@ -0,0 +1,227 @@
+package p_model
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// RequiredRoles maps operations that change a chat to the minimum role a member needs to perform them.
+var RequiredRoles = map[network.OperationType]store.ChatRole{
+	network.INVITE_TO_CHAT:       store.ROLE_ADMIN,
+	network.SET_ROLE:             store.ROLE_OWNER,
+	network.REMOVE_MEMBER:        store.ROLE_ADMIN,
+	network.BAN_MEMBER:           store.ROLE_ADMIN,
+	network.UPDATE_CHAT_METADATA: store.ROLE_ADMIN,
+	network.UPDATE_CHAT_SETTINGS: store.ROLE_ADMIN,
+}
+
+// Membership is the member list of a chat as an add-wins observed-remove set (OR-set).
+// Every join adds a unique tag (the id of the JOIN_CHAT message) for the peer and a removal only removes
+// the tags it has observed. A join that is concurrent to a removal survives, so all peers that stored the
+// same messages end up with the same members, regardless of the order in which the messages arrived.
+// The roles of the members are derived from the same messages, kicks and bans only count if the sender had the role.
+type Membership struct {
+	adds    map[string]map[string]struct{} // peer id -> tags of its joins
+	removed map[string]struct{}            // tags removed by leaves, kicks and bans
+	roles   map[string]store.ChatRole      // roles granted by SET_ROLE messages, other members are ROLE_MEMBER
+}
+
+// NewMembership creates an empty membership set.
+func NewMembership() *Membership {
+	return &Membership{
+		adds:    map[string]map[string]struct{}{},
+		removed: map[string]struct{}{},
+		roles:   map[string]store.ChatRole{},
+	}
+}
+
+// Add adds the peer with a unique tag.
+func (m *Membership) Add(peerID, tag string) {
+	if _, exists := m.adds[peerID]; !exists {
+		m.adds[peerID] = map[string]struct{}{}
+	}
+	m.adds[peerID][tag] = struct{}{}
+}
+
+// Remove removes the observed tags. Tags that are added later are not affected.
+func (m *Membership) Remove(tags []string) {
+	for _, tag := range tags {
+		m.removed[tag] = struct{}{}
+	}
+}
+
+// Merge merges another membership set into this one. Roles are not merged, they depend on the order of the whole history.
+func (m *Membership) Merge(other *Membership) {
+	for peerID, tags := range other.adds {
+		for tag := range tags {
+			m.Add(peerID, tag)
+		}
+	}
+	for tag := range other.removed {
+		m.removed[tag] = struct{}{}
+	}
+}
+
+// Tags returns the sorted tags of the peer that have not been removed.
+// A removal of the peer has to contain these tags.
+func (m *Membership) Tags(peerID string) []string {
+	tags := []string{}
+	for tag := range m.adds[peerID] {
+		if _, removed := m.removed[tag]; !removed {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Contains checks if the peer is a member.
+func (m *Membership) Contains(peerID string) bool {
+	return len(m.Tags(peerID)) > 0
+}
+
+// Role returns the role of the member, peers that are not members have ROLE_MEMBER.
+func (m *Membership) Role(peerID string) store.ChatRole {
+	return m.roles[peerID]
+}
+
+// Members returns the sorted ids of all members.
+func (m *Membership) Members() []string {
+	members := []string{}
+	for peerID := range m.adds {
+		if m.Contains(peerID) {
+			members = append(members, peerID)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Peers returns the sorted ids of all peers that ever joined, including the ones that were removed.
+func (m *Membership) Peers() []string {
+	peers := make([]string, 0, len(m.adds))
+	for peerID := range m.adds {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// MembershipFromMessages derives the membership of a chat from its JOIN_CHAT, LEAVE_CHAT, REMOVE_MEMBER, BAN_MEMBER
+// and SET_ROLE messages. Other messages are ignored, the order of the messages does not matter.
+// Every member, including the creator of the chat, is added by a JOIN_CHAT message. Role changes and removals are
+// checked against the roles the history gives their senders at their time, so forged ones change nothing.
+func MembershipFromMessages(messages []network.Message) *Membership {
+	membership := NewMembership()
+	joinedAt := map[string]int64{}
+
+	for _, message := range messages {
+		if message.Operation == network.JOIN_CHAT {
+			membership.Add(message.SenderID, message.Id)
+			joinedAt[message.Id] = message.Timestamp
+		}
+	}
+
+	// Roles change over time, so role changes and removals are applied in the order they were sent.
+	// Messages with the same timestamp are ordered by their id, every peer checks them in the same order.
+	var changes []network.Message
+	for _, message := range messages {
+		switch message.Operation {
+		case network.SET_ROLE, network.LEAVE_CHAT, network.REMOVE_MEMBER, network.BAN_MEMBER:
+			changes = append(changes, message)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Timestamp != changes[j].Timestamp {
+			return changes[i].Timestamp < changes[j].Timestamp
+		}
+		return changes[i].Id < changes[j].Id
+	})
+
+	for _, message := range changes {
+		if message.Operation == network.SET_ROLE {
+			membership.applyRoleChange(message)
+		} else {
+			membership.applyRemoval(message, joinedAt)
+		}
+	}
+
+	return membership
+}
+
+// applyRoleChange sets the role if the sender is an owner, or if the sender claims ownership of a chat without owner
+func (m *Membership) applyRoleChange(message network.Message) {
+	var change struct {
+		PeerID string         `json:"peerId"`
+		Role   store.ChatRole `json:"role"`
+	}
+	if json.Unmarshal([]byte(message.Content), &change) != nil {
+		return
+	}
+
+	if change.Role < store.ROLE_MEMBER || change.Role > store.ROLE_OWNER || !m.Contains(change.PeerID) {
+		return
+	}
+
+	isClaim := change.PeerID == message.SenderID && change.Role == store.ROLE_OWNER && !m.hasOwner()
+	if !isClaim && m.Role(message.SenderID) < RequiredRoles[network.SET_ROLE] {
+		return
+	}
+
+	m.roles[change.PeerID] = change.Role
+}
+
+// applyRemoval removes the joins a leave, kick or ban observed. Kicks and bans need a sender with a higher role than the peer.
+func (m *Membership) applyRemoval(message network.Message, joinedAt map[string]int64) {
+	var removal struct {
+		PeerID  string   `json:"peerId"`
+		Removes []string `json:"removes"`
+	}
+	if message.Content != "" && json.Unmarshal([]byte(message.Content), &removal) != nil {
+		return
+	}
+
+	// Peers can only leave by themselves
+	if message.Operation == network.LEAVE_CHAT {
+		removal.PeerID = message.SenderID
+	} else {
+		senderRole := m.Role(message.SenderID)
+		if !m.Contains(message.SenderID) || senderRole < RequiredRoles[message.Operation] || senderRole <= m.Role(removal.PeerID) {
+			return
+		}
+	}
+
+	// Removals of older versions don't list the observed tags, they remove every join of the peer up to their timestamp
+	if removal.Removes == nil {
+		for tag := range m.adds[removal.PeerID] {
+			if joinedAt[tag] <= message.Timestamp {
+				removal.Removes = append(removal.Removes, tag)
+			}
+		}
+	}
+
+	// A removal can only remove joins of the removed peer
+	for _, tag := range removal.Removes {
+		if _, exists := m.adds[removal.PeerID][tag]; exists {
+			m.Remove([]string{tag})
+		}
+	}
+
+	// Removed peers lose their role
+	if !m.Contains(removal.PeerID) {
+		delete(m.roles, removal.PeerID)
+	}
+}
+
+// hasOwner checks if any member is an owner
+func (m *Membership) hasOwner() bool {
+	for peerID, role := range m.roles {
+		if role == store.ROLE_OWNER && m.Contains(peerID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package p_model
+
+import (
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// PresenceInterval is how often a peer sends PRESENCE heartbeats to the members of its chats.
+const PresenceInterval = 30 * time.Second
+
+// PresenceTimeout is how long a peer counts as online after its last heartbeat, a few heartbeats may get lost.
+const PresenceTimeout = 3 * PresenceInterval
+
+// PresenceUpdate is the content of a PRESENCE message.
+type PresenceUpdate struct {
+	Online bool `json:"online"` // false if the peer is going offline
+}
+
+// IsOnline checks if the peer did not go offline and was seen within the PresenceTimeout.
+func IsOnline(presence store.Presence, now time.Time) bool {
+	return presence.Online && now.Sub(time.Unix(0, presence.LastSeen)) < PresenceTimeout
+}
@@ -0,0 +1,36 @@
+package p_model
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+// MaxEmojiLength is the maximum length of a reaction in bytes, long enough for emoji sequences like flags and families.
+const MaxEmojiLength = 32
+
+// ReactionChange is the content of a REACT message.
+type ReactionChange struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+	Add       bool   `json:"add"`
+}
+
+// Validate checks that the reaction refers to a message and is a single short emoji without whitespace.
+func (r ReactionChange) Validate() error {
+	if r.MessageID == "" {
+		return errors.New("reaction does not refer to a message")
+	}
+
+	if r.Emoji == "" || len(r.Emoji) > MaxEmojiLength {
+		return fmt.Errorf("reaction has to be between 1 and %d bytes long", MaxEmojiLength)
+	}
+
+	for _, c := range r.Emoji {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return errors.New("reaction must not contain whitespace or control characters")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package p_model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// MinRetentionAge is the shortest time a retention policy keeps messages. Shorter times are disappearing messages,
+// which all peers of the chat have to agree on.
+const MinRetentionAge = 24 * time.Hour
+
+// sizeUnits are the units of attachment sizes, the largest unit comes first
+var sizeUnits = []struct {
+	name  string
+	bytes int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ValidateRetentionPolicy checks that no limit is negative and that messages are kept at least MinRetentionAge
+func ValidateRetentionPolicy(policy store.RetentionPolicy) error {
+	if policy.MaxAge < 0 || policy.MaxMessages < 0 || policy.MaxAttachmentSize < 0 {
+		return errors.New("the limits of a retention policy can't be negative")
+	}
+
+	if policy.MaxAge != 0 && time.Duration(policy.MaxAge) < MinRetentionAge {
+		return fmt.Errorf("messages have to be kept at least %v, use disappearing messages for shorter times", MinRetentionAge)
+	}
+
+	return nil
+}
+
+// ParseSize parses an attachment size like 500KB, 50MB or 1GB, a number without unit is in bytes
+func ParseSize(size string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.name) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.name))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value < 0 || value > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid size %q, use a number of bytes or KB, MB or GB", size)
+	}
+	return value * multiplier, nil
+}
+
+// FormatSize formats the size with the largest unit that divides it
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size != 0 && size%unit.bytes == 0 {
+			return fmt.Sprintf("%d%s", size/unit.bytes, unit.name)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// DescribeRetentionPolicy describes what the policy keeps
+func DescribeRetentionPolicy(policy store.RetentionPolicy) string {
+	var limits []string
+	if policy.MaxAge > 0 {
+		limits = append(limits, fmt.Sprintf("the last %d days", time.Duration(policy.MaxAge)/(24*time.Hour)))
+	}
+	if policy.MaxMessages > 0 {
+		limits = append(limits, fmt.Sprintf("the newest %d messages", policy.MaxMessages))
+	}
+	if policy.MaxAttachmentSize > 0 {
+		limits = append(limits, fmt.Sprintf("the newest files up to %s", FormatSize(policy.MaxAttachmentSize)))
+	}
+
+	if len(limits) == 0 {
+		return "keeps everything"
+	}
+	return "keeps " + strings.Join(limits, ", ")
+}
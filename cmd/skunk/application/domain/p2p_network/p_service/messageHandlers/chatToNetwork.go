@@ -1,34 +1,261 @@
 package messageHandlers
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"sync"
 	"time"
 )
 
+// invitationLifetime is how long an invitation can be accepted after it was sent.
+const invitationLifetime = 7 * 24 * time.Hour
+
 type ChatToNetwork struct {
-	sender  *MessageSender
-	storage store.Storage
+	sender      *MessageSender
+	storage     store.Storage
+	address     string
+	identityKey ed25519.PrivateKey
+	typingMutex sync.Mutex
+	typingSent  map[string]time.Time // last TYPING_STARTED sent to each chat
 }
 
 func NewChatToNetwork(sender *MessageSender, chatActionStorage store.Storage) *ChatToNetwork {
 	return &ChatToNetwork{
-		sender:  sender,
-		storage: chatActionStorage,
+		sender:     sender,
+		storage:    chatActionStorage,
+		typingSent: map[string]time.Time{},
 	}
 }
 
+// SetIdentity sets the onion address of this peer and the matching private key used to sign invite tokens.
+func (c *ChatToNetwork) SetIdentity(address string, identityKey ed25519.PrivateKey) {
+	c.address = address
+	c.identityKey = identityKey
+}
+
+// CreateChat creates a new chat with this peer as its owner
 func (c *ChatToNetwork) CreateChat(chatId string, chatName string) error {
 	err := c.storage.ChatCreated(chatName, chatId)
 	if err != nil {
 		return err
 	}
 
+	// The creator joins like every other member, so the membership can be derived from the history of the chat
+	join := newChatMessage(chatId, "", network.JOIN_CHAT)
+	err = c.storage.PeerJoinedChat(join.Timestamp, "self", chatId)
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.StoreMessage(join)
+	if err != nil {
+		return err
+	}
+
+	return c.SetRole(chatId, "self", store.ROLE_OWNER)
+}
+
+// SetRole changes the role of a chat member
+func (c *ChatToNetwork) SetRole(chatId string, peerId string, role store.ChatRole) error {
+	err := c.storage.SetPeerRole(peerId, chatId, role)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(struct {
+		PeerID string         `json:"peerId"`
+		Role   store.ChatRole `json:"role"`
+	}{
+		PeerID: peerId,
+		Role:   role,
+	})
+	if err != nil {
+		return err
+	}
+
+	message := network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         string(content),
+		SenderID:        "self",
+		ReceiverID:      "?",
+		SenderAddress:   "self",
+		ReceiverAddress: "?",
+		ChatID:          chatId,
+		Operation:       network.SET_ROLE,
+	}
+
+	c.storage.StoreMessage(message)
+	c.sender.SendMessage(message)
+	return nil
+}
+
+// RemoveMember kicks a member from the chat, the peer may join again with a new invitation
+func (c *ChatToNetwork) RemoveMember(chatId string, peerId string) error {
+	removes, err := observedJoins(c.storage, chatId, peerId)
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.PeerLeftChat(peerId, chatId)
+	if err != nil {
+		return err
+	}
+
+	return c.sendMemberRemoval(chatId, peerId, removes, network.REMOVE_MEMBER)
+}
+
+// BanMember removes a member from the chat and prevents the peer from joining again
+func (c *ChatToNetwork) BanMember(chatId string, peerId string) error {
+	removes, err := observedJoins(c.storage, chatId, peerId)
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.PeerBannedFromChat(time.Now().UnixNano(), peerId, chatId)
+	if err != nil {
+		return err
+	}
+
+	return c.sendMemberRemoval(chatId, peerId, removes, network.BAN_MEMBER)
+}
+
+// sendMemberRemoval sends the removal of a peer together with the joins of the peer that it removes
+func (c *ChatToNetwork) sendMemberRemoval(chatId string, peerId string, removes []string, operation network.OperationType) error {
+	content, err := json.Marshal(struct {
+		PeerID  string   `json:"peerId"`
+		Removes []string `json:"removes"`
+	}{
+		PeerID:  peerId,
+		Removes: removes,
+	})
+	if err != nil {
+		return err
+	}
+
+	message := network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         string(content),
+		SenderID:        "self",
+		ReceiverID:      "?",
+		SenderAddress:   "self",
+		ReceiverAddress: "?",
+		ChatID:          chatId,
+		Operation:       operation,
+	}
+
+	c.storage.StoreMessage(message)
+	c.sender.SendMessage(message)
+
+	// TODO: rotate the group key once chats are encrypted, so the removed peer can't read new messages
+	return nil
+}
+
+// UpdateChatMetadata changes the name, topic or description of the chat
+func (c *ChatToNetwork) UpdateChatMetadata(chatId string, field store.ChatMetadataField, value string) error {
+	update, err := p_model.NewChatMetadataUpdate(field, value)
+	if err != nil {
+		return err
+	}
+
+	err = update.Validate()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	message := network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         string(content),
+		SenderID:        "self",
+		ReceiverID:      "?",
+		SenderAddress:   "self",
+		ReceiverAddress: "?",
+		ChatID:          chatId,
+		Operation:       network.UPDATE_CHAT_METADATA,
+	}
+
+	_, err = c.storage.UpdateChatMetadata(message.Timestamp, message.Id, chatId, field, value)
+	if err != nil {
+		return err
+	}
+
+	c.storage.StoreMessage(message)
+	c.sender.SendMessage(message)
 	return nil
 }
 
 func (c *ChatToNetwork) JoinChat(chatId string) error {
+	return c.joinChat(chatId, "", "?", "?")
+}
+
+// CreateInviteToken creates a signed one-time invite token for the chat, encoded as a skunk:// URI.
+func (c *ChatToNetwork) CreateInviteToken(chatId string) (string, error) {
+	if c.identityKey == nil || c.address == "" {
+		return "", fmt.Errorf("no identity is set, invite tokens can only be created while online")
+	}
+
+	chatName, err := c.getChatName(chatId)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().UnixNano() + invitationLifetime.Nanoseconds()
+	// The inviter is known by the host of the onion address that signs the token
+	token, err := p_model.NewInviteToken(chatId, chatName, p_model.OnionHost(c.address), c.address, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	err = token.Sign(c.identityKey)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Encode()
+}
+
+// JoinChatWithToken joins the chat an invite token was issued for by sending the token to the inviter.
+func (c *ChatToNetwork) JoinChatWithToken(uri string) error {
+	token, err := p_model.ParseInviteToken(uri)
+	if err != nil {
+		return err
+	}
+
+	err = token.Verify(time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.ChatCreated(token.ChatName, token.ChatID)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(struct {
+		InviteToken string `json:"inviteToken"`
+	}{
+		InviteToken: uri,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.joinChat(token.ChatID, string(content), token.InviterID, token.InviterAddress)
+}
+
+func (c *ChatToNetwork) joinChat(chatId string, content string, receiverId string, receiverAddress string) error {
 	timestamp := time.Now().UnixNano()
 
 	err := c.storage.PeerJoinedChat(timestamp, "self", chatId)
@@ -39,11 +266,11 @@ func (c *ChatToNetwork) JoinChat(chatId string) error {
 	message := network.Message{
 		Id:              uuid.New().String(),
 		Timestamp:       timestamp,
-		Content:         "",
+		Content:         content,
 		SenderID:        "self",
-		ReceiverID:      "?",
+		ReceiverID:      receiverId,
 		SenderAddress:   "self",
-		ReceiverAddress: "?",
+		ReceiverAddress: receiverAddress,
 		ChatID:          chatId,
 		Operation:       network.JOIN_CHAT,
 	}
@@ -54,7 +281,22 @@ func (c *ChatToNetwork) JoinChat(chatId string) error {
 }
 
 func (c *ChatToNetwork) LeaveChat(chatId string) error {
-	err := c.storage.PeerLeftChat("self", chatId)
+	removes, err := observedJoins(c.storage, chatId, "self")
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.PeerLeftChat("self", chatId)
+	if err != nil {
+		return err
+	}
+
+	// The leave only removes the joins seen so far, see p_model.Membership
+	content, err := json.Marshal(struct {
+		Removes []string `json:"removes"`
+	}{
+		Removes: removes,
+	})
 	if err != nil {
 		return err
 	}
@@ -62,7 +304,7 @@ func (c *ChatToNetwork) LeaveChat(chatId string) error {
 	message := network.Message{
 		Id:              uuid.New().String(),
 		Timestamp:       time.Now().UnixNano(),
-		Content:         "",
+		Content:         string(content),
 		SenderID:        "self",
 		ReceiverID:      "?",
 		SenderAddress:   "self",
@@ -77,30 +319,209 @@ func (c *ChatToNetwork) LeaveChat(chatId string) error {
 }
 
 func (c *ChatToNetwork) InviteToChat(chatId string, peerId string) error {
+	err := c.checkNotBlocked(peerId)
+	if err != nil {
+		return err
+	}
+
+	chatName, err := c.getChatName(chatId)
+	if err != nil {
+		return err
+	}
+
+	members, err := c.storage.GetUsersInChat(chatId)
+	if err != nil {
+		return err
+	}
+
+	// The invited peer learns the members with their addresses. This peer is named by its onion address,
+	// the address of another member is known from its JOIN_CHAT message and stays empty otherwise.
+	addresses, err := memberAddresses(c.storage, store.Chat{ChatId: chatId})
+	if err != nil {
+		return err
+	}
+
+	peers := make([]store.PublicKeyAddress, len(members))
+	for i, member := range members {
+		peers[i] = store.PublicKeyAddress{PublicKey: member.UserId, Address: addresses[member.UserId]}
+		if member.UserId == "self" && c.address != "" {
+			peers[i] = store.PublicKeyAddress{PublicKey: p_model.OnionHost(c.address), Address: c.address}
+		}
+	}
 
-	err := c.storage.PeerGotInvitedToChat(peerId, chatId)
+	timestamp := time.Now().UnixNano()
+	expiresAt := timestamp + invitationLifetime.Nanoseconds()
+
+	content, err := json.Marshal(struct {
+		ChatID    string                   `json:"chatId"`
+		ChatName  string                   `json:"chatName"`
+		ExpiresAt int64                    `json:"expiresAt"`
+		Peers     []store.PublicKeyAddress `json:"peers"`
+	}{
+		ChatID:    chatId,
+		ChatName:  chatName,
+		ExpiresAt: expiresAt,
+		Peers:     peers,
+	})
 	if err != nil {
 		return err
 	}
 
 	message := network.Message{
 		Id:              uuid.New().String(),
-		Timestamp:       time.Now().UnixNano(),
-		Content:         "", //TODO fill
+		Timestamp:       timestamp,
+		Content:         string(content),
 		SenderID:        "self",
-		ReceiverID:      "?",
+		ReceiverID:      peerId,
 		SenderAddress:   "self",
 		ReceiverAddress: peerId,
 		ChatID:          chatId,
 		Operation:       network.INVITE_TO_CHAT,
 	}
 
-	c.storage.StoreMessage(message)
+	err = c.storage.StoreMessage(message)
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.PeerGotInvitedToChat(message.Id, expiresAt)
+	if err != nil {
+		return err
+	}
+
 	c.sender.SendMessage(message)
 
 	return nil
 }
 
+// AcceptInvitation accepts a pending invitation, creates the chat locally and joins it.
+func (c *ChatToNetwork) AcceptInvitation(invitationId string) error {
+	invitation, err := c.getPendingInvitation(invitationId)
+	if err != nil {
+		return err
+	}
+
+	invitationMessage, err := c.storage.RetrieveMessage(invitationId)
+	if err != nil {
+		return err
+	}
+
+	var content struct {
+		ChatName string `json:"chatName"`
+	}
+	err = json.Unmarshal([]byte(invitationMessage.Content), &content)
+	if err != nil {
+		return err
+	}
+
+	err = c.storage.ChatCreated(content.ChatName, invitation.ChatId)
+	if err != nil {
+		return err
+	}
+
+	err = c.respondToInvitation(invitation, store.INVITATION_ACCEPTED)
+	if err != nil {
+		return err
+	}
+
+	return c.JoinChat(invitation.ChatId)
+}
+
+// DeclineInvitation declines a pending invitation and lets the inviter know.
+func (c *ChatToNetwork) DeclineInvitation(invitationId string) error {
+	invitation, err := c.getPendingInvitation(invitationId)
+	if err != nil {
+		return err
+	}
+
+	return c.respondToInvitation(invitation, store.INVITATION_DECLINED)
+}
+
+// RevokeInvitation withdraws a pending invitation that was sent by this peer.
+func (c *ChatToNetwork) RevokeInvitation(invitationId string) error {
+	invitation, err := c.getPendingInvitation(invitationId)
+	if err != nil {
+		return err
+	}
+
+	if invitation.InviterId != "self" {
+		return fmt.Errorf("only the inviter can revoke invitation %s", invitationId)
+	}
+
+	return c.respondToInvitation(invitation, store.INVITATION_REVOKED)
+}
+
+func (c *ChatToNetwork) getPendingInvitation(invitationId string) (store.Invitation, error) {
+	invitation, err := c.storage.GetInvitation(invitationId)
+	if err != nil {
+		return store.Invitation{}, err
+	}
+
+	if invitation.Status != store.INVITATION_PENDING {
+		return store.Invitation{}, fmt.Errorf("invitation %s is no longer pending", invitationId)
+	}
+
+	return invitation, nil
+}
+
+// respondToInvitation updates the invitation locally and sends an INVITE_RESPONSE to the other party.
+func (c *ChatToNetwork) respondToInvitation(invitation store.Invitation, status store.InvitationStatus) error {
+	err := c.storage.SetInvitationStatus(invitation.MessageId, status)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(struct {
+		InvitationID string                 `json:"invitationId"`
+		Status       store.InvitationStatus `json:"status"`
+	}{
+		InvitationID: invitation.MessageId,
+		Status:       status,
+	})
+	if err != nil {
+		return err
+	}
+
+	receiverID, receiverAddress := invitation.InviterId, invitation.InviterAddress
+	if status == store.INVITATION_REVOKED {
+		receiverID, receiverAddress = invitation.InviteeId, invitation.InviteeAddress
+	}
+
+	message := network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         string(content),
+		SenderID:        "self",
+		ReceiverID:      receiverID,
+		SenderAddress:   "self",
+		ReceiverAddress: receiverAddress,
+		ChatID:          invitation.ChatId,
+		Operation:       network.INVITE_RESPONSE,
+	}
+
+	err = c.storage.StoreMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return c.sender.SendMessage(message)
+}
+
+func (c *ChatToNetwork) getChatName(chatId string) (string, error) {
+	chats, err := c.storage.GetChats()
+	if err != nil {
+		return "", err
+	}
+
+	for _, chat := range chats {
+		if chat.ChatId == chatId {
+			return chat.ChatName, nil
+		}
+	}
+
+	return "", fmt.Errorf("chat with ID %s does not exist", chatId)
+}
+
 func (c *ChatToNetwork) SendFileToChat(chatId string, filePath string) error {
 
 	message := network.Message{
@@ -127,3 +548,244 @@ func (c *ChatToNetwork) SetUsernameInChat(chatId string, username string) error
 func (c *ChatToNetwork) SendMessageToChat(chatId string, message string) error {
 	return nil
 }
+
+// SendDirectMessage sends a message to the direct chat with the peer, the first message creates the chat.
+// The addresses of both peers identify the chat, so it can only be created while online.
+// The peer is known by the host of its onion address, only its messages are accepted in the chat.
+func (c *ChatToNetwork) SendDirectMessage(peerId string, peerAddress string, message string) error {
+	if c.address == "" {
+		return fmt.Errorf("no identity is set, direct messages can only be sent while online")
+	}
+
+	err := c.checkNotBlocked(peerAddress)
+	if err != nil {
+		return err
+	}
+
+	if peerId != p_model.OnionHost(peerAddress) {
+		return fmt.Errorf("peer %s has to be named by the host of its address %s", peerId, peerAddress)
+	}
+
+	content, err := json.Marshal(struct {
+		Message string `json:"message"`
+	}{
+		Message: message,
+	})
+	if err != nil {
+		return err
+	}
+
+	directMessage := network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         string(content),
+		SenderID:        "self",
+		ReceiverID:      peerId,
+		SenderAddress:   c.address,
+		ReceiverAddress: peerAddress,
+		ChatID:          p_model.DirectChatID(c.address, peerAddress),
+		Operation:       network.SEND_MESSAGE,
+	}
+
+	err = c.storage.DirectChatCreated(directMessage.Timestamp, directMessage.ChatID, peerId, peerAddress)
+	if err != nil {
+		return err
+	}
+
+	c.storage.StoreMessage(directMessage)
+	c.sender.SendMessage(directMessage)
+	return nil
+}
+
+// BlockPeer adds the peer to the block list. Nothing is sent, the peer must not learn that it was blocked.
+func (c *ChatToNetwork) BlockPeer(address string) error {
+	return c.storage.BlockPeer(time.Now().UnixNano(), p_model.OnionHost(address))
+}
+
+func (c *ChatToNetwork) UnblockPeer(address string) error {
+	return c.storage.UnblockPeer(p_model.OnionHost(address))
+}
+
+// checkNotBlocked returns an error if the peer with the address is blocked
+func (c *ChatToNetwork) checkNotBlocked(address string) error {
+	blocked, err := c.storage.IsBlocked(p_model.OnionHost(address))
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return fmt.Errorf("peer %s is blocked", address)
+	}
+
+	return nil
+}
+
+// ReplyToMessage sends a message that quotes another message of the chat
+func (c *ChatToNetwork) ReplyToMessage(chatId string, replyTo string, message string) error {
+	content, err := json.Marshal(struct {
+		Message string `json:"message"`
+		ReplyTo string `json:"replyTo"`
+	}{
+		Message: message,
+		ReplyTo: replyTo,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply := newChatMessage(chatId, string(content), network.SEND_MESSAGE)
+
+	c.storage.StoreMessage(reply)
+	c.sender.SendMessage(reply)
+	return nil
+}
+
+// EditMessage replaces the content of one of our messages, the previous versions are kept in the edit history
+func (c *ChatToNetwork) EditMessage(chatId string, messageId string, message string) error {
+	content, err := json.Marshal(struct {
+		MessageID string `json:"messageId"`
+		Message   string `json:"message"`
+	}{
+		MessageID: messageId,
+		Message:   message,
+	})
+	if err != nil {
+		return err
+	}
+
+	edit := newChatMessage(chatId, string(content), network.EDIT_MESSAGE)
+	if !c.sender.IsOwnMessageChange(edit) {
+		return fmt.Errorf("message %s is not one of your messages in chat %s", messageId, chatId)
+	}
+
+	c.storage.StoreMessage(edit)
+	err = c.storage.MessageEdited(edit.Timestamp, edit.Id, messageId, message)
+	if err != nil {
+		return err
+	}
+
+	c.sender.SendMessage(edit)
+	return nil
+}
+
+// DeleteMessage deletes one of our messages, only a tombstone is kept
+func (c *ChatToNetwork) DeleteMessage(chatId string, messageId string) error {
+	content, err := json.Marshal(struct {
+		MessageID string `json:"messageId"`
+	}{
+		MessageID: messageId,
+	})
+	if err != nil {
+		return err
+	}
+
+	deletion := newChatMessage(chatId, string(content), network.DELETE_MESSAGE)
+	if !c.sender.IsOwnMessageChange(deletion) {
+		return fmt.Errorf("message %s is not one of your messages in chat %s", messageId, chatId)
+	}
+
+	c.storage.StoreMessage(deletion)
+	err = c.storage.MessageDeleted(deletion.Timestamp, messageId)
+	if err != nil {
+		return err
+	}
+
+	c.sender.SendMessage(deletion)
+	return nil
+}
+
+// React adds or removes a reaction to a message of the chat
+func (c *ChatToNetwork) React(chatId string, messageId string, emoji string, add bool) error {
+	reaction := p_model.ReactionChange{
+		MessageID: messageId,
+		Emoji:     emoji,
+		Add:       add,
+	}
+
+	err := reaction.Validate()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(reaction)
+	if err != nil {
+		return err
+	}
+
+	message := newChatMessage(chatId, string(content), network.REACT)
+
+	_, err = c.storage.PeerReacted(message.Timestamp, message.Id, messageId, "self", emoji, add)
+	if err != nil {
+		return err
+	}
+
+	c.storage.StoreMessage(message)
+	c.sender.SendMessage(message)
+	return nil
+}
+
+// SetMessageExpiry turns on disappearing messages for the chat, messages older than the expiry are removed by every member.
+// An expiry of 0 turns disappearing messages off.
+func (c *ChatToNetwork) SetMessageExpiry(chatId string, expiry time.Duration) error {
+	settings := p_model.NewMessageExpirySettings(expiry)
+
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	message := newChatMessage(chatId, string(content), network.UPDATE_CHAT_SETTINGS)
+
+	_, err = applyChatSettings(c.storage, message, settings)
+	if err != nil {
+		return err
+	}
+
+	c.storage.StoreMessage(message)
+	c.sender.SendMessage(message)
+	return nil
+}
+
+// SetTyping tells the members of the chat that this peer started or stopped typing.
+// It can be called on every key press, TYPING_STARTED is only repeated every typingSendInterval.
+// Typing messages are ephemeral, so they are not stored.
+func (c *ChatToNetwork) SetTyping(chatId string, typing bool) error {
+	c.typingMutex.Lock()
+	lastSent, isTyping := c.typingSent[chatId]
+	if (typing && isTyping && time.Since(lastSent) < typingSendInterval) || (!typing && !isTyping) {
+		c.typingMutex.Unlock()
+		return nil
+	}
+	if typing {
+		c.typingSent[chatId] = time.Now()
+	} else {
+		delete(c.typingSent, chatId)
+	}
+	c.typingMutex.Unlock()
+
+	operation := network.TYPING_STOPPED
+	if typing {
+		operation = network.TYPING_STARTED
+	}
+
+	return c.sender.SendMessage(newChatMessage(chatId, "{}", operation))
+}
+
+// newChatMessage creates a message from this peer to all members of the chat
+func newChatMessage(chatId string, content string, operation network.OperationType) network.Message {
+	return network.Message{
+		Id:              uuid.New().String(),
+		Timestamp:       time.Now().UnixNano(),
+		Content:         content,
+		SenderID:        "self",
+		ReceiverID:      "?",
+		SenderAddress:   "self",
+		ReceiverAddress: "?",
+		ChatID:          chatId,
+		Operation:       operation,
+	}
+}
@@ -0,0 +1,164 @@
+package messageHandlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// isPruned checks if the message lies in the pruned range of its chat.
+// Like with the message expiry, membership, roles and settings are never pruned.
+func isPruned(pruned store.PrunedRange, message network.Message) bool {
+	if !isExpiringOperation(message.Operation) {
+		return false
+	}
+	return message.Timestamp < pruned.Before || (message.Operation == network.SEND_FILE && message.Timestamp < pruned.FilesBefore)
+}
+
+// Compactor enforces the retention policies of the chats. It prunes the messages and files the policies don't keep,
+// records the pruned ranges, so a sync doesn't bring the messages back, and removes the peers nothing refers to anymore.
+// Every peer runs it on a background timer.
+type Compactor struct {
+	retentionStorage    store.RetentionStoragePort
+	chatSettingsStorage store.ChatSettingsStoragePort
+	displayStorage      store.DisplayStoragePort
+	fileDir             string
+	quit                chan struct{}
+	stopOnce            sync.Once
+}
+
+func NewCompactor(retentionStorage store.RetentionStoragePort, chatSettingsStorage store.ChatSettingsStoragePort, displayStorage store.DisplayStoragePort, fileDir string) *Compactor {
+	return &Compactor{
+		retentionStorage:    retentionStorage,
+		chatSettingsStorage: chatSettingsStorage,
+		displayStorage:      displayStorage,
+		fileDir:             fileDir,
+		quit:                make(chan struct{}),
+	}
+}
+
+// Start compacts the storage every interval until Stop is called
+func (c *Compactor) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := c.Compact(time.Now().UnixNano())
+				if err != nil {
+					fmt.Println("Error compacting the storage:", err)
+				}
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background timer
+func (c *Compactor) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+	})
+}
+
+// Compact enforces the retention policies of all chats and removes the orphaned peers afterwards
+func (c *Compactor) Compact(now int64) error {
+	policies, err := c.retentionStorage.GetRetentionPolicies()
+	if err != nil {
+		return err
+	}
+
+	for chatID, policy := range policies {
+		err = c.compactChat(chatID, policy, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.retentionStorage.RemoveOrphanedPeers()
+	return err
+}
+
+// compactChat prunes the messages of the chat the policy doesn't keep and the files they sent
+func (c *Compactor) compactChat(chatID string, policy store.RetentionPolicy, now int64) error {
+	pruned, err := c.prunedRange(chatID, policy, now)
+	if err != nil || pruned == (store.PrunedRange{}) {
+		return err
+	}
+
+	// The range is recorded first, a sync that runs meanwhile must not store the messages again
+	err = c.retentionStorage.MessagesPruned(chatID, pruned)
+	if err != nil {
+		return err
+	}
+
+	purged, err := c.chatSettingsStorage.PurgeMessages(chatID, pruned.Before, expiringOperations)
+	if err != nil {
+		return err
+	}
+	purgedFiles, err := c.chatSettingsStorage.PurgeMessages(chatID, pruned.FilesBefore, []network.OperationType{network.SEND_FILE})
+	if err != nil {
+		return err
+	}
+
+	return removeUnreferencedFiles(c.fileDir, c.displayStorage, append(purged, purgedFiles...))
+}
+
+// prunedRange returns the range of the chat the policy doesn't keep at the time now
+func (c *Compactor) prunedRange(chatID string, policy store.RetentionPolicy, now int64) (store.PrunedRange, error) {
+	var pruned store.PrunedRange
+	if policy.MaxAge > 0 {
+		pruned.Before = now - policy.MaxAge
+	}
+	if policy.MaxMessages <= 0 && policy.MaxAttachmentSize <= 0 {
+		return pruned, nil
+	}
+
+	messages, err := c.displayStorage.GetChatMessages(chatID)
+	if err != nil {
+		return store.PrunedRange{}, err
+	}
+
+	// Only messages and files count, the edits and reactions older than the oldest kept one are pruned with them
+	if policy.MaxMessages > 0 {
+		kept := 0
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Operation != network.SEND_MESSAGE && messages[i].Operation != network.SEND_FILE {
+				continue
+			}
+			kept++
+			if kept == policy.MaxMessages {
+				if messages[i].Timestamp > pruned.Before {
+					pruned.Before = messages[i].Timestamp
+				}
+				break
+			}
+		}
+	}
+
+	// The newest files are kept as long as they fit, the first one that doesn't fit is pruned with all older files
+	if policy.MaxAttachmentSize > 0 {
+		var size int64
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Operation != network.SEND_FILE || messages[i].Timestamp < pruned.Before {
+				continue
+			}
+			_, fileData, err := decodeSentFile(messages[i])
+			if err != nil {
+				continue
+			}
+			size += int64(len(fileData))
+			if size > policy.MaxAttachmentSize {
+				pruned.FilesBefore = messages[i].Timestamp + 1
+				break
+			}
+		}
+	}
+
+	return pruned, nil
+}
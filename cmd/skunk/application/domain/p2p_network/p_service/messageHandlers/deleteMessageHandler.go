@@ -0,0 +1,54 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer deletes one of its messages
+type deleteMessageHandler struct {
+	userChatLogic      chat.ChatLogic
+	messageEditStorage store.MessageEditStoragePort
+}
+
+func NewDeleteMessageHandler(userChatLogic chat.ChatLogic, messageEditStorage store.MessageEditStoragePort) *deleteMessageHandler {
+	return &deleteMessageHandler{
+		userChatLogic:      userChatLogic,
+		messageEditStorage: messageEditStorage,
+	}
+}
+
+func (d *deleteMessageHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message:
+	/*
+		{
+			"messageId": "id_of_the_deleted_message",
+		}
+	*/
+
+	var content struct {
+		MessageID string `json:"messageId"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Leave a tombstone, the content of the message and its edits is removed
+	err = d.messageEditStorage.MessageDeleted(message.Timestamp, content.MessageID)
+	if err != nil {
+		fmt.Println("Error deleting message")
+		return err
+	}
+
+	// Handle the deleted message
+	d.userChatLogic.MessageDeleted(message.SenderID, message.ChatID, content.MessageID)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer edits one of its messages
+type editMessageHandler struct {
+	userChatLogic      chat.ChatLogic
+	messageEditStorage store.MessageEditStoragePort
+}
+
+func NewEditMessageHandler(userChatLogic chat.ChatLogic, messageEditStorage store.MessageEditStoragePort) *editMessageHandler {
+	return &editMessageHandler{
+		userChatLogic:      userChatLogic,
+		messageEditStorage: messageEditStorage,
+	}
+}
+
+func (e *editMessageHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message:
+	/*
+		{
+			"messageId": "id_of_the_edited_message",
+			"message": "new content",
+		}
+	*/
+
+	var content struct {
+		MessageID string `json:"messageId"`
+		Message   string `json:"message"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Add the new version to the edit history
+	err = e.messageEditStorage.MessageEdited(message.Timestamp, message.Id, content.MessageID, content.Message)
+	if err != nil {
+		fmt.Println("Error storing message edit")
+		return err
+	}
+
+	// Handle the edited message
+	e.userChatLogic.MessageEdited(message.SenderID, message.ChatID, content.MessageID, content.Message)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// InviteResponseHandler handles the outcome of an invitation (accepted, declined or revoked)
+type InviteResponseHandler struct {
+	userChatLogic         chat.ChatLogic
+	chatInvitationStorage store.ChatInvitationStoragePort
+}
+
+// NewInviteResponseHandler creates a new InviteResponseHandler
+func NewInviteResponseHandler(userChatLogic chat.ChatLogic, chatInvitationStorage store.ChatInvitationStoragePort) *InviteResponseHandler {
+	return &InviteResponseHandler{
+		userChatLogic:         userChatLogic,
+		chatInvitationStorage: chatInvitationStorage,
+	}
+}
+
+// HandleMessage processes the invitation response message
+func (i *InviteResponseHandler) HandleMessage(message network.Message) error {
+
+	// Message structure:
+	/*
+		{
+			"invitationId": "id_of_the_invite_to_chat_message",
+			"status": 1
+		}
+	*/
+
+	var content struct {
+		InvitationID string                 `json:"invitationId"`
+		Status       store.InvitationStatus `json:"status"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Update the state of the invitation
+	err = i.chatInvitationStorage.SetInvitationStatus(content.InvitationID, content.Status)
+	if err != nil {
+		fmt.Println("Error updating invitation status")
+		return err
+	}
+
+	// Notify the chat logic of the outcome of the invitation
+	i.userChatLogic.ReceiveInvitationResponse(message.SenderID, message.ChatID, content.InvitationID, content.Status)
+
+	return nil
+}
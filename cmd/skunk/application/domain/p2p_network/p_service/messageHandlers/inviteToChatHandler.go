@@ -30,6 +30,7 @@ func (i *InviteToChatHandler) HandleMessage(message network.Message) error {
 		{
 			"chatId": "chat_id",
 			"chatName": "chat_name",
+			"expiresAt": 1633029460000000000, (optional, 0 = never expires)
 			"peers": [
 				{
 					"address": "peer_address",
@@ -41,9 +42,10 @@ func (i *InviteToChatHandler) HandleMessage(message network.Message) error {
 	*/
 
 	var content struct {
-		ChatID   string                   `json:"chatId"`
-		ChatName string                   `json:"chatName"`
-		Peers    []store.PublicKeyAddress `json:"peers"`
+		ChatID    string                   `json:"chatId"`
+		ChatName  string                   `json:"chatName"`
+		ExpiresAt int64                    `json:"expiresAt"`
+		Peers     []store.PublicKeyAddress `json:"peers"`
 	}
 
 	err := json.Unmarshal([]byte(message.Content), &content)
@@ -58,6 +60,11 @@ func (i *InviteToChatHandler) HandleMessage(message network.Message) error {
 		return err
 	}
 
+	err = i.chatInvitationStorage.PeerGotInvitedToChat(message.Id, content.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
 	// Extract addresses from peers
 	peerAddresses := make([]string, len(content.Peers))
 	for idx, peer := range content.Peers {
@@ -1,36 +1,76 @@
 package messageHandlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
 )
 
 // A Peer joins a chat
 type joinChatHandler struct {
-	userChatLogic     chat.ChatLogic
-	chatActionStorage store.ChatActionStoragePort
+	userChatLogic         chat.ChatLogic
+	chatActionStorage     store.ChatActionStoragePort
+	chatInvitationStorage store.ChatInvitationStoragePort
 }
 
-func NewJoinChatHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort) *joinChatHandler {
+func NewJoinChatHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort, chatInvitationStorage store.ChatInvitationStoragePort) *joinChatHandler {
 	return &joinChatHandler{
-		userChatLogic:     userChatLogic,
-		chatActionStorage: chatActionStorage,
+		userChatLogic:         userChatLogic,
+		chatActionStorage:     chatActionStorage,
+		chatInvitationStorage: chatInvitationStorage,
 	}
 }
 
 func (j *joinChatHandler) HandleMessage(message network.Message) error {
 
-	// Update chat invitation storage
-	err := j.chatActionStorage.PeerJoinedChat(message.Timestamp, message.SenderID, message.ChatID)
+	// Structure of the message (content is optional, only set when joining with an invite token):
+	/*
+		{
+			"inviteToken": "skunk://join/...",
+		}
+	*/
+
+	var content struct {
+		InviteToken string `json:"inviteToken"`
+	}
+
+	if message.Content != "" {
+		err := json.Unmarshal([]byte(message.Content), &content)
+		if err != nil {
+			fmt.Println("Error unmarshalling message content")
+			return err
+		}
+	}
+
+	// Invite tokens can only be used once, remember who used it
+	if content.InviteToken != "" {
+		token, err := p_model.ParseInviteToken(content.InviteToken)
+		if err != nil {
+			fmt.Println("Error parsing invite token")
+			return err
+		}
+
+		err = j.chatInvitationStorage.InviteTokenUsed(token.Secret, message.SenderID)
+		if err != nil {
+			fmt.Println("Error storing invite token usage")
+			return err
+		}
+	}
+
+	// Derive the membership from the chat history, a removal that arrived earlier may already cover this join
+	isMember, err := updateMembership(j.chatActionStorage, message, message.SenderID)
 	if err != nil {
-		fmt.Println("Error updating chat invitation storage")
+		fmt.Println("Error updating chat membership")
 		return err
 	}
 
 	// Handle peer joining the chat
-	j.userChatLogic.PeerJoinsChat(message.SenderID, message.ChatID)
+	if isMember {
+		j.userChatLogic.PeerJoinsChat(message.SenderID, message.ChatID)
+	}
 
 	return nil
 }
@@ -22,12 +22,22 @@ func NewLeaveChatHandler(userChatLogic chat.ChatLogic, chatActionStorage store.C
 
 func (l *leaveChatHandler) HandleMessage(message network.Message) error {
 
-	// Update chat invitation storage and remove the peer from the chat
-	err := l.chatActionStorage.PeerLeftChat(message.SenderID, message.ChatID)
+	// Structure of the message (content is optional, messages of older versions have none):
+	/*
+		{
+			"removes": ["join_message_id", ...],
+		}
+	*/
+
+	// Derive the membership from the chat history, a concurrent join of the peer wins over the leave
+	isMember, err := updateMembership(l.chatActionStorage, message, message.SenderID)
 	if err != nil {
-		fmt.Println("Error updating chat invitation storage")
+		fmt.Println("Error updating chat membership")
 		return err
 	}
+	if isMember {
+		return nil
+	}
 
 	// Handle peer leaving the chat
 	err1 := l.userChatLogic.PeerLeavesChat(message.SenderID, message.ChatID)
@@ -0,0 +1,65 @@
+package messageHandlers
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// updateMembership derives the membership of the peer from the membership messages of the chat, including the
+// handled message, and updates the member list accordingly. Returns whether the peer is a member afterwards.
+func updateMembership(chatActionStorage store.ChatActionStoragePort, message network.Message, peerID string) (bool, error) {
+	messages, err := chatActionStorage.GetMembershipMessages(message.ChatID)
+	if err != nil {
+		return false, err
+	}
+
+	membership := p_model.MembershipFromMessages(append(messages, message))
+	if membership.Contains(peerID) {
+		return true, chatActionStorage.PeerJoinedChat(message.Timestamp, peerID, message.ChatID)
+	}
+
+	return false, chatActionStorage.PeerLeftChat(peerID, message.ChatID)
+}
+
+// RebuildMembership recomputes the member list of the chat and the roles of its members from its stored membership messages.
+// Every member, the creator of the chat too, joined with a JOIN_CHAT message.
+func RebuildMembership(chatActionStorage store.ChatActionStoragePort, chatID string) error {
+	messages, err := chatActionStorage.GetMembershipMessages(chatID)
+	if err != nil {
+		return err
+	}
+
+	joinedAt := map[string]int64{}
+	for _, message := range messages {
+		joinedAt[message.Id] = message.Timestamp
+	}
+
+	membership := p_model.MembershipFromMessages(messages)
+	for _, peerID := range membership.Peers() {
+		tags := membership.Tags(peerID)
+		if len(tags) == 0 {
+			err = chatActionStorage.PeerLeftChat(peerID, chatID)
+		} else {
+			err = chatActionStorage.PeerJoinedChat(joinedAt[tags[0]], peerID, chatID)
+			if err == nil {
+				err = chatActionStorage.SetPeerRole(peerID, chatID, membership.Role(peerID))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// observedJoins returns the joins of the peer that a removal of the peer has to list
+func observedJoins(chatActionStorage store.ChatActionStoragePort, chatID string, peerID string) ([]string, error) {
+	messages, err := chatActionStorage.GetMembershipMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	return p_model.MembershipFromMessages(messages).Tags(peerID), nil
+}
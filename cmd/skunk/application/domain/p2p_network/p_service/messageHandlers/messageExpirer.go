@@ -0,0 +1,192 @@
+package messageHandlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"os"
+	"sync"
+	"time"
+)
+
+// expiringOperations are the operations that disappear in chats with a message expiry.
+// Membership, roles and settings are kept, the chat can't be rebuilt without them.
+var expiringOperations = []network.OperationType{
+	network.SEND_MESSAGE,
+	network.SEND_FILE,
+	network.EDIT_MESSAGE,
+	network.DELETE_MESSAGE,
+	network.REACT,
+}
+
+// isExpired checks if the message has to disappear because it is older than the message expiry of its chat
+func isExpired(chatSettingsStorage store.ChatSettingsStoragePort, message network.Message, now int64) (bool, error) {
+	if !isExpiringOperation(message.Operation) {
+		return false, nil
+	}
+
+	expiry, err := chatSettingsStorage.GetMessageExpiry(message.ChatID)
+	if err != nil || expiry == 0 {
+		return false, err
+	}
+
+	return message.Timestamp < now-expiry, nil
+}
+
+func isExpiringOperation(operation network.OperationType) bool {
+	for _, o := range expiringOperations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageExpirer removes expired messages of chats with disappearing messages and the files sent with them.
+// Every peer runs it on a background timer, so the messages disappear even if the chat is not opened.
+type MessageExpirer struct {
+	chatSettingsStorage store.ChatSettingsStoragePort
+	displayStorage      store.DisplayStoragePort
+	fileDir             string
+	quit                chan struct{}
+	stopOnce            sync.Once
+}
+
+func NewMessageExpirer(chatSettingsStorage store.ChatSettingsStoragePort, displayStorage store.DisplayStoragePort, fileDir string) *MessageExpirer {
+	return &MessageExpirer{
+		chatSettingsStorage: chatSettingsStorage,
+		displayStorage:      displayStorage,
+		fileDir:             fileDir,
+		quit:                make(chan struct{}),
+	}
+}
+
+// Start purges expired messages every interval until Stop is called
+func (e *MessageExpirer) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := e.PurgeExpiredMessages(time.Now().UnixNano())
+				if err != nil {
+					fmt.Println("Error purging expired messages:", err)
+				}
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background timer
+func (e *MessageExpirer) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.quit)
+	})
+}
+
+// PurgeExpiredMessages removes all messages that are older than the message expiry of their chat
+func (e *MessageExpirer) PurgeExpiredMessages(now int64) error {
+	expiries, err := e.chatSettingsStorage.GetMessageExpiries()
+	if err != nil {
+		return err
+	}
+
+	var purged []network.Message
+	for chatID, expiry := range expiries {
+		purgedInChat, err := e.chatSettingsStorage.PurgeMessages(chatID, now-expiry, expiringOperations)
+		if err != nil {
+			return err
+		}
+		purged = append(purged, purgedInChat...)
+	}
+
+	return removeUnreferencedFiles(e.fileDir, e.displayStorage, purged)
+}
+
+// sentFile is the content of a SEND_FILE message, the file content is base64 encoded
+type sentFile struct {
+	FileName      string `json:"fileName"`
+	FileExtension string `json:"fileExtension"`
+	FileContent   string `json:"fileContent"`
+}
+
+// decodeSentFile returns the content of the SEND_FILE message and the data of its file
+func decodeSentFile(message network.Message) (sentFile, []byte, error) {
+	var content sentFile
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		return sentFile{}, nil, err
+	}
+
+	fileData, err := base64.StdEncoding.DecodeString(content.FileContent)
+	return content, fileData, err
+}
+
+// removeUnreferencedFiles removes the files stored for the removed SEND_FILE messages. All chats share the fileDir and
+// the files are stored by name and content, so a file is only removed if no remaining message of any chat sent it too.
+func removeUnreferencedFiles(fileDir string, displayStorage store.DisplayStoragePort, removed []network.Message) error {
+	files := map[string]network.Message{}
+	for _, message := range removed {
+		if key, ok := storedFileKey(message); ok {
+			files[key] = message
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	chats, err := displayStorage.GetChats()
+	if err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		messages, err := displayStorage.GetChatMessages(chat.ChatId)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if key, ok := storedFileKey(message); ok {
+				delete(files, key)
+			}
+		}
+	}
+
+	for _, message := range files {
+		removeStoredFile(fileDir, message)
+	}
+	return nil
+}
+
+// storedFileKey identifies the file of a SEND_FILE message by its name, extension and the hash of its content
+func storedFileKey(message network.Message) (string, bool) {
+	if message.Operation != network.SEND_FILE {
+		return "", false
+	}
+
+	content, fileData, err := decodeSentFile(message)
+	if err != nil {
+		return "", false
+	}
+	return content.FileName + "." + content.FileExtension + ":" + computeHash(fileData), true
+}
+
+// removeStoredFile removes the file that was stored in fileDir for the SEND_FILE message.
+// Files are stored by name and content, so the path is found the same way the sendFileHandler chose it.
+func removeStoredFile(fileDir string, message network.Message) {
+	content, fileData, err := decodeSentFile(message)
+	if err != nil {
+		return
+	}
+
+	// the path either belongs to a file with the same content or doesn't exist
+	filePath := getFilePathConsideringHash(fileDir, content.FileName, content.FileExtension, fileData)
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error removing file:", err)
+	}
+}
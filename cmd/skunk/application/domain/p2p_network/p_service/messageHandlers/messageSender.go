@@ -33,7 +33,7 @@ func (m *MessageSender) SendMessage(message network.Message) error {
 		return errors.New("invalid message")
 	}
 
-	err := m.networkConnection.SendMessageToNetworkPeer(message)
+	err := m.networkConnection.SendMessageToNetworkPeer(m.ToNetwork(message))
 	if err != nil {
 		return err
 	}
@@ -44,3 +44,13 @@ func (m *MessageSender) SendMessage(message network.Message) error {
 func (m *MessageSender) SetNetworkConnection(networkConnection network.NetworkConnection) {
 	m.networkConnection = networkConnection
 }
+
+// ToNetwork returns the message as the other peers have to see it, with the identity of this peer instead of "self"
+func (m *MessageSender) ToNetwork(message network.Message) network.Message {
+	return m.securityContext.Identity().ToNetwork(message)
+}
+
+// IsOwnMessageChange checks that an edit or deletion of this peer refers to one of its messages in the chat
+func (m *MessageSender) IsOwnMessageChange(message network.Message) bool {
+	return m.securityContext.ValidateMessageChange(message)
+}
@@ -8,10 +8,17 @@ import (
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 )
 
-type NetworkOnlineHandler struct{}
+// NetworkOnlineHandler sets the address of the peer once its network connection is online
+type NetworkOnlineHandler struct {
+	peer *Peer
+}
+
+func NewNetworkOnlineHandler(peer *Peer) *NetworkOnlineHandler {
+	return &NetworkOnlineHandler{peer: peer}
+}
 
 func (s *NetworkOnlineHandler) HandleMessage(message network.Message) error {
-	peer := GetPeerInstance()
+	peer := s.peer
 
 	var peerAddress string
 	err := json.Unmarshal([]byte(message.Content), &peerAddress)
@@ -22,6 +29,6 @@ func (s *NetworkOnlineHandler) HandleMessage(message network.Message) error {
 	if !strings.HasPrefix(peerAddress, "ws://") || !strings.Contains(peerAddress, ".onion:") {
 		return fmt.Errorf("network has sent incorrectly formatted peer address")
 	}
-	peer.Address = peerAddress
+	peer.SetIdentity(peerAddress)
 	return nil
 }
@@ -3,18 +3,19 @@ package messageHandlers
 import (
 	"errors"
 	"fmt"
-	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
-	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
-	"sync"
+	"time"
 )
 
-var (
-	peerInstance *Peer
-	once         sync.Once
-)
+// messageExpiryInterval is how often expired messages of chats with disappearing messages are purged
+const messageExpiryInterval = time.Minute
+
+// compactionInterval is how often the retention policies of the chats are enforced
+const compactionInterval = 10 * time.Minute
 
 type Peer struct {
 	Address         string
@@ -24,40 +25,97 @@ type Peer struct {
 	messageSender   *MessageSender
 	securityContext p_service.SecurityValidater
 	storage         store.NetworkMessageStoragePort
+	chatStorage     store.ChatActionStoragePort
+	messageExpirer  *MessageExpirer
+	compactor       *Compactor
+	heartbeat       *PresenceHeartbeat
 }
 
-func GetPeerInstance() *Peer {
-	once.Do(func() {
-		storage := storageSQLiteAdapter.GetInstance("skunk.db")
-		securityContext := p_service.NewSecurityContext(storage, storage, storage)
-		sender := NewMessageSender(securityContext)
-		chatLogic := c_service.GetChatServiceInstance()
-
-		handlers := map[network.OperationType]MessageHandler{
-			network.SEND_MESSAGE:   NewSendMessageHandler(chatLogic, storage),
-			network.SYNC_REQUEST:   NewSyncRequestHandler(storage, storage, sender),
-			network.SYNC_RESPONSE:  NewSyncResponseHandler(storage),
-			network.JOIN_CHAT:      NewJoinChatHandler(chatLogic, storage),
-			network.LEAVE_CHAT:     NewLeaveChatHandler(chatLogic, storage),
-			network.INVITE_TO_CHAT: NewInviteToChatHandler(chatLogic, storage),
-			network.SEND_FILE:      NewSendFileHandler(chatLogic, storage),
-			network.SET_USERNAME:   NewSetUsernameHandler(chatLogic, storage),
-			network.NETWORK_ONLINE: &NetworkOnlineHandler{},
-			network.TEST_MESSAGE:   &TestMessageHandler{},
-			network.TEST_MESSAGE_2: &TestMessageHandler2{},
-		}
+// NewPeer creates a peer that keeps its messages in the storage and passes them to the chat logic.
+// Received files are stored in fileDir. The peer purges expired messages, enforces the retention policies
+// and sends heartbeats until it is closed.
+func NewPeer(storage store.Storage, chatLogic chat.ChatLogic, fileDir string) *Peer {
+	securityContext := p_service.NewSecurityContext(storage, storage, storage, storage)
+	sender := NewMessageSender(securityContext)
 
-		peerInstance = &Peer{
-			Address:         "",
-			handlers:        handlers,
-			connections:     []network.NetworkConnection{},
-			securityContext: securityContext,
-			storage:         storage,
-			messageSender:   sender,
-		}
-	})
+	typingHandler := NewTypingHandler(chatLogic, typingTimeout)
+	presenceHandler := NewPresenceHandler(chatLogic, storage, storage)
+
+	peer := &Peer{
+		Address:         "",
+		connections:     []network.NetworkConnection{},
+		securityContext: securityContext,
+		storage:         storage,
+		chatStorage:     storage,
+		messageSender:   sender,
+	}
+
+	peer.handlers = map[network.OperationType]MessageHandler{
+		network.SEND_MESSAGE:         NewSendMessageHandler(chatLogic, storage),
+		network.SYNC_REQUEST:         NewSyncRequestHandler(storage, storage, storage, storage, sender),
+		network.SYNC_RESPONSE:        NewSyncResponseHandler(storage, storage, storage, storage, storage, storage, storage, storage, securityContext),
+		network.JOIN_CHAT:            NewJoinChatHandler(chatLogic, storage, storage),
+		network.LEAVE_CHAT:           NewLeaveChatHandler(chatLogic, storage),
+		network.INVITE_TO_CHAT:       NewInviteToChatHandler(chatLogic, storage),
+		network.SEND_FILE:            NewSendFileHandler(chatLogic, storage, fileDir),
+		network.SET_USERNAME:         NewSetUsernameHandler(chatLogic, storage),
+		network.NETWORK_ONLINE:       NewNetworkOnlineHandler(peer),
+		network.TEST_MESSAGE:         &TestMessageHandler{},
+		network.TEST_MESSAGE_2:       &TestMessageHandler2{},
+		network.INVITE_RESPONSE:      NewInviteResponseHandler(chatLogic, storage),
+		network.SET_ROLE:             NewSetRoleHandler(chatLogic, storage),
+		network.REMOVE_MEMBER:        NewRemoveMemberHandler(chatLogic, storage),
+		network.BAN_MEMBER:           NewBanMemberHandler(chatLogic, storage),
+		network.UPDATE_CHAT_METADATA: NewUpdateChatMetadataHandler(chatLogic, storage),
+		network.EDIT_MESSAGE:         NewEditMessageHandler(chatLogic, storage),
+		network.DELETE_MESSAGE:       NewDeleteMessageHandler(chatLogic, storage),
+		network.REACT:                NewReactHandler(chatLogic, storage),
+		network.UPDATE_CHAT_SETTINGS: NewUpdateChatSettingsHandler(chatLogic, storage),
+		network.TYPING_STARTED:       typingHandler,
+		network.TYPING_STOPPED:       typingHandler,
+		network.PRESENCE:             presenceHandler,
+		network.USER_OFFLINE:         presenceHandler,
+	}
 
-	return peerInstance
+	peer.messageExpirer = NewMessageExpirer(storage, storage, fileDir)
+	peer.messageExpirer.Start(messageExpiryInterval)
+
+	peer.compactor = NewCompactor(storage, storage, storage, fileDir)
+	peer.compactor.Start(compactionInterval)
+
+	peer.heartbeat = NewPresenceHeartbeat(storage, sender)
+	peer.heartbeat.Start(p_model.PresenceInterval)
+
+	return peer
+}
+
+// SetIdentity sets the onion address of this peer once its network connection is online.
+// The other peers know this peer by the host of the address.
+func (p *Peer) SetIdentity(address string) {
+	p.securityContext.Identity().Set(address)
+	p.Address = address
+	p.ID = p.securityContext.Identity().ID()
+}
+
+// MessageSender returns the sender that sends the messages of this peer over its network connection
+func (p *Peer) MessageSender() *MessageSender {
+	return p.messageSender
+}
+
+// Close tells the chats that this peer goes offline, stops its background timers and removes its network connections
+func (p *Peer) Close() error {
+	p.messageExpirer.Stop()
+	p.compactor.Stop()
+	err := p.heartbeat.Stop()
+	if len(p.connections) == 0 {
+		// Without a network connection nobody can be told that this peer goes offline
+		err = nil
+	}
+
+	for len(p.connections) > 0 {
+		p.RemoveNetworkConnection(p.connections[0])
+	}
+	return err
 }
 
 // AddNetworkConnection adds a new network connection to the Peer instance
@@ -67,8 +125,12 @@ func (p *Peer) AddNetworkConnection(connection network.NetworkConnection) error
 		return errors.New("connection already exists, multiple connections are not supported so far")
 	}
 
+	err := connection.SubscribeToNetwork(p)
+	if err != nil {
+		return err
+	}
+
 	p.connections = append(p.connections, connection)
-	connection.SubscribeToNetwork(p)
 	p.messageSender.SetNetworkConnection(connection)
 
 	return nil
@@ -93,12 +155,30 @@ func (p *Peer) RemoveNetworkConnection(connection network.NetworkConnection) {
 // the message operation type. If the message is invalid or the operation type
 // is not supported, an error is returned.
 func (p *Peer) Notify(message network.Message) error {
+	// Messages of blocked peers are dropped without an error, the peer must not learn that it was blocked
+	if !p.ValidatePeer(message.SenderAddress) {
+		return nil
+	}
+
 	if handler, exists := p.handlers[message.Operation]; exists {
+		// Members the message refers to by the id of this peer are "self" in the storage
+		message = p.securityContext.Identity().FromNetwork(message)
 		if !p.securityContext.ValidateIncomingMessage(message) {
 			return errors.New("invalid message")
 		}
 
-		p.storage.StoreMessage(message)
+		// Direct chats need no invitation, the first message of the other peer creates the chat
+		if p_model.IsDirectChatID(message.ChatID) && (message.Operation == network.SEND_MESSAGE || message.Operation == network.SEND_FILE) {
+			err := p.chatStorage.DirectChatCreated(time.Now().UnixNano(), message.ChatID, message.SenderID, message.SenderAddress)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Ephemeral messages are only handled, they must not come back with a sync
+		if !message.Operation.IsEphemeral() {
+			p.storage.StoreMessage(message)
+		}
 
 		if message.ReceiverID == p.ID {
 			return handler.HandleMessage(message)
@@ -107,3 +187,8 @@ func (p *Peer) Notify(message network.Message) error {
 	}
 	return errors.New("invalid message operation")
 }
+
+// ValidatePeer checks if the network connection may connect to the peer or accept its connection
+func (p *Peer) ValidatePeer(address string) bool {
+	return p.securityContext.ValidatePeer(address)
+}
@@ -0,0 +1,112 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
+)
+
+// A Peer sends a PRESENCE heartbeat, or the network adapter reports with USER_OFFLINE that a connection to a peer failed
+// The presence of a member is kept for the address the member is known by in the chat.
+type presenceHandler struct {
+	userChatLogic   chat.ChatLogic
+	presenceStorage store.PresenceStoragePort
+	displayStorage  store.DisplayStoragePort
+}
+
+func NewPresenceHandler(userChatLogic chat.ChatLogic, presenceStorage store.PresenceStoragePort, displayStorage store.DisplayStoragePort) *presenceHandler {
+	return &presenceHandler{
+		userChatLogic:   userChatLogic,
+		presenceStorage: presenceStorage,
+		displayStorage:  displayStorage,
+	}
+}
+
+func (p *presenceHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the PRESENCE message:
+	/*
+		{
+			"online": true, (false if the peer is going offline)
+		}
+	*/
+	// USER_OFFLINE messages have no content, the address of the peer that is offline is the ReceiverAddress
+
+	address := message.ReceiverAddress
+	online := false
+
+	if message.Operation == network.PRESENCE {
+		var update p_model.PresenceUpdate
+		err := json.Unmarshal([]byte(message.Content), &update)
+		if err != nil {
+			fmt.Println("Error unmarshalling message content")
+			return err
+		}
+		online = update.Online
+
+		address, err = p.knownAddress(message.SenderID, message.ChatID)
+		if err != nil {
+			fmt.Println("Error getting the address of the member")
+			return err
+		}
+		if address == "" {
+			// Members without a known address can't be reached, their presence isn't tracked
+			return nil
+		}
+	}
+
+	previous, err := p.presenceStorage.GetPresence(address)
+	if err != nil {
+		fmt.Println("Error getting presence")
+		return err
+	}
+
+	// The time of arrival is used, the clocks of the peers don't have to match
+	now := time.Now()
+	if online {
+		err = p.presenceStorage.PeerSeen(address, now.UnixNano())
+	} else {
+		err = p.presenceStorage.PeerOffline(address)
+	}
+	if err != nil {
+		fmt.Println("Error storing presence")
+		return err
+	}
+
+	current, err := p.presenceStorage.GetPresence(address)
+	if err != nil {
+		fmt.Println("Error getting presence")
+		return err
+	}
+
+	// Heartbeats of peers that are already online don't change anything
+	if p_model.IsOnline(previous, now) != p_model.IsOnline(current, now) {
+		p.userChatLogic.PresenceChanged(address, online, current.LastSeen)
+	}
+
+	return nil
+}
+
+// knownAddress returns the address the member is known by in the chat, empty if there is none
+func (p *presenceHandler) knownAddress(peerID, chatID string) (string, error) {
+	chats, err := p.displayStorage.GetChats()
+	if err != nil {
+		return "", err
+	}
+
+	for _, chat := range chats {
+		if chat.ChatId != chatID {
+			continue
+		}
+		addresses, err := memberAddresses(p.displayStorage, chat)
+		if err != nil {
+			return "", err
+		}
+		return addresses[peerID], nil
+	}
+	return "", nil
+}
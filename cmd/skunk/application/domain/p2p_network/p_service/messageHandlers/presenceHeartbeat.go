@@ -0,0 +1,131 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PresenceHeartbeat tells the members of all chats on a background timer that this peer is online.
+// The heartbeats are ephemeral, peers that miss them consider this peer offline after the PresenceTimeout.
+type PresenceHeartbeat struct {
+	displayStorage store.DisplayStoragePort
+	sender         *MessageSender
+	quit           chan struct{}
+	stopOnce       sync.Once
+}
+
+func NewPresenceHeartbeat(displayStorage store.DisplayStoragePort, sender *MessageSender) *PresenceHeartbeat {
+	return &PresenceHeartbeat{
+		displayStorage: displayStorage,
+		sender:         sender,
+		quit:           make(chan struct{}),
+	}
+}
+
+// Start sends a heartbeat every interval until Stop is called
+func (h *PresenceHeartbeat) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Heartbeats fail while there is no network connection, the next one is sent after the interval
+				h.SendHeartbeat(true)
+			case <-h.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background timer and tells the members of all chats that this peer is going offline
+func (h *PresenceHeartbeat) Stop() error {
+	var err error
+	h.stopOnce.Do(func() {
+		close(h.quit)
+		err = h.SendHeartbeat(false)
+	})
+	return err
+}
+
+// SendHeartbeat sends a PRESENCE message to every member of the chats this peer is a member of.
+// Members that can't be reached don't stop the heartbeat, their errors are returned together.
+func (h *PresenceHeartbeat) SendHeartbeat(online bool) error {
+	content, err := json.Marshal(p_model.PresenceUpdate{Online: online})
+	if err != nil {
+		return err
+	}
+
+	chats, err := h.displayStorage.GetChats()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, chat := range chats {
+		addresses, err := memberAddresses(h.displayStorage, chat)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		members := make([]string, 0, len(addresses))
+		for member := range addresses {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+
+		for _, member := range members {
+			message := newChatMessage(chat.ChatId, string(content), network.PRESENCE)
+			message.ReceiverID = member
+			message.ReceiverAddress = addresses[member]
+			err = h.sender.SendMessage(message)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// memberAddresses returns the addresses of the other members of the chat, by their id. A member is known by the
+// address of its JOIN_CHAT message, the peer of a direct chat by the address stored with the chat.
+// Members without a known address are left out.
+func memberAddresses(displayStorage store.DisplayStoragePort, chat store.Chat) (map[string]string, error) {
+	members, err := displayStorage.GetUsersInChat(chat.ChatId)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := displayStorage.GetChatMessages(chat.ChatId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the id of a peer is the host of its onion address, addresses that don't belong to the sender are ignored
+	known := make(map[string]string)
+	for _, message := range messages {
+		if message.Operation == network.JOIN_CHAT && message.SenderID != "self" && message.SenderID == p_model.OnionHost(message.SenderAddress) {
+			known[message.SenderID] = message.SenderAddress
+		}
+	}
+	if chat.DirectPeerId != "" && chat.DirectPeerAddress != "" {
+		known[chat.DirectPeerId] = chat.DirectPeerAddress
+	}
+
+	addresses := make(map[string]string)
+	for _, member := range members {
+		if address, ok := known[member.UserId]; ok {
+			addresses[member.UserId] = address
+		}
+	}
+	return addresses, nil
+}
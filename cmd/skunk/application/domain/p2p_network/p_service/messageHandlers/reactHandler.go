@@ -0,0 +1,56 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer adds or removes a reaction to a message
+type reactHandler struct {
+	userChatLogic   chat.ChatLogic
+	reactionStorage store.ReactionStoragePort
+}
+
+func NewReactHandler(userChatLogic chat.ChatLogic, reactionStorage store.ReactionStoragePort) *reactHandler {
+	return &reactHandler{
+		userChatLogic:   userChatLogic,
+		reactionStorage: reactionStorage,
+	}
+}
+
+func (r *reactHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message:
+	/*
+		{
+			"messageId": "id_of_the_message",
+			"emoji": "👍",
+			"add": true, (false removes the reaction)
+		}
+	*/
+
+	var reaction p_model.ReactionChange
+	err := json.Unmarshal([]byte(message.Content), &reaction)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Reactions that are older than the stored reaction of the peer are ignored
+	updated, err := r.reactionStorage.PeerReacted(message.Timestamp, message.Id, reaction.MessageID, message.SenderID, reaction.Emoji, reaction.Add)
+	if err != nil {
+		fmt.Println("Error storing reaction")
+		return err
+	}
+
+	// Handle the changed reaction
+	if updated {
+		r.userChatLogic.ReactionChanged(message.SenderID, message.ChatID, reaction.MessageID, reaction.Emoji, reaction.Add)
+	}
+
+	return nil
+}
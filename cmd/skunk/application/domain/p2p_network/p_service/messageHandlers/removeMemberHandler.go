@@ -0,0 +1,78 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer removes (kicks) or bans another member from a chat
+type removeMemberHandler struct {
+	userChatLogic     chat.ChatLogic
+	chatActionStorage store.ChatActionStoragePort
+	ban               bool
+}
+
+// NewRemoveMemberHandler creates a handler for REMOVE_MEMBER messages
+func NewRemoveMemberHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort) *removeMemberHandler {
+	return &removeMemberHandler{
+		userChatLogic:     userChatLogic,
+		chatActionStorage: chatActionStorage,
+		ban:               false,
+	}
+}
+
+// NewBanMemberHandler creates a handler for BAN_MEMBER messages
+func NewBanMemberHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort) *removeMemberHandler {
+	return &removeMemberHandler{
+		userChatLogic:     userChatLogic,
+		chatActionStorage: chatActionStorage,
+		ban:               true,
+	}
+}
+
+func (r *removeMemberHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message:
+	/*
+		{
+			"peerId": "peer_id",
+			"removes": ["join_message_id", ...], (the joins of the peer the sender has seen)
+		}
+	*/
+
+	var content struct {
+		PeerID string `json:"peerId"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Banned peers are removed for good and rejected when they try to join again.
+	// A kicked peer stays a member if it joined again concurrently.
+	if r.ban {
+		err = r.chatActionStorage.PeerBannedFromChat(message.Timestamp, content.PeerID, message.ChatID)
+	} else {
+		var isMember bool
+		isMember, err = updateMembership(r.chatActionStorage, message, content.PeerID)
+		if err == nil && isMember {
+			return nil
+		}
+	}
+	if err != nil {
+		fmt.Println("Error removing peer from chat")
+		return err
+	}
+
+	// TODO: rotate the group key once chats are encrypted, so removed peers can't read new messages
+
+	// Handle the peer being removed from the chat
+	r.userChatLogic.PeerRemovedFromChat(message.SenderID, message.ChatID, content.PeerID, r.ban)
+
+	return nil
+}
@@ -18,12 +18,14 @@ import (
 type sendFileHandler struct {
 	userChatLogic         chat.ChatLogic
 	chatInvitationStorage store.ChatInvitationStoragePort
+	fileDir               string // directory the received files are stored in
 }
 
-func NewSendFileHandler(userChatLogic chat.ChatLogic, chatInvitationStorage store.ChatInvitationStoragePort) *sendFileHandler {
+func NewSendFileHandler(userChatLogic chat.ChatLogic, chatInvitationStorage store.ChatInvitationStoragePort, fileDir string) *sendFileHandler {
 	return &sendFileHandler{
 		userChatLogic:         userChatLogic,
 		chatInvitationStorage: chatInvitationStorage,
+		fileDir:               fileDir,
 	}
 }
 
@@ -58,7 +60,7 @@ func (s *sendFileHandler) HandleMessage(message network.Message) error {
 	}
 
 	// Create a directory for storing the files if it doesn't exist
-	fileDir := "./stored_files"
+	fileDir := s.fileDir
 	if err := os.MkdirAll(fileDir, os.ModePerm); err != nil {
 		fmt.Println("Error creating file directory")
 		return err
@@ -27,11 +27,13 @@ func (s *sendMessageHandler) HandleMessage(message network.Message) error {
 	/*
 		{
 			"message": "asdfasdfasdf",
+			"replyTo": "parent_message_id", (optional)
 		}
 	*/
 
 	var content struct {
 		Message string `json:"message"`
+		ReplyTo string `json:"replyTo"`
 	}
 
 	err := json.Unmarshal([]byte(message.Content), &content)
@@ -47,8 +49,12 @@ func (s *sendMessageHandler) HandleMessage(message network.Message) error {
 		return err
 	}
 
-	// Handle the received message
-	s.userChatLogic.ReceiveMessage(message.SenderID, message.ChatID, content.Message)
+	// Handle the received message, the parent of a reply may not have arrived yet
+	if content.ReplyTo != "" {
+		s.userChatLogic.ReceiveReply(message.SenderID, message.ChatID, content.Message, content.ReplyTo)
+	} else {
+		s.userChatLogic.ReceiveMessage(message.SenderID, message.ChatID, content.Message)
+	}
 
 	return nil
 }
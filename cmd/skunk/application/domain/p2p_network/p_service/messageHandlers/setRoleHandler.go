@@ -0,0 +1,56 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer changes the role of a chat member
+type setRoleHandler struct {
+	userChatLogic     chat.ChatLogic
+	chatActionStorage store.ChatActionStoragePort
+}
+
+func NewSetRoleHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort) *setRoleHandler {
+	return &setRoleHandler{
+		userChatLogic:     userChatLogic,
+		chatActionStorage: chatActionStorage,
+	}
+}
+
+func (s *setRoleHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message (role: 0 = member, 1 = admin, 2 = owner):
+	/*
+		{
+			"peerId": "peer_id",
+			"role": 1,
+		}
+	*/
+
+	var content struct {
+		PeerID string         `json:"peerId"`
+		Role   store.ChatRole `json:"role"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Store the new role
+	err = s.chatActionStorage.SetPeerRole(content.PeerID, message.ChatID, content.Role)
+	if err != nil {
+		fmt.Println("Error storing role")
+		return err
+	}
+
+	// Handle the role change
+	s.userChatLogic.PeerRoleChanged(message.SenderID, message.ChatID, content.PeerID, content.Role)
+
+	return nil
+}
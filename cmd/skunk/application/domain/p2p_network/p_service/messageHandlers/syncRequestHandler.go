@@ -10,18 +10,30 @@ import (
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
 )
 
+// syncRequestContent is the content of a SYNC_REQUEST message. The pruned range of the requesting peer is optional,
+// messages in it are not sent because the peer would only drop them.
+type syncRequestContent struct {
+	ExistingMessageIDs []string `json:"existingMessageIds"`
+	PrunedBefore       int64    `json:"prunedBefore,omitempty"`
+	FilesPrunedBefore  int64    `json:"filesPrunedBefore,omitempty"`
+}
+
 // SyncRequestHandler handles the "SyncRequest" message operation.
 type syncRequestHandler struct {
 	syncStorage           store.SyncStoragePort
 	networkMessageStorage store.NetworkMessageStoragePort
+	chatSettingsStorage   store.ChatSettingsStoragePort
+	retentionStorage      store.RetentionStoragePort
 	messageSender         *MessageSender
 }
 
 // NewSyncRequestHandler creates a new instance of syncRequestHandler.
-func NewSyncRequestHandler(syncStorage store.SyncStoragePort, networkMessageStorage store.NetworkMessageStoragePort, messageSender *MessageSender) *syncRequestHandler {
+func NewSyncRequestHandler(syncStorage store.SyncStoragePort, networkMessageStorage store.NetworkMessageStoragePort, chatSettingsStorage store.ChatSettingsStoragePort, retentionStorage store.RetentionStoragePort, messageSender *MessageSender) *syncRequestHandler {
 	return &syncRequestHandler{
 		syncStorage:           syncStorage,
 		networkMessageStorage: networkMessageStorage,
+		chatSettingsStorage:   chatSettingsStorage,
+		retentionStorage:      retentionStorage,
 		messageSender:         messageSender,
 	}
 }
@@ -35,13 +47,13 @@ func (s *syncRequestHandler) HandleMessage(message network.Message) error {
 			"<message id 1>",
 			"<message id 2>",
 			...
-		  ]
+		  ],
+		  "prunedBefore": <timestamp>,
+		  "filesPrunedBefore": <timestamp>
 		}
 	*/
 
-	var content struct {
-		ExistingMessageIDs []string `json:"existingMessageIds"`
-	}
+	var content syncRequestContent
 	err := json.Unmarshal([]byte(message.Content), &content)
 	if err != nil {
 		fmt.Println("Error unmarshalling message content")
@@ -56,16 +68,26 @@ func (s *syncRequestHandler) HandleMessage(message network.Message) error {
 	}
 
 	var missingExternalMessages []network.Message
-	missingExternalMessages = make([]network.Message, len(missingExternalMessageIDs))
+	missingExternalMessages = make([]network.Message, 0, len(missingExternalMessageIDs))
 
-	for i, messageID := range missingExternalMessageIDs {
-		missingExternalMessages[i], err = s.networkMessageStorage.RetrieveMessage(messageID)
+	requesterPruned := store.PrunedRange{Before: content.PrunedBefore, FilesBefore: content.FilesPrunedBefore}
+	now := time.Now().UnixNano()
+	for _, messageID := range missingExternalMessageIDs {
+		missingMessage, err := s.networkMessageStorage.RetrieveMessage(messageID)
 		if err != nil {
 			return err
 		}
+
+		// Expired messages that were not purged yet must not be served, they would come back on the other peer
+		expired, err := isExpired(s.chatSettingsStorage, missingMessage, now)
+		if err != nil {
+			return err
+		}
+		if !expired && !isPruned(requesterPruned, missingMessage) {
+			missingExternalMessages = append(missingExternalMessages, s.messageSender.ToNetwork(missingMessage))
+		}
 	}
 
-	// TODO FIX
 	missingInternalMessages, err := s.syncStorage.GetMissingInternalMessages(message.ChatID, content.ExistingMessageIDs)
 	if err != nil {
 		fmt.Println("Error getting missing internal messages")
@@ -79,12 +101,6 @@ func (s *syncRequestHandler) HandleMessage(message network.Message) error {
 		return err
 	}
 
-	internalMessagesBytes, err := json.Marshal(missingInternalMessages)
-	if err != nil {
-		fmt.Println("Error marshalling missing internal messages")
-		return err
-	}
-
 	// Create and send the sync response
 	syncResponse := network.Message{
 		Id:              uuid.New().String(),
@@ -98,11 +114,52 @@ func (s *syncRequestHandler) HandleMessage(message network.Message) error {
 		Operation:       network.SYNC_RESPONSE,
 	}
 
-	// Create and send the sync request
+	err = s.messageSender.SendMessage(syncResponse)
+	if err != nil {
+		return err
+	}
+
+	// The requesting peer has messages this peer doesn't have, they are requested with the own pruned range,
+	// so the messages this peer pruned are not sent back
+	if len(missingInternalMessages) == 0 {
+		return nil
+	}
+	return s.requestMissingMessages(message, content.ExistingMessageIDs, missingInternalMessages, missingExternalMessageIDs)
+}
+
+// requestMissingMessages answers the sync request with a sync request for the messages this peer is missing.
+// The messages this peer has are the ones both peers have and the ones only this peer has.
+func (s *syncRequestHandler) requestMissingMessages(message network.Message, requesterMessageIDs, missingMessageIDs, onlyOwnMessageIDs []string) error {
+	pruned, err := s.retentionStorage.GetPrunedRange(message.ChatID)
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[string]bool, len(missingMessageIDs))
+	for _, id := range missingMessageIDs {
+		missing[id] = true
+	}
+	request := syncRequestContent{
+		ExistingMessageIDs: append([]string{}, onlyOwnMessageIDs...),
+		PrunedBefore:       pruned.Before,
+		FilesPrunedBefore:  pruned.FilesBefore,
+	}
+	for _, id := range requesterMessageIDs {
+		if !missing[id] {
+			request.ExistingMessageIDs = append(request.ExistingMessageIDs, id)
+		}
+	}
+
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		fmt.Println("Error marshalling sync request")
+		return err
+	}
+
 	syncRequest := network.Message{
 		Id:              uuid.New().String(),
 		Timestamp:       time.Now().UnixNano(),
-		Content:         string(internalMessagesBytes),
+		Content:         string(requestBytes),
 		SenderID:        message.ReceiverID,
 		ReceiverID:      message.SenderID,
 		SenderAddress:   message.ReceiverAddress,
@@ -110,15 +167,5 @@ func (s *syncRequestHandler) HandleMessage(message network.Message) error {
 		ChatID:          message.ChatID,
 		Operation:       network.SYNC_REQUEST,
 	}
-
-	err = s.messageSender.SendMessage(syncResponse)
-	if err != nil {
-		return err
-	}
-	err = s.messageSender.SendMessage(syncRequest)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.messageSender.SendMessage(syncRequest)
 }
@@ -3,24 +3,45 @@ package messageHandlers
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"sort"
+	"time"
 )
 
 type syncResponseHandler struct {
 	networkMessageStorage store.NetworkMessageStoragePort
+	chatActionStorage     store.ChatActionStoragePort
+	chatInvitationStorage store.ChatInvitationStoragePort
+	messageEditStorage    store.MessageEditStoragePort
+	reactionStorage       store.ReactionStoragePort
+	chatSettingsStorage   store.ChatSettingsStoragePort
+	retentionStorage      store.RetentionStoragePort
+	blockList             store.BlockListStoragePort
+	securityContext       p_service.SecurityValidater
 }
 
-func NewSyncResponseHandler(networkMessageStorage store.NetworkMessageStoragePort) *syncResponseHandler {
+func NewSyncResponseHandler(networkMessageStorage store.NetworkMessageStoragePort, chatActionStorage store.ChatActionStoragePort, chatInvitationStorage store.ChatInvitationStoragePort, messageEditStorage store.MessageEditStoragePort, reactionStorage store.ReactionStoragePort, chatSettingsStorage store.ChatSettingsStoragePort, retentionStorage store.RetentionStoragePort, blockList store.BlockListStoragePort, securityContext p_service.SecurityValidater) *syncResponseHandler {
 	return &syncResponseHandler{
 		networkMessageStorage: networkMessageStorage,
+		chatActionStorage:     chatActionStorage,
+		chatInvitationStorage: chatInvitationStorage,
+		messageEditStorage:    messageEditStorage,
+		reactionStorage:       reactionStorage,
+		chatSettingsStorage:   chatSettingsStorage,
+		retentionStorage:      retentionStorage,
+		blockList:             blockList,
+		securityContext:       securityContext,
 	}
 }
 
 // HandleMessage processes the received "SyncResponse" message.
-// It retrieves the chat message repository, unmarshals the received messages from the message content,
-// and adds each received message to the chat message repository.
-// TODO: Implement a security check to ensure that the message is valid.
+// It unmarshals the received messages from the message content, checks each of them with the same rules as a
+// message that is received directly, and stores and applies the valid ones.
+// The messages are handled in the order they were sent, a message may only be valid because of an earlier one,
+// like the messages of a member after its join.
 func (s *syncResponseHandler) HandleMessage(message network.Message) error {
 
 	var receivedMessages []network.Message
@@ -30,7 +51,70 @@ func (s *syncResponseHandler) HandleMessage(message network.Message) error {
 		return err
 	}
 
+	identity := s.securityContext.Identity()
+	for i := range receivedMessages {
+		receivedMessages[i] = identity.FromNetwork(receivedMessages[i])
+	}
+	sort.SliceStable(receivedMessages, func(i, j int) bool {
+		if receivedMessages[i].Timestamp != receivedMessages[j].Timestamp {
+			return receivedMessages[i].Timestamp < receivedMessages[j].Timestamp
+		}
+		return receivedMessages[i].Id < receivedMessages[j].Id
+	})
+
+	// Apply the synced settings first, they decide which of the other messages already expired.
+	// Only settings of senders that are admins already are applied here, the others are checked again in order below.
+	for _, msg := range receivedMessages {
+		if msg.Operation != network.UPDATE_CHAT_SETTINGS || !s.securityContext.ValidateSyncedMessage(message, msg) {
+			continue
+		}
+		err = s.applySettings(msg)
+		if err != nil {
+			fmt.Println("Error applying chat settings:", err)
+			return err
+		}
+	}
+
+	now := time.Now().UnixNano()
 	for _, msg := range receivedMessages {
+		// Forged messages and messages of other chats are dropped, the others may still be valid
+		if !s.securityContext.ValidateSyncedMessage(message, msg) {
+			continue
+		}
+
+		// Expired and ephemeral messages are not accepted, otherwise purged content would come back
+		expired, err := isExpired(s.chatSettingsStorage, msg, now)
+		if err != nil {
+			fmt.Println("Error checking message expiry:", err)
+			return err
+		}
+		if expired || msg.Operation.IsEphemeral() {
+			continue
+		}
+
+		// Messages the retention policy of the chat pruned are not accepted either, the compactor would prune them again
+		pruned, err := s.retentionStorage.GetPrunedRange(msg.ChatID)
+		if err != nil {
+			fmt.Println("Error getting pruned range:", err)
+			return err
+		}
+		if isPruned(pruned, msg) {
+			continue
+		}
+
+		// Content of blocked peers is not accepted from other peers either.
+		// Their membership messages are kept, otherwise the members of the chat would differ between the peers.
+		if isExpiringOperation(msg.Operation) {
+			blocked, err := s.blockList.IsBlocked(p_model.OnionHost(msg.SenderAddress))
+			if err != nil {
+				fmt.Println("Error checking block list:", err)
+				return err
+			}
+			if blocked {
+				continue
+			}
+		}
+
 		// Store the message
 		err = s.networkMessageStorage.StoreMessage(msg)
 		if err != nil {
@@ -38,7 +122,142 @@ func (s *syncResponseHandler) HandleMessage(message network.Message) error {
 			return err
 		}
 		// TODO: This should be processed by the peer. Not just saved to the database.
+
+		switch msg.Operation {
+		case network.JOIN_CHAT, network.LEAVE_CHAT, network.REMOVE_MEMBER, network.BAN_MEMBER, network.SET_ROLE:
+			// The joins, removals and role changes may arrive in any order, derive the members and their roles from
+			// the whole history. The next messages are validated with the new members and roles.
+			err = RebuildMembership(s.chatActionStorage, msg.ChatID)
+			if err != nil {
+				fmt.Println("Error rebuilding chat membership:", err)
+				return err
+			}
+			if msg.Operation == network.BAN_MEMBER {
+				err = s.applyBan(msg)
+				if err != nil {
+					fmt.Println("Error applying ban:", err)
+					return err
+				}
+			}
+		case network.UPDATE_CHAT_SETTINGS:
+			err = s.applySettings(msg)
+			if err != nil {
+				fmt.Println("Error applying chat settings:", err)
+				return err
+			}
+		case network.UPDATE_CHAT_METADATA:
+			err = s.applyChatMetadata(msg)
+			if err != nil {
+				fmt.Println("Error applying chat metadata:", err)
+				return err
+			}
+		case network.INVITE_TO_CHAT:
+			err = s.applyInvitation(msg)
+			if err != nil {
+				fmt.Println("Error applying invitation:", err)
+				return err
+			}
+		case network.EDIT_MESSAGE, network.DELETE_MESSAGE:
+			err = s.applyMessageChange(msg)
+			if err != nil {
+				fmt.Println("Error applying message change:", err)
+				return err
+			}
+		case network.REACT:
+			err = s.applyReaction(msg)
+			if err != nil {
+				fmt.Println("Error applying reaction:", err)
+				return err
+			}
+		}
 	}
 
 	return nil
 }
+
+// applyBan adds the peer to the bans of the chat, so it can't join again on this peer either
+func (s *syncResponseHandler) applyBan(message network.Message) error {
+	var content struct {
+		PeerID string `json:"peerId"`
+	}
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		return err
+	}
+
+	return s.chatActionStorage.PeerBannedFromChat(message.Timestamp, content.PeerID, message.ChatID)
+}
+
+// applySettings applies synced chat settings, older settings than the stored ones are ignored
+func (s *syncResponseHandler) applySettings(message network.Message) error {
+	var settings p_model.ChatSettings
+	err := json.Unmarshal([]byte(message.Content), &settings)
+	if err != nil {
+		return err
+	}
+
+	_, err = applyChatSettings(s.chatSettingsStorage, message, settings)
+	return err
+}
+
+// applyChatMetadata applies a synced metadata update, every field keeps the newest update like for live updates
+func (s *syncResponseHandler) applyChatMetadata(message network.Message) error {
+	var update p_model.ChatMetadataUpdate
+	err := json.Unmarshal([]byte(message.Content), &update)
+	if err != nil {
+		return err
+	}
+
+	for field, value := range update.Fields() {
+		_, err = s.chatActionStorage.UpdateChatMetadata(message.Timestamp, message.Id, message.ChatID, field, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyInvitation remembers a synced invitation, so the join of the invited peer is accepted when it is synced too
+func (s *syncResponseHandler) applyInvitation(message network.Message) error {
+	var content struct {
+		ExpiresAt int64 `json:"expiresAt"`
+	}
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		return err
+	}
+
+	return s.chatInvitationStorage.PeerGotInvitedToChat(message.Id, content.ExpiresAt)
+}
+
+// applyMessageChange adds a synced edit to the edit history or leaves a tombstone for a synced deletion.
+// The edited message doesn't have to be stored yet, its content is removed when it arrives after the deletion.
+func (s *syncResponseHandler) applyMessageChange(message network.Message) error {
+	var content struct {
+		MessageID string `json:"messageId"`
+		Message   string `json:"message"`
+	}
+
+	err := json.Unmarshal([]byte(message.Content), &content)
+	if err != nil {
+		return err
+	}
+
+	if message.Operation == network.DELETE_MESSAGE {
+		return s.messageEditStorage.MessageDeleted(message.Timestamp, content.MessageID)
+	}
+	return s.messageEditStorage.MessageEdited(message.Timestamp, message.Id, content.MessageID, content.Message)
+}
+
+// applyReaction stores a synced reaction, reactions that are older than the stored one are ignored
+func (s *syncResponseHandler) applyReaction(message network.Message) error {
+	var reaction p_model.ReactionChange
+	err := json.Unmarshal([]byte(message.Content), &reaction)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.reactionStorage.PeerReacted(message.Timestamp, message.Id, reaction.MessageID, message.SenderID, reaction.Emoji, reaction.Add)
+	return err
+}
@@ -0,0 +1,106 @@
+package messageHandlers
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"sync"
+	"time"
+)
+
+const (
+	// typingSendInterval is how often a typing peer repeats TYPING_STARTED, so the indicator doesn't expire
+	typingSendInterval = 3 * time.Second
+	// typingRateLimit is the minimum time between two TYPING_STARTED messages of a peer, more frequent ones are dropped
+	typingRateLimit = time.Second
+	// typingTimeout is how long a peer is shown as typing without a new TYPING_STARTED message
+	typingTimeout = 2 * typingSendInterval
+)
+
+// typingKey identifies a peer typing in a chat
+type typingKey struct {
+	peerID string
+	chatID string
+}
+
+// typingState is the last accepted TYPING_STARTED of a peer and the timer that stops the indicator
+type typingState struct {
+	started time.Time
+	timer   *time.Timer
+}
+
+// A Peer starts or stops typing in a chat. The messages are ephemeral, so the handler keeps the state in memory.
+type typingHandler struct {
+	userChatLogic chat.ChatLogic
+	timeout       time.Duration
+	mutex         sync.Mutex
+	typing        map[typingKey]*typingState
+}
+
+func NewTypingHandler(userChatLogic chat.ChatLogic, timeout time.Duration) *typingHandler {
+	return &typingHandler{
+		userChatLogic: userChatLogic,
+		timeout:       timeout,
+		typing:        map[typingKey]*typingState{},
+	}
+}
+
+func (t *typingHandler) HandleMessage(message network.Message) error {
+
+	// TYPING_STARTED and TYPING_STOPPED messages have no content
+
+	key := typingKey{peerID: message.SenderID, chatID: message.ChatID}
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if message.Operation == network.TYPING_STOPPED {
+		if t.stop(key) {
+			t.userChatLogic.PeerTyping(message.SenderID, message.ChatID, false)
+		}
+		return nil
+	}
+
+	// Messages that were delayed longer than the indicator is shown are outdated
+	if now.Sub(time.Unix(0, message.Timestamp)) > t.timeout {
+		return nil
+	}
+
+	state, typing := t.typing[key]
+	if typing && now.Sub(state.started) < typingRateLimit {
+		return nil
+	}
+
+	if typing {
+		state.started = now
+		state.timer.Reset(t.timeout)
+		return nil
+	}
+
+	// Stop the indicator if the peer neither stops typing nor keeps typing
+	state = &typingState{started: now}
+	state.timer = time.AfterFunc(t.timeout, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		// the timer may have fired while a new TYPING_STARTED was handled
+		if t.typing[key] == state && time.Since(state.started) >= t.timeout && t.stop(key) {
+			t.userChatLogic.PeerTyping(key.peerID, key.chatID, false)
+		}
+	})
+	t.typing[key] = state
+	t.userChatLogic.PeerTyping(message.SenderID, message.ChatID, true)
+
+	return nil
+}
+
+// stop removes the typing state of the peer, false if the peer wasn't typing
+func (t *typingHandler) stop(key typingKey) bool {
+	state, typing := t.typing[key]
+	if !typing {
+		return false
+	}
+
+	state.timer.Stop()
+	delete(t.typing, key)
+	return true
+}
@@ -0,0 +1,58 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer changes the name, topic or description of a chat
+type updateChatMetadataHandler struct {
+	userChatLogic     chat.ChatLogic
+	chatActionStorage store.ChatActionStoragePort
+}
+
+func NewUpdateChatMetadataHandler(userChatLogic chat.ChatLogic, chatActionStorage store.ChatActionStoragePort) *updateChatMetadataHandler {
+	return &updateChatMetadataHandler{
+		userChatLogic:     userChatLogic,
+		chatActionStorage: chatActionStorage,
+	}
+}
+
+func (u *updateChatMetadataHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message (every field is optional, missing fields are not changed):
+	/*
+		{
+			"name": "chat_name",
+			"topic": "chat_topic",
+			"description": "chat_description",
+		}
+	*/
+
+	var update p_model.ChatMetadataUpdate
+	err := json.Unmarshal([]byte(message.Content), &update)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	// Every field is a last-writer-wins register, older updates that arrive late are ignored by the storage
+	for field, value := range update.Fields() {
+		updated, err := u.chatActionStorage.UpdateChatMetadata(message.Timestamp, message.Id, message.ChatID, field, value)
+		if err != nil {
+			fmt.Println("Error updating chat metadata")
+			return err
+		}
+
+		// Handle the changed metadata
+		if updated {
+			u.userChatLogic.ChatMetadataChanged(message.SenderID, message.ChatID, field, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package messageHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// A Peer changes the settings of a chat, e.g. turns on disappearing messages
+type updateChatSettingsHandler struct {
+	userChatLogic       chat.ChatLogic
+	chatSettingsStorage store.ChatSettingsStoragePort
+}
+
+func NewUpdateChatSettingsHandler(userChatLogic chat.ChatLogic, chatSettingsStorage store.ChatSettingsStoragePort) *updateChatSettingsHandler {
+	return &updateChatSettingsHandler{
+		userChatLogic:       userChatLogic,
+		chatSettingsStorage: chatSettingsStorage,
+	}
+}
+
+func (u *updateChatSettingsHandler) HandleMessage(message network.Message) error {
+
+	// Structure of the message (every field is optional, missing fields are not changed):
+	/*
+		{
+			"messageExpiry": 3600, (seconds, 0 turns disappearing messages off)
+		}
+	*/
+
+	var settings p_model.ChatSettings
+	err := json.Unmarshal([]byte(message.Content), &settings)
+	if err != nil {
+		fmt.Println("Error unmarshalling message content")
+		return err
+	}
+
+	updated, err := applyChatSettings(u.chatSettingsStorage, message, settings)
+	if err != nil {
+		fmt.Println("Error updating chat settings")
+		return err
+	}
+
+	// Handle the changed settings
+	if updated {
+		u.userChatLogic.ChatSettingsChanged(message.SenderID, message.ChatID, settings.Expiry())
+	}
+
+	return nil
+}
+
+// applyChatSettings stores the settings of the message, older settings that arrive late are ignored by the storage
+func applyChatSettings(chatSettingsStorage store.ChatSettingsStoragePort, message network.Message, settings p_model.ChatSettings) (bool, error) {
+	if settings.MessageExpiry == nil {
+		return false, nil
+	}
+
+	return chatSettingsStorage.SetMessageExpiry(message.Timestamp, message.Id, message.ChatID, settings.Expiry().Nanoseconds())
+}
@@ -1,14 +1,20 @@
 package p_service
 
 import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
 )
 
 type SecurityValidater interface {
 	ValidateOutgoingMessage(message network.Message) bool
 	ValidateIncomingMessage(message network.Message) bool
+	ValidateSyncedMessage(response network.Message, message network.Message) bool
+	ValidateMessageChange(message network.Message) bool
 	ValidatePeer(peer string) bool
+	Identity() *p_model.Identity
 }
 
 // SecurityContext is a service that provides security checks for the network
@@ -18,21 +24,40 @@ type SecurityContext struct {
 	store           store.ChatInvitationStoragePort
 	chatActionStore store.ChatActionStoragePort
 	displayStorage  store.DisplayStoragePort
+	blockList       store.BlockListStoragePort
+	identity        *p_model.Identity
 }
 
-func NewSecurityContext(displayStorage store.DisplayStoragePort, store store.ChatInvitationStoragePort, chatActionStore store.ChatActionStoragePort) *SecurityContext {
+func NewSecurityContext(displayStorage store.DisplayStoragePort, store store.ChatInvitationStoragePort, chatActionStore store.ChatActionStoragePort, blockList store.BlockListStoragePort) *SecurityContext {
 	return &SecurityContext{
 		store:           store,
 		chatActionStore: chatActionStore,
 		displayStorage:  displayStorage,
+		blockList:       blockList,
+		identity:        &p_model.Identity{},
 	}
 }
 
+// Identity returns the identity of this peer, it is set once the network connection is online
+func (s *SecurityContext) Identity() *p_model.Identity {
+	return s.identity
+}
+
+// ValidateOutgoingMessage checks that the message is not sent to a blocked peer
 func (s *SecurityContext) ValidateOutgoingMessage(message network.Message) bool {
-	return true
+	return s.ValidatePeer(message.ReceiverAddress)
 }
 
 func (s *SecurityContext) ValidateIncomingMessage(message network.Message) bool {
+	// Only this peer is "self", messages of other peers that claim to come from it are forged
+	if !message.Local && s.identity.IsSelf(message.SenderID) {
+		return false
+	}
+
+	if p_model.IsDirectChatID(message.ChatID) {
+		return s.validateDirectMessage(message)
+	}
+
 	switch message.Operation {
 	case network.SEND_MESSAGE:
 		return s.isMemberOfChat(message.SenderID, message.ChatID)
@@ -41,11 +66,11 @@ func (s *SecurityContext) ValidateIncomingMessage(message network.Message) bool
 	case network.SYNC_RESPONSE:
 		return s.validateSyncResponseMessages(message)
 	case network.JOIN_CHAT:
-		return s.hasValidInvitation(message.SenderID, message.ChatID)
+		return s.hasValidInvitation(message)
 	case network.LEAVE_CHAT:
 		return s.isMemberOfChat(message.SenderID, message.ChatID)
 	case network.INVITE_TO_CHAT:
-		return true
+		return s.mayInvite(message)
 	case network.SEND_FILE:
 		return s.isMemberOfChat(message.SenderID, message.ChatID)
 	case network.SET_USERNAME:
@@ -54,13 +79,70 @@ func (s *SecurityContext) ValidateIncomingMessage(message network.Message) bool
 		return true
 	case network.TEST_MESSAGE_2:
 		return true
+	case network.INVITE_RESPONSE:
+		return s.isValidInvitationResponse(message)
+	case network.SET_ROLE:
+		return s.isValidRoleChange(message)
+	case network.REMOVE_MEMBER, network.BAN_MEMBER:
+		return s.mayRemoveMember(message)
+	case network.UPDATE_CHAT_METADATA:
+		return s.isValidChatMetadataUpdate(message)
+	case network.EDIT_MESSAGE, network.DELETE_MESSAGE:
+		return s.isMemberOfChat(message.SenderID, message.ChatID) && s.isOwnMessage(message)
+	case network.REACT:
+		return s.isMemberOfChat(message.SenderID, message.ChatID) && s.isValidReaction(message)
+	case network.UPDATE_CHAT_SETTINGS:
+		return s.isValidChatSettingsUpdate(message)
+	case network.TYPING_STARTED, network.TYPING_STOPPED:
+		return s.isMemberOfChat(message.SenderID, message.ChatID)
+	case network.PRESENCE:
+		// The presence is kept for the address of the member, so only the member itself may send it
+		return s.isMemberOfChat(message.SenderID, message.ChatID) && message.SenderID == p_model.OnionHost(message.SenderAddress)
+	case network.NETWORK_ONLINE:
+		// Created by the network adapter once it is online, other peers must not change the address of this peer
+		return message.Local
+	case network.USER_OFFLINE:
+		// Created by the network adapter when a connection to the ReceiverAddress fails, other peers can't report it
+		return message.Local && message.ReceiverAddress != ""
 	default:
 		return false
 	}
 }
 
+// ValidateMessageChange checks an edit or deletion of this peer before it is stored and sent. The other peers reject
+// changes of messages that aren't its own, so they are refused here too, otherwise the histories would differ.
+func (s *SecurityContext) ValidateMessageChange(message network.Message) bool {
+	return s.identity.IsSelf(message.SenderID) && s.isOwnMessage(message)
+}
+
+// ValidateSyncedMessage checks a message of a SYNC_RESPONSE with the rules of ValidateIncomingMessage before it is applied.
+// The message has to belong to the synced chat. A peer that just joined learns the join of the creator only through sync,
+// so it is accepted without invitation.
+func (s *SecurityContext) ValidateSyncedMessage(response network.Message, message network.Message) bool {
+	if message.ChatID != response.ChatID || message.Local {
+		return false
+	}
+
+	switch message.Operation {
+	case network.SYNC_REQUEST, network.SYNC_RESPONSE, network.NETWORK_ONLINE, network.USER_OFFLINE:
+		return false
+	case network.JOIN_CHAT:
+		if !s.identity.IsSelf(message.SenderID) && !p_model.IsDirectChatID(message.ChatID) && s.isCreatorJoin(message) {
+			return true
+		}
+	}
+
+	return s.ValidateIncomingMessage(message)
+}
+
+// ValidatePeer checks that the peer with the address is not blocked, errors are treated as blocked
 func (s *SecurityContext) ValidatePeer(peer string) bool {
-	return true
+	blocked, err := s.blockList.IsBlocked(p_model.OnionHost(peer))
+	if err != nil {
+		return false
+	}
+
+	return !blocked
 }
 
 // Helper methods for security checks
@@ -79,14 +161,28 @@ func (s *SecurityContext) isMemberOfChat(peerID, chatID string) bool {
 	return false
 }
 
-func (s *SecurityContext) hasValidInvitation(peerID, chatID string) bool {
-	invitations, err := s.store.GetInvitations(peerID)
+// hasValidInvitation checks that the sender of a JOIN_CHAT message was invited to the chat,
+// either by an invitation message or by an invite token contained in the message.
+// Banned peers are always rejected.
+func (s *SecurityContext) hasValidInvitation(message network.Message) bool {
+	if s.isBanned(message.SenderID, message.ChatID) {
+		return false
+	}
+
+	var content struct {
+		InviteToken string `json:"inviteToken"`
+	}
+	if message.Content != "" && json.Unmarshal([]byte(message.Content), &content) == nil && content.InviteToken != "" {
+		return s.hasValidInviteToken(message, content.InviteToken)
+	}
+
+	invitations, err := s.store.GetInvitations(message.SenderID)
 	if err != nil {
 		return false
 	}
 
 	for _, invitation := range invitations {
-		if invitation == chatID {
+		if invitation == message.ChatID {
 			return true
 		}
 	}
@@ -94,7 +190,349 @@ func (s *SecurityContext) hasValidInvitation(peerID, chatID string) bool {
 	return false
 }
 
-func (s *SecurityContext) validateSyncResponseMessages(message network.Message) bool {
-	// TODO: implement
+// hasValidInviteToken checks that an invite token was issued for the chat by one of its admins,
+// has not expired and was not used by another peer before. The inviter is the peer whose onion address signed the token,
+// so nobody can issue a token in the name of an admin.
+func (s *SecurityContext) hasValidInviteToken(message network.Message, uri string) bool {
+	token, err := p_model.ParseInviteToken(uri)
+	if err != nil {
+		return false
+	}
+
+	if err := token.Verify(time.Now().UnixNano()); err != nil {
+		return false
+	}
+
+	if token.InviterID != p_model.OnionHost(token.InviterAddress) || token.ChatID != message.ChatID {
+		return false
+	}
+
+	if !s.hasRole(s.identity.LocalID(token.InviterID), token.ChatID, p_model.RequiredRoles[network.INVITE_TO_CHAT]) {
+		return false
+	}
+
+	usedBy, err := s.store.GetInviteTokenUser(token.Secret)
+	if err != nil {
+		return false
+	}
+
+	return usedBy == "" || usedBy == message.SenderID
+}
+
+// isCreatorJoin checks that the join can be the one of the creator: the chat has no owner yet and no join is older
+func (s *SecurityContext) isCreatorJoin(message network.Message) bool {
+	if s.isBanned(message.SenderID, message.ChatID) || s.hasOwner(message.ChatID) {
+		return false
+	}
+
+	messages, err := s.chatActionStore.GetMembershipMessages(message.ChatID)
+	if err != nil {
+		return false
+	}
+
+	for _, stored := range messages {
+		if stored.Operation != network.JOIN_CHAT || stored.Id == message.Id {
+			continue
+		}
+		if stored.Timestamp < message.Timestamp || (stored.Timestamp == message.Timestamp && stored.Id < message.Id) {
+			return false
+		}
+	}
+
 	return true
 }
+
+// isBanned checks if the peer was banned from the chat, errors are treated as banned
+func (s *SecurityContext) isBanned(peerID, chatID string) bool {
+	banned, err := s.chatActionStore.IsBannedFromChat(peerID, chatID)
+	if err != nil {
+		return true
+	}
+
+	return banned
+}
+
+// hasRole checks that the peer is a member of the chat with at least the given role
+func (s *SecurityContext) hasRole(peerID, chatID string, role store.ChatRole) bool {
+	memberRole, err := s.chatActionStore.GetPeerRole(peerID, chatID)
+	if err != nil {
+		return false
+	}
+
+	return memberRole >= role
+}
+
+// mayInvite checks that the sender of an invitation is an admin and that the invited peer is not banned.
+// Peers that are not part of the chat yet (the invitee) can't know the roles and bans and accept every invitation.
+func (s *SecurityContext) mayInvite(message network.Message) bool {
+	members, err := s.displayStorage.GetUsersInChat(message.ChatID)
+	if err != nil {
+		return false
+	}
+
+	if len(members) == 0 {
+		return true
+	}
+
+	if s.isBanned(message.ReceiverID, message.ChatID) {
+		return false
+	}
+
+	return s.hasRole(message.SenderID, message.ChatID, p_model.RequiredRoles[network.INVITE_TO_CHAT])
+}
+
+// mayRemoveMember checks that the sender may kick or ban the peer. Admins can only remove peers with a lower role.
+// Peers that are not members can't be kicked, but they can be banned to prevent them from joining.
+func (s *SecurityContext) mayRemoveMember(message network.Message) bool {
+	var content struct {
+		PeerID string `json:"peerId"`
+	}
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil || content.PeerID == "" {
+		return false
+	}
+
+	senderRole, err := s.chatActionStore.GetPeerRole(message.SenderID, message.ChatID)
+	if err != nil || senderRole < p_model.RequiredRoles[message.Operation] {
+		return false
+	}
+
+	peerRole, err := s.chatActionStore.GetPeerRole(content.PeerID, message.ChatID)
+	if err != nil {
+		return message.Operation == network.BAN_MEMBER
+	}
+
+	return senderRole > peerRole
+}
+
+// isValidRoleChange checks that only owners change roles, and only those of chat members.
+// A member may claim ownership of a chat that has no owner yet, this is how the creator of a chat becomes its owner.
+// The other peers learn the claim with the real id of the creator through sync, so they see an owner as well.
+func (s *SecurityContext) isValidRoleChange(message network.Message) bool {
+	var content struct {
+		PeerID string         `json:"peerId"`
+		Role   store.ChatRole `json:"role"`
+	}
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		return false
+	}
+
+	if content.Role < store.ROLE_MEMBER || content.Role > store.ROLE_OWNER {
+		return false
+	}
+
+	if !s.isMemberOfChat(content.PeerID, message.ChatID) {
+		return false
+	}
+
+	if s.hasRole(message.SenderID, message.ChatID, p_model.RequiredRoles[network.SET_ROLE]) {
+		return true
+	}
+
+	return content.PeerID == message.SenderID && content.Role == store.ROLE_OWNER && !s.hasOwner(message.ChatID)
+}
+
+// hasOwner checks if any member of the chat is an owner
+func (s *SecurityContext) hasOwner(chatID string) bool {
+	members, err := s.displayStorage.GetUsersInChat(chatID)
+	if err != nil {
+		return false
+	}
+
+	for _, member := range members {
+		if member.Role == store.ROLE_OWNER {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValidInvitationResponse checks that a pending invitation is only accepted or declined by the invitee
+// and only revoked by the inviter.
+func (s *SecurityContext) isValidInvitationResponse(message network.Message) bool {
+	var content struct {
+		InvitationID string                 `json:"invitationId"`
+		Status       store.InvitationStatus `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		return false
+	}
+
+	invitation, err := s.store.GetInvitation(content.InvitationID)
+	if err != nil {
+		return false
+	}
+
+	if invitation.ChatId != message.ChatID || invitation.Status != store.INVITATION_PENDING {
+		return false
+	}
+
+	switch content.Status {
+	case store.INVITATION_ACCEPTED, store.INVITATION_DECLINED:
+		return message.SenderID == invitation.InviteeId
+	case store.INVITATION_REVOKED:
+		return message.SenderID == invitation.InviterId
+	default:
+		return false
+	}
+}
+
+// isValidChatMetadataUpdate checks that the sender may change the chat metadata and that the new values are valid
+func (s *SecurityContext) isValidChatMetadataUpdate(message network.Message) bool {
+	var update p_model.ChatMetadataUpdate
+	if err := json.Unmarshal([]byte(message.Content), &update); err != nil {
+		return false
+	}
+
+	if update.Validate() != nil {
+		return false
+	}
+
+	return s.hasRole(message.SenderID, message.ChatID, p_model.RequiredRoles[network.UPDATE_CHAT_METADATA])
+}
+
+// isOwnMessage checks that an edit or deletion refers to a message the sender sent to the same chat
+// and that the message was not deleted before.
+func (s *SecurityContext) isOwnMessage(message network.Message) bool {
+	var content struct {
+		MessageID string `json:"messageId"`
+	}
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil || content.MessageID == "" {
+		return false
+	}
+
+	messages, err := s.displayStorage.GetChatMessages(message.ChatID)
+	if err != nil {
+		return false
+	}
+
+	isOwn := false
+	for _, chatMessage := range messages {
+		switch {
+		case chatMessage.Id == content.MessageID:
+			// Own messages are stored as "self", synced ones by the id of this peer
+			isOwn = chatMessage.Operation == network.SEND_MESSAGE && s.identity.LocalID(chatMessage.SenderID) == s.identity.LocalID(message.SenderID)
+		case chatMessage.Operation == network.DELETE_MESSAGE && chatMessage.Id != message.Id:
+			var deletion struct {
+				MessageID string `json:"messageId"`
+			}
+			if json.Unmarshal([]byte(chatMessage.Content), &deletion) == nil && deletion.MessageID == content.MessageID {
+				return false
+			}
+		}
+	}
+
+	return isOwn
+}
+
+// isValidChatSettingsUpdate checks that the sender may change the chat settings and that the new settings are valid
+func (s *SecurityContext) isValidChatSettingsUpdate(message network.Message) bool {
+	var settings p_model.ChatSettings
+	if err := json.Unmarshal([]byte(message.Content), &settings); err != nil {
+		return false
+	}
+
+	if settings.Validate() != nil {
+		return false
+	}
+
+	return s.hasRole(message.SenderID, message.ChatID, p_model.RequiredRoles[network.UPDATE_CHAT_SETTINGS])
+}
+
+// isValidReaction checks that the reaction is a short emoji and that it refers to a message of the same chat.
+// Reactions are stored by the id of the message, so a reaction to a message of another chat would show up there.
+// Reactions that arrive before their message are dropped, sync delivers them again after the message.
+func (s *SecurityContext) isValidReaction(message network.Message) bool {
+	var reaction p_model.ReactionChange
+	if err := json.Unmarshal([]byte(message.Content), &reaction); err != nil {
+		return false
+	}
+
+	if reaction.Validate() != nil {
+		return false
+	}
+
+	messages, err := s.displayStorage.GetChatMessages(message.ChatID)
+	if err != nil {
+		return false
+	}
+
+	for _, chatMessage := range messages {
+		if chatMessage.Id == reaction.MessageID {
+			return chatMessage.Operation == network.SEND_MESSAGE || chatMessage.Operation == network.SEND_FILE
+		}
+	}
+	return false
+}
+
+// validateDirectMessage checks messages of direct chats. Both peers are the only members of a direct chat,
+// so it has no invitations, roles or settings. The first message creates the chat.
+func (s *SecurityContext) validateDirectMessage(message network.Message) bool {
+	switch message.Operation {
+	case network.SEND_MESSAGE, network.SEND_FILE:
+		return s.isDirectPeer(message)
+	case network.SYNC_REQUEST, network.SET_USERNAME, network.TYPING_STARTED, network.TYPING_STOPPED, network.PRESENCE:
+		return s.isMemberOfChat(message.SenderID, message.ChatID)
+	case network.EDIT_MESSAGE, network.DELETE_MESSAGE:
+		return s.isMemberOfChat(message.SenderID, message.ChatID) && s.isOwnMessage(message)
+	case network.REACT:
+		return s.isMemberOfChat(message.SenderID, message.ChatID) && s.isValidReaction(message)
+	case network.SYNC_RESPONSE:
+		return s.validateSyncResponseMessages(message)
+	default:
+		return false
+	}
+}
+
+// isDirectPeer checks that the message comes from the other peer of the direct chat. Once the chat exists only its peer
+// is accepted. Before, the chat has to belong to this peer and the address of the connection the message came from,
+// and the sender has to be the peer with that address.
+func (s *SecurityContext) isDirectPeer(message network.Message) bool {
+	chats, err := s.displayStorage.GetChats()
+	if err != nil {
+		return false
+	}
+
+	for _, chat := range chats {
+		if chat.ChatId == message.ChatID && chat.DirectPeerId != "" {
+			return message.SenderID == chat.DirectPeerId
+		}
+	}
+
+	address := s.identity.Address()
+	if address == "" || message.SenderID != p_model.OnionHost(message.SenderAddress) {
+		return false
+	}
+
+	return p_model.DirectChatID(message.SenderAddress, address) == message.ChatID
+}
+
+// validateSyncResponseMessages checks that the sync response contains messages and comes from a member of the chat.
+// A peer that just joined knows no other member yet and accepts the response of any peer.
+// The synced messages are checked one by one with ValidateSyncedMessage when they are applied,
+// because whether they are valid depends on the messages before them.
+func (s *SecurityContext) validateSyncResponseMessages(message network.Message) bool {
+	var messages []network.Message
+	if err := json.Unmarshal([]byte(message.Content), &messages); err != nil {
+		return false
+	}
+
+	members, err := s.displayStorage.GetUsersInChat(message.ChatID)
+	if err != nil {
+		return false
+	}
+
+	isMember, knowsOthers := false, false
+	for _, member := range members {
+		switch {
+		case member.UserId == message.SenderID:
+			return true
+		case s.identity.IsSelf(member.UserId):
+			isMember = true
+		default:
+			knowsOthers = true
+		}
+	}
+
+	return isMember && !knowsOthers
+}
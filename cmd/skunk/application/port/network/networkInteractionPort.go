@@ -1,23 +1,50 @@
 package network
 
+import "crypto/ed25519"
+
 // OperationType represents the type of operation performed in a network message.
 type OperationType int
 
 const (
-	SEND_MESSAGE   OperationType = iota
-	SYNC_REQUEST   OperationType = iota
-	SYNC_RESPONSE  OperationType = iota
-	JOIN_CHAT      OperationType = iota
-	LEAVE_CHAT     OperationType = iota
-	INVITE_TO_CHAT OperationType = iota
-	SEND_FILE      OperationType = iota
-	SET_USERNAME   OperationType = iota
-	USER_OFFLINE   OperationType = iota
-	NETWORK_ONLINE OperationType = iota
-	TEST_MESSAGE   OperationType = iota
-	TEST_MESSAGE_2 OperationType = iota
+	SEND_MESSAGE         OperationType = iota
+	SYNC_REQUEST         OperationType = iota
+	SYNC_RESPONSE        OperationType = iota
+	JOIN_CHAT            OperationType = iota
+	LEAVE_CHAT           OperationType = iota
+	INVITE_TO_CHAT       OperationType = iota
+	SEND_FILE            OperationType = iota
+	SET_USERNAME         OperationType = iota
+	USER_OFFLINE         OperationType = iota
+	NETWORK_ONLINE       OperationType = iota
+	TEST_MESSAGE         OperationType = iota
+	TEST_MESSAGE_2       OperationType = iota
+	INVITE_RESPONSE      OperationType = iota
+	SET_ROLE             OperationType = iota
+	REMOVE_MEMBER        OperationType = iota
+	BAN_MEMBER           OperationType = iota
+	UPDATE_CHAT_METADATA OperationType = iota
+	EDIT_MESSAGE         OperationType = iota
+	DELETE_MESSAGE       OperationType = iota
+	REACT                OperationType = iota
+	UPDATE_CHAT_SETTINGS OperationType = iota
+	TYPING_STARTED       OperationType = iota
+	TYPING_STOPPED       OperationType = iota
+	PRESENCE             OperationType = iota
 )
 
+// ephemeralOperations are only delivered to peers that are online, they are neither stored nor synced.
+var ephemeralOperations = map[OperationType]bool{
+	USER_OFFLINE:   true,
+	TYPING_STARTED: true,
+	TYPING_STOPPED: true,
+	PRESENCE:       true,
+}
+
+// IsEphemeral checks if messages with the operation must not be stored or synced.
+func (o OperationType) IsEphemeral() bool {
+	return ephemeralOperations[o]
+}
+
 // Message represents a network message exchanged between peers.
 type Message struct {
 	Id              string
@@ -29,6 +56,7 @@ type Message struct {
 	ReceiverAddress string
 	ChatID          string
 	Operation       OperationType
+	Local           bool `json:"-"` // created by the network connection of this peer, peers can't set it
 }
 
 // NetworkObserver is an interface that defines the contract for observing network events.
@@ -37,6 +65,18 @@ type NetworkObserver interface {
 	Notify(message Message) error
 }
 
+// PeerValidator is implemented by observers that decide which peers the network connection may connect to.
+// Connections to and from peers it rejects are refused.
+type PeerValidator interface {
+	ValidatePeer(address string) bool
+}
+
+// NetworkIdentity is implemented by network connections that know the private key of the onion address of this peer.
+// The key signs what only this peer may issue, like invite tokens.
+type NetworkIdentity interface {
+	IdentityKey() ed25519.PrivateKey // nil while the connection is offline
+}
+
 // NetworkConnection is an interface that defines the contract for a network connection.
 // It provides methods for subscribing/unsubscribing observers and sending messages to network peers.
 type NetworkConnection interface {
@@ -1,15 +1,44 @@
 package store
 
-import "github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+import (
+	"errors"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+)
 
 type UserMessageStoragePort interface {
 	PeerSetUsername(peerId string, chatId string, username string) error
 }
 
+// ChatRole is the role of a member in a chat. Higher roles include the permissions of lower roles.
+type ChatRole int
+
+const (
+	ROLE_MEMBER ChatRole = iota
+	ROLE_ADMIN  ChatRole = iota
+	ROLE_OWNER  ChatRole = iota
+)
+
+// ChatMetadataField is a field of the chat metadata that can be changed after the chat was created.
+type ChatMetadataField int
+
+const (
+	CHAT_NAME        ChatMetadataField = iota
+	CHAT_TOPIC       ChatMetadataField = iota
+	CHAT_DESCRIPTION ChatMetadataField = iota
+)
+
 type ChatActionStoragePort interface {
 	PeerJoinedChat(timestamp int64, peerId string, chatId string) error
 	PeerLeftChat(peerId string, chatId string) error
 	ChatCreated(chatName string, chatId string) error // Ensure this line exists
+	// DirectChatCreated creates the direct chat with the peer, this peer and the other peer are its only members
+	DirectChatCreated(timestamp int64, chatId string, peerId string, peerAddress string) error
+	SetPeerRole(peerId string, chatId string, role ChatRole) error
+	GetPeerRole(peerId string, chatId string) (ChatRole, error)
+	PeerBannedFromChat(timestamp int64, peerId string, chatId string) error
+	IsBannedFromChat(peerId string, chatId string) (bool, error)
+	GetMembershipMessages(chatId string) ([]network.Message, error)                                                           // JOIN_CHAT, LEAVE_CHAT, REMOVE_MEMBER, BAN_MEMBER and SET_ROLE messages
+	UpdateChatMetadata(timestamp int64, messageId string, chatId string, field ChatMetadataField, value string) (bool, error) // false if a newer update exists
 }
 
 type PublicKeyAddress struct {
@@ -17,10 +46,38 @@ type PublicKeyAddress struct {
 	PublicKey string
 }
 
+// InvitationStatus represents the state of a chat invitation.
+// Invitations start as pending and can move to exactly one of the other states.
+type InvitationStatus int
+
+const (
+	INVITATION_PENDING  InvitationStatus = iota
+	INVITATION_ACCEPTED InvitationStatus = iota
+	INVITATION_DECLINED InvitationStatus = iota
+	INVITATION_EXPIRED  InvitationStatus = iota
+	INVITATION_REVOKED  InvitationStatus = iota
+)
+
+// Invitation is an invitation to a chat, identified by the id of the INVITE_TO_CHAT message.
+type Invitation struct {
+	MessageId      string
+	ChatId         string
+	InviterId      string
+	InviterAddress string
+	InviteeId      string
+	InviteeAddress string
+	Status         InvitationStatus
+	ExpiresAt      int64 // 0 if the invitation never expires
+}
+
 type ChatInvitationStoragePort interface {
 	InvitedToChat(messageID string, peers []PublicKeyAddress) error
-	PeerGotInvitedToChat(peerId string, chatId string) error
+	PeerGotInvitedToChat(messageID string, expiresAt int64) error
 	GetInvitations(peerId string) ([]string, error)
+	GetInvitation(messageID string) (Invitation, error)
+	SetInvitationStatus(messageID string, status InvitationStatus) error
+	InviteTokenUsed(secret string, peerId string) error
+	GetInviteTokenUser(secret string) (string, error)
 }
 
 type SyncStoragePort interface {
@@ -33,6 +90,123 @@ type NetworkMessageStoragePort interface {
 	RetrieveMessage(messageId string) (network.Message, error)
 }
 
+// MessageEdit is a version of an edited message, identified by the id of the EDIT_MESSAGE message
+type MessageEdit struct {
+	EditId    string
+	Content   string
+	Timestamp int64
+}
+
+// MessageEditStoragePort keeps the edit history of messages and tombstones of deleted messages.
+// The content of a deleted message and of all its edits is removed, the messages themselves are kept.
+type MessageEditStoragePort interface {
+	MessageEdited(timestamp int64, editId string, messageId string, content string) error
+	MessageDeleted(timestamp int64, messageId string) error
+	GetMessageEdits(messageId string) ([]MessageEdit, error) // oldest edit first
+	IsMessageDeleted(messageId string) (bool, error)
+}
+
+// Reaction is the number of peers that reacted to a message with the emoji
+type Reaction struct {
+	Emoji string
+	Count int
+}
+
+// ReactionStoragePort stores the reactions of peers to messages. Every peer can react once with each emoji,
+// the newest add or remove wins, so reactions converge no matter in which order they arrive.
+type ReactionStoragePort interface {
+	PeerReacted(timestamp int64, reactionId string, messageId string, peerId string, emoji string, add bool) (bool, error) // false if a newer reaction exists
+	GetReactions(messageId string) ([]Reaction, error)                                                                     // most used emoji first
+}
+
+// ChatSettingsStoragePort stores the settings of a chat that every peer has to enforce.
+// The message expiry is given in nanoseconds, 0 if the messages of the chat don't disappear.
+type ChatSettingsStoragePort interface {
+	SetMessageExpiry(timestamp int64, messageId string, chatId string, expiry int64) (bool, error) // false if a newer setting exists
+	GetMessageExpiry(chatId string) (int64, error)
+	GetMessageExpiries() (map[string]int64, error)                                                            // only chats with disappearing messages
+	PurgeMessages(chatId string, before int64, operations []network.OperationType) ([]network.Message, error) // returns the removed messages
+}
+
+// RetentionPolicy limits how much of a chat is kept on this peer, limits that are 0 are off.
+// Unlike the message expiry it is a local setting, the other peers keep their messages.
+type RetentionPolicy struct {
+	MaxAge            int64 // in nanoseconds, older messages are pruned
+	MaxMessages       int   // only the newest messages and files are kept
+	MaxAttachmentSize int64 // in bytes, the oldest files are pruned once all files of the chat are larger
+}
+
+// PrunedRange tells which messages of a chat were pruned by its retention policy.
+// They are neither requested nor accepted again when the chat is synced.
+type PrunedRange struct {
+	Before      int64 // messages older than Before were pruned
+	FilesBefore int64 // files older than FilesBefore were pruned as well, to keep the attachments of the chat small
+}
+
+// RetentionStoragePort stores the retention policies of the chats and the ranges they pruned so far
+type RetentionStoragePort interface {
+	SetRetentionPolicy(chatId string, policy RetentionPolicy) error // a policy without limits removes the policy of the chat
+	GetRetentionPolicy(chatId string) (RetentionPolicy, error)
+	GetRetentionPolicies() (map[string]RetentionPolicy, error) // only chats with a policy
+	MessagesPruned(chatId string, pruned PrunedRange) error    // extends the pruned range of the chat, it never shrinks
+	GetPrunedRange(chatId string) (PrunedRange, error)
+	RemoveOrphanedPeers() (int, error) // removes peers and invited peers nothing refers to anymore, returns how many
+}
+
+// Presence is the last known state of a peer, identified by its address
+type Presence struct {
+	Address  string
+	Online   bool  // false once the peer went offline or a connection to it failed
+	LastSeen int64 // 0 if the peer was never seen
+}
+
+// PresenceStoragePort stores when peers were last seen online
+type PresenceStoragePort interface {
+	PeerSeen(address string, timestamp int64) error
+	PeerOffline(address string) error
+	GetPresence(address string) (Presence, error) // peers that were never seen are offline
+}
+
+// Contact is an entry of the address book of the user, identified by its onion address
+type Contact struct {
+	Address   string
+	Nickname  string
+	PublicKey string // hex encoded ed25519 key of the onion address
+	Verified  bool   // the user compared the public key with the peer outside of Skunk
+	Notes     string
+	Blocked   bool // the address is on the block list
+}
+
+// ContactStoragePort stores the address book of the user
+type ContactStoragePort interface {
+	SaveContact(contact Contact) error // adds the contact or replaces the contact with the same address
+	RemoveContact(address string) error
+	GetContact(address string) (Contact, error)
+	GetContacts() ([]Contact, error) // sorted by nickname
+}
+
+// BlockListStoragePort stores the addresses of blocked peers. Their connections are refused and their messages dropped.
+type BlockListStoragePort interface {
+	BlockPeer(timestamp int64, address string) error
+	UnblockPeer(address string) error
+	IsBlocked(address string) (bool, error)
+	GetBlockedPeers() ([]string, error) // the peer blocked first comes first
+}
+
+var (
+	ErrDatabaseLocked  = errors.New("the database is locked, unlock it with the passphrase")
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// EncryptionStoragePort encrypts the messages and other sensitive data at rest with a key derived from a passphrase.
+// An encrypted database is locked until it is unlocked with the passphrase, locked databases return ErrDatabaseLocked.
+type EncryptionStoragePort interface {
+	IsEncrypted() (bool, error)
+	SetPassphrase(passphrase string) error // encrypts a database that isn't encrypted yet
+	Unlock(passphrase string) error        // ErrWrongPassphrase if the passphrase doesn't match
+	ChangePassphrase(oldPassphrase, newPassphrase string) error
+}
+
 type ChatMessage struct {
 	Username  string
 	Content   string
@@ -41,13 +215,41 @@ type ChatMessage struct {
 }
 
 type Chat struct {
-	ChatId   string
-	ChatName string
+	ChatId            string
+	ChatName          string
+	Topic             string
+	Description       string
+	DirectPeerId      string // the other peer of a direct chat, empty for group chats
+	DirectPeerAddress string
 }
 
 type User struct {
 	UserId   string
 	Username string
+	Role     ChatRole
+}
+
+// SearchQuery finds the SEND_MESSAGE messages that contain all words of Text, edited messages are found by their newest text
+type SearchQuery struct {
+	Text     string
+	ChatID   string // empty to search all chats
+	SenderID string // empty to search the messages of all senders
+	Limit    int    // 0 for all results
+}
+
+// SearchResult is a message that matched a search, the snippet is the part of its text with the matching words in [brackets]
+type SearchResult struct {
+	Message network.Message
+	Snippet string
+}
+
+// MessagePage selects the messages of a chat that are older than Before and newer than After, both are message ids.
+// Without After the newest matching messages are returned, otherwise the oldest ones.
+type MessagePage struct {
+	ChatID string
+	Before string // empty for no upper bound
+	After  string // empty for no lower bound
+	Limit  int    // 0 for all messages
 }
 
 type DisplayStoragePort interface {
@@ -55,7 +257,10 @@ type DisplayStoragePort interface {
 	GetUsername(peerID, chatID string) (string, error)
 	GetUsersInChat(chatID string) ([]User, error)
 	GetPeers() ([]string, error)
-	GetChatMessages(chatID string) ([]network.Message, error)
+	GetChatMessages(chatID string) ([]network.Message, error)       // oldest message first
+	GetChatMessagePage(page MessagePage) ([]network.Message, error) // oldest message first
+	GetReplies(messageID string) ([]network.Message, error)         // oldest reply first, the parent doesn't have to be stored yet
+	SearchMessages(query SearchQuery) ([]SearchResult, error)       // newest message first
 }
 
 type Storage interface {
@@ -65,4 +270,12 @@ type Storage interface {
 	SyncStoragePort
 	NetworkMessageStoragePort
 	DisplayStoragePort
+	MessageEditStoragePort
+	ReactionStoragePort
+	ChatSettingsStoragePort
+	RetentionStoragePort
+	PresenceStoragePort
+	ContactStoragePort
+	BlockListStoragePort
+	EncryptionStoragePort
 }
@@ -0,0 +1,615 @@
+// Package backup exports a profile into a single encrypted archive and imports it again, to move an identity
+// to another machine or to recover it after a disk loss.
+//
+// The archive starts with a header of the format version and the key derivation parameters, followed by the
+// AES-256-GCM encrypted tar of the profile. The header is authenticated with the content, so any change to the
+// archive is detected when it is opened. The tar contains a manifest with the SHA-256 hash of every file.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/tor"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/node"
+	"github.com/scherzma/Skunk/cmd/skunk/util"
+)
+
+// FormatVersion is the newest version of the archive format, archives with a higher version can't be imported
+const FormatVersion = 1
+
+// magic starts every archive
+const magic = "SKUNKBAK"
+
+// headerLength is the length of magic, version, salt, time, memory and threads
+const headerLength = len(magic) + 2 + util.KdfSaltLength + 4 + 4 + 1
+
+// Paths of the profile in the archive
+const (
+	manifestPath = "manifest.json"
+	identityPath = "identity/" + IdentityKeyFile
+	databasePath = "database/skunk.db"
+	filesPrefix  = "files/"
+)
+
+// IdentityKeyFile is the file of the Tor data directory that contains the key of the onion address
+const IdentityKeyFile = tor.PrivateKeyFile
+
+// DefaultTorDataDir is the Tor data directory of a profile if neither the profile nor SKUNK_TOR_DATA_DIR names one
+const DefaultTorDataDir = tor.DefaultDataDir
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase or damaged backup")
+	ErrNotABackup      = errors.New("the file is no Skunk backup")
+	ErrOtherIdentity   = errors.New("the backup belongs to another identity, it can only replace this profile")
+)
+
+// Profile is where the data of a peer is stored
+type Profile struct {
+	DatabasePath string // SQLite database with the chats, messages and contacts
+	FileDir      string // received files
+	TorDataDir   string // contains the identity key
+}
+
+// DefaultProfile returns the profile at the default paths. The Tor data directory is the one the node uses,
+// SKUNK_TOR_DATA_DIR moves it.
+func DefaultProfile() Profile {
+	torDataDir := DefaultTorDataDir
+	if value, ok := os.LookupEnv("SKUNK_TOR_DATA_DIR"); ok && value != "" {
+		torDataDir = value
+	}
+	return Profile{DatabasePath: node.DefaultDatabasePath, FileDir: node.DefaultFileDir, TorDataDir: torDataDir}
+}
+
+// ImportMode decides what happens to the data that is already in the profile
+type ImportMode int
+
+const (
+	MERGE   ImportMode = iota // adds the chats, messages, contacts and files of the backup, the profile keeps its own data
+	REPLACE ImportMode = iota // the profile is exactly the backup afterwards
+)
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	Mode ImportMode
+	// DatabasePassphrase unlocks the encrypted databases of the profile and the backup when they are merged
+	DatabasePassphrase string
+}
+
+// Manifest describes the content of an archive
+type Manifest struct {
+	Version int            `json:"version"`
+	Created int64          `json:"created"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// HasIdentity checks if the archive contains the identity key
+func (m Manifest) HasIdentity() bool {
+	for _, file := range m.Files {
+		if file.Path == identityPath {
+			return true
+		}
+	}
+	return false
+}
+
+// ManifestFile is a file of the archive with its hash
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Export writes the identity key, the database and the received files of the profile as an archive encrypted
+// with the passphrase. Missing parts of the profile are left out. The contacts are part of the database.
+func Export(profile Profile, passphrase string, w io.Writer) (Manifest, error) {
+	profile = withDefaults(profile)
+	manifest := Manifest{Version: FormatVersion, Created: time.Now().Unix()}
+	files := map[string][]byte{}
+
+	add := func(archivePath string, data []byte) {
+		hash := sha256.Sum256(data)
+		files[archivePath] = data
+		manifest.Files = append(manifest.Files, ManifestFile{Path: archivePath, Size: int64(len(data)), SHA256: hex.EncodeToString(hash[:])})
+	}
+
+	key, err := os.ReadFile(filepath.Join(profile.TorDataDir, IdentityKeyFile))
+	if err == nil {
+		add(identityPath, key)
+	} else if !os.IsNotExist(err) {
+		return Manifest{}, err
+	}
+
+	database, err := snapshotDatabase(profile.DatabasePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if database != nil {
+		add(databasePath, database)
+	}
+
+	err = filepath.WalkDir(profile.FileDir, func(filePath string, entry os.DirEntry, err error) error {
+		if os.IsNotExist(err) && filePath == profile.FileDir {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(profile.FileDir, filePath)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		add(filesPrefix+filepath.ToSlash(relative), data)
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if len(manifest.Files) == 0 {
+		return Manifest{}, errors.New("the profile is empty, there is nothing to back up")
+	}
+
+	content, err := pack(manifest, files)
+	if err != nil {
+		return Manifest{}, err
+	}
+	sealed, err := seal(passphrase, content)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	_, err = w.Write(sealed)
+	return manifest, err
+}
+
+// snapshotDatabase returns a consistent copy of the database, nil if there is none
+func snapshotDatabase(databasePath string) ([]byte, error) {
+	if _, err := os.Stat(databasePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(databasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer adapter.Close()
+
+	dir, err := os.MkdirTemp("", "skunk-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, "skunk.db")
+	err = adapter.Snapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(snapshot)
+}
+
+// Import restores the archive into the profile. Nothing is changed before the whole archive was decrypted and verified.
+func Import(profile Profile, passphrase string, r io.Reader, options ImportOptions) (Manifest, error) {
+	profile = withDefaults(profile)
+
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return Manifest{}, err
+	}
+	content, err := open(passphrase, sealed)
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest, files, err := unpack(content)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	// The database is checked in a temporary directory next to the profile, so it can be moved into place
+	err = os.MkdirAll(filepath.Dir(profile.DatabasePath), 0700)
+	if err != nil {
+		return Manifest{}, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(profile.DatabasePath), ".skunk-import-")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	var importedDatabase string
+	if data, ok := files[databasePath]; ok {
+		importedDatabase = filepath.Join(dir, "skunk.db")
+		err = os.WriteFile(importedDatabase, data, 0600)
+		if err != nil {
+			return Manifest{}, err
+		}
+		// Databases of newer versions are refused here, older ones are upgraded
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(importedDatabase)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("the database of the backup can't be used: %w", err)
+		}
+		adapter.Close()
+	}
+
+	if options.Mode == REPLACE {
+		return manifest, replaceProfile(profile, files, importedDatabase)
+	}
+	return manifest, mergeProfile(profile, files, importedDatabase, options.DatabasePassphrase)
+}
+
+// replaceProfile removes the profile and moves the content of the archive in its place
+func replaceProfile(profile Profile, files map[string][]byte, importedDatabase string) error {
+	keyPath := filepath.Join(profile.TorDataDir, IdentityKeyFile)
+	if key, ok := files[identityPath]; ok {
+		err := writeIdentityKey(keyPath, key)
+		if err != nil {
+			return err
+		}
+	} else if err := os.Remove(keyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err := os.Remove(profile.DatabasePath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if importedDatabase != "" {
+		err := os.Rename(importedDatabase, profile.DatabasePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.RemoveAll(profile.FileDir)
+	if err != nil {
+		return err
+	}
+	for _, archivePath := range sortedFiles(files) {
+		err = os.MkdirAll(filepath.Dir(storedFilePath(profile, archivePath)), 0700)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(storedFilePath(profile, archivePath), files[archivePath], 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeProfile adds the content of the archive to the profile. The identity of the profile can't change,
+// a backup of another identity is refused before anything is merged.
+func mergeProfile(profile Profile, files map[string][]byte, importedDatabase, databasePassphrase string) error {
+	keyPath := filepath.Join(profile.TorDataDir, IdentityKeyFile)
+	key, hasKey := files[identityPath]
+	if hasKey {
+		existing, err := os.ReadFile(keyPath)
+		if err == nil && !bytes.Equal(existing, key) {
+			return ErrOtherIdentity
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if importedDatabase != "" {
+		err := mergeDatabase(profile.DatabasePath, importedDatabase, databasePassphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasKey {
+		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+			err = writeIdentityKey(keyPath, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, archivePath := range sortedFiles(files) {
+		err := mergeFile(storedFilePath(profile, archivePath), files[archivePath])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeDatabase merges the imported database into the database of the profile, or moves it there if the profile has none
+func mergeDatabase(databasePath, importedDatabase, passphrase string) error {
+	if _, err := os.Stat(databasePath); os.IsNotExist(err) {
+		return os.Rename(importedDatabase, databasePath)
+	}
+
+	target, err := openUnlocked(databasePath, passphrase)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	source, err := openUnlocked(importedDatabase, passphrase)
+	if err != nil {
+		return fmt.Errorf("the database of the backup can't be unlocked: %w", err)
+	}
+	defer source.Close()
+
+	return target.Merge(source)
+}
+
+// openUnlocked opens the database and unlocks it with the passphrase if it is encrypted
+func openUnlocked(databasePath, passphrase string) (*storageSQLiteAdapter.StorageSQLiteAdapter, error) {
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(databasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := adapter.IsEncrypted()
+	if err == nil && encrypted {
+		err = adapter.Unlock(passphrase)
+	}
+	if err != nil {
+		adapter.Close()
+		return nil, err
+	}
+	return adapter, nil
+}
+
+// mergeFile writes a received file, unless the profile already has it.
+// A different file with the same name is kept and the imported one gets a numbered name, like received files do.
+func mergeFile(filePath string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	extension := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, extension)
+	candidate := filePath
+	for counter := 1; ; counter++ {
+		existing, err := os.ReadFile(candidate)
+		if os.IsNotExist(err) {
+			return os.WriteFile(candidate, data, 0600)
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(existing, data) {
+			return nil
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, counter, extension)
+	}
+}
+
+// writeIdentityKey writes the key readable only by the owner, like Tor does
+func writeIdentityKey(keyPath string, key []byte) error {
+	err := os.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, key, 0600)
+}
+
+// storedFilePath returns the path in the profile of a received file of the archive
+func storedFilePath(profile Profile, archivePath string) string {
+	return filepath.Join(profile.FileDir, filepath.FromSlash(strings.TrimPrefix(archivePath, filesPrefix)))
+}
+
+// sortedFiles returns the paths of the received files of the archive in order
+func sortedFiles(files map[string][]byte) []string {
+	var paths []string
+	for archivePath := range files {
+		if strings.HasPrefix(archivePath, filesPrefix) {
+			paths = append(paths, archivePath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// withDefaults fills the empty paths of the profile with the default paths
+func withDefaults(profile Profile) Profile {
+	defaults := DefaultProfile()
+	if profile.DatabasePath == "" {
+		profile.DatabasePath = defaults.DatabasePath
+	}
+	if profile.FileDir == "" {
+		profile.FileDir = defaults.FileDir
+	}
+	if profile.TorDataDir == "" {
+		profile.TorDataDir = defaults.TorDataDir
+	}
+	return profile
+}
+
+// pack writes the manifest and the files into a compressed tar
+func pack(manifest Manifest, files map[string][]byte) ([]byte, error) {
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+
+	write := func(name string, data []byte) error {
+		err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Unix(manifest.Created, 0)})
+		if err != nil {
+			return err
+		}
+		_, err = archive.Write(data)
+		return err
+	}
+
+	err = write(manifestPath, manifestData)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		err = write(file.Path, files[file.Path])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// unpack reads the manifest and the files from a compressed tar and verifies them against the manifest
+func unpack(content []byte) (Manifest, map[string][]byte, error) {
+	compressed, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	archive := tar.NewReader(compressed)
+
+	var manifest Manifest
+	files := map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, nil, err
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return Manifest{}, nil, err
+		}
+		if header.Name == manifestPath {
+			err = json.Unmarshal(data, &manifest)
+			if err != nil {
+				return Manifest{}, nil, err
+			}
+			continue
+		}
+		files[header.Name] = data
+	}
+
+	if manifest.Version == 0 {
+		return Manifest{}, nil, errors.New("the backup has no manifest")
+	}
+	if len(files) != len(manifest.Files) {
+		return Manifest{}, nil, errors.New("the backup doesn't match its manifest")
+	}
+	for _, file := range manifest.Files {
+		if !validArchivePath(file.Path) {
+			return Manifest{}, nil, fmt.Errorf("the backup contains the invalid path %s", file.Path)
+		}
+		data, ok := files[file.Path]
+		hash := sha256.Sum256(data)
+		if !ok || int64(len(data)) != file.Size || hex.EncodeToString(hash[:]) != file.SHA256 {
+			return Manifest{}, nil, fmt.Errorf("%s doesn't match the manifest of the backup", file.Path)
+		}
+	}
+	return manifest, files, nil
+}
+
+// validArchivePath checks that the path is one of the parts of a profile and can't leave the profile
+func validArchivePath(archivePath string) bool {
+	if archivePath == identityPath || archivePath == databasePath {
+		return true
+	}
+	cleaned := path.Clean(archivePath)
+	return cleaned == archivePath && strings.HasPrefix(cleaned, filesPrefix) && !strings.Contains(cleaned, "..")
+}
+
+// seal encrypts the content with a key derived from the passphrase and prepends the header
+func seal(passphrase string, content []byte) ([]byte, error) {
+	parameters, err := util.NewKdfParameters()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerLength)
+	header = append(header, magic...)
+	header = binary.BigEndian.AppendUint16(header, FormatVersion)
+	header = append(header, parameters.Salt...)
+	header = binary.BigEndian.AppendUint32(header, parameters.Time)
+	header = binary.BigEndian.AppendUint32(header, parameters.Memory)
+	header = append(header, parameters.Threads)
+
+	aead, err := util.DeriveKey(passphrase, parameters)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(header, nonce...)
+	return aead.Seal(sealed, nonce, content, header), nil
+}
+
+// open checks the header and decrypts the content, the header is authenticated with it
+func open(passphrase string, sealed []byte) ([]byte, error) {
+	if len(sealed) < headerLength || string(sealed[:len(magic)]) != magic {
+		return nil, ErrNotABackup
+	}
+
+	header := sealed[:headerLength]
+	rest := header[len(magic):]
+	version := binary.BigEndian.Uint16(rest)
+	if version > FormatVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than the supported version %d", version, FormatVersion)
+	}
+	rest = rest[2:]
+	parameters := util.KdfParameters{Salt: rest[:util.KdfSaltLength]}
+	rest = rest[util.KdfSaltLength:]
+	parameters.Time = binary.BigEndian.Uint32(rest)
+	parameters.Memory = binary.BigEndian.Uint32(rest[4:])
+	parameters.Threads = rest[8]
+	// The header is only authenticated after the key is derived, crafted parameters must not exhaust the memory first
+	if parameters.Validate() != nil {
+		return nil, ErrNotABackup
+	}
+
+	aead, err := util.DeriveKey(passphrase, parameters)
+	if err != nil {
+		return nil, err
+	}
+	body := sealed[headerLength:]
+	if len(body) < aead.NonceSize() {
+		return nil, ErrNotABackup
+	}
+
+	content, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return content, nil
+}
@@ -0,0 +1,106 @@
+// Package node is the composition root of Skunk. It builds a complete, independent peer from a Config,
+// so several nodes can run in one process without sharing any state.
+package node
+
+import (
+	"io"
+	"os"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+)
+
+// Defaults for the paths of a Config
+const (
+	DefaultDatabasePath = "skunk.db"
+	DefaultFileDir      = "./stored_files"
+)
+
+// Config describes a node. The zero value is a node with the default paths and without network connection.
+type Config struct {
+	DatabasePath string                    // SQLite database of the node, DefaultDatabasePath if empty
+	FileDir      string                    // directory the received files are stored in, DefaultFileDir if empty
+	Ephemeral    bool                      // keeps all data in memory and the received files in a temporary directory that is removed on Close
+	Network      network.NetworkConnection // connection to the other peers, like networkAdapter.NewAdapter(tor.DefaultConfig()), nil to stay offline
+}
+
+// Node is a complete peer: its storage, the chat app, the peer with its security context and handlers, and its network connection
+type Node struct {
+	Storage       store.Storage
+	ChatApp       *c_service.ChatApp
+	Peer          *messageHandlers.Peer
+	ChatToNetwork *messageHandlers.ChatToNetwork
+	FileDir       string
+
+	network       network.NetworkConnection
+	removeFileDir bool // the file directory is temporary
+}
+
+// New builds the node and connects it to its network
+func New(config Config) (*Node, error) {
+	n := &Node{FileDir: config.FileDir, network: config.Network}
+	if n.FileDir == "" {
+		n.FileDir = DefaultFileDir
+	}
+
+	if config.Ephemeral {
+		fileDir, err := os.MkdirTemp("", "skunk-files-")
+		if err != nil {
+			return nil, err
+		}
+		n.FileDir = fileDir
+		n.removeFileDir = true
+		n.Storage = storageMemoryAdapter.NewStorageMemoryAdapter()
+	} else {
+		databasePath := config.DatabasePath
+		if databasePath == "" {
+			databasePath = DefaultDatabasePath
+		}
+		storage, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(databasePath)
+		if err != nil {
+			return nil, err
+		}
+		n.Storage = storage
+	}
+
+	n.ChatApp = c_service.NewChatApp()
+	n.Peer = messageHandlers.NewPeer(n.Storage, n.ChatApp, n.FileDir)
+	n.ChatToNetwork = messageHandlers.NewChatToNetwork(n.Peer.MessageSender(), n.Storage)
+
+	if n.network != nil {
+		err := n.Peer.AddNetworkConnection(n.network)
+		if err != nil {
+			n.Close()
+			return nil, err
+		}
+
+		// The key of the onion address signs the invite tokens of this node
+		if identity, ok := n.network.(network.NetworkIdentity); ok && n.Peer.Address != "" {
+			n.ChatToNetwork.SetIdentity(n.Peer.Address, identity.IdentityKey())
+		}
+	}
+
+	return n, nil
+}
+
+// Close disconnects the node and closes its storage. The data of an ephemeral node is gone afterwards.
+func (n *Node) Close() error {
+	err := n.Peer.Close()
+
+	if closer, ok := n.Storage.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if n.removeFileDir {
+		if removeErr := os.RemoveAll(n.FileDir); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Parameters of the Argon2id key derivation for new passphrases
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	keyLength    = 32 // AES-256
+
+	// KdfSaltLength is the length of the salt of new parameters
+	KdfSaltLength = 16
+)
+
+// Limits of stored parameters. They are read from files that may be crafted, a key is never derived with more
+// than 1 GiB of memory or more rounds than a slow machine manages in a few seconds.
+const (
+	maxArgonTime    = 16
+	maxArgonMemory  = 1024 * 1024 // KiB
+	maxArgonThreads = 16
+)
+
+var ErrInvalidKdfParameters = errors.New("invalid key derivation parameters")
+
+// KdfParameters are the salt and Argon2id parameters a key is derived from a passphrase with.
+// They are stored with the encrypted data, so the defaults can change without breaking older data.
+type KdfParameters struct {
+	Salt    []byte
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// NewKdfParameters returns the default parameters with a random salt
+func NewKdfParameters() (KdfParameters, error) {
+	salt := make([]byte, KdfSaltLength)
+	_, err := rand.Read(salt)
+	return KdfParameters{Salt: salt, Time: argonTime, Memory: argonMemory, Threads: argonThreads}, err
+}
+
+// Validate checks that the parameters are within the limits
+func (p KdfParameters) Validate() error {
+	if len(p.Salt) < KdfSaltLength || p.Time == 0 || p.Time > maxArgonTime || p.Memory > maxArgonMemory ||
+		p.Threads == 0 || p.Threads > maxArgonThreads {
+		return ErrInvalidKdfParameters
+	}
+	return nil
+}
+
+// DeriveKey derives the AES-GCM cipher from the passphrase, parameters outside the limits are rejected
+func DeriveKey(passphrase string, parameters KdfParameters) (cipher.AEAD, error) {
+	if err := parameters.Validate(); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(passphrase), parameters.Salt, parameters.Time, parameters.Memory, parameters.Threads, keyLength)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
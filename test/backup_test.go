@@ -0,0 +1,266 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/tor"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/scherzma/Skunk/cmd/skunk/backup"
+	"github.com/stretchr/testify/assert"
+)
+
+const backupPassphrase = "backup passphrase"
+
+// newBackupProfile returns a profile in a temporary directory with the identity key and the received files
+func newBackupProfile(t *testing.T, key string, files map[string]string) backup.Profile {
+	dir := t.TempDir()
+	profile := backup.Profile{
+		DatabasePath: filepath.Join(dir, "skunk.db"),
+		FileDir:      filepath.Join(dir, "stored_files"),
+		TorDataDir:   filepath.Join(dir, "tor-data"),
+	}
+
+	if key != "" {
+		assert.NoError(t, os.MkdirAll(profile.TorDataDir, 0700))
+		assert.NoError(t, os.WriteFile(filepath.Join(profile.TorDataDir, backup.IdentityKeyFile), []byte(key), 0600))
+	}
+	assert.NoError(t, os.MkdirAll(profile.FileDir, 0700))
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(profile.FileDir, name), []byte(content), 0600))
+	}
+	return profile
+}
+
+// storeBackupMessages stores the messages in the database of the profile, which is encrypted if a passphrase is given
+func storeBackupMessages(t *testing.T, profile backup.Profile, passphrase string, contact store.Contact, messages ...network.Message) {
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(profile.DatabasePath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+
+	assert.NoError(t, adapter.ChatCreated("Backup Chat", "backupChat"))
+	for _, message := range messages {
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+	}
+	assert.NoError(t, adapter.SaveContact(contact), "Error saving contact")
+	if passphrase != "" {
+		assert.NoError(t, adapter.SetPassphrase(passphrase), "Error encrypting database")
+	}
+}
+
+func backupMessage(id, content, sender string) network.Message {
+	return network.Message{
+		Id:              id,
+		Timestamp:       1633029445,
+		Content:         `{"message":"` + content + `"}`,
+		SenderID:        sender,
+		ReceiverID:      "",
+		SenderAddress:   sender + ".onion",
+		ReceiverAddress: "",
+		ChatID:          "backupChat",
+		Operation:       network.SEND_MESSAGE,
+	}
+}
+
+func exportBackup(t *testing.T, profile backup.Profile) []byte {
+	var archive bytes.Buffer
+	_, err := backup.Export(profile, backupPassphrase, &archive)
+	assert.NoError(t, err, "Error exporting backup")
+	return archive.Bytes()
+}
+
+// openBackupDatabase opens the database of the profile, unlocked with the passphrase if it is encrypted
+func openBackupDatabase(t *testing.T, profile backup.Profile, passphrase string) *storageSQLiteAdapter.StorageSQLiteAdapter {
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(profile.DatabasePath)
+	if !assert.NoError(t, err, "Error opening database") {
+		t.FailNow()
+	}
+	t.Cleanup(func() { adapter.Close() })
+	if passphrase != "" {
+		assert.NoError(t, adapter.Unlock(passphrase), "Error unlocking database")
+	}
+	return adapter
+}
+
+func TestBackupExportAndReplace(t *testing.T) {
+	source := newBackupProfile(t, "identity key", map[string]string{"photo.png": "photo"})
+	message := backupMessage("backupMsg1", "Hello from the old machine", "user1")
+	storeBackupMessages(t, source, "", store.Contact{Address: "bob.onion", Nickname: "Bob", PublicKey: "bobKey"}, message)
+
+	var buffer bytes.Buffer
+	manifest, err := backup.Export(source, backupPassphrase, &buffer)
+	if !assert.NoError(t, err, "Error exporting backup") {
+		return
+	}
+	assert.Equal(t, backup.FormatVersion, manifest.Version)
+	assert.Len(t, manifest.Files, 3, "The backup should contain the key, the database and the file")
+	assert.True(t, manifest.HasIdentity(), "The backup should contain the identity key")
+	assert.NotContains(t, buffer.String(), "Hello from the old machine", "The backup has to be encrypted")
+
+	target := newBackupProfile(t, "other key", map[string]string{"old.txt": "old"})
+	_, err = backup.Import(target, backupPassphrase, bytes.NewReader(buffer.Bytes()), backup.ImportOptions{Mode: backup.REPLACE})
+	if !assert.NoError(t, err, "Error importing backup") {
+		return
+	}
+
+	key, err := os.ReadFile(filepath.Join(target.TorDataDir, backup.IdentityKeyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "identity key", string(key), "The identity key should be replaced")
+
+	photo, err := os.ReadFile(filepath.Join(target.FileDir, "photo.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "photo", string(photo))
+	_, err = os.Stat(filepath.Join(target.FileDir, "old.txt"))
+	assert.True(t, os.IsNotExist(err), "Files of the replaced profile should be removed")
+
+	adapter := openBackupDatabase(t, target, "")
+	retrieved, err := adapter.RetrieveMessage("backupMsg1")
+	assert.NoError(t, err, "The message should be restored")
+	assert.Equal(t, message.Content, retrieved.Content)
+	contact, err := adapter.GetContact("bob.onion")
+	assert.NoError(t, err, "The contacts should be restored")
+	assert.Equal(t, "Bob", contact.Nickname)
+}
+
+func TestBackupIntegrity(t *testing.T) {
+	source := newBackupProfile(t, "identity key", map[string]string{"photo.png": "photo"})
+	archive := exportBackup(t, source)
+	target := newBackupProfile(t, "", nil)
+
+	_, err := backup.Import(target, "wrong passphrase", bytes.NewReader(archive), backup.ImportOptions{Mode: backup.REPLACE})
+	assert.ErrorIs(t, err, backup.ErrWrongPassphrase, "A wrong passphrase should be detected")
+
+	for _, position := range []int{10, len(archive) / 2, len(archive) - 1} {
+		damaged := append([]byte(nil), archive...)
+		damaged[position] ^= 1
+		_, err = backup.Import(target, backupPassphrase, bytes.NewReader(damaged), backup.ImportOptions{Mode: backup.REPLACE})
+		assert.Error(t, err, "A changed byte at %d should be detected", position)
+	}
+
+	newer := append([]byte(nil), archive...)
+	newer[9] = backup.FormatVersion + 1
+	_, err = backup.Import(target, backupPassphrase, bytes.NewReader(newer), backup.ImportOptions{Mode: backup.REPLACE})
+	assert.ErrorContains(t, err, "newer", "Backups of newer versions should be refused")
+
+	_, err = backup.Import(target, backupPassphrase, bytes.NewReader([]byte("no backup")), backup.ImportOptions{Mode: backup.REPLACE})
+	assert.ErrorIs(t, err, backup.ErrNotABackup)
+
+	// the key derivation parameters are read before the header is authenticated, crafted ones must be refused first
+	// header: magic (8), version (2), salt (16), time (4), memory (4), threads (1)
+	for name, field := range map[string][2]int{"time": {26, 30}, "memory": {30, 34}, "threads": {34, 35}} {
+		crafted := append([]byte(nil), archive...)
+		for i := field[0]; i < field[1]; i++ {
+			crafted[i] = 0xFF
+		}
+		_, err = backup.Import(target, backupPassphrase, bytes.NewReader(crafted), backup.ImportOptions{Mode: backup.REPLACE})
+		assert.ErrorIs(t, err, backup.ErrNotABackup, "A huge %s parameter should be refused", name)
+	}
+
+	_, err = os.Stat(filepath.Join(target.TorDataDir, backup.IdentityKeyFile))
+	assert.True(t, os.IsNotExist(err), "Failed imports must not change the profile")
+}
+
+func TestBackupMerge(t *testing.T) {
+	source := newBackupProfile(t, "identity key", map[string]string{"photo.png": "photo", "same.txt": "same"})
+	storeBackupMessages(t, source, "", store.Contact{Address: "bob.onion", Nickname: "Bob", PublicKey: "bobKey"},
+		backupMessage("backupMsg1", "From the backup", "user1"))
+	archive := exportBackup(t, source)
+
+	target := newBackupProfile(t, "identity key", map[string]string{"photo.png": "other photo", "same.txt": "same"})
+	storeBackupMessages(t, target, "", store.Contact{Address: "carol.onion", Nickname: "Carol", PublicKey: "carolKey"},
+		backupMessage("backupMsg2", "From this machine", "user2"))
+
+	_, err := backup.Import(target, backupPassphrase, bytes.NewReader(archive), backup.ImportOptions{Mode: backup.MERGE})
+	if !assert.NoError(t, err, "Error merging backup") {
+		return
+	}
+
+	adapter := openBackupDatabase(t, target, "")
+	for _, messageID := range []string{"backupMsg1", "backupMsg2"} {
+		_, err = adapter.RetrieveMessage(messageID)
+		assert.NoError(t, err, "Message %s should be there after the merge", messageID)
+	}
+	messages, err := adapter.GetChatMessages("backupChat")
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2, "The merged messages should belong to the same chat")
+	contacts, err := adapter.GetContacts()
+	assert.NoError(t, err)
+	assert.Len(t, contacts, 2, "The contacts should be merged")
+
+	photo, err := os.ReadFile(filepath.Join(target.FileDir, "photo.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "other photo", string(photo), "Files of the profile should be kept")
+	photo, err = os.ReadFile(filepath.Join(target.FileDir, "photo_1.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "photo", string(photo), "A different file with the same name should get a new name")
+	_, err = os.Stat(filepath.Join(target.FileDir, "same_1.txt"))
+	assert.True(t, os.IsNotExist(err), "Equal files should not be duplicated")
+
+	_, err = backup.Import(target, backupPassphrase, bytes.NewReader(archive), backup.ImportOptions{Mode: backup.MERGE})
+	assert.NoError(t, err, "Merging the same backup again should work")
+	messages, err = adapter.GetChatMessages("backupChat")
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2, "Merging twice should not duplicate messages")
+}
+
+func TestBackupMergeEncrypted(t *testing.T) {
+	const databasePassphrase = "database passphrase"
+
+	source := newBackupProfile(t, "", nil)
+	storeBackupMessages(t, source, databasePassphrase, store.Contact{Address: "bob.onion", Nickname: "Bob", PublicKey: "bobKey"},
+		backupMessage("backupMsg1", "Secret from the backup", "user1"))
+	archive := exportBackup(t, source)
+
+	target := newBackupProfile(t, "", nil)
+	storeBackupMessages(t, target, databasePassphrase, store.Contact{Address: "carol.onion", Nickname: "Carol", PublicKey: "carolKey"},
+		backupMessage("backupMsg2", "Secret from this machine", "user2"))
+
+	_, err := backup.Import(target, backupPassphrase, bytes.NewReader(archive), backup.ImportOptions{Mode: backup.MERGE})
+	assert.ErrorIs(t, err, store.ErrWrongPassphrase, "Encrypted databases can't be merged without their passphrase")
+
+	_, err = backup.Import(target, backupPassphrase, bytes.NewReader(archive), backup.ImportOptions{Mode: backup.MERGE, DatabasePassphrase: databasePassphrase})
+	if !assert.NoError(t, err, "Error merging backup") {
+		return
+	}
+
+	adapter := openBackupDatabase(t, target, databasePassphrase)
+	message, err := adapter.RetrieveMessage("backupMsg1")
+	assert.NoError(t, err)
+	assert.Contains(t, message.Content, "Secret from the backup", "The merged message should be readable with the key of the profile")
+	contact, err := adapter.GetContact("bob.onion")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", contact.Nickname)
+}
+
+func TestBackupMergeOtherIdentity(t *testing.T) {
+	source := newBackupProfile(t, "identity key", map[string]string{"photo.png": "photo"})
+	archive := exportBackup(t, source)
+
+	target := newBackupProfile(t, "other key", nil)
+	_, err := backup.Import(target, backupPassphrase, bytes.NewReader(archive), backup.ImportOptions{Mode: backup.MERGE})
+	assert.ErrorIs(t, err, backup.ErrOtherIdentity)
+
+	_, err = os.Stat(filepath.Join(target.FileDir, "photo.png"))
+	assert.True(t, os.IsNotExist(err), "Nothing should be merged from another identity")
+}
+
+func TestBackupDefaultProfile(t *testing.T) {
+	// the identity key is where the node keeps it
+	assert.Equal(t, tor.DefaultConfig().DataDir, backup.DefaultProfile().TorDataDir, "The default profile should use the Tor data directory of the node")
+
+	t.Setenv("SKUNK_TOR_DATA_DIR", "other-tor-data")
+	assert.Equal(t, "other-tor-data", backup.DefaultProfile().TorDataDir, "SKUNK_TOR_DATA_DIR should move the Tor data directory")
+
+	source := newBackupProfile(t, "", nil)
+	storeBackupMessages(t, source, "", store.Contact{Address: "bob.onion", Nickname: "Bob", PublicKey: "bobKey"})
+	var buffer bytes.Buffer
+	manifest, err := backup.Export(source, backupPassphrase, &buffer)
+	assert.NoError(t, err, "Error exporting backup")
+	assert.False(t, manifest.HasIdentity(), "A profile without identity key can only be exported without it")
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"database/sql"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatabaseEncryption(t *testing.T) {
+	dbPath := "test_database_encryption.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	t.Log("Storage adapter initialized")
+
+	message := network.Message{
+		Id:              "encryptedMessage",
+		Timestamp:       time.Now().UnixNano(),
+		Content:         `{"message":"Top secret"}`,
+		SenderID:        "encryptedSender",
+		ReceiverID:      "encryptedReceiver",
+		SenderAddress:   "encryptedsender.onion",
+		ReceiverAddress: "encryptedreceiver.onion",
+		ChatID:          "encryptedChat",
+		Operation:       network.SEND_MESSAGE,
+	}
+
+	// readRaw reads a column without decrypting it
+	readRaw := func(query string) string {
+		db, err := sql.Open("sqlite3", dbPath)
+		if !assert.NoError(t, err, "Error opening database") {
+			return ""
+		}
+		defer db.Close()
+
+		var value string
+		assert.NoError(t, db.QueryRow(query).Scan(&value), "Error reading raw value")
+		return value
+	}
+
+	reopen := func() {
+		assert.NoError(t, adapter.Close(), "Error closing database")
+		adapter, err = storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		assert.NoError(t, err, "Error opening database")
+	}
+
+	t.Run("EncryptExistingData", func(t *testing.T) {
+		encrypted, err := adapter.IsEncrypted()
+		assert.NoError(t, err, "Error checking encryption")
+		assert.False(t, encrypted, "A new database has no passphrase")
+
+		assert.NoError(t, adapter.CreateChat("encryptedChat", "Secret plans"), "Error creating chat")
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+		assert.NoError(t, adapter.SaveContact(store.Contact{Address: "zed.onion", Nickname: "Zed", PublicKey: "00", Notes: "Met at the conference"}), "Error saving contact")
+		assert.NoError(t, adapter.SaveContact(store.Contact{Address: "amy.onion", Nickname: "amy", PublicKey: "00"}), "Error saving contact")
+		assert.NoError(t, adapter.InvitedToChat("encryptedInvitation", []store.PublicKeyAddress{{PublicKey: "invitedKey", Address: "invitedpeer.onion"}}), "Error storing invitation")
+
+		assert.NoError(t, adapter.SetPassphrase("correct horse battery staple"), "Error setting passphrase")
+		assert.Error(t, adapter.SetPassphrase("another passphrase"), "The passphrase can only be set once")
+
+		// the old values must not stay in the free pages or the search index of the file
+		file, err := os.ReadFile(dbPath)
+		if assert.NoError(t, err, "Error reading database file") {
+			for _, plaintext := range []string{"Top secret", "encryptedsender.onion", "encryptedreceiver.onion", "Secret plans", "conference", "invitedpeer.onion"} {
+				assert.NotContains(t, string(file), plaintext, "The database file must not contain the plaintext %q", plaintext)
+			}
+		}
+
+		content := readRaw("SELECT content FROM Messages WHERE message_id = 'encryptedMessage'")
+		assert.True(t, strings.HasPrefix(content, "enc1:"), "The content should be encrypted")
+		assert.NotContains(t, content, "Top secret", "The content must not be stored in plaintext")
+		assert.NotContains(t, readRaw("SELECT sender_address FROM Messages WHERE message_id = 'encryptedMessage'"), "encryptedsender", "The address must not be stored in plaintext")
+		assert.NotContains(t, readRaw("SELECT name FROM Chats WHERE chat_id = 'encryptedChat'"), "Secret plans", "The chat name must not be stored in plaintext")
+		assert.NotContains(t, readRaw("SELECT notes FROM Contacts WHERE address = 'zed.onion'"), "conference", "The notes must not be stored in plaintext")
+		assert.NotContains(t, readRaw("SELECT address FROM PeersInInvitedChat WHERE public_key = 'invitedKey'"), "invitedpeer", "The invited address must not be stored in plaintext")
+
+		stored, err := adapter.RetrieveMessage("encryptedMessage")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, message.Content, stored.Content, "The message should be decrypted")
+		assert.Equal(t, message.SenderAddress, stored.SenderAddress, "The address should be decrypted")
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		reopen()
+
+		encrypted, err := adapter.IsEncrypted()
+		assert.NoError(t, err, "Error checking encryption")
+		assert.True(t, encrypted, "The database should have a passphrase")
+
+		_, err = adapter.GetChatMessages("encryptedChat")
+		assert.ErrorIs(t, err, store.ErrDatabaseLocked, "The messages can't be read before the database is unlocked")
+		assert.ErrorIs(t, adapter.StoreMessage(message), store.ErrDatabaseLocked, "Messages can't be stored before the database is unlocked")
+
+		assert.ErrorIs(t, adapter.Unlock("wrong passphrase"), store.ErrWrongPassphrase, "A wrong passphrase must not unlock the database")
+		assert.NoError(t, adapter.Unlock("correct horse battery staple"), "Error unlocking database")
+
+		messages, err := adapter.GetChatMessages("encryptedChat")
+		assert.NoError(t, err, "Error getting messages")
+		if assert.Len(t, messages, 1, "Expected the stored message") {
+			assert.Equal(t, message.Content, messages[0].Content, "The message should be decrypted")
+		}
+
+		// new invitations are encrypted when they are stored, and a peer is invited once per invitation
+		invited := []store.PublicKeyAddress{{PublicKey: "laterKey", Address: "laterpeer.onion"}}
+		assert.NoError(t, adapter.InvitedToChat("laterInvitation", invited), "Error storing invitation")
+		assert.NoError(t, adapter.InvitedToChat("laterInvitation", invited), "Error storing invitation again")
+		assert.NotContains(t, readRaw("SELECT address FROM PeersInInvitedChat WHERE public_key = 'laterKey'"), "laterpeer", "The invited address must not be stored in plaintext")
+		assert.Equal(t, "1", readRaw("SELECT COUNT(*) FROM PeersInInvitedChat WHERE public_key = 'laterKey'"), "The peer should be invited once")
+
+		chats, err := adapter.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		if assert.Len(t, chats, 1, "Expected the stored chat") {
+			assert.Equal(t, "Secret plans", chats[0].ChatName, "The chat name should be decrypted")
+		}
+
+		contacts, err := adapter.GetContacts()
+		assert.NoError(t, err, "Error getting contacts")
+		if assert.Len(t, contacts, 2, "Expected the stored contacts") {
+			assert.Equal(t, "amy", contacts[0].Nickname, "The contacts should be sorted by their decrypted nickname")
+			assert.Equal(t, "Met at the conference", contacts[1].Notes, "The notes should be decrypted")
+		}
+	})
+
+	t.Run("ChangePassphrase", func(t *testing.T) {
+		before := readRaw("SELECT content FROM Messages WHERE message_id = 'encryptedMessage'")
+
+		assert.ErrorIs(t, adapter.ChangePassphrase("wrong passphrase", "new passphrase"), store.ErrWrongPassphrase, "The current passphrase is needed to change it")
+		assert.NoError(t, adapter.ChangePassphrase("correct horse battery staple", "new passphrase"), "Error changing passphrase")
+		assert.NotEqual(t, before, readRaw("SELECT content FROM Messages WHERE message_id = 'encryptedMessage'"), "The content should be encrypted again")
+
+		reopen()
+		assert.ErrorIs(t, adapter.Unlock("correct horse battery staple"), store.ErrWrongPassphrase, "The old passphrase must not unlock the database")
+		assert.NoError(t, adapter.Unlock("new passphrase"), "Error unlocking with the new passphrase")
+
+		stored, err := adapter.RetrieveMessage("encryptedMessage")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, message.Content, stored.Content, "The message should survive the change of the passphrase")
+	})
+
+	adapter.Close()
+	t.Log("Database encryption test passed")
+}
@@ -1,10 +1,22 @@
 package test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
+
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/frontend"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
 )
 
 // TestCreateMessage tests the createMessage function.
@@ -90,6 +102,71 @@ func TestHandleCommand(t *testing.T) {
 	}
 }
 
+// fakeChatActions records the invitations that were accepted or declined
+type fakeChatActions struct {
+	accepted []string
+	declined []string
+	err      error
+}
+
+func (f *fakeChatActions) AcceptInvitation(invitationId string) error {
+	f.accepted = append(f.accepted, invitationId)
+	return f.err
+}
+
+func (f *fakeChatActions) DeclineInvitation(invitationId string) error {
+	f.declined = append(f.declined, invitationId)
+	return f.err
+}
+
+func (f *fakeChatActions) JoinChatWithToken(uri string) error {
+	return f.err
+}
+
+// TestInvitationActions tests that accepting and declining an invitation is sent to the other peers.
+func TestInvitationActions(t *testing.T) {
+	actions := &fakeChatActions{}
+	m := frontend.InitialModel()
+	m.ChatActions = actions
+	update := func(msg tea.Msg) {
+		modelInterface, _ := m.Update(msg)
+		switch updated := modelInterface.(type) {
+		case frontend.Model:
+			m = updated
+		case *frontend.Model:
+			m = *updated
+		}
+	}
+
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.HandleCommand("/invite", "Bob")
+	m.HandleCommand("/invite", "Carol")
+	invites := m.Chats["1"]
+
+	update(tea.KeyMsg{Type: tea.KeyEnter}) // leave the intro
+	update(tea.KeyMsg{Type: tea.KeyTab})   // focus the invites
+	actions.err = errors.New("offline")
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	if len(actions.accepted) != 1 || actions.accepted[0] != invites[0].Id {
+		t.Fatalf("Expected the invitation %s to be accepted, got %v", invites[0].Id, actions.accepted)
+	}
+	if !strings.Contains(m.TempMessage, "offline") {
+		t.Errorf("Expected the error to be shown, got %s", m.TempMessage)
+	}
+
+	actions.err = nil
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	if len(actions.accepted) != 2 || actions.accepted[1] != invites[0].Id {
+		t.Errorf("Expected the invitation to be kept after an error, got %v", actions.accepted)
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyBackspace})
+	if len(actions.declined) != 1 || actions.declined[0] != invites[1].Id {
+		t.Errorf("Expected the invitation %s to be declined, got %v", invites[1].Id, actions.declined)
+	}
+}
+
 // TestClearTempMessage tests the clearTempMessage function.
 func TestClearTempMessage(t *testing.T) {
 	m := frontend.InitialModel()
@@ -144,3 +221,614 @@ func TestGetSortedChatIDs(t *testing.T) {
 		}
 	}
 }
+
+// TestChatMetadata tests that changes of the chat metadata are shown immediately.
+func TestChatMetadata(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.Chats["1"] = []frontend.FrontendMessage{}
+
+	m.HandleCommand("/rename", "Renamed Chat")
+	m.HandleCommand("/topic", "Weekend plans")
+
+	view := m.ChatDetailView()
+	if !strings.Contains(view, "Chat: Renamed Chat") {
+		t.Errorf("Expected the new chat name in the header, got %s", view)
+	}
+	if !strings.Contains(view, "Topic: Weekend plans") {
+		t.Errorf("Expected the topic in the header, got %s", view)
+	}
+
+	modelInterface, _ := m.Update(frontend.CreateMessage("1", "Bob", frontend.MetadataTopic, "Monday plans", "", "", frontend.UPDATE_CHAT_METADATA))
+	m, _ = modelInterface.(frontend.Model)
+
+	if !strings.Contains(m.ChatDetailView(), "Topic: Monday plans") {
+		t.Errorf("Expected the received topic in the header")
+	}
+	if len(m.Chats["1"]) != 3 {
+		t.Errorf("Expected 3 messages, got %d", len(m.Chats["1"]))
+	}
+}
+
+// TestEditAndDeleteMessage tests the edited and deleted states of messages.
+func TestEditAndDeleteMessage(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.Chats["1"] = []frontend.FrontendMessage{
+		frontend.CreateMessage("1", "Alice", "", "Helo", "", "", frontend.SEND_MESSAGE),
+	}
+	bobMessage := frontend.CreateMessage("1", "Bob", "", "Hi", "", "", frontend.SEND_MESSAGE)
+	bobMessage.Id = "bobMessage"
+	m.ReceiveMessage(bobMessage)
+
+	m.HandleCommand("/edit", "Hello")
+	if !strings.Contains(m.ChatDetailView(), "Alice: Hello (edited)") {
+		t.Errorf("Expected the edited message, got %s", m.ChatDetailView())
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "bobMessage", "", "", "", frontend.DELETE_MESSAGE))
+	if !strings.Contains(m.ChatDetailView(), "Bob: message deleted") {
+		t.Errorf("Expected the deleted message, got %s", m.ChatDetailView())
+	}
+	if len(m.Chats["1"]) != 2 {
+		t.Errorf("Expected edits and deletions to change messages instead of adding new ones, got %d messages", len(m.Chats["1"]))
+	}
+}
+
+// TestRepliesAndThreads tests quoting parents and the thread view.
+func TestRepliesAndThreads(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	parent := frontend.CreateMessage("1", "Bob", "", "Who brings snacks?", "", "", frontend.SEND_MESSAGE)
+	parent.Id = "parent"
+	m.Chats["1"] = []frontend.FrontendMessage{parent}
+
+	m.HandleCommand("/reply", "1 I do")
+	if len(m.Chats["1"]) != 2 || m.Chats["1"][1].ReplyTo != "parent" {
+		t.Fatalf("Expected a reply to the first message")
+	}
+
+	orphan := frontend.CreateMessage("1", "Carol", "", "Me too", "", "", frontend.SEND_MESSAGE)
+	orphan.ReplyTo = "notArrived"
+	m.ReceiveMessage(orphan)
+
+	view := m.ChatDetailView()
+	if !strings.Contains(view, "┆ #1 Bob: Who brings snacks?") {
+		t.Errorf("Expected the quoted parent, got %s", view)
+	}
+	if !strings.Contains(view, "(1 reply)") {
+		t.Errorf("Expected the number of replies, got %s", view)
+	}
+	if !strings.Contains(view, "reply to a message that has not arrived yet") {
+		t.Errorf("Expected a hint for replies without parent, got %s", view)
+	}
+
+	m.HandleCommand("/thread", "1")
+	thread := m.ChatDetailView()
+	if !strings.Contains(thread, "Thread in") || !strings.Contains(thread, "Alice: I do") || strings.Contains(thread, "Carol: Me too") {
+		t.Errorf("Expected the thread with its replies only, got %s", thread)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	message := frontend.CreateMessage("1", "Bob", "", "Pizza tonight?", "", "", frontend.SEND_MESSAGE)
+	message.Id = "pizza"
+	m.Chats["1"] = []frontend.FrontendMessage{message}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "pizza", "🍕", "", "", frontend.REACT))
+	m.HandleCommand("/react", "1 🍕")
+	if view := m.ChatDetailView(); !strings.Contains(view, "🍕 2") {
+		t.Errorf("Expected two reactions, got %s", view)
+	}
+
+	m.HandleCommand("/react", "1 🍕")
+	if view := m.ChatDetailView(); !strings.Contains(view, "🍕 1") {
+		t.Errorf("Expected reacting again to remove the reaction, got %s", view)
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "pizza", "🍕", "", "", frontend.UNREACT))
+	if view := m.ChatDetailView(); strings.Contains(view, "🍕") {
+		t.Errorf("Expected no reactions, got %s", view)
+	}
+}
+
+func TestDisappearingMessages(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	old := frontend.CreateMessage("1", "Bob", "", "Old news", "", "", frontend.SEND_MESSAGE)
+	old.Timestamp = time.Now().Add(-2 * time.Hour).Unix()
+	m.Chats["1"] = []frontend.FrontendMessage{old}
+
+	m.HandleCommand("/disappear", "1s")
+	if view := m.ChatDetailView(); !strings.Contains(view, "Old news") {
+		t.Errorf("Expected too short durations to be rejected, got %s", view)
+	}
+
+	m.HandleCommand("/disappear", "1h")
+	view := m.ChatDetailView()
+	if strings.Contains(view, "Old news") {
+		t.Errorf("Expected expired messages to be removed, got %s", view)
+	}
+	if !strings.Contains(view, "Messages disappear after 1h0m0s") {
+		t.Errorf("Expected the message expiry in the header, got %s", view)
+	}
+
+	m.HandleCommand("/disappear", "off")
+	if view := m.ChatDetailView(); strings.Contains(view, "Messages disappear after") {
+		t.Errorf("Expected disappearing messages to be turned off, got %s", view)
+	}
+}
+
+func TestTypingIndicator(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "", "", "", "", frontend.TYPING_STARTED))
+	if view := m.ChatDetailView(); !strings.Contains(view, "Bob is typing…") {
+		t.Errorf("Expected a typing indicator, got %s", view)
+	}
+	if len(m.Chats["1"]) != 0 {
+		t.Errorf("Typing messages must not be added to the chat")
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Carol", "", "", "", "", frontend.TYPING_STARTED))
+	if view := m.ChatDetailView(); !strings.Contains(view, "Bob and Carol are typing…") {
+		t.Errorf("Expected two typing users, got %s", view)
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "", "Hi", "", "", frontend.SEND_MESSAGE))
+	m.ReceiveMessage(frontend.CreateMessage("1", "Carol", "", "", "", "", frontend.TYPING_STOPPED))
+	if view := m.ChatDetailView(); strings.Contains(view, "typing") {
+		t.Errorf("Expected the typing indicators to be hidden, got %s", view)
+	}
+}
+
+func TestMemberPresence(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.Chats["1"] = []frontend.FrontendMessage{
+		frontend.CreateMessage("1", "Bob", "", "Bob joined", "", "", frontend.JOIN_CHAT),
+		frontend.CreateMessage("1", "Carol", "", "Hi", "", "", frontend.SEND_MESSAGE),
+		frontend.CreateMessage("1", "Dave", "", "Dave joined", "", "", frontend.JOIN_CHAT),
+		frontend.CreateMessage("1", "Dave", "", "", "", "", frontend.LEAVE_CHAT),
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage("1", "Bob", "", frontend.PresenceOnline, "", "", frontend.PRESENCE))
+	carol := frontend.CreateMessage("1", "Carol", "", frontend.PresenceOffline, "", "", frontend.PRESENCE)
+	carol.Timestamp = time.Now().Add(-5 * time.Minute).Unix()
+	m.ReceiveMessage(carol)
+
+	if view := m.ChatDetailView(); strings.Contains(view, "Members:") {
+		t.Errorf("Expected the member list to be hidden, got %s", view)
+	}
+
+	m.HandleCommand("/members", "")
+	view := m.ChatDetailView()
+	for _, expected := range []string{"Alice - online", "Bob - online", "Carol - last seen 5 minutes ago"} {
+		if !strings.Contains(view, expected) {
+			t.Errorf("Expected %q in the member list, got %s", expected, view)
+		}
+	}
+	if strings.Contains(view, "Dave -") {
+		t.Errorf("Expected members that left to be hidden, got %s", view)
+	}
+}
+
+// TestDirectMessages tests that direct chats are created by /dm and listed in their own section.
+func TestDirectMessages(t *testing.T) {
+	m := frontend.InitialModel()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.HandleCommand("/dm", "bob.onion Hi Bob")
+	if len(m.Chats["1"]) != 0 {
+		t.Errorf("Expected /dm to fail while offline, got %v", m.Chats["1"])
+	}
+
+	m.OwnAddress = "alice.onion"
+	m.HandleCommand("/dm", "bob.onion Hi Bob")
+	chatID := p_model.DirectChatID("alice.onion", "bob.onion")
+	if m.CurrentChat != chatID {
+		t.Errorf("Expected the direct chat to be opened, got %s", m.CurrentChat)
+	}
+	if messages := m.Chats[chatID]; len(messages) != 1 || messages[0].Content != "Hi Bob" || messages[0].ReceiverAddress != "bob.onion" {
+		t.Errorf("Expected the direct message in the direct chat, got %v", messages)
+	}
+
+	// the first message of another user creates a direct chat named after them
+	m.ReceiveMessage(frontend.CreateMessage(p_model.DirectChatID("alice.onion", "carol.onion"), "Carol", "", "Hi Alice", "carol.onion", "alice.onion", frontend.SEND_MESSAGE))
+
+	view := m.ChatsView()
+	directSection := strings.Index(view, "Direct messages")
+	if directSection < 0 {
+		t.Fatalf("Expected a direct messages section, got %s", view)
+	}
+	for _, name := range []string{"bob.onion", "carol.onion"} {
+		if index := strings.Index(view, name); index < directSection {
+			t.Errorf("Expected %s in the direct messages section, got %s", name, view)
+		}
+	}
+}
+
+// TestContactBook tests adding contacts in the TUI and completing OnionIDs from them.
+func TestContactBook(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	contact, err := p_model.NewContact("Bob", testOnionAddress(publicKey), "")
+	if err != nil {
+		t.Fatalf("Error creating contact: %v", err)
+	}
+
+	m := frontend.InitialModel()
+	update := func(msg tea.Msg) {
+		modelInterface, _ := m.Update(msg)
+		switch updated := modelInterface.(type) {
+		case frontend.Model:
+			m = updated
+		case *frontend.Model:
+			m = *updated
+		}
+	}
+	typeText := func(text string) {
+		update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)})
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	typeText("a")
+	typeText("n")
+	typeText("Bob")
+	update(tea.KeyMsg{Type: tea.KeyTab})
+	typeText(contact.Address)
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	if stored, exists := m.Contacts[contact.Address]; !exists || stored.Nickname != "Bob" || stored.PublicKey != contact.PublicKey {
+		t.Fatalf("Expected Bob to be added to the contacts, got %v", m.Contacts)
+	}
+	if view := m.View(); !strings.Contains(view, "Bob - "+contact.Address) {
+		t.Errorf("Expected Bob in the contacts view, got %s", view)
+	}
+
+	typeText("v")
+	if !m.Contacts[contact.Address].Verified {
+		t.Errorf("Expected Bob to be verified")
+	}
+
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+	m.HandleCommand("/invite", "bo")
+	if messages := m.Chats["1"]; len(messages) != 1 || messages[0].ReceiverID != contact.Address {
+		t.Errorf("Expected the invite to be completed to the address of Bob, got %v", messages)
+	}
+
+	typeText("b")
+	m.HandleCommand("/invite", "bo")
+	if messages := m.Chats["1"]; len(messages) != 2 || messages[1].ReceiverID != "bo" {
+		t.Errorf("Expected blocked contacts not to be completed, got %v", messages)
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyBackspace})
+	if len(m.Contacts) != 0 {
+		t.Errorf("Expected Bob to be removed, got %v", m.Contacts)
+	}
+}
+
+// fakeEncryption keeps the passphrase of the database in memory.
+type fakeEncryption struct {
+	passphrase string
+	unlocked   bool
+}
+
+func (f *fakeEncryption) IsEncrypted() (bool, error) {
+	return f.passphrase != "", nil
+}
+
+func (f *fakeEncryption) SetPassphrase(passphrase string) error {
+	if f.passphrase != "" {
+		return errors.New("the database already has a passphrase")
+	}
+	f.passphrase = passphrase
+	f.unlocked = true
+	return nil
+}
+
+func (f *fakeEncryption) Unlock(passphrase string) error {
+	if passphrase != f.passphrase {
+		return store.ErrWrongPassphrase
+	}
+	f.unlocked = true
+	return nil
+}
+
+func (f *fakeEncryption) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if oldPassphrase != f.passphrase {
+		return store.ErrWrongPassphrase
+	}
+	f.passphrase = newPassphrase
+	return nil
+}
+
+func TestPassphrasePrompt(t *testing.T) {
+	newModel := func(encryption *fakeEncryption) (*frontend.Model, func(msg tea.Msg)) {
+		m := frontend.InitialModel()
+		if err := m.SetEncryption(encryption); err != nil {
+			t.Fatalf("Error setting encryption: %v", err)
+		}
+		update := func(msg tea.Msg) {
+			modelInterface, _ := m.Update(msg)
+			switch updated := modelInterface.(type) {
+			case frontend.Model:
+				m = updated
+			case *frontend.Model:
+				m = *updated
+			}
+		}
+		return &m, update
+	}
+	keys := func(update func(msg tea.Msg), inputs ...string) {
+		for i, input := range inputs {
+			if i > 0 {
+				update(tea.KeyMsg{Type: tea.KeyTab})
+			}
+			update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(input)})
+		}
+		update(tea.KeyMsg{Type: tea.KeyEnter})
+	}
+
+	encryption := &fakeEncryption{}
+	m, update := newModel(encryption)
+	if view := m.View(); !strings.Contains(view, "Choose a passphrase") {
+		t.Fatalf("Expected the intro to ask for a new passphrase, got %s", view)
+	}
+
+	keys(update, "short", "short")
+	if encryption.passphrase != "" || !strings.Contains(m.View(), "at least") {
+		t.Errorf("Expected short passphrases to be rejected, got %s", m.View())
+	}
+	keys(update, "first passphrase", "other passphrase")
+	if encryption.passphrase != "" || !strings.Contains(m.View(), "don't match") {
+		t.Errorf("Expected different passphrases to be rejected, got %s", m.View())
+	}
+	keys(update, "first passphrase", "first passphrase")
+	if encryption.passphrase != "first passphrase" || !strings.Contains(m.View(), "Chats") {
+		t.Fatalf("Expected the database to be encrypted and the chats to be shown, got %s", m.View())
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	if view := m.View(); !strings.Contains(view, "Change your passphrase") {
+		t.Fatalf("Expected the passphrase form, got %s", view)
+	}
+	if strings.Contains(m.View(), "first passphrase") {
+		t.Errorf("Expected the passphrase to be hidden")
+	}
+	keys(update, "first passphrase", "second passphrase", "second passphrase")
+	if encryption.passphrase != "second passphrase" || !strings.Contains(m.View(), "Passphrase changed") {
+		t.Errorf("Expected the passphrase to be changed, got %s", m.View())
+	}
+
+	// Encrypted databases are unlocked on the next start
+	encryption = &fakeEncryption{passphrase: "second passphrase"}
+	m, update = newModel(encryption)
+	if view := m.View(); !strings.Contains(view, "enter your passphrase") {
+		t.Fatalf("Expected the intro to ask for the passphrase, got %s", view)
+	}
+	keys(update, "first passphrase")
+	if encryption.unlocked || !strings.Contains(m.View(), "wrong passphrase") {
+		t.Errorf("Expected a wrong passphrase to be rejected, got %s", m.View())
+	}
+	keys(update, "second passphrase")
+	if !encryption.unlocked || !strings.Contains(m.View(), "Chats") {
+		t.Errorf("Expected the database to be unlocked, got %s", m.View())
+	}
+}
+
+// fakeMessageStore keeps the stored messages of all chats oldest first, returns the same search results for every search
+// and remembers the last query.
+type fakeMessageStore struct {
+	store.DisplayStoragePort
+	messages []network.Message
+	results  []store.SearchResult
+	query    store.SearchQuery
+}
+
+func (f *fakeMessageStore) SearchMessages(query store.SearchQuery) ([]store.SearchResult, error) {
+	f.query = query
+	return f.results, nil
+}
+
+func (f *fakeMessageStore) GetUsername(peerID, chatID string) (string, error) {
+	return "user-" + peerID, nil
+}
+
+func (f *fakeMessageStore) GetChatMessagePage(page store.MessagePage) ([]network.Message, error) {
+	messages := []network.Message{}
+	for _, message := range f.messages {
+		if message.Id == page.Before {
+			break
+		}
+		if message.ChatID == page.ChatID {
+			messages = append(messages, message)
+		}
+	}
+	if page.Limit > 0 && len(messages) > page.Limit {
+		messages = messages[len(messages)-page.Limit:]
+	}
+	return messages, nil
+}
+
+func (f *fakeMessageStore) GetChats() ([]store.Chat, error) {
+	return nil, nil
+}
+
+func (f *fakeMessageStore) GetChatMessages(chatID string) ([]network.Message, error) {
+	return f.GetChatMessagePage(store.MessagePage{ChatID: chatID})
+}
+
+func TestSearchCommand(t *testing.T) {
+	messageStore := &fakeMessageStore{}
+	m := frontend.InitialModel()
+	update := func(msg tea.Msg) {
+		modelInterface, _ := m.Update(msg)
+		switch updated := modelInterface.(type) {
+		case frontend.Model:
+			m = updated
+		case *frontend.Model:
+			m = *updated
+		}
+	}
+
+	m.Usernames["1"] = "Alice"
+	m.Usernames["2"] = "Alice"
+	m.CurrentChat = "1"
+	m.HandleCommand("/search", "park")
+	if strings.Contains(m.View(), "Messages containing") {
+		t.Errorf("Expected the search to fail without a message store, got %s", m.View())
+	}
+
+	m.MessageStore = messageStore
+	m.HandleCommand("/search", "park")
+	if !strings.Contains(m.View(), "No messages found for park") {
+		t.Errorf("Expected no results, got %s", m.View())
+	}
+	if messageStore.query.ChatID != "1" {
+		t.Errorf("Expected /search to search the current chat, got %v", messageStore.query)
+	}
+
+	found := frontend.CreateMessage("2", "Bob", "", "The park is closed", "", "", frontend.SEND_MESSAGE)
+	found.Id = "parkMessage"
+	m.ReceiveMessage(found)
+	messageStore.results = []store.SearchResult{
+		{Message: network.Message{Id: "parkMessage", ChatID: "2", SenderID: "Bob", Timestamp: time.Now().UnixNano()}, Snippet: "The [park] is closed"},
+		{Message: network.Message{Id: "oldMessage", ChatID: "2", SenderID: "Bob", Timestamp: time.Now().UnixNano()}, Snippet: "An old [park]"},
+	}
+	m.HandleCommand("/searchall", "park from:Bob")
+	if messageStore.query != (store.SearchQuery{Text: "park", SenderID: "Bob", Limit: frontend.MaxSearchResults}) {
+		t.Errorf("Expected /searchall to search all chats of the sender, got %v", messageStore.query)
+	}
+	if view := m.View(); !strings.Contains(view, "> [") || !strings.Contains(view, "The [park] is closed") {
+		t.Fatalf("Expected the search results, got %s", view)
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.CurrentChat != "2" || !strings.Contains(m.View(), "» #1") {
+		t.Errorf("Expected to jump to the highlighted message, got %s", m.View())
+	}
+
+	m.HandleCommand("/searchall", "park")
+	update(tea.KeyMsg{Type: tea.KeyDown})
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	if !strings.Contains(m.View(), "could not be loaded") {
+		t.Errorf("Expected a hint for messages that are not stored, got %s", m.View())
+	}
+}
+
+func TestMessageHistory(t *testing.T) {
+	// Opening a chat needs a known chat, the first message of a direct chat creates it
+	chatID := p_model.DirectChatID("alice.onion", "bob.onion")
+	messageStore := &fakeMessageStore{}
+	for i := 1; i <= 120; i++ {
+		messageStore.messages = append(messageStore.messages, network.Message{
+			Id:        fmt.Sprintf("history%d", i),
+			Timestamp: int64(i) * int64(time.Second),
+			Content:   fmt.Sprintf(`{"message":"Message %d"}`, i),
+			SenderID:  "bob",
+			ChatID:    chatID,
+			Operation: network.SEND_MESSAGE,
+		})
+	}
+	// The last message deletes the first one, which is only loaded with the last page
+	messageStore.messages = append(messageStore.messages, network.Message{
+		Id: "historyDelete", Timestamp: 121 * int64(time.Second), Content: `{"messageId":"history1"}`, SenderID: "bob", ChatID: chatID, Operation: network.DELETE_MESSAGE,
+	})
+
+	m := frontend.InitialModel()
+	m.MessageStore = messageStore
+	update := func(msg tea.Msg) {
+		modelInterface, _ := m.Update(msg)
+		switch updated := modelInterface.(type) {
+		case frontend.Model:
+			m = updated
+		case *frontend.Model:
+			m = *updated
+		}
+	}
+
+	m.ReceiveMessage(frontend.CreateMessage(chatID, "Alice", "", "", "", "", frontend.JOIN_CHAT))
+	m.Usernames[chatID] = "Alice"
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	if view := m.View(); !strings.Contains(view, "user-bob: Message 120") || strings.Contains(view, "Message 71\n") || len(m.Chats[chatID]) != 50 {
+		t.Fatalf("Expected the latest page of messages, got %d messages", len(m.Chats[chatID]))
+	}
+	if m.Chats[chatID][0].Content != "Message 72" || m.Chats[chatID][49].Operation != frontend.JOIN_CHAT {
+		t.Errorf("Expected the stored messages before the received ones, got %v", m.Chats[chatID][0])
+	}
+
+	m.HandleCommand("/loadmessages", "")
+	if len(m.Chats[chatID]) != 100 || m.Chats[chatID][0].Content != "Message 22" {
+		t.Fatalf("Expected /loadmessages to load the previous page, got %d messages", len(m.Chats[chatID]))
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyUp})
+	if len(m.Chats[chatID]) != 121 || !m.Chats[chatID][0].Deleted {
+		t.Fatalf("Expected scrolling up to load the first page with the deletion applied, got %d messages", len(m.Chats[chatID]))
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyPgUp})
+	if !strings.Contains(m.View(), "There are no older messages") || len(m.Chats[chatID]) != 121 {
+		t.Errorf("Expected no older messages, got %d messages", len(m.Chats[chatID]))
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	day := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+	messageStore := &fakeMessageStore{messages: []network.Message{
+		{Id: "exported1", Timestamp: day.UnixNano(), Content: `{"message":"First day"}`, SenderID: "bob", ChatID: "1", Operation: network.SEND_MESSAGE},
+		{Id: "exported2", Timestamp: day.AddDate(0, 0, 1).UnixNano(), Content: `{"message":"Second day"}`, SenderID: "bob", ChatID: "1", Operation: network.SEND_MESSAGE},
+	}}
+	m := frontend.InitialModel()
+	m.ExportDir = t.TempDir()
+	m.Usernames["1"] = "Alice"
+	m.CurrentChat = "1"
+
+	m.HandleCommand("/export", "")
+	if strings.Contains(m.View(), "Chat exported") {
+		t.Errorf("Expected the export to fail without a message store, got %s", m.View())
+	}
+
+	m.MessageStore = messageStore
+	m.HandleCommand("/export", "html 2024-03-02")
+	if !strings.Contains(m.View(), "Chat exported to") {
+		t.Fatalf("Expected the export to succeed, got %s", m.View())
+	}
+	files, _ := filepath.Glob(filepath.Join(m.ExportDir, "*.html"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one HTML transcript, got %v", files)
+	}
+	page, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(page), "user-bob") || !strings.Contains(string(page), "Second day") || strings.Contains(string(page), "First day") {
+		t.Errorf("Expected only the second day with the username of the sender, got %s", page)
+	}
+
+	m.HandleCommand("/export", "")
+	m.HandleCommand("/export", "md")
+	files, _ = filepath.Glob(filepath.Join(m.ExportDir, "*.md"))
+	if len(files) != 2 {
+		t.Errorf("Expected a new markdown file for every export, got %v", files)
+	}
+
+	m.HandleCommand("/export", "md yesterday")
+	files, _ = filepath.Glob(filepath.Join(m.ExportDir, "*"))
+	if len(files) != 3 {
+		t.Errorf("Expected no export with an invalid date, got %v", files)
+	}
+}
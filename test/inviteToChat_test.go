@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
 )
@@ -18,15 +21,20 @@ func TestInviteToChatHandler(t *testing.T) {
 	t.Logf("Using temporary database: %s", dbPath)
 
 	// Initialize storage adapter
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
 	// Create a mock network connection
-	mockNetworkConnection := networkMockAdapter.GetMockConnection()
+	mockNetworkConnection := networkMockAdapter.NewMockConnection()
 	t.Log("Mock network connection created")
 
 	// Create a peer and add the mock network connection
-	peer := messageHandlers.GetPeerInstance()
+	peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
 	peer.AddNetworkConnection(mockNetworkConnection)
 	t.Log("Peer instance created and mock network connection added")
 
@@ -87,3 +95,44 @@ func TestInviteToChatHandler(t *testing.T) {
 
 	t.Log("Invite to chat test passed")
 }
+
+func TestInviteToChatSendsMemberAddresses(t *testing.T) {
+	dbPath := "test_invite_addresses.db"
+	defer os.Remove(dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer adapter.Close()
+
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	sender := messageHandlers.NewMessageSender(securityContext)
+	connection := &recordingConnection{}
+	sender.SetNetworkConnection(connection)
+	chatToNetwork := messageHandlers.NewChatToNetwork(sender, adapter)
+	chatToNetwork.SetIdentity("ws://inviter.onion:2222", nil)
+
+	assert.NoError(t, chatToNetwork.CreateChat("inviteAddressChat", "Address Chat"), "Error creating chat")
+	// the address of a member is known from its join, the address of the other member is unknown
+	assert.NoError(t, adapter.PeerJoinedChat(2, "member.onion", "inviteAddressChat"), "Error adding peer to chat")
+	assert.NoError(t, adapter.StoreMessage(network.Message{Id: "memberJoin", Timestamp: 2, SenderID: "member.onion", SenderAddress: "ws://member.onion:2222", ChatID: "inviteAddressChat", Operation: network.JOIN_CHAT}), "Error storing join")
+	assert.NoError(t, adapter.PeerJoinedChat(3, "quiet.onion", "inviteAddressChat"), "Error adding peer to chat")
+
+	assert.NoError(t, chatToNetwork.InviteToChat("inviteAddressChat", "invitee.onion"), "Error inviting peer")
+	if !assert.NotEmpty(t, connection.sent, "The invitation should be sent") {
+		return
+	}
+	invitation := connection.sent[len(connection.sent)-1]
+	assert.Equal(t, network.INVITE_TO_CHAT, invitation.Operation, "Unexpected operation")
+
+	var content struct {
+		Peers []store.PublicKeyAddress `json:"peers"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(invitation.Content), &content), "Error unmarshalling invitation")
+	assert.ElementsMatch(t, []store.PublicKeyAddress{
+		{PublicKey: "inviter.onion", Address: "ws://inviter.onion:2222"},
+		{PublicKey: "member.onion", Address: "ws://member.onion:2222"},
+		{PublicKey: "quiet.onion", Address: ""},
+	}, content.Peers, "The invitation should name the members with their known addresses")
+}
@@ -0,0 +1,194 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/frontend"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOnionAddress builds the address of a peer with the v3 onion address of the public key
+func testOnionAddress(publicKey ed25519.PublicKey) string {
+	return "ws://" + p_model.OnionAddressFromPublicKey(publicKey) + ":2222"
+}
+
+func TestInviteToken(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err, "Error generating key")
+	address := testOnionAddress(publicKey)
+
+	newToken := func(expiresAt int64) string {
+		token, err := p_model.NewInviteToken("tokenChat", "Token Chat", "tokenInviter", address, expiresAt)
+		assert.NoError(t, err, "Error creating invite token")
+		assert.NoError(t, token.Sign(privateKey), "Error signing invite token")
+
+		uri, err := token.Encode()
+		assert.NoError(t, err, "Error encoding invite token")
+		return uri
+	}
+
+	t.Run("EncodeParseVerify", func(t *testing.T) {
+		uri := newToken(0)
+		assert.True(t, strings.HasPrefix(uri, "skunk://join/"), "Unexpected invite token scheme")
+
+		token, err := p_model.ParseInviteToken(uri)
+		assert.NoError(t, err, "Error parsing invite token")
+		assert.Equal(t, "tokenChat", token.ChatID, "Unexpected chat ID")
+		assert.Equal(t, address, token.InviterAddress, "Unexpected inviter address")
+		assert.NoError(t, token.Verify(time.Now().UnixNano()), "Valid token should verify")
+	})
+
+	t.Run("TamperedToken", func(t *testing.T) {
+		token, err := p_model.ParseInviteToken(newToken(0))
+		assert.NoError(t, err, "Error parsing invite token")
+
+		token.ChatID = "otherChat"
+		assert.Error(t, token.Verify(time.Now().UnixNano()), "Tampered token must not verify")
+	})
+
+	t.Run("ExpiredToken", func(t *testing.T) {
+		token, err := p_model.ParseInviteToken(newToken(1))
+		assert.NoError(t, err, "Error parsing invite token")
+		assert.Error(t, token.Verify(time.Now().UnixNano()), "Expired token must not verify")
+	})
+
+	t.Run("OnionAddress", func(t *testing.T) {
+		parsed, err := p_model.PublicKeyFromOnionAddress(address)
+		assert.NoError(t, err, "Error reading public key from onion address")
+		assert.Equal(t, publicKey, parsed, "Unexpected public key")
+
+		_, err = p_model.PublicKeyFromOnionAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion")
+		assert.NoError(t, err, "A published onion address should be valid")
+
+		host := p_model.OnionHost(address)
+		mistyped := "a" + host[1:]
+		if host[0] == 'a' {
+			mistyped = "b" + host[1:]
+		}
+		_, err = p_model.PublicKeyFromOnionAddress(mistyped)
+		assert.Error(t, err, "Mistyped onion address must have an invalid checksum")
+
+		noChecksum := append(append([]byte{}, publicKey...), 0, 0, 0x03)
+		_, err = p_model.PublicKeyFromOnionAddress(strings.ToLower(base32.StdEncoding.EncodeToString(noChecksum)) + ".onion")
+		assert.Error(t, err, "Onion address without checksum must be invalid")
+
+		decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(strings.TrimSuffix(host, ".onion")))
+		assert.NoError(t, err, "Error decoding onion address")
+		decoded[len(decoded)-1] = 0x02
+		_, err = p_model.PublicKeyFromOnionAddress(strings.ToLower(base32.StdEncoding.EncodeToString(decoded)) + ".onion")
+		assert.Error(t, err, "Onion address with another version must be invalid")
+	})
+
+	t.Run("QRCode", func(t *testing.T) {
+		qrCode, err := frontend.RenderQRCode(newToken(0))
+		assert.NoError(t, err, "Error rendering QR code")
+		assert.NotEmpty(t, qrCode, "QR code should not be empty")
+	})
+}
+
+func TestJoinChatWithInviteToken(t *testing.T) {
+	dbPath := "test_join_with_invite_token.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	joinChatHandler := messageHandlers.NewJoinChatHandler(&MockChatLogic{}, adapter, adapter)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err, "Error generating key")
+	inviterAddress := testOnionAddress(publicKey)
+	inviterID := p_model.OnionHost(inviterAddress)
+
+	err = adapter.PeerJoinedChat(1633029460, inviterID, "tokenChat")
+	assert.NoError(t, err, "Error adding inviter to chat")
+	err = adapter.SetPeerRole(inviterID, "tokenChat", store.ROLE_ADMIN)
+	assert.NoError(t, err, "Error making inviter an admin")
+
+	newContent := func(inviterID string, key ed25519.PrivateKey) string {
+		token, err := p_model.NewInviteToken("tokenChat", "Token Chat", inviterID, testOnionAddress(key.Public().(ed25519.PublicKey)), 0)
+		assert.NoError(t, err, "Error creating invite token")
+		assert.NoError(t, token.Sign(key), "Error signing invite token")
+		uri, err := token.Encode()
+		assert.NoError(t, err, "Error encoding invite token")
+
+		content, err := json.Marshal(struct {
+			InviteToken string `json:"inviteToken"`
+		}{uri})
+		assert.NoError(t, err, "Error marshalling join content")
+		return string(content)
+	}
+	content := newContent(inviterID, privateKey)
+
+	joinMessage := func(senderID string) network.Message {
+		return network.Message{
+			Id:            senderID + "_tokenJoin",
+			Timestamp:     1633029470,
+			Content:       content,
+			SenderID:      senderID,
+			SenderAddress: senderID + ".onion",
+			ChatID:        "tokenChat",
+			Operation:     network.JOIN_CHAT,
+		}
+	}
+
+	assert.True(t, securityContext.ValidateIncomingMessage(joinMessage("newcomer")), "Join with a valid token should be accepted")
+	assert.NoError(t, joinChatHandler.HandleMessage(joinMessage("newcomer")), "Error handling join chat message")
+
+	assert.True(t, securityContext.ValidateIncomingMessage(joinMessage("newcomer")), "The same peer may repeat its join")
+	assert.False(t, securityContext.ValidateIncomingMessage(joinMessage("latecomer")), "A used token must be rejected for other peers")
+
+	wrongChat := joinMessage("wrongChatPeer")
+	wrongChat.ChatID = "otherChat"
+	assert.False(t, securityContext.ValidateIncomingMessage(wrongChat), "A token must only be valid for its chat")
+
+	t.Run("ForgedInviter", func(t *testing.T) {
+		_, strangerKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err, "Error generating key")
+
+		forged := joinMessage("forger")
+		forged.Content = newContent("self", strangerKey)
+		assert.False(t, securityContext.ValidateIncomingMessage(forged), "A token must not name this peer as its inviter")
+
+		forged.Content = newContent(inviterID, strangerKey)
+		assert.False(t, securityContext.ValidateIncomingMessage(forged), "A token must be signed by the onion address of its inviter")
+
+		forged.Content = newContent(p_model.OnionHost(testOnionAddress(strangerKey.Public().(ed25519.PublicKey))), strangerKey)
+		assert.False(t, securityContext.ValidateIncomingMessage(forged), "A token of a peer that is no admin must be rejected")
+	})
+
+	t.Run("OwnToken", func(t *testing.T) {
+		ownPublicKey, ownKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err, "Error generating key")
+		ownAddress := testOnionAddress(ownPublicKey)
+		securityContext.Identity().Set(ownAddress)
+		defer securityContext.Identity().Set("")
+
+		assert.NoError(t, adapter.PeerJoinedChat(1633029460, "self", "tokenChat"), "Error adding self to chat")
+		assert.NoError(t, adapter.SetPeerRole("self", "tokenChat", store.ROLE_OWNER), "Error making self the owner")
+
+		own := joinMessage("ownTokenPeer")
+		own.Content = newContent(p_model.OnionHost(ownAddress), ownKey)
+		assert.True(t, securityContext.ValidateIncomingMessage(own), "A token issued by this peer should be accepted")
+	})
+
+	t.Log("Join chat with invite token test passed")
+}
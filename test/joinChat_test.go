@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
@@ -19,15 +20,20 @@ func TestNetworkToPeerFlow(t *testing.T) {
 	t.Logf("Using temporary database: %s", dbPath)
 
 	// Initialize storage adapter
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
 	// Create a mock network connection
-	mockNetworkConnection := networkMockAdapter.GetMockConnection()
+	mockNetworkConnection := networkMockAdapter.NewMockConnection()
 	t.Log("Mock network connection created")
 
 	// Create a peer and add the mock network connection
-	peer := messageHandlers.GetPeerInstance()
+	peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
 	peer.AddNetworkConnection(mockNetworkConnection)
 	t.Log("Peer instance created and mock network connection added")
 
@@ -109,7 +115,11 @@ func TestJoinChatHandler(t *testing.T) {
 	t.Logf("Using temporary database: %s", dbPath)
 
 	// Initialize storage adapter
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
 	// Create a mock chat logic
@@ -117,7 +127,7 @@ func TestJoinChatHandler(t *testing.T) {
 	t.Log("Mock chat logic created")
 
 	// Create a joinChatHandler with the mock chat logic and storage adapter
-	joinChatHandler := messageHandlers.NewJoinChatHandler(mockChatLogic, adapter)
+	joinChatHandler := messageHandlers.NewJoinChatHandler(mockChatLogic, adapter, adapter)
 	t.Log("Join chat handler created")
 
 	// Prepare a join chat message
@@ -135,7 +145,7 @@ func TestJoinChatHandler(t *testing.T) {
 	t.Logf("Join chat message created: %+v", joinChatMessage)
 
 	// Handle the join chat message
-	err := joinChatHandler.HandleMessage(joinChatMessage)
+	err = joinChatHandler.HandleMessage(joinChatMessage)
 	assert.NoError(t, err, "Error handling join chat message")
 
 	// Verify that the peer joined the chat
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestContacts(t *testing.T) {
+	dbPath := "test_contacts.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err, "Error generating key")
+	address := testOnionAddress(publicKey)
+
+	t.Run("NewContact", func(t *testing.T) {
+		contact, err := p_model.NewContact(" Bob ", address, "met at the conference")
+		assert.NoError(t, err, "Error creating contact")
+		assert.Equal(t, "Bob", contact.Nickname, "The nickname should be trimmed")
+		assert.Equal(t, hex.EncodeToString(publicKey), contact.PublicKey, "The public key should be taken from the onion address")
+		assert.True(t, strings.HasSuffix(contact.Address, ".onion"), "The scheme and port should be removed")
+		assert.False(t, contact.Verified, "New contacts are not verified")
+
+		_, err = p_model.NewContact("", address, "")
+		assert.Error(t, err, "Contacts need a nickname")
+		_, err = p_model.NewContact("Bob", "bob.onion", "")
+		assert.Error(t, err, "Contacts need a v3 onion address")
+
+		contact.PublicKey = hex.EncodeToString(make([]byte, ed25519.PublicKeySize))
+		assert.Error(t, p_model.ValidateContact(contact), "The public key must belong to the address")
+	})
+
+	t.Run("SaveAndRemoveContacts", func(t *testing.T) {
+		bob, err := p_model.NewContact("bob", address, "")
+		assert.NoError(t, err, "Error creating contact")
+		assert.NoError(t, adapter.SaveContact(bob), "Error saving contact")
+
+		otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err, "Error generating key")
+		alice, err := p_model.NewContact("Alice", testOnionAddress(otherKey), "")
+		assert.NoError(t, err, "Error creating contact")
+		assert.NoError(t, adapter.SaveContact(alice), "Error saving contact")
+
+		contacts, err := adapter.GetContacts()
+		assert.NoError(t, err, "Error getting contacts")
+		if assert.Len(t, contacts, 2, "Expected two contacts") {
+			assert.Equal(t, "Alice", contacts[0].Nickname, "Contacts should be sorted by nickname")
+			assert.Equal(t, "bob", contacts[1].Nickname, "Contacts should be sorted by nickname")
+		}
+
+		bob.Nickname = "Bobby"
+		bob.Verified = true
+		bob.Blocked = true
+		bob.Notes = "verified in person"
+		assert.NoError(t, adapter.SaveContact(bob), "Error updating contact")
+		stored, err := adapter.GetContact(bob.Address)
+		assert.NoError(t, err, "Error getting contact")
+		assert.Equal(t, bob, stored, "The contact should be replaced")
+
+		assert.NoError(t, adapter.RemoveContact(bob.Address), "Error removing contact")
+		_, err = adapter.GetContact(bob.Address)
+		assert.Error(t, err, "The contact should be removed")
+		assert.Error(t, adapter.RemoveContact(bob.Address), "Removing a missing contact should fail")
+
+		contacts, err = adapter.GetContacts()
+		assert.NoError(t, err, "Error getting contacts")
+		assert.Equal(t, []store.Contact{alice}, contacts, "Only Alice should be left")
+	})
+
+	t.Log("Contacts test passed")
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func membershipMessage(id string, timestamp int64, senderID string, content string, operation network.OperationType) network.Message {
+	return network.Message{
+		Id:            id,
+		Timestamp:     timestamp,
+		Content:       content,
+		SenderID:      senderID,
+		SenderAddress: senderID + ".onion",
+		ChatID:        "membershipChat",
+		Operation:     operation,
+	}
+}
+
+func TestMembershipCRDT(t *testing.T) {
+	messages := []network.Message{
+		membershipMessage("aliceJoin", 1, "alice", "", network.JOIN_CHAT),
+		membershipMessage("aliceOwner", 1, "alice", `{"peerId":"alice","role":2}`, network.SET_ROLE),
+		membershipMessage("bobJoin", 2, "bob", "", network.JOIN_CHAT),
+		// the chat has an owner already, bob can't claim it
+		membershipMessage("bobOwner", 2, "bob", `{"peerId":"bob","role":2}`, network.SET_ROLE),
+		membershipMessage("bobLeave", 3, "bob", `{"removes":["bobJoin"]}`, network.LEAVE_CHAT),
+		// carol leaves while her second join is still on the way, the concurrent join wins
+		membershipMessage("carolJoin", 4, "carol", "", network.JOIN_CHAT),
+		membershipMessage("carolLeave", 6, "carol", `{"removes":["carolJoin"]}`, network.LEAVE_CHAT),
+		membershipMessage("carolRejoin", 5, "carol", "", network.JOIN_CHAT),
+		// alice kicks dave, the removal must not touch joins of other peers
+		membershipMessage("daveJoin", 7, "dave", "", network.JOIN_CHAT),
+		membershipMessage("daveKick", 8, "alice", `{"peerId":"dave","removes":["daveJoin","aliceJoin"]}`, network.REMOVE_MEMBER),
+		// carol is no admin, her kick of alice is ignored
+		membershipMessage("aliceKick", 8, "carol", `{"peerId":"alice","removes":["aliceJoin"]}`, network.REMOVE_MEMBER),
+		// leaves of older versions don't list the joins and remove the earlier ones
+		membershipMessage("erinJoin", 9, "erin", "", network.JOIN_CHAT),
+		membershipMessage("erinLeave", 10, "erin", "", network.LEAVE_CHAT),
+		membershipMessage("aliceMessage", 11, "alice", "hello", network.SEND_MESSAGE),
+	}
+	expected := []string{"alice", "carol"}
+
+	t.Run("DerivedMembers", func(t *testing.T) {
+		membership := p_model.MembershipFromMessages(messages)
+		assert.Equal(t, expected, membership.Members(), "Unexpected members")
+		assert.Equal(t, []string{"carolRejoin"}, membership.Tags("carol"), "Unexpected tags")
+		assert.Equal(t, []string{"alice", "bob", "carol", "dave", "erin"}, membership.Peers(), "Unexpected peers")
+		assert.Equal(t, store.ROLE_OWNER, membership.Role("alice"), "The first claim should make alice owner")
+		assert.Equal(t, store.ROLE_MEMBER, membership.Role("bob"), "A chat can't be claimed twice")
+	})
+
+	t.Run("OrderIndependent", func(t *testing.T) {
+		for shift := range messages {
+			reordered := append(append([]network.Message{}, messages[shift:]...), messages[:shift]...)
+			assert.Equal(t, expected, p_model.MembershipFromMessages(reordered).Members(), "Members depend on the message order")
+
+			reversed := make([]network.Message, len(reordered))
+			for i, message := range reordered {
+				reversed[len(reordered)-1-i] = message
+			}
+			assert.Equal(t, expected, p_model.MembershipFromMessages(reversed).Members(), "Members depend on the message order")
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		left := p_model.MembershipFromMessages(messages[:5])
+		right := p_model.MembershipFromMessages(messages[5:])
+
+		merged := p_model.NewMembership()
+		merged.Merge(right)
+		merged.Merge(left)
+
+		assert.Contains(t, merged.Members(), "carol", "The concurrent join must survive the merge")
+		assert.NotContains(t, merged.Members(), "bob", "The leave must survive the merge")
+	})
+}
+
+func TestMembershipHandlersConverge(t *testing.T) {
+	dbPath := "test_membership.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	joinChatHandler := messageHandlers.NewJoinChatHandler(&MockChatLogic{}, adapter, adapter)
+	leaveChatHandler := messageHandlers.NewLeaveChatHandler(&MockChatLogic{}, adapter)
+
+	isMember := func(peerID string) bool {
+		users, err := adapter.GetUsersInChat("membershipChat")
+		assert.NoError(t, err, "Error getting users in chat")
+		for _, user := range users {
+			if user.UserId == peerID {
+				return true
+			}
+		}
+		return false
+	}
+
+	// handle messages the way the peer does: store first, then handle
+	handle := func(handler messageHandlers.MessageHandler, message network.Message) {
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+		assert.NoError(t, handler.HandleMessage(message), "Error handling message")
+	}
+
+	// the leave arrives before the join it removes
+	handle(leaveChatHandler, membershipMessage("frankLeave", 2, "frank", `{"removes":["frankJoin"]}`, network.LEAVE_CHAT))
+	handle(joinChatHandler, membershipMessage("frankJoin", 1, "frank", "", network.JOIN_CHAT))
+	assert.False(t, isMember("frank"), "A join removed by an earlier delivered leave must not make the peer a member")
+
+	// a stale leave does not remove a newer join
+	handle(joinChatHandler, membershipMessage("graceJoin", 3, "grace", "", network.JOIN_CHAT))
+	handle(joinChatHandler, membershipMessage("graceRejoin", 5, "grace", "", network.JOIN_CHAT))
+	handle(leaveChatHandler, membershipMessage("graceLeave", 4, "grace", `{"removes":["graceJoin"]}`, network.LEAVE_CHAT))
+	assert.True(t, isMember("grace"), "A concurrent join must win over the leave")
+
+	// the member list can be recomputed from the history
+	assert.NoError(t, adapter.PeerJoinedChat(6, "frank", "membershipChat"), "Error adding peer to chat")
+	assert.NoError(t, adapter.PeerLeftChat("grace", "membershipChat"), "Error removing peer from chat")
+	assert.NoError(t, messageHandlers.RebuildMembership(adapter, "membershipChat"), "Error rebuilding membership")
+	assert.False(t, isMember("frank"), "Rebuild should remove frank again")
+	assert.True(t, isMember("grace"), "Rebuild should add grace again")
+
+	// the creator joins with a JOIN_CHAT message too, so the rebuild keeps it as the owner
+	chatToNetwork := messageHandlers.NewChatToNetwork(messageHandlers.NewMessageSender(p_service.NewSecurityContext(adapter, adapter, adapter, adapter)), adapter)
+	assert.NoError(t, chatToNetwork.CreateChat("createdChat", "Created Chat"), "Error creating chat")
+	messages, err := adapter.GetMembershipMessages("createdChat")
+	assert.NoError(t, err, "Error getting membership messages")
+	assert.Equal(t, []string{"self"}, p_model.MembershipFromMessages(messages).Members(), "The history should contain the join of the creator")
+	assert.Equal(t, store.ROLE_OWNER, p_model.MembershipFromMessages(messages).Role("self"), "The history should make the creator owner")
+
+	assert.NoError(t, messageHandlers.RebuildMembership(adapter, "createdChat"), "Error rebuilding membership")
+	role, err := adapter.GetPeerRole("self", "createdChat")
+	assert.NoError(t, err, "The creator should still be a member")
+	assert.Equal(t, store.ROLE_OWNER, role, "The creator should still be the owner")
+
+	t.Log("Membership handlers test passed")
+}
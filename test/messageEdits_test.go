@@ -0,0 +1,143 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestEditAndDeleteMessageHandler(t *testing.T) {
+	dbPath := "test_edit_message.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	editMessageHandler := messageHandlers.NewEditMessageHandler(mockChatLogic, adapter)
+	deleteMessageHandler := messageHandlers.NewDeleteMessageHandler(mockChatLogic, adapter)
+	t.Log("Edit and delete message handlers created")
+
+	for _, peerID := range []string{"editAuthor", "editOther"} {
+		assert.NoError(t, adapter.PeerJoinedChat(1633029460, peerID, "editChat"), "Error adding peer to chat")
+	}
+
+	newMessage := func(id string, timestamp int64, senderID string, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "?",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "?",
+			ChatID:          "editChat",
+			Operation:       operation,
+		}
+	}
+
+	// handle messages the way the peer does: validate, store, then handle
+	handle := func(handler messageHandlers.MessageHandler, message network.Message) bool {
+		if !securityContext.ValidateIncomingMessage(message) {
+			return false
+		}
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+		assert.NoError(t, handler.HandleMessage(message), "Error handling message")
+		return true
+	}
+
+	original := newMessage("editOriginal", 10, "editAuthor", `{"message":"Helo"}`, network.SEND_MESSAGE)
+	assert.NoError(t, adapter.StoreMessage(original), "Error storing message")
+
+	t.Run("OnlyTheSenderMayEdit", func(t *testing.T) {
+		assert.False(t, handle(editMessageHandler, newMessage("editForeign", 20, "editOther", `{"messageId":"editOriginal","message":"Hacked"}`, network.EDIT_MESSAGE)), "Only the sender may edit")
+		assert.False(t, handle(deleteMessageHandler, newMessage("deleteForeign", 20, "editOther", `{"messageId":"editOriginal"}`, network.DELETE_MESSAGE)), "Only the sender may delete")
+		assert.False(t, handle(editMessageHandler, newMessage("editUnknown", 20, "editAuthor", `{"messageId":"unknown","message":"Hello"}`, network.EDIT_MESSAGE)), "Unknown messages can't be edited")
+	})
+
+	t.Run("EditHistory", func(t *testing.T) {
+		assert.True(t, handle(editMessageHandler, newMessage("edit1", 20, "editAuthor", `{"messageId":"editOriginal","message":"Hello"}`, network.EDIT_MESSAGE)), "The sender may edit")
+		assert.True(t, handle(editMessageHandler, newMessage("edit2", 30, "editAuthor", `{"messageId":"editOriginal","message":"Hello!"}`, network.EDIT_MESSAGE)), "The sender may edit again")
+		assert.Equal(t, "editOriginal", mockChatLogic.LastMessageId, "Unexpected LastMessageId")
+		assert.Equal(t, "Hello!", mockChatLogic.LastMessage, "Unexpected LastMessage")
+
+		edits, err := adapter.GetMessageEdits("editOriginal")
+		assert.NoError(t, err, "Error getting edits")
+		if assert.Len(t, edits, 2, "Unexpected number of edits") {
+			assert.Equal(t, "Hello", edits[0].Content, "Edits should be ordered oldest first")
+			assert.Equal(t, "Hello!", edits[1].Content, "Edits should be ordered oldest first")
+		}
+
+		stored, err := adapter.RetrieveMessage("editOriginal")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, `{"message":"Helo"}`, stored.Content, "The original message should be kept")
+	})
+
+	t.Run("DeleteLeavesTombstone", func(t *testing.T) {
+		assert.True(t, handle(deleteMessageHandler, newMessage("delete1", 40, "editAuthor", `{"messageId":"editOriginal"}`, network.DELETE_MESSAGE)), "The sender may delete")
+
+		deleted, err := adapter.IsMessageDeleted("editOriginal")
+		assert.NoError(t, err, "Error checking deletion")
+		assert.True(t, deleted, "Message should be deleted")
+
+		stored, err := adapter.RetrieveMessage("editOriginal")
+		assert.NoError(t, err, "The tombstone should be kept")
+		assert.Equal(t, `{"message":""}`, stored.Content, "The content should be removed")
+
+		edits, err := adapter.GetMessageEdits("editOriginal")
+		assert.NoError(t, err, "Error getting edits")
+		for _, edit := range edits {
+			assert.Empty(t, edit.Content, "The edit history should be removed")
+			editMessage, err := adapter.RetrieveMessage(edit.EditId)
+			assert.NoError(t, err, "Error retrieving edit message")
+			assert.Equal(t, `{"messageId":"editOriginal","message":""}`, editMessage.Content, "The content of edit messages should be removed")
+		}
+
+		assert.False(t, handle(editMessageHandler, newMessage("edit3", 50, "editAuthor", `{"messageId":"editOriginal","message":"Back"}`, network.EDIT_MESSAGE)), "Deleted messages can't be edited")
+	})
+
+	t.Run("DeletionBeforeMessage", func(t *testing.T) {
+		// a sync can deliver the deletion before the message itself
+		assert.NoError(t, adapter.MessageDeleted(60, "editLate"), "Error deleting message")
+		assert.NoError(t, adapter.StoreMessage(newMessage("editLate", 55, "editAuthor", `{"message":"Secret"}`, network.SEND_MESSAGE)), "Error storing message")
+
+		stored, err := adapter.RetrieveMessage("editLate")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, `{"message":""}`, stored.Content, "A message deleted before it arrived must not keep its content")
+	})
+
+	t.Run("LocalChangesOfOwnMessagesOnly", func(t *testing.T) {
+		chatToNetwork := messageHandlers.NewChatToNetwork(messageHandlers.NewMessageSender(securityContext), adapter)
+		assert.NoError(t, adapter.StoreMessage(newMessage("editOwn", 70, "self", `{"message":"Mine"}`, network.SEND_MESSAGE)), "Error storing message")
+		assert.NoError(t, adapter.StoreMessage(newMessage("editTheirs", 70, "editOther", `{"message":"Theirs"}`, network.SEND_MESSAGE)), "Error storing message")
+
+		assert.Error(t, chatToNetwork.EditMessage("editChat", "editTheirs", "Changed"), "Messages of other peers can't be edited")
+		assert.Error(t, chatToNetwork.DeleteMessage("editChat", "editTheirs"), "Messages of other peers can't be deleted")
+		assert.Error(t, chatToNetwork.EditMessage("editOtherChat", "editOwn", "Changed"), "Messages of other chats can't be edited")
+		deleted, err := adapter.IsMessageDeleted("editTheirs")
+		assert.NoError(t, err, "Error checking deletion")
+		assert.False(t, deleted, "The message of the other peer must not be deleted")
+		edits, err := adapter.GetMessageEdits("editTheirs")
+		assert.NoError(t, err, "Error getting edits")
+		assert.Empty(t, edits, "The message of the other peer must not be edited")
+
+		assert.NoError(t, chatToNetwork.EditMessage("editChat", "editOwn", "Still mine"), "Own messages can be edited")
+		edits, err = adapter.GetMessageEdits("editOwn")
+		assert.NoError(t, err, "Error getting edits")
+		assert.Len(t, edits, 1, "The edit should be stored")
+		assert.NoError(t, chatToNetwork.DeleteMessage("editChat", "editOwn"), "Own messages can be deleted")
+		assert.Error(t, chatToNetwork.DeleteMessage("editChat", "editOwn"), "Deleted messages can't be deleted again")
+	})
+
+	t.Log("Edit and delete message handler test passed")
+}
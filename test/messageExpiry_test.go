@@ -0,0 +1,198 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingConnection keeps every message that is sent to the network
+type recordingConnection struct {
+	sent []network.Message
+}
+
+func (r *recordingConnection) SubscribeToNetwork(observer network.NetworkObserver) error { return nil }
+func (r *recordingConnection) UnsubscribeFromNetwork() error                             { return nil }
+func (r *recordingConnection) SendMessageToNetworkPeer(message network.Message) error {
+	r.sent = append(r.sent, message)
+	return nil
+}
+
+func TestMessageExpiry(t *testing.T) {
+	dbPath := "test_message_expiry.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	fileDir := t.TempDir()
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	updateChatSettingsHandler := messageHandlers.NewUpdateChatSettingsHandler(mockChatLogic, adapter)
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(adapter, adapter, adapter, adapter, adapter, adapter, adapter, adapter, securityContext)
+	messageExpirer := messageHandlers.NewMessageExpirer(adapter, adapter, fileDir)
+	t.Log("Chat settings handler and message expirer created")
+
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "expiryAdmin", "expiryChat"), "Error adding admin to chat")
+	assert.NoError(t, adapter.SetPeerRole("expiryAdmin", "expiryChat", store.ROLE_ADMIN), "Error making peer an admin")
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "expiryMember", "expiryChat"), "Error adding member to chat")
+
+	now := time.Now().UnixNano()
+	newMessage := func(id string, timestamp int64, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        "expiryAdmin",
+			ReceiverID:      "?",
+			SenderAddress:   "expiryAdmin.onion",
+			ReceiverAddress: "?",
+			ChatID:          "expiryChat",
+			Operation:       operation,
+		}
+	}
+
+	t.Run("OnlyAdminsChangeSettings", func(t *testing.T) {
+		memberSettings := newMessage("expiryMemberSettings", now, `{"messageExpiry":3600}`, network.UPDATE_CHAT_SETTINGS)
+		memberSettings.SenderID = "expiryMember"
+		assert.False(t, securityContext.ValidateIncomingMessage(memberSettings), "Members must not change the settings")
+		assert.False(t, securityContext.ValidateIncomingMessage(newMessage("expiryTooShort", now, `{"messageExpiry":1}`, network.UPDATE_CHAT_SETTINGS)), "Too short expiries must be rejected")
+		assert.False(t, securityContext.ValidateIncomingMessage(newMessage("expiryEmpty", now, `{}`, network.UPDATE_CHAT_SETTINGS)), "Empty settings must be rejected")
+
+		settings := newMessage("expirySettings", now, `{"messageExpiry":3600}`, network.UPDATE_CHAT_SETTINGS)
+		assert.True(t, securityContext.ValidateIncomingMessage(settings), "Admins may change the settings")
+		assert.NoError(t, updateChatSettingsHandler.HandleMessage(settings), "Error handling chat settings")
+		assert.Equal(t, time.Hour, mockChatLogic.LastExpiry, "Unexpected LastExpiry")
+
+		// an older setting that arrives late must not overwrite the newer one
+		assert.NoError(t, updateChatSettingsHandler.HandleMessage(newMessage("expiryOldSettings", now-1, `{"messageExpiry":0}`, network.UPDATE_CHAT_SETTINGS)), "Error handling chat settings")
+		expiry, err := adapter.GetMessageExpiry("expiryChat")
+		assert.NoError(t, err, "Error getting message expiry")
+		assert.Equal(t, time.Hour.Nanoseconds(), expiry, "Older settings must be ignored")
+	})
+
+	t.Run("SyncedSettingsNeedAdmins", func(t *testing.T) {
+		memberSettings := newMessage("expirySyncedMemberSettings", now+1, `{"messageExpiry":60}`, network.UPDATE_CHAT_SETTINGS)
+		memberSettings.SenderID = "expiryMember"
+		memberSettings.SenderAddress = "expiryMember.onion"
+		content, err := json.Marshal([]network.Message{memberSettings})
+		assert.NoError(t, err, "Error marshalling synced messages")
+
+		assert.NoError(t, syncResponseHandler.HandleMessage(newMessage("expirySettingsSyncResponse", now+2, string(content), network.SYNC_RESPONSE)), "Error handling sync response")
+		expiry, err := adapter.GetMessageExpiry("expiryChat")
+		assert.NoError(t, err, "Error getting message expiry")
+		assert.Equal(t, time.Hour.Nanoseconds(), expiry, "Synced settings of members must be ignored")
+		_, err = adapter.RetrieveMessage("expirySyncedMemberSettings")
+		assert.Error(t, err, "Synced settings of members must not be stored")
+	})
+
+	t.Run("PurgeExpiredMessages", func(t *testing.T) {
+		fileData := []byte("expired file")
+		fileContent, err := json.Marshal(map[string]string{"fileName": "expired", "fileExtension": "txt", "fileContent": base64.StdEncoding.EncodeToString(fileData)})
+		assert.NoError(t, err, "Error marshalling file content")
+		filePath := filepath.Join(fileDir, "expired.txt")
+		assert.NoError(t, os.WriteFile(filePath, fileData, 0600), "Error writing file")
+
+		old := now - 2*time.Hour.Nanoseconds()
+		for _, message := range []network.Message{
+			newMessage("expiryOld", old, `{"message":"old"}`, network.SEND_MESSAGE),
+			newMessage("expiryOldFile", old, string(fileContent), network.SEND_FILE),
+			newMessage("expiryOldJoin", old, `{}`, network.JOIN_CHAT),
+			newMessage("expiryNew", now, `{"message":"new"}`, network.SEND_MESSAGE),
+		} {
+			assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+		}
+
+		// the same file was sent to a chat without expiry, it is stored once and must be kept for that chat
+		sharedData := []byte("shared file")
+		sharedContent, err := json.Marshal(map[string]string{"fileName": "shared", "fileExtension": "txt", "fileContent": base64.StdEncoding.EncodeToString(sharedData)})
+		assert.NoError(t, err, "Error marshalling file content")
+		sharedPath := filepath.Join(fileDir, "shared.txt")
+		assert.NoError(t, os.WriteFile(sharedPath, sharedData, 0600), "Error writing file")
+		assert.NoError(t, adapter.ChatCreated("Keep Chat", "expiryKeepChat"), "Error creating chat")
+		assert.NoError(t, adapter.StoreMessage(newMessage("expiryOldSharedFile", old, string(sharedContent), network.SEND_FILE)), "Error storing message")
+		keptFile := newMessage("expiryKeptSharedFile", old, string(sharedContent), network.SEND_FILE)
+		keptFile.ChatID = "expiryKeepChat"
+		assert.NoError(t, adapter.StoreMessage(keptFile), "Error storing message")
+
+		_, err = adapter.PeerReacted(now, "expiryReaction", "expiryOld", "expiryMember", "👍", true)
+		assert.NoError(t, err, "Error storing reaction")
+
+		assert.NoError(t, messageExpirer.PurgeExpiredMessages(now), "Error purging expired messages")
+
+		_, err = adapter.RetrieveMessage("expiryOld")
+		assert.Error(t, err, "Expired messages should be removed")
+		_, err = adapter.RetrieveMessage("expiryOldFile")
+		assert.Error(t, err, "Expired files should be removed")
+		_, err = os.Stat(filePath)
+		assert.True(t, os.IsNotExist(err), "The stored file should be removed")
+		_, err = adapter.RetrieveMessage("expiryOldSharedFile")
+		assert.Error(t, err, "Expired files should be removed")
+		_, err = os.Stat(sharedPath)
+		assert.NoError(t, err, "Files other chats still refer to must be kept")
+
+		reactions, err := adapter.GetReactions("expiryOld")
+		assert.NoError(t, err, "Error getting reactions")
+		assert.Empty(t, reactions, "Reactions to expired messages should be removed")
+
+		_, err = adapter.RetrieveMessage("expiryOldJoin")
+		assert.NoError(t, err, "Membership messages must be kept")
+		_, err = adapter.RetrieveMessage("expiryNew")
+		assert.NoError(t, err, "Messages that did not expire must be kept")
+	})
+
+	t.Run("SyncRefusesExpiredMessages", func(t *testing.T) {
+		content, err := json.Marshal([]network.Message{
+			newMessage("expirySyncedOld", now-2*time.Hour.Nanoseconds(), `{"message":"old"}`, network.SEND_MESSAGE),
+			newMessage("expirySyncedNew", now, `{"message":"new"}`, network.SEND_MESSAGE),
+		})
+		assert.NoError(t, err, "Error marshalling synced messages")
+
+		assert.NoError(t, syncResponseHandler.HandleMessage(newMessage("expirySyncResponse", now, string(content), network.SYNC_RESPONSE)), "Error handling sync response")
+		_, err = adapter.RetrieveMessage("expirySyncedOld")
+		assert.Error(t, err, "Expired messages must not be accepted")
+		_, err = adapter.RetrieveMessage("expirySyncedNew")
+		assert.NoError(t, err, "Messages that did not expire must be accepted")
+
+		// an expired message that was not purged yet must not be served
+		assert.NoError(t, adapter.StoreMessage(newMessage("expiryNotPurged", now-2*time.Hour.Nanoseconds(), `{"message":"old"}`, network.SEND_MESSAGE)), "Error storing message")
+
+		connection := &recordingConnection{}
+		sender := messageHandlers.NewMessageSender(securityContext)
+		sender.SetNetworkConnection(connection)
+		syncRequestHandler := messageHandlers.NewSyncRequestHandler(adapter, adapter, adapter, adapter, sender)
+
+		syncRequest := newMessage("expirySyncRequest", now, `{"existingMessageIds": ["expirySyncedNew"]}`, network.SYNC_REQUEST)
+		syncRequest.SenderID = "expiryMember"
+		assert.NoError(t, syncRequestHandler.HandleMessage(syncRequest), "Error handling sync request")
+
+		if assert.NotEmpty(t, connection.sent, "A sync response should be sent") {
+			var served []network.Message
+			assert.NoError(t, json.Unmarshal([]byte(connection.sent[0].Content), &served), "Error unmarshalling sync response")
+
+			servedIDs := []string{}
+			for _, message := range served {
+				servedIDs = append(servedIDs, message.Id)
+			}
+			assert.Contains(t, servedIDs, "expiryNew", "Messages that did not expire should be served")
+			assert.NotContains(t, servedIDs, "expiryNotPurged", "Expired messages must not be served")
+		}
+	})
+
+	t.Log("Message expiry test passed")
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestChatMessagePages(t *testing.T) {
+	dbPath := "test_message_pages.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	// The messages are stored out of order, page5 and page6 have the same timestamp
+	for _, i := range []int{4, 1, 6, 2, 5, 3, 7} {
+		timestamp := int64(i * 10)
+		if i == 6 {
+			timestamp = 50
+		}
+		assert.NoError(t, adapter.StoreMessage(network.Message{
+			Id:              fmt.Sprintf("page%d", i),
+			Timestamp:       timestamp,
+			Content:         fmt.Sprintf(`{"message":"Message %d"}`, i),
+			SenderID:        "pageSender",
+			ReceiverID:      "pageReceiver",
+			SenderAddress:   "pagesender.onion",
+			ReceiverAddress: "pagereceiver.onion",
+			ChatID:          "pageChat",
+			Operation:       network.SEND_MESSAGE,
+		}), "Error storing message")
+	}
+	assert.NoError(t, adapter.StoreMessage(network.Message{
+		Id: "pageOtherChat", Timestamp: 35, Content: `{"message":"Elsewhere"}`, SenderID: "pageSender", ReceiverID: "pageReceiver", ChatID: "pageOtherChat", Operation: network.SEND_MESSAGE,
+	}), "Error storing message")
+
+	ids := func(messages []network.Message) []string {
+		found := []string{}
+		for _, message := range messages {
+			found = append(found, message.Id)
+		}
+		return found
+	}
+
+	messages, err := adapter.GetChatMessages("pageChat")
+	assert.NoError(t, err, "Error getting messages")
+	assert.Equal(t, []string{"page1", "page2", "page3", "page4", "page5", "page6", "page7"}, ids(messages), "Expected all messages, oldest first")
+
+	for _, test := range []struct {
+		name     string
+		page     store.MessagePage
+		expected []string
+	}{
+		{"Latest", store.MessagePage{ChatID: "pageChat", Limit: 3}, []string{"page5", "page6", "page7"}},
+		{"Before", store.MessagePage{ChatID: "pageChat", Before: "page6", Limit: 3}, []string{"page3", "page4", "page5"}},
+		{"BeforeFirst", store.MessagePage{ChatID: "pageChat", Before: "page2", Limit: 3}, []string{"page1"}},
+		{"After", store.MessagePage{ChatID: "pageChat", After: "page5", Limit: 1}, []string{"page6"}},
+		{"Between", store.MessagePage{ChatID: "pageChat", After: "page2", Before: "page6", Limit: 10}, []string{"page3", "page4", "page5"}},
+		{"AllBefore", store.MessagePage{ChatID: "pageChat", Before: "page3"}, []string{"page1", "page2"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			messages, err := adapter.GetChatMessagePage(test.page)
+			assert.NoError(t, err, "Error getting page")
+			assert.Equal(t, test.expected, ids(messages), "Unexpected page")
+		})
+	}
+
+	t.Run("UnknownCursor", func(t *testing.T) {
+		_, err := adapter.GetChatMessagePage(store.MessagePage{ChatID: "pageChat", Before: "pageMissing", Limit: 3})
+		assert.Error(t, err, "An unknown cursor should fail")
+		_, err = adapter.GetChatMessagePage(store.MessagePage{ChatID: "pageChat", After: "pageOtherChat", Limit: 3})
+		assert.Error(t, err, "A cursor of another chat should fail")
+	})
+
+	t.Log("Chat message pages test passed")
+}
@@ -1,5 +1,10 @@
 package test
 
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"time"
+)
+
 type MockChatLogic struct {
 	LastSenderId    string
 	LastChatId      string
@@ -10,6 +15,21 @@ type MockChatLogic struct {
 	LastFileData    string
 	LastMessage     string
 	LastUsername    string
+	LastInvitation  string
+	LastStatus      store.InvitationStatus
+	LastPeerId      string
+	LastRole        store.ChatRole
+	LastBanned      bool
+	LastField       store.ChatMetadataField
+	LastValue       string
+	LastMessageId   string
+	LastEmoji       string
+	LastAdd         bool
+	LastExpiry      time.Duration
+	LastTyping      bool
+	LastAddress     string
+	LastOnline      bool
+	LastSeen        int64
 	LogEntries      []string
 }
 
@@ -17,6 +37,15 @@ func (m *MockChatLogic) log(message string) {
 	m.LogEntries = append(m.LogEntries, message)
 }
 
+func (m *MockChatLogic) ReceiveReply(senderId string, chatId string, message string, replyTo string) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastMessage = message
+	m.LastMessageId = replyTo
+	m.log("ReceiveReply called")
+	return nil
+}
+
 func (m *MockChatLogic) ReceiveMessage(senderId string, chatId string, message string) error {
 	m.LastSenderId = senderId
 	m.LastChatId = chatId
@@ -63,3 +92,90 @@ func (m *MockChatLogic) PeerSetsUsername(senderId string, chatId string, usernam
 	m.log("PeerSetsUsername called")
 	return nil
 }
+
+func (m *MockChatLogic) ReceiveInvitationResponse(senderId string, chatId string, invitationId string, status store.InvitationStatus) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastInvitation = invitationId
+	m.LastStatus = status
+	m.log("ReceiveInvitationResponse called")
+	return nil
+}
+
+func (m *MockChatLogic) PeerRoleChanged(senderId string, chatId string, peerId string, role store.ChatRole) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastPeerId = peerId
+	m.LastRole = role
+	m.log("PeerRoleChanged called")
+	return nil
+}
+
+func (m *MockChatLogic) PeerRemovedFromChat(senderId string, chatId string, peerId string, banned bool) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastPeerId = peerId
+	m.LastBanned = banned
+	m.log("PeerRemovedFromChat called")
+	return nil
+}
+
+func (m *MockChatLogic) ChatMetadataChanged(senderId string, chatId string, field store.ChatMetadataField, value string) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastField = field
+	m.LastValue = value
+	m.log("ChatMetadataChanged called")
+	return nil
+}
+
+func (m *MockChatLogic) MessageEdited(senderId string, chatId string, messageId string, content string) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastMessageId = messageId
+	m.LastMessage = content
+	m.log("MessageEdited called")
+	return nil
+}
+
+func (m *MockChatLogic) MessageDeleted(senderId string, chatId string, messageId string) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastMessageId = messageId
+	m.log("MessageDeleted called")
+	return nil
+}
+
+func (m *MockChatLogic) ReactionChanged(senderId string, chatId string, messageId string, emoji string, add bool) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastMessageId = messageId
+	m.LastEmoji = emoji
+	m.LastAdd = add
+	m.log("ReactionChanged called")
+	return nil
+}
+
+func (m *MockChatLogic) ChatSettingsChanged(senderId string, chatId string, messageExpiry time.Duration) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastExpiry = messageExpiry
+	m.log("ChatSettingsChanged called")
+	return nil
+}
+
+func (m *MockChatLogic) PeerTyping(senderId string, chatId string, typing bool) error {
+	m.LastSenderId = senderId
+	m.LastChatId = chatId
+	m.LastTyping = typing
+	m.log("PeerTyping called")
+	return nil
+}
+
+func (m *MockChatLogic) PresenceChanged(address string, online bool, lastSeen int64) error {
+	m.LastAddress = address
+	m.LastOnline = online
+	m.LastSeen = lastSeen
+	m.log("PresenceChanged called")
+	return nil
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTwoNodesInOneProcess builds two ephemeral nodes and verifies that they share no state.
+func TestTwoNodesInOneProcess(t *testing.T) {
+	connection1 := networkMockAdapter.NewMockConnection()
+	connection2 := networkMockAdapter.NewMockConnection()
+
+	node1, err := node.New(node.Config{Ephemeral: true, Network: connection1})
+	if !assert.NoError(t, err, "Error creating the first node") {
+		return
+	}
+	defer node1.Close()
+	node2, err := node.New(node.Config{Ephemeral: true, Network: connection2})
+	if !assert.NoError(t, err, "Error creating the second node") {
+		return
+	}
+	defer node2.Close()
+
+	assert.NotSame(t, node1.Peer, node2.Peer, "Every node needs its own peer")
+	assert.NotEqual(t, node1.FileDir, node2.FileDir, "Every ephemeral node needs its own file directory")
+
+	testMessage := network.Message{
+		Id:              "nodeMessage",
+		Timestamp:       1633029445,
+		Content:         "Hello World!",
+		SenderID:        "user1",
+		ReceiverID:      "user2",
+		SenderAddress:   "user1.onion",
+		ReceiverAddress: "user2.onion",
+		ChatID:          "chat1",
+		Operation:       network.TEST_MESSAGE,
+	}
+	assert.NoError(t, connection1.SendMockNetworkMessageToSubscribers(testMessage))
+
+	_, err = node1.Storage.RetrieveMessage("nodeMessage")
+	assert.NoError(t, err, "The message should be stored by the node that received it")
+	_, err = node2.Storage.RetrieveMessage("nodeMessage")
+	assert.Error(t, err, "The other node must not share the storage")
+}
+
+// TestEphemeralNodeRemovesItsFiles verifies that nothing of an ephemeral node is left after Close.
+func TestEphemeralNodeRemovesItsFiles(t *testing.T) {
+	n, err := node.New(node.Config{Ephemeral: true})
+	if !assert.NoError(t, err, "Error creating the node") {
+		return
+	}
+
+	_, err = os.Stat(n.FileDir)
+	assert.NoError(t, err, "The file directory should exist while the node runs")
+
+	assert.NoError(t, n.Close(), "Error closing the node")
+	_, err = os.Stat(n.FileDir)
+	assert.True(t, os.IsNotExist(err), "The file directory should be removed on Close")
+}
+
+// TestPersistentNodeUsesItsDatabase verifies that a node keeps its data in the configured database.
+func TestPersistentNodeUsesItsDatabase(t *testing.T) {
+	dir := t.TempDir()
+	config := node.Config{DatabasePath: filepath.Join(dir, "node.db"), FileDir: filepath.Join(dir, "files")}
+
+	n, err := node.New(config)
+	if !assert.NoError(t, err, "Error creating the node") {
+		return
+	}
+	testMessage := network.Message{
+		Id:        "persistentMessage",
+		Timestamp: 1633029445,
+		Content:   "Hello World!",
+		SenderID:  "user1",
+		ChatID:    "chat1",
+		Operation: network.SEND_MESSAGE,
+	}
+	assert.NoError(t, n.Storage.StoreMessage(testMessage))
+	assert.NoError(t, n.Close(), "Error closing the node")
+
+	n, err = node.New(config)
+	if !assert.NoError(t, err, "Error reopening the node") {
+		return
+	}
+	defer n.Close()
+
+	retrievedMessage, err := n.Storage.RetrieveMessage("persistentMessage")
+	assert.NoError(t, err)
+	assert.Equal(t, testMessage, retrievedMessage, "The data should survive a restart of the node")
+}
+
+// TestNodeIdentity verifies that the node signs invite tokens with the key of its onion address
+// and that the other peers see the onion address instead of "self".
+func TestNodeIdentity(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if !assert.NoError(t, err, "Error generating key") {
+		return
+	}
+	address := testOnionAddress(publicKey)
+	connection := networkMockAdapter.NewMockConnection()
+	connection.Address = address
+	connection.Key = privateKey
+
+	n, err := node.New(node.Config{Ephemeral: true, Network: connection})
+	if !assert.NoError(t, err, "Error creating the node") {
+		return
+	}
+	defer n.Close()
+
+	assert.Equal(t, address, n.Peer.Address, "The node should know its address once it is online")
+	assert.Equal(t, p_model.OnionHost(address), n.Peer.ID, "The node should be known by its onion host")
+
+	assert.NoError(t, n.ChatToNetwork.CreateChat("identityChat", "Identity Chat"), "Error creating chat")
+	assert.Equal(t, p_model.OnionHost(address), connection.LastSent.SenderID, "The other peers must not see \"self\"")
+	assert.Equal(t, address, connection.LastSent.SenderAddress, "The other peers must not see \"self\"")
+	assert.Contains(t, connection.LastSent.Content, p_model.OnionHost(address), "The owner must be named by its onion host")
+
+	uri, err := n.ChatToNetwork.CreateInviteToken("identityChat")
+	if !assert.NoError(t, err, "The node should create invite tokens while online") {
+		return
+	}
+	token, err := p_model.ParseInviteToken(uri)
+	assert.NoError(t, err, "Error parsing invite token")
+	assert.Equal(t, p_model.OnionHost(address), token.InviterID, "The inviter should be the onion host")
+	assert.NoError(t, token.Verify(1), "The token should be signed with the key of the onion address")
+
+	stored, err := n.Storage.RetrieveMessage(connection.LastSent.Id)
+	assert.NoError(t, err, "Error retrieving the sent message")
+	assert.Equal(t, "self", stored.SenderID, "The node stores itself as \"self\"")
+}
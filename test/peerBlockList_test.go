@@ -0,0 +1,132 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBlockList(t *testing.T) {
+	dbPath := "test_block_list.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	connection := &recordingConnection{}
+	sender := messageHandlers.NewMessageSender(securityContext)
+	sender.SetNetworkConnection(connection)
+	chatToNetwork := messageHandlers.NewChatToNetwork(sender, adapter)
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(adapter, adapter, adapter, adapter, adapter, adapter, adapter, adapter, securityContext)
+	t.Log("Security context and handlers created")
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err, "Error generating key")
+	blockedAddress := testOnionAddress(publicKey)
+
+	assert.NoError(t, adapter.ChatCreated("Block Chat", "blockChat"), "Error creating chat")
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "self", "blockChat"), "Error adding self to chat")
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "blockedPeer", "blockChat"), "Error adding peer to chat")
+
+	newMessage := func(id string, senderID string, senderAddress string, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       time.Now().UnixNano(),
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "?",
+			SenderAddress:   senderAddress,
+			ReceiverAddress: "?",
+			ChatID:          "blockChat",
+			Operation:       operation,
+		}
+	}
+
+	t.Run("BlockPeer", func(t *testing.T) {
+		assert.True(t, securityContext.ValidatePeer(blockedAddress), "Peers are not blocked by default")
+
+		assert.NoError(t, chatToNetwork.BlockPeer(blockedAddress), "Error blocking peer")
+		assert.NoError(t, chatToNetwork.BlockPeer(blockedAddress), "Blocking a peer twice should not fail")
+		assert.False(t, securityContext.ValidatePeer(blockedAddress), "Blocked peers must be rejected")
+		assert.False(t, securityContext.ValidatePeer(p_model.OnionHost(blockedAddress)), "Blocked peers must be rejected without scheme and port")
+
+		blocked, err := adapter.GetBlockedPeers()
+		assert.NoError(t, err, "Error getting blocked peers")
+		assert.Equal(t, []string{p_model.OnionHost(blockedAddress)}, blocked, "Unexpected block list")
+
+		outgoing := newMessage("blockOutgoing", "self", "self", `{"message":"Hi"}`, network.SEND_MESSAGE)
+		outgoing.ReceiverAddress = blockedAddress
+		assert.False(t, securityContext.ValidateOutgoingMessage(outgoing), "Nothing must be sent to blocked peers")
+	})
+
+	t.Run("ContactsShowBlockList", func(t *testing.T) {
+		contact, err := p_model.NewContact("Mallory", blockedAddress, "")
+		assert.NoError(t, err, "Error creating contact")
+		contact.Blocked = true
+		assert.NoError(t, adapter.SaveContact(contact), "Error saving contact")
+
+		stored, err := adapter.GetContact(contact.Address)
+		assert.NoError(t, err, "Error getting contact")
+		assert.True(t, stored.Blocked, "The contact should be blocked")
+
+		assert.NoError(t, chatToNetwork.UnblockPeer(blockedAddress), "Error unblocking peer")
+		stored, err = adapter.GetContact(contact.Address)
+		assert.NoError(t, err, "Error getting contact")
+		assert.False(t, stored.Blocked, "Unblocking should change the contact")
+
+		assert.NoError(t, adapter.SaveContact(contact), "Error saving contact")
+		assert.False(t, securityContext.ValidatePeer(blockedAddress), "Blocking the contact should block the peer")
+	})
+
+	t.Run("DropMessages", func(t *testing.T) {
+		peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+		defer peer.Close()
+		message := newMessage("blockDropped", "blockedPeer", blockedAddress, `{"message":"Hi"}`, network.SEND_MESSAGE)
+		assert.NoError(t, peer.Notify(message), "Dropping a message must not fail, the peer must not learn that it was blocked")
+
+		_, err := adapter.RetrieveMessage("blockDropped")
+		assert.Error(t, err, "Messages of blocked peers must not be stored")
+	})
+
+	t.Run("ExcludeFromInvitations", func(t *testing.T) {
+		assert.Error(t, chatToNetwork.InviteToChat("blockChat", blockedAddress), "Blocked peers must not be invited")
+		assert.Error(t, chatToNetwork.SendDirectMessage("blockedPeer", blockedAddress, "Hi"), "Blocked peers must not get direct messages")
+		assert.Empty(t, connection.sent, "Nothing should be sent to blocked peers")
+	})
+
+	t.Run("ExcludeFromSync", func(t *testing.T) {
+		assert.NoError(t, adapter.PeerJoinedChat(1633029460, "otherPeer", "blockChat"), "Error adding peer to chat")
+		content, err := json.Marshal([]network.Message{
+			newMessage("blockSyncedMessage", "blockedPeer", blockedAddress, `{"message":"Hi"}`, network.SEND_MESSAGE),
+			newMessage("blockSyncedLeave", "blockedPeer", blockedAddress, "", network.LEAVE_CHAT),
+			newMessage("blockSyncedOther", "otherPeer", "other.onion", `{"message":"Hi"}`, network.SEND_MESSAGE),
+		})
+		assert.NoError(t, err, "Error marshalling synced messages")
+
+		assert.NoError(t, syncResponseHandler.HandleMessage(newMessage("blockSyncResponse", "otherPeer", "other.onion", string(content), network.SYNC_RESPONSE)), "Error handling sync response")
+		_, err = adapter.RetrieveMessage("blockSyncedMessage")
+		assert.Error(t, err, "Synced messages of blocked peers must be dropped")
+		_, err = adapter.RetrieveMessage("blockSyncedLeave")
+		assert.NoError(t, err, "Membership messages of blocked peers must be kept")
+		_, err = adapter.RetrieveMessage("blockSyncedOther")
+		assert.NoError(t, err, "Messages of other peers must be accepted")
+	})
+
+	t.Log("Block list test passed")
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkAdapter"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/peer"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/tor"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/scherzma/Skunk/cmd/skunk/util"
@@ -15,7 +17,7 @@ import (
 )
 
 /*
-We have to test sending and receiving a message separately, because the application peer is a singleton and therefore can only exist once and because we cannot send messages from the same socket to the same socket, we need an application peer once and the network peer once, then the network peer acts once as a client and once as a server.
+We have to test sending and receiving a message separately, because the network adapter of the application peer runs Tor on fixed ports and therefore can only exist once and because we cannot send messages from the same socket to the same socket, we need an application peer once and the network peer once, then the network peer acts once as a client and once as a server.
 Since the embedded tor process is already used in the application peer, the network peer must run over the locally installed Tor version.
 */
 
@@ -24,14 +26,15 @@ func TestNetworkAdapterSendMessage(t *testing.T) {
 		Id:        util.UUID(),
 		Timestamp: util.CurrentTimeMillis(),
 		Content:   "",
-		FromUser:  "Alice",
+		SenderID:  "Alice",
 		ChatID:    "1",
 		Operation: network.TEST_MESSAGE,
 	}
 	testMessageJson, _ := json.Marshal(testMessage)
 
-	peerInstance := messageHandlers.GetPeerInstance()
-	networkConnection := networkAdapter.NewAdapter()
+	peerInstance := messageHandlers.NewPeer(storageMemoryAdapter.NewStorageMemoryAdapter(), c_service.NewChatApp(), t.TempDir())
+	defer peerInstance.Close()
+	networkConnection := networkAdapter.NewAdapter(tor.DefaultConfig())
 	peerInstance.AddNetworkConnection(networkConnection)
 
 	conf := &tor.TorConfig{
@@ -64,7 +67,9 @@ func TestNetworkAdapterSendMessage(t *testing.T) {
 
 	go peerNetworkInstance.ReadMessages(messageCh, errorCh)
 
-	err := peerInstance.SendMessageToNetworkPeer(peerNetworkInstance.Address, testMessage)
+	testMessage.ReceiverAddress = peerNetworkInstance.Address
+	testMessageJson, _ = json.Marshal(testMessage)
+	err := networkConnection.SendMessageToNetworkPeer(testMessage)
 	assert.NoError(t, err)
 
 	time.Sleep(1 * time.Minute)
@@ -86,13 +91,14 @@ func TestNetworkAdapterReceiveMessage(t *testing.T) {
 		Id:        util.UUID(),
 		Timestamp: util.CurrentTimeMillis(),
 		Content:   `"ws://testworked.onion:1111"`,
-		FromUser:  "Bob",
+		SenderID:  "Bob",
 		Operation: network.NETWORK_ONLINE,
 	}
 	testMessageJson, _ := json.Marshal(testMessage)
 
-	peerInstance := messageHandlers.GetPeerInstance()
-	networkConnection := networkAdapter.NewAdapter()
+	peerInstance := messageHandlers.NewPeer(storageMemoryAdapter.NewStorageMemoryAdapter(), c_service.NewChatApp(), t.TempDir())
+	defer peerInstance.Close()
+	networkConnection := networkAdapter.NewAdapter(tor.DefaultConfig())
 	peerInstance.AddNetworkConnection(networkConnection)
 
 	conf := &tor.TorConfig{
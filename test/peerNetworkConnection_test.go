@@ -2,28 +2,53 @@ package test
 
 import (
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
-// TestGetPeerInstance tests the GetPeerInstance function of the messageHandlers package.
-// It verifies that the function returns the same instance when called multiple times.
-func TestGetPeerInstance(t *testing.T) {
-	peer1 := messageHandlers.GetPeerInstance()
-	peer2 := messageHandlers.GetPeerInstance()
+// TestNewPeerNode tests the NewPeer function of the messageHandlers package.
+// It verifies that every peer is an independent instance that uses its own storage.
+func TestNewPeerNode(t *testing.T) {
+	storage1 := storageMemoryAdapter.NewStorageMemoryAdapter()
+	storage2 := storageMemoryAdapter.NewStorageMemoryAdapter()
+	peer1 := messageHandlers.NewPeer(storage1, c_service.NewChatApp(), t.TempDir())
+	defer peer1.Close()
+	peer2 := messageHandlers.NewPeer(storage2, c_service.NewChatApp(), t.TempDir())
+	defer peer2.Close()
 
-	if peer1 != peer2 {
-		t.Errorf("GetPeerInstance() failed, expected same instance, got different instances")
+	if peer1 == peer2 {
+		t.Errorf("NewPeer() failed, expected different instances, got the same instance")
 	}
+
+	testMessage := network.Message{
+		Id:              "newPeerMessage",
+		Timestamp:       1633029445,
+		Content:         "Hello World!",
+		SenderID:        "user1",
+		ReceiverID:      "user2",
+		SenderAddress:   "user1.onion",
+		ReceiverAddress: "user2.onion",
+		ChatID:          "chat1",
+		Operation:       network.TEST_MESSAGE,
+	}
+	assert.NoError(t, peer1.Notify(testMessage), "Notify() failed, expected nil, got error")
+
+	_, err := storage1.RetrieveMessage("newPeerMessage")
+	assert.NoError(t, err, "The message should be stored by the peer that received it")
+	_, err = storage2.RetrieveMessage("newPeerMessage")
+	assert.Error(t, err, "The other peer must not share the storage")
 }
 
 // TestNotify tests the Notify method of the Peer struct.
 // It creates a test message and sends it to the peer using the Notify method.
 // It asserts that no error is returned.
 func TestNotify(t *testing.T) {
-	peer := messageHandlers.GetPeerInstance()
+	peer := messageHandlers.NewPeer(storageMemoryAdapter.NewStorageMemoryAdapter(), c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
 
 	testMessage := network.Message{
 		Id:              "8888",
@@ -49,8 +74,9 @@ func TestNotify(t *testing.T) {
 // to/from the network.
 // It asserts that no error is returned in both cases.
 func TestSubscribeAndUnsubscribeToNetwork(t *testing.T) {
-	mockConnection := networkMockAdapter.GetMockConnection()
-	peer := messageHandlers.GetPeerInstance()
+	mockConnection := networkMockAdapter.NewMockConnection()
+	peer := messageHandlers.NewPeer(storageMemoryAdapter.NewStorageMemoryAdapter(), c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
 
 	err := peer.AddNetworkConnection(mockConnection)
 	assert.NoError(t, err, "AddNetworkConnection() failed, expected nil, got error")
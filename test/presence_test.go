@@ -0,0 +1,161 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPresenceHandler(t *testing.T) {
+	dbPath := "test_presence.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	presenceHandler := messageHandlers.NewPresenceHandler(mockChatLogic, adapter, adapter)
+	t.Log("Presence handler created")
+
+	assert.NoError(t, adapter.ChatCreated("Presence Chat", "presenceChat"), "Error creating chat")
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "presencepeer.onion", "presenceChat"), "Error adding peer to chat")
+	assert.NoError(t, adapter.StoreMessage(network.Message{
+		Id:            "presenceJoin",
+		Timestamp:     1633029460,
+		SenderID:      "presencepeer.onion",
+		SenderAddress: "presencepeer.onion",
+		ChatID:        "presenceChat",
+		Operation:     network.JOIN_CHAT,
+	}), "Error storing the join of the peer")
+
+	newPresence := func(id string, senderID string, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       time.Now().UnixNano(),
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "?",
+			SenderAddress:   senderID,
+			ReceiverAddress: "?",
+			ChatID:          "presenceChat",
+			Operation:       operation,
+		}
+	}
+
+	countPresenceCalls := func() int {
+		count := 0
+		for _, entry := range mockChatLogic.LogEntries {
+			if entry == "PresenceChanged called" {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.False(t, securityContext.ValidateIncomingMessage(newPresence("presenceStranger", "presencestranger.onion", `{"online":true}`, network.PRESENCE)), "Only members may send heartbeats")
+		assert.True(t, securityContext.ValidateIncomingMessage(newPresence("presenceMember", "presencepeer.onion", `{"online":true}`, network.PRESENCE)), "Members may send heartbeats")
+
+		forged := newPresence("presenceForged", "presencepeer.onion", `{"online":true}`, network.PRESENCE)
+		forged.SenderAddress = "presencestranger.onion"
+		assert.False(t, securityContext.ValidateIncomingMessage(forged), "Heartbeats must come from the address of the member")
+	})
+
+	t.Run("Heartbeats", func(t *testing.T) {
+		presence, err := adapter.GetPresence("presencepeer.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.False(t, p_model.IsOnline(presence, time.Now()), "Peers that were never seen are offline")
+
+		assert.NoError(t, presenceHandler.HandleMessage(newPresence("presence1", "presencepeer.onion", `{"online":true}`, network.PRESENCE)), "Error handling heartbeat")
+		assert.True(t, mockChatLogic.LastOnline, "The peer should be online")
+		assert.Equal(t, "presencepeer.onion", mockChatLogic.LastAddress, "Unexpected LastAddress")
+
+		assert.NoError(t, presenceHandler.HandleMessage(newPresence("presence2", "presencepeer.onion", `{"online":true}`, network.PRESENCE)), "Error handling heartbeat")
+		assert.Equal(t, 1, countPresenceCalls(), "Heartbeats of online peers must not notify the chat logic")
+
+		assert.NoError(t, presenceHandler.HandleMessage(newPresence("presence3", "presencepeer.onion", `{"online":false}`, network.PRESENCE)), "Error handling heartbeat")
+		assert.False(t, mockChatLogic.LastOnline, "The peer should have gone offline")
+		assert.Equal(t, 2, countPresenceCalls(), "Going offline should notify the chat logic")
+	})
+
+	t.Run("UserOffline", func(t *testing.T) {
+		offline := newPresence("presenceOffline", "", "", network.USER_OFFLINE)
+		offline.ReceiverAddress = "presencepeer.onion"
+		assert.False(t, securityContext.ValidateIncomingMessage(offline), "USER_OFFLINE from other peers must be rejected")
+
+		offline.Local = true
+		assert.True(t, securityContext.ValidateIncomingMessage(offline), "USER_OFFLINE of the network adapter should be accepted")
+
+		peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+		defer peer.Close()
+		assert.NoError(t, peer.Notify(offline), "USER_OFFLINE should be handled")
+
+		presence, err := adapter.GetPresence("presencepeer.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.False(t, presence.Online, "The peer should be offline")
+		assert.NotZero(t, presence.LastSeen, "The last seen time should be kept")
+
+		_, err = adapter.RetrieveMessage("presenceOffline")
+		assert.Error(t, err, "USER_OFFLINE must not be stored")
+	})
+
+	t.Run("SendHeartbeat", func(t *testing.T) {
+		connection := &recordingConnection{}
+		sender := messageHandlers.NewMessageSender(securityContext)
+		sender.SetNetworkConnection(connection)
+
+		heartbeat := messageHandlers.NewPresenceHeartbeat(adapter, sender)
+		assert.NoError(t, heartbeat.SendHeartbeat(true), "Error sending heartbeat")
+		assert.NoError(t, heartbeat.Stop(), "Error stopping heartbeat")
+
+		chats, err := adapter.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		if assert.NotEmpty(t, chats, "Expected a chat") && assert.Len(t, connection.sent, 2*len(chats), "A heartbeat should be sent to every member") {
+			var update p_model.PresenceUpdate
+			assert.NoError(t, json.Unmarshal([]byte(connection.sent[len(connection.sent)-1].Content), &update), "Error unmarshalling heartbeat")
+			assert.Equal(t, network.PRESENCE, connection.sent[0].Operation, "Unexpected operation")
+			assert.Equal(t, "presencepeer.onion", connection.sent[0].ReceiverAddress, "The heartbeat should be sent to the address of the member")
+			assert.False(t, update.Online, "Stopping should announce that the peer goes offline")
+		}
+	})
+
+	t.Run("SendHeartbeatAfterError", func(t *testing.T) {
+		// the blocked member comes first, the heartbeat must still reach the other member
+		assert.NoError(t, adapter.PeerJoinedChat(1633029461, "presenceblocked.onion", "presenceChat"), "Error adding peer to chat")
+		assert.NoError(t, adapter.StoreMessage(network.Message{
+			Id:            "presenceBlockedJoin",
+			Timestamp:     1633029461,
+			SenderID:      "presenceblocked.onion",
+			SenderAddress: "presenceblocked.onion",
+			ChatID:        "presenceChat",
+			Operation:     network.JOIN_CHAT,
+		}), "Error storing the join of the peer")
+		assert.NoError(t, adapter.BlockPeer(1633029462, "presenceblocked.onion"), "Error blocking peer")
+
+		connection := &recordingConnection{}
+		sender := messageHandlers.NewMessageSender(securityContext)
+		sender.SetNetworkConnection(connection)
+
+		heartbeat := messageHandlers.NewPresenceHeartbeat(adapter, sender)
+		assert.Error(t, heartbeat.SendHeartbeat(true), "The failed heartbeat should be returned")
+		if assert.Len(t, connection.sent, 1, "The other member should get the heartbeat") {
+			assert.Equal(t, "presencepeer.onion", connection.sent[0].ReceiverAddress, "Unexpected receiver")
+		}
+	})
+
+	t.Log("Presence handler test passed")
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestReactHandler(t *testing.T) {
+	dbPath := "test_react.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	reactHandler := messageHandlers.NewReactHandler(mockChatLogic, adapter)
+	t.Log("React handler created")
+
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(adapter, adapter, adapter, adapter, adapter, adapter, adapter, adapter, securityContext)
+
+	for _, peerID := range []string{"reactAlice", "reactBob"} {
+		assert.NoError(t, adapter.PeerJoinedChat(1633029460, peerID, "reactChat"), "Error adding peer to chat")
+	}
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "reactBob", "reactOtherChat"), "Error adding peer to chat")
+
+	// the messages the reactions refer to, one in each chat
+	for _, target := range []network.Message{
+		{Id: "reactTarget", Timestamp: 5, Content: "Hello", SenderID: "reactBob", SenderAddress: "reactBob.onion", ChatID: "reactChat", Operation: network.SEND_MESSAGE},
+		{Id: "reactOtherTarget", Timestamp: 5, Content: "Secret", SenderID: "reactBob", SenderAddress: "reactBob.onion", ChatID: "reactOtherChat", Operation: network.SEND_MESSAGE},
+	} {
+		assert.NoError(t, adapter.StoreMessage(target), "Error storing message")
+	}
+
+	newReaction := func(id string, timestamp int64, senderID string, content string) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "?",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "?",
+			ChatID:          "reactChat",
+			Operation:       network.REACT,
+		}
+	}
+
+	// handle messages the way the peer does: validate, store, then handle
+	handle := func(message network.Message) bool {
+		if !securityContext.ValidateIncomingMessage(message) {
+			return false
+		}
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+		assert.NoError(t, reactHandler.HandleMessage(message), "Error handling message")
+		return true
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.False(t, handle(newReaction("reactStranger", 10, "reactStranger", `{"messageId":"reactTarget","emoji":"👍","add":true}`)), "Only members may react")
+		assert.False(t, handle(newReaction("reactEmpty", 10, "reactAlice", `{"messageId":"reactTarget","emoji":"","add":true}`)), "Empty reactions must be rejected")
+		assert.False(t, handle(newReaction("reactText", 10, "reactAlice", `{"messageId":"reactTarget","emoji":"a whole sentence","add":true}`)), "Reactions must not contain whitespace")
+		assert.False(t, handle(newReaction("reactNoTarget", 10, "reactAlice", `{"emoji":"👍","add":true}`)), "Reactions must refer to a message")
+		assert.False(t, handle(newReaction("reactUnknown", 10, "reactAlice", `{"messageId":"reactUnknownTarget","emoji":"👍","add":true}`)), "Reactions must refer to a stored message")
+		assert.False(t, handle(newReaction("reactOtherChat", 10, "reactAlice", `{"messageId":"reactOtherTarget","emoji":"👍","add":true}`)), "Reactions must refer to a message of the same chat")
+	})
+
+	t.Run("SyncedReactionToOtherChat", func(t *testing.T) {
+		content, err := json.Marshal([]network.Message{newReaction("reactSyncedOtherChat", 11, "reactAlice", `{"messageId":"reactOtherTarget","emoji":"👍","add":true}`)})
+		assert.NoError(t, err, "Error marshalling synced messages")
+
+		response := newReaction("reactSyncResponse", 12, "reactAlice", string(content))
+		response.Operation = network.SYNC_RESPONSE
+		assert.NoError(t, syncResponseHandler.HandleMessage(response), "Error handling sync response")
+
+		reactions, err := adapter.GetReactions("reactOtherTarget")
+		assert.NoError(t, err, "Error getting reactions")
+		assert.Empty(t, reactions, "Synced reactions must refer to a message of the same chat")
+	})
+
+	t.Run("Aggregation", func(t *testing.T) {
+		assert.True(t, handle(newReaction("reactA1", 20, "reactAlice", `{"messageId":"reactTarget","emoji":"👍","add":true}`)), "Members may react")
+		assert.True(t, handle(newReaction("reactB1", 21, "reactBob", `{"messageId":"reactTarget","emoji":"👍","add":true}`)), "Members may react")
+		assert.True(t, handle(newReaction("reactB2", 22, "reactBob", `{"messageId":"reactTarget","emoji":"🎉","add":true}`)), "Members may react with several emoji")
+		assert.Equal(t, "🎉", mockChatLogic.LastEmoji, "Unexpected LastEmoji")
+		assert.True(t, mockChatLogic.LastAdd, "Unexpected LastAdd")
+
+		// the same reaction again must not be counted twice
+		assert.True(t, handle(newReaction("reactA1Again", 23, "reactAlice", `{"messageId":"reactTarget","emoji":"👍","add":true}`)), "Repeated reactions are valid")
+
+		reactions, err := adapter.GetReactions("reactTarget")
+		assert.NoError(t, err, "Error getting reactions")
+		if assert.Len(t, reactions, 2, "Unexpected number of emoji") {
+			assert.Equal(t, "👍", reactions[0].Emoji, "The most used emoji should be first")
+			assert.Equal(t, 2, reactions[0].Count, "Unexpected count")
+			assert.Equal(t, 1, reactions[1].Count, "Unexpected count")
+		}
+	})
+
+	t.Run("LastWriterWins", func(t *testing.T) {
+		// Bob removes the reaction, the older add arrives afterwards and must be ignored
+		assert.True(t, handle(newReaction("reactB3", 40, "reactBob", `{"messageId":"reactTarget","emoji":"🎉","add":false}`)), "Members may remove reactions")
+		assert.False(t, mockChatLogic.LastAdd, "Unexpected LastAdd")
+
+		updated, err := adapter.PeerReacted(30, "reactB2Late", "reactTarget", "reactBob", "🎉", true)
+		assert.NoError(t, err, "Error storing reaction")
+		assert.False(t, updated, "Older reactions must not overwrite newer ones")
+
+		reactions, err := adapter.GetReactions("reactTarget")
+		assert.NoError(t, err, "Error getting reactions")
+		assert.Len(t, reactions, 1, "The removed reaction should not be counted")
+	})
+
+	t.Log("React handler test passed")
+}
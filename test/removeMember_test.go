@@ -0,0 +1,123 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestRemoveMemberHandler(t *testing.T) {
+	dbPath := "test_remove_member.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	removeMemberHandler := messageHandlers.NewRemoveMemberHandler(mockChatLogic, adapter)
+	banMemberHandler := messageHandlers.NewBanMemberHandler(mockChatLogic, adapter)
+	t.Log("Remove and ban member handlers created")
+
+	for _, peerID := range []string{"kickOwner", "kickAdmin", "kickMember", "banMember"} {
+		err := adapter.PeerJoinedChat(1633029460, peerID, "kickChat")
+		assert.NoError(t, err, "Error adding peer to chat")
+	}
+	assert.NoError(t, adapter.SetPeerRole("kickOwner", "kickChat", store.ROLE_OWNER), "Error setting owner role")
+	assert.NoError(t, adapter.SetPeerRole("kickAdmin", "kickChat", store.ROLE_ADMIN), "Error setting admin role")
+
+	removeMessage := func(senderID string, peerID string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:            senderID + "_" + peerID + "_remove",
+			Timestamp:     1633029470,
+			Content:       `{"peerId":"` + peerID + `"}`,
+			SenderID:      senderID,
+			SenderAddress: senderID + ".onion",
+			ChatID:        "kickChat",
+			Operation:     operation,
+		}
+	}
+
+	t.Run("Permissions", func(t *testing.T) {
+		assert.False(t, securityContext.ValidateIncomingMessage(removeMessage("kickMember", "banMember", network.REMOVE_MEMBER)), "Members must not kick")
+		assert.False(t, securityContext.ValidateIncomingMessage(removeMessage("kickAdmin", "kickOwner", network.REMOVE_MEMBER)), "Admins must not kick owners")
+		assert.False(t, securityContext.ValidateIncomingMessage(removeMessage("kickAdmin", "kickAdmin", network.BAN_MEMBER)), "Admins must not ban admins")
+		assert.False(t, securityContext.ValidateIncomingMessage(removeMessage("kickOwner", "stranger", network.REMOVE_MEMBER)), "Non-members can't be kicked")
+		assert.True(t, securityContext.ValidateIncomingMessage(removeMessage("kickOwner", "stranger", network.BAN_MEMBER)), "Non-members can be banned")
+		assert.True(t, securityContext.ValidateIncomingMessage(removeMessage("kickOwner", "kickAdmin", network.REMOVE_MEMBER)), "Owners may kick admins")
+	})
+
+	t.Run("KickMember", func(t *testing.T) {
+		message := removeMessage("kickAdmin", "kickMember", network.REMOVE_MEMBER)
+		assert.True(t, securityContext.ValidateIncomingMessage(message), "Admins may kick members")
+		assert.NoError(t, removeMemberHandler.HandleMessage(message), "Error handling remove member message")
+		assert.Equal(t, "kickMember", mockChatLogic.LastPeerId, "Unexpected LastPeerId")
+		assert.False(t, mockChatLogic.LastBanned, "A kick is not a ban")
+
+		role, err := adapter.GetPeerRole("kickMember", "kickChat")
+		assert.Error(t, err, "Kicked peer should not be a member anymore")
+		assert.Equal(t, store.ROLE_MEMBER, role, "Unexpected role")
+
+		banned, err := adapter.IsBannedFromChat("kickMember", "kickChat")
+		assert.NoError(t, err, "Error checking ban")
+		assert.False(t, banned, "Kicked peer must not be banned")
+	})
+
+	t.Run("BanMember", func(t *testing.T) {
+		message := removeMessage("kickAdmin", "banMember", network.BAN_MEMBER)
+		assert.True(t, securityContext.ValidateIncomingMessage(message), "Admins may ban members")
+		assert.NoError(t, banMemberHandler.HandleMessage(message), "Error handling ban member message")
+		assert.True(t, mockChatLogic.LastBanned, "Unexpected LastBanned")
+
+		banned, err := adapter.IsBannedFromChat("banMember", "kickChat")
+		assert.NoError(t, err, "Error checking ban")
+		assert.True(t, banned, "Peer should be banned")
+
+		// even with an invitation the banned peer must not join again
+		assert.NoError(t, adapter.StoreMessage(network.Message{
+			Id:              "banInvite",
+			Timestamp:       1633029480,
+			Content:         `{"chatId":"kickChat","chatName":"Kick Chat","peers":[]}`,
+			SenderID:        "kickOwner",
+			ReceiverID:      "banMember",
+			SenderAddress:   "kickOwner.onion",
+			ReceiverAddress: "banMember.onion",
+			ChatID:          "kickChat",
+			Operation:       network.INVITE_TO_CHAT,
+		}), "Error storing invitation message")
+		assert.NoError(t, adapter.PeerGotInvitedToChat("banInvite", 0), "Error storing invitation")
+
+		joinMessage := network.Message{
+			Id:            "banMember_join",
+			Timestamp:     1633029490,
+			SenderID:      "banMember",
+			SenderAddress: "banMember.onion",
+			ChatID:        "kickChat",
+			Operation:     network.JOIN_CHAT,
+		}
+		assert.False(t, securityContext.ValidateIncomingMessage(joinMessage), "Banned peers must not join")
+
+		inviteMessage := network.Message{
+			Id:         "banMember_reinvite",
+			Timestamp:  1633029500,
+			Content:    `{"chatId":"kickChat","chatName":"Kick Chat","peers":[]}`,
+			SenderID:   "kickOwner",
+			ReceiverID: "banMember",
+			ChatID:     "kickChat",
+			Operation:  network.INVITE_TO_CHAT,
+		}
+		assert.False(t, securityContext.ValidateIncomingMessage(inviteMessage), "Banned peers must not be invited")
+	})
+
+	t.Log("Remove member handler test passed")
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestReplies(t *testing.T) {
+	dbPath := "test_replies.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	sendMessageHandler := messageHandlers.NewSendMessageHandler(mockChatLogic, adapter)
+
+	newMessage := func(id string, timestamp int64, content string) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        "replyPeer",
+			ReceiverID:      "?",
+			SenderAddress:   "replyPeer.onion",
+			ReceiverAddress: "?",
+			ChatID:          "replyChat",
+			Operation:       network.SEND_MESSAGE,
+		}
+	}
+
+	// the replies arrive before their parent, like they can during a sync
+	assert.NoError(t, sendMessageHandler.HandleMessage(newMessage("reply2", 30, `{"message":"Second","replyTo":"replyParent"}`)), "Error handling reply")
+	assert.NoError(t, sendMessageHandler.HandleMessage(newMessage("reply1", 20, `{"message":"First","replyTo":"replyParent"}`)), "Error handling reply")
+	assert.Contains(t, mockChatLogic.LogEntries, "ReceiveReply called", "Expected ReceiveReply to be called")
+	assert.Equal(t, "replyParent", mockChatLogic.LastMessageId, "Unexpected parent")
+
+	replies, err := adapter.GetReplies("replyParent")
+	assert.NoError(t, err, "Error getting replies")
+	if assert.Len(t, replies, 2, "Replies without parent should be stored") {
+		assert.Equal(t, "reply1", replies[0].Id, "Replies should be ordered oldest first")
+		assert.Equal(t, "reply2", replies[1].Id, "Replies should be ordered oldest first")
+	}
+
+	assert.NoError(t, sendMessageHandler.HandleMessage(newMessage("replyParent", 10, `{"message":"Parent"}`)), "Error handling message")
+	assert.Contains(t, mockChatLogic.LogEntries, "ReceiveMessage called", "Expected ReceiveMessage to be called")
+
+	replies, err = adapter.GetReplies("replyParent")
+	assert.NoError(t, err, "Error getting replies")
+	assert.Len(t, replies, 2, "Replies should stay linked once the parent arrives")
+
+	replies, err = adapter.GetReplies("reply1")
+	assert.NoError(t, err, "Error getting replies")
+	assert.Empty(t, replies, "Messages without replies should have none")
+
+	t.Log("Replies test passed")
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestInviteResponseHandler(t *testing.T) {
+	dbPath := "test_respond_to_invitation.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	inviteResponseHandler := messageHandlers.NewInviteResponseHandler(mockChatLogic, adapter)
+	t.Log("Invite response handler created")
+
+	storeInvitation := func(messageID string, expiresAt int64) {
+		inviteMessage := network.Message{
+			Id:              messageID,
+			Timestamp:       1633029460,
+			Content:         `{"chatId":"responseChat","chatName":"Response Chat","peers":[]}`,
+			SenderID:        "inviter",
+			ReceiverID:      "invitee",
+			SenderAddress:   "inviter.onion",
+			ReceiverAddress: "invitee.onion",
+			ChatID:          "responseChat",
+			Operation:       network.INVITE_TO_CHAT,
+		}
+		assert.NoError(t, adapter.StoreMessage(inviteMessage), "Error storing invitation message")
+		assert.NoError(t, adapter.PeerGotInvitedToChat(messageID, expiresAt), "Error storing invitation")
+	}
+
+	responseMessage := func(senderID string, invitationID string, status store.InvitationStatus) network.Message {
+		content, err := json.Marshal(struct {
+			InvitationID string                 `json:"invitationId"`
+			Status       store.InvitationStatus `json:"status"`
+		}{invitationID, status})
+		assert.NoError(t, err, "Error marshalling response content")
+
+		return network.Message{
+			Id:              invitationID + "_response",
+			Timestamp:       1633029470,
+			Content:         string(content),
+			SenderID:        senderID,
+			ReceiverID:      "inviter",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "inviter.onion",
+			ChatID:          "responseChat",
+			Operation:       network.INVITE_RESPONSE,
+		}
+	}
+
+	t.Run("PendingInvitationAllowsJoin", func(t *testing.T) {
+		storeInvitation("pendingInvite", 0)
+
+		invitation, err := adapter.GetInvitation("pendingInvite")
+		assert.NoError(t, err, "Error getting invitation")
+		assert.Equal(t, store.INVITATION_PENDING, invitation.Status, "Unexpected invitation status")
+		assert.Equal(t, "inviter", invitation.InviterId, "Unexpected inviter")
+		assert.Equal(t, "invitee", invitation.InviteeId, "Unexpected invitee")
+
+		joinMessage := network.Message{Id: "responseJoin", SenderID: "invitee", ChatID: "responseChat", Operation: network.JOIN_CHAT}
+		assert.True(t, securityContext.ValidateIncomingMessage(joinMessage), "Join with pending invitation should be valid")
+	})
+
+	t.Run("OnlyInviteeCanRespond", func(t *testing.T) {
+		message := responseMessage("stranger", "pendingInvite", store.INVITATION_ACCEPTED)
+		assert.False(t, securityContext.ValidateIncomingMessage(message), "Response from a stranger should be invalid")
+
+		message = responseMessage("invitee", "pendingInvite", store.INVITATION_REVOKED)
+		assert.False(t, securityContext.ValidateIncomingMessage(message), "Invitee must not be able to revoke")
+	})
+
+	t.Run("DeclinedInvitationRejectsJoin", func(t *testing.T) {
+		message := responseMessage("invitee", "pendingInvite", store.INVITATION_DECLINED)
+		assert.True(t, securityContext.ValidateIncomingMessage(message), "Decline from invitee should be valid")
+
+		err := inviteResponseHandler.HandleMessage(message)
+		assert.NoError(t, err, "Error handling invite response")
+		assert.Equal(t, "pendingInvite", mockChatLogic.LastInvitation, "Unexpected LastInvitation")
+		assert.Equal(t, store.INVITATION_DECLINED, mockChatLogic.LastStatus, "Unexpected LastStatus")
+
+		invitation, err := adapter.GetInvitation("pendingInvite")
+		assert.NoError(t, err, "Error getting invitation")
+		assert.Equal(t, store.INVITATION_DECLINED, invitation.Status, "Unexpected invitation status")
+
+		invitations, err := adapter.GetInvitations("invitee")
+		assert.NoError(t, err, "Error getting invitations")
+		assert.NotContains(t, invitations, "responseChat", "Declined invitation should not be returned")
+
+		err = inviteResponseHandler.HandleMessage(responseMessage("invitee", "pendingInvite", store.INVITATION_ACCEPTED))
+		assert.Error(t, err, "A declined invitation must not be accepted afterwards")
+	})
+
+	t.Run("ExpiredInvitation", func(t *testing.T) {
+		storeInvitation("expiredInvite", 1)
+
+		invitation, err := adapter.GetInvitation("expiredInvite")
+		assert.NoError(t, err, "Error getting invitation")
+		assert.Equal(t, store.INVITATION_EXPIRED, invitation.Status, "Unexpected invitation status")
+
+		message := responseMessage("invitee", "expiredInvite", store.INVITATION_ACCEPTED)
+		assert.False(t, securityContext.ValidateIncomingMessage(message), "Expired invitation must not be accepted")
+	})
+
+	t.Run("ResponsesAreSent", func(t *testing.T) {
+		storeInvitation("sentInvite", 0)
+		sender := messageHandlers.NewMessageSender(securityContext)
+		chatToNetwork := messageHandlers.NewChatToNetwork(sender, adapter)
+
+		assert.Error(t, chatToNetwork.DeclineInvitation("sentInvite"), "An invitation response that can't be sent must return an error")
+
+		storeInvitation("acceptedInvite", 0)
+		connection := &recordingConnection{}
+		sender.SetNetworkConnection(connection)
+		assert.NoError(t, chatToNetwork.AcceptInvitation("acceptedInvite"), "Error accepting invitation")
+		if assert.NotEmpty(t, connection.sent, "The response should be sent") {
+			assert.Equal(t, network.INVITE_RESPONSE, connection.sent[0].Operation, "Unexpected operation")
+			assert.Equal(t, "inviter.onion", connection.sent[0].ReceiverAddress, "The response should be sent to the inviter")
+		}
+	})
+
+	t.Log("Invite response handler test passed")
+}
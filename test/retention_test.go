@@ -0,0 +1,210 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+)
+
+const day = int64(24 * time.Hour)
+
+func retentionMessage(id string, timestamp int64, content string, operation network.OperationType) network.Message {
+	return network.Message{
+		Id:              id,
+		Timestamp:       timestamp,
+		Content:         content,
+		SenderID:        "retentionSender",
+		ReceiverID:      "retentionReceiver",
+		SenderAddress:   "retentionSender.onion",
+		ReceiverAddress: "retentionReceiver.onion",
+		ChatID:          "retentionChat",
+		Operation:       operation,
+	}
+}
+
+// retentionFile returns a SEND_FILE message with size bytes of content
+func retentionFile(id string, timestamp int64, size int) network.Message {
+	content, _ := json.Marshal(map[string]string{
+		"fileName":      id,
+		"fileExtension": "txt",
+		"fileContent":   base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], size))),
+	})
+	return retentionMessage(id, timestamp, string(content), network.SEND_FILE)
+}
+
+func storedIDs(t *testing.T, storage store.Storage) []string {
+	messages, err := storage.GetChatMessages("retentionChat")
+	assert.NoError(t, err, "Error getting messages")
+	ids := []string{}
+	for _, message := range messages {
+		ids = append(ids, message.Id)
+	}
+	return ids
+}
+
+func TestCompactorRetentionPolicies(t *testing.T) {
+	storages := map[string]func(t *testing.T) store.Storage{
+		"SQLite": func(t *testing.T) store.Storage {
+			adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(filepath.Join(t.TempDir(), "retention.db"))
+			if err != nil {
+				t.Fatalf("Error opening database: %v", err)
+			}
+			t.Cleanup(func() { adapter.Close() })
+			return adapter
+		},
+		"Memory": func(t *testing.T) store.Storage {
+			return storageMemoryAdapter.NewStorageMemoryAdapter()
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			fileDir := t.TempDir()
+			sendFileHandler := messageHandlers.NewSendFileHandler(&MockChatLogic{}, storage, fileDir)
+			compactor := messageHandlers.NewCompactor(storage, storage, storage, fileDir)
+
+			now := time.Now().UnixNano()
+			assert.NoError(t, storage.ChatCreated("Retention", "retentionChat"), "Error creating chat")
+			assert.NoError(t, storage.PeerSetUsername("retentionLeft", "retentionChat", "Left"), "Error setting username")
+			for _, message := range []network.Message{
+				retentionMessage("join", now-10*day, "", network.JOIN_CHAT),
+				retentionMessage("old", now-10*day+1, `{"message":"Old"}`, network.SEND_MESSAGE),
+				retentionMessage("oldEdit", now-9*day, `{"messageId":"old","message":"Still old"}`, network.EDIT_MESSAGE),
+				retentionMessage("m1", now-3*day, `{"message":"1"}`, network.SEND_MESSAGE),
+				retentionMessage("m2", now-2*day, `{"message":"2"}`, network.SEND_MESSAGE),
+				retentionMessage("m3", now-day, `{"message":"3"}`, network.SEND_MESSAGE),
+			} {
+				assert.NoError(t, storage.StoreMessage(message), "Error storing message %s", message.Id)
+			}
+			for _, file := range []network.Message{
+				retentionFile("a", now-3*day+1, 600),
+				retentionFile("b", now-2*day+1, 600),
+				retentionFile("c", now-1, 600),
+			} {
+				assert.NoError(t, storage.StoreMessage(file), "Error storing file %s", file.Id)
+				assert.NoError(t, sendFileHandler.HandleMessage(file), "Error storing file %s", file.Id)
+			}
+			storedFiles := func() int {
+				entries, err := os.ReadDir(fileDir)
+				assert.NoError(t, err, "Error reading file directory")
+				return len(entries)
+			}
+			assert.Equal(t, 3, storedFiles(), "All files should be stored")
+
+			assert.NoError(t, compactor.Compact(now), "Chats without policy should be left alone")
+			assert.Len(t, storedIDs(t, storage), 9, "Nothing should be pruned without a policy")
+
+			assert.NoError(t, storage.SetRetentionPolicy("retentionChat", store.RetentionPolicy{MaxAge: 5 * day}), "Error setting policy")
+			assert.NoError(t, compactor.Compact(now), "Error compacting")
+			assert.Equal(t, []string{"join", "m1", "a", "m2", "b", "m3", "c"}, storedIDs(t, storage), "Messages older than 5 days should be pruned, the join is kept")
+			pruned, err := storage.GetPrunedRange("retentionChat")
+			assert.NoError(t, err, "Error getting pruned range")
+			assert.Equal(t, now-5*day, pruned.Before, "The pruned range should be recorded")
+
+			peers, err := storage.GetPeers()
+			assert.NoError(t, err, "Error getting peers")
+			assert.NotContains(t, peers, "retentionLeft", "Peers nothing refers to should be removed")
+			assert.Contains(t, peers, "retentionSender", "Peers of the kept messages should stay")
+
+			assert.NoError(t, storage.SetRetentionPolicy("retentionChat", store.RetentionPolicy{MaxAge: 5 * day, MaxAttachmentSize: 1000}), "Error setting policy")
+			assert.NoError(t, compactor.Compact(now), "Error compacting")
+			assert.Equal(t, []string{"join", "m1", "m2", "m3", "c"}, storedIDs(t, storage), "Only the newest file fits")
+			assert.Equal(t, 1, storedFiles(), "The pruned files should be removed")
+
+			assert.NoError(t, storage.SetRetentionPolicy("retentionChat", store.RetentionPolicy{MaxMessages: 2}), "Error setting policy")
+			assert.NoError(t, compactor.Compact(now), "Error compacting")
+			assert.Equal(t, []string{"join", "m3", "c"}, storedIDs(t, storage), "Only the newest message and file should be kept")
+			pruned, err = storage.GetPrunedRange("retentionChat")
+			assert.NoError(t, err, "Error getting pruned range")
+			assert.Equal(t, now-day, pruned.Before, "The pruned range should grow to the oldest kept message")
+			assert.Equal(t, now-2*day+2, pruned.FilesBefore, "The files stay pruned up to the last file that didn't fit")
+		})
+	}
+}
+
+func TestSyncRespectsPrunedRanges(t *testing.T) {
+	storage := storageMemoryAdapter.NewStorageMemoryAdapter()
+	now := time.Now().UnixNano()
+
+	assert.NoError(t, storage.PeerJoinedChat(now, "retentionSender", "retentionChat"), "Error joining chat")
+	assert.NoError(t, storage.MessagesPruned("retentionChat", store.PrunedRange{Before: now - 2*day, FilesBefore: now - day}), "Error recording pruned range")
+	securityContext := p_service.NewSecurityContext(storage, storage, storage, storage)
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(storage, storage, storage, storage, storage, storage, storage, storage, securityContext)
+
+	synced, err := json.Marshal([]network.Message{
+		retentionMessage("oldJoin", now-3*day, "", network.JOIN_CHAT),
+		retentionMessage("prunedMessage", now-3*day, `{"message":"Pruned"}`, network.SEND_MESSAGE),
+		retentionFile("prunedFile", now-2*day+1, 10),
+		retentionMessage("keptMessage", now-2*day+1, `{"message":"Kept"}`, network.SEND_MESSAGE),
+		retentionFile("keptFile", now-1, 10),
+	})
+	assert.NoError(t, err, "Error marshalling synced messages")
+	assert.NoError(t, syncResponseHandler.HandleMessage(retentionMessage("response", now, string(synced), network.SYNC_RESPONSE)), "Error handling sync response")
+	assert.Equal(t, []string{"oldJoin", "keptMessage", "keptFile"}, storedIDs(t, storage), "Pruned messages must not be accepted again, membership is never pruned")
+
+	connection := &recordingConnection{}
+	sender := messageHandlers.NewMessageSender(securityContext)
+	sender.SetNetworkConnection(connection)
+	syncRequestHandler := messageHandlers.NewSyncRequestHandler(storage, storage, storage, storage, sender)
+
+	// The requesting peer pruned everything older than a day, and has a message this peer doesn't have
+	request := retentionMessage("request", now, fmt.Sprintf(`{"existingMessageIds":["oldJoin","onlyRequester"],"prunedBefore":%d}`, now-day), network.SYNC_REQUEST)
+	assert.NoError(t, syncRequestHandler.HandleMessage(request), "Error handling sync request")
+
+	if !assert.Len(t, connection.sent, 2, "Expected a sync response and a sync request for the missing message") {
+		return
+	}
+	var served []network.Message
+	assert.NoError(t, json.Unmarshal([]byte(connection.sent[0].Content), &served), "Error unmarshalling sync response")
+	servedIDs := []string{}
+	for _, message := range served {
+		servedIDs = append(servedIDs, message.Id)
+	}
+	assert.Equal(t, []string{"keptFile"}, servedIDs, "Messages in the pruned range of the requesting peer must not be served")
+
+	var followUp struct {
+		ExistingMessageIDs []string `json:"existingMessageIds"`
+		PrunedBefore       int64    `json:"prunedBefore"`
+		FilesPrunedBefore  int64    `json:"filesPrunedBefore"`
+	}
+	assert.Equal(t, network.SYNC_REQUEST, connection.sent[1].Operation)
+	assert.NoError(t, json.Unmarshal([]byte(connection.sent[1].Content), &followUp), "The sync request should be a valid request")
+	assert.ElementsMatch(t, []string{"oldJoin", "keptMessage", "keptFile"}, followUp.ExistingMessageIDs, "The request should list the messages this peer has")
+	assert.Equal(t, now-2*day, followUp.PrunedBefore, "The request should carry the own pruned range")
+	assert.Equal(t, now-day, followUp.FilesPrunedBefore)
+}
+
+func TestRetentionPolicyValidation(t *testing.T) {
+	assert.NoError(t, p_model.ValidateRetentionPolicy(store.RetentionPolicy{}), "A policy without limits keeps everything")
+	assert.NoError(t, p_model.ValidateRetentionPolicy(store.RetentionPolicy{MaxAge: day, MaxMessages: 100, MaxAttachmentSize: 1}))
+	assert.Error(t, p_model.ValidateRetentionPolicy(store.RetentionPolicy{MaxAge: day - 1}), "Shorter times are disappearing messages")
+	assert.Error(t, p_model.ValidateRetentionPolicy(store.RetentionPolicy{MaxMessages: -1}), "Limits can't be negative")
+
+	for text, size := range map[string]int64{"512": 512, "500KB": 500 << 10, "50 mb": 50 << 20, "1GB": 1 << 30, "0": 0} {
+		parsed, err := p_model.ParseSize(text)
+		assert.NoError(t, err, "Error parsing %s", text)
+		assert.Equal(t, size, parsed, "Unexpected size of %s", text)
+	}
+	for _, text := range []string{"", "MB", "-1KB", "1.5GB", "ten"} {
+		_, err := p_model.ParseSize(text)
+		assert.Error(t, err, "%q is no size", text)
+	}
+	assert.Equal(t, "50MB", p_model.FormatSize(50<<20))
+	assert.Equal(t, "keeps the last 30 days, the newest files up to 1GB",
+		p_model.DescribeRetentionPolicy(store.RetentionPolicy{MaxAge: 30 * day, MaxAttachmentSize: 1 << 30}))
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadFixture creates the database from the SQL dump in testdata
+func loadFixture(t *testing.T, fixture string, dbPath string) {
+	dump, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if !assert.NoError(t, err, "Error reading fixture") {
+		t.FailNow()
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if !assert.NoError(t, err, "Error opening fixture database") {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	_, err = db.Exec(string(dump))
+	if !assert.NoError(t, err, "Error loading fixture") {
+		t.FailNow()
+	}
+}
+
+// schemaVersion reads the schema version of the database without migrating it
+func schemaVersion(t *testing.T, dbPath string) int {
+	db, err := sql.Open("sqlite3", dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		t.FailNow()
+	}
+	defer db.Close()
+
+	var version int
+	err = db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	assert.NoError(t, err, "Error reading schema version")
+	return version
+}
+
+func TestSchemaMigrations(t *testing.T) {
+
+	t.Run("NewDatabase", func(t *testing.T) {
+		dbPath := "test_migrations_new.db"
+		defer os.Remove(dbPath)
+
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		if !assert.NoError(t, err, "Error creating database") {
+			return
+		}
+		assert.NoError(t, adapter.Close(), "Error closing database")
+		assert.Equal(t, storageSQLiteAdapter.SchemaVersion(), schemaVersion(t, dbPath), "A new database should have the newest schema")
+
+		// Opening the database again doesn't change anything
+		adapter, err = storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		if assert.NoError(t, err, "Error opening database again") {
+			adapter.Close()
+		}
+		assert.Equal(t, storageSQLiteAdapter.SchemaVersion(), schemaVersion(t, dbPath), "Unexpected schema version")
+	})
+
+	t.Run("UnversionedDatabase", func(t *testing.T) {
+		dbPath := "test_migrations_unversioned.db"
+		defer os.Remove(dbPath)
+		loadFixture(t, "skunk_unversioned.sql", dbPath)
+
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		if !assert.NoError(t, err, "Error upgrading database") {
+			return
+		}
+		defer adapter.Close()
+		assert.Equal(t, storageSQLiteAdapter.SchemaVersion(), schemaVersion(t, dbPath), "The database should be upgraded to the newest schema")
+
+		chats, err := adapter.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		assert.Equal(t, []store.Chat{{ChatId: "fixtureChat", ChatName: "Fixture chat"}}, chats, "The chat should be kept with empty metadata")
+
+		users, err := adapter.GetUsersInChat("fixtureChat")
+		assert.NoError(t, err, "Error getting users in chat")
+		assert.Len(t, users, 2, "The members should be kept")
+		for _, user := range users {
+			assert.Equal(t, store.ChatRole(0), user.Role, "Existing members should get the default role")
+		}
+
+		message, err := adapter.RetrieveMessage("fixtureMessage")
+		assert.NoError(t, err, "The message should be kept")
+		assert.Equal(t, `{"message":"Hello from an old database"}`, message.Content, "Unexpected content")
+
+		invitation, err := adapter.GetInvitation("fixtureInvitation")
+		assert.NoError(t, err, "The invitation should be kept")
+		assert.Equal(t, int64(0), invitation.ExpiresAt, "Existing invitations should never expire")
+
+		// The tables of the newer features exist
+		assert.NoError(t, adapter.BlockPeer(1, "blocked.onion"), "Error blocking peer")
+		_, err = adapter.GetReactions("fixtureMessage")
+		assert.NoError(t, err, "Error getting reactions")
+		_, err = adapter.GetReplies("fixtureMessage")
+		assert.NoError(t, err, "Error getting replies")
+	})
+
+	t.Run("VersionedDatabase", func(t *testing.T) {
+		dbPath := "test_migrations_v3.db"
+		defer os.Remove(dbPath)
+		loadFixture(t, "skunk_v3.sql", dbPath)
+
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		if !assert.NoError(t, err, "Error upgrading database") {
+			return
+		}
+		defer adapter.Close()
+		assert.Equal(t, storageSQLiteAdapter.SchemaVersion(), schemaVersion(t, dbPath), "The database should be upgraded to the newest schema")
+
+		peerID, err := adapter.GetInviteTokenUser("fixtureSecret")
+		assert.NoError(t, err, "Error getting invite token")
+		assert.Equal(t, "fixtureInvitee", peerID, "The invite token should be kept")
+
+		expiry, err := adapter.GetMessageExpiry("fixtureChat")
+		assert.NoError(t, err, "Error getting message expiry")
+		assert.Equal(t, int64(0), expiry, "Existing chats should keep their messages")
+	})
+
+	t.Run("BlockedContacts", func(t *testing.T) {
+		dbPath := "test_migrations_contacts.db"
+		defer os.Remove(dbPath)
+		loadFixture(t, "skunk_unversioned_contacts.sql", dbPath)
+
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		if !assert.NoError(t, err, "Error upgrading database") {
+			return
+		}
+		defer adapter.Close()
+
+		blocked, err := adapter.IsBlocked("blockedcontact.onion")
+		assert.NoError(t, err, "Error checking block list")
+		assert.True(t, blocked, "Blocked contacts should be moved to the block list")
+
+		contact, err := adapter.GetContact("blockedcontact.onion")
+		assert.NoError(t, err, "Error getting contact")
+		assert.True(t, contact.Blocked, "The contact should still be shown as blocked")
+
+		contact, err = adapter.GetContact("friendcontact.onion")
+		assert.NoError(t, err, "Error getting contact")
+		assert.False(t, contact.Blocked, "Other contacts should not be blocked")
+		assert.True(t, contact.Verified, "The contact should be kept")
+		assert.Equal(t, "Friend", contact.Notes, "The contact should be kept")
+	})
+
+	t.Run("NewerDatabase", func(t *testing.T) {
+		dbPath := "test_migrations_newer.db"
+		defer os.Remove(dbPath)
+		loadFixture(t, "skunk_unversioned.sql", dbPath)
+
+		newer := storageSQLiteAdapter.SchemaVersion() + 1
+		db, err := sql.Open("sqlite3", dbPath)
+		if !assert.NoError(t, err, "Error opening database") {
+			return
+		}
+		_, err = db.Exec(fmt.Sprintf("CREATE TABLE schema_version (version INTEGER NOT NULL); INSERT INTO schema_version VALUES (%d);", newer))
+		db.Close()
+		assert.NoError(t, err, "Error setting schema version")
+
+		_, err = storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+		assert.Error(t, err, "A database from a newer version must not be opened")
+		assert.Equal(t, newer, schemaVersion(t, dbPath), "The database must not be changed")
+	})
+
+	t.Log("Schema migrations test passed")
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"database/sql"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSearchMessages(t *testing.T) {
+	dbPath := "test_search_messages.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	t.Log("Storage adapter initialized")
+
+	newMessage := func(id string, timestamp int64, senderID, chatID, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "searchReceiver",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "searchreceiver.onion",
+			ChatID:          chatID,
+			Operation:       operation,
+		}
+	}
+
+	for _, message := range []network.Message{
+		newMessage("searchPicnic", 10, "searchAlice", "searchChat", `{"message":"Shall we meet for a picnic in the park on Sunday?"}`, network.SEND_MESSAGE),
+		newMessage("searchPark", 20, "searchBob", "searchChat", `{"message":"The park is closed on Sunday"}`, network.SEND_MESSAGE),
+		newMessage("searchOther", 30, "searchAlice", "searchOtherChat", `{"message":"Which park?","replyTo":"searchPark"}`, network.SEND_MESSAGE),
+		newMessage("searchUsername", 40, "searchAlice", "searchChat", `park`, network.SET_USERNAME),
+	} {
+		assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+	}
+
+	ids := func(results []store.SearchResult) []string {
+		found := []string{}
+		for _, result := range results {
+			found = append(found, result.Message.Id)
+		}
+		return found
+	}
+
+	t.Run("Search", func(t *testing.T) {
+		results, err := adapter.SearchMessages(store.SearchQuery{Text: "park"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchOther", "searchPark", "searchPicnic"}, ids(results), "Expected the messages with the word, newest first")
+
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: "PARK sunday", ChatID: "searchChat"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchPark", "searchPicnic"}, ids(results), "Expected the messages of the chat with all words")
+		if assert.Len(t, results, 2) {
+			assert.Contains(t, results[1].Snippet, "[park]", "The snippet should mark the matching word")
+			assert.Equal(t, "searchAlice", results[1].Message.SenderID, "Expected the sender of the message")
+		}
+
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: "park", SenderID: "searchBob"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchPark"}, ids(results), "Expected the messages of the sender")
+
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: "park", Limit: 1})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchOther"}, ids(results), "Expected the newest message only")
+
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: `park" OR "sunday`})
+		assert.NoError(t, err, "Quotes must not break the search")
+		assert.Empty(t, results, "Quotes are searched as text")
+
+		_, err = adapter.SearchMessages(store.SearchQuery{Text: " "})
+		assert.Error(t, err, "An empty search should fail")
+	})
+
+	t.Run("EditsAndDeletions", func(t *testing.T) {
+		assert.NoError(t, adapter.MessageEdited(50, "searchEdit", "searchPicnic", "Shall we meet at the lake instead?"), "Error editing message")
+		assert.NoError(t, adapter.MessageDeleted(60, "searchOther"), "Error deleting message")
+		// An edit of a message that didn't arrive yet is indexed when it arrives
+		assert.NoError(t, adapter.MessageEdited(70, "searchLateEdit", "searchLate", "Bring a blanket to the lake"), "Error editing message")
+
+		results, err := adapter.SearchMessages(store.SearchQuery{Text: "park"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchPark"}, ids(results), "Edited and deleted messages must not be found by their old text")
+
+		assert.NoError(t, adapter.StoreMessage(newMessage("searchLate", 45, "searchBob", "searchChat", `{"message":"Bring a blanket"}`, network.SEND_MESSAGE)), "Error storing message")
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: "lake"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchLate", "searchPicnic"}, ids(results), "Expected the messages by their newest text")
+	})
+
+	t.Run("EncryptedDatabase", func(t *testing.T) {
+		assert.NoError(t, adapter.SetPassphrase("correct horse battery staple"), "Error setting passphrase")
+
+		db, err := sql.Open("sqlite3", dbPath)
+		if assert.NoError(t, err, "Error opening database") {
+			var indexed int
+			assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM MessageSearch").Scan(&indexed), "Error counting indexed messages")
+			assert.Zero(t, indexed, "The index must not keep the plaintext of an encrypted database")
+			db.Close()
+		}
+
+		results, err := adapter.SearchMessages(store.SearchQuery{Text: "LAKE"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchLate", "searchPicnic"}, ids(results), "Encrypted messages should be found by their newest text")
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, "Bring a blanket to the [lake]", results[0].Snippet, "The snippet should mark the matching word")
+			assert.Equal(t, `{"message":"Bring a blanket"}`, results[0].Message.Content, "The message should be decrypted")
+		}
+
+		results, err = adapter.SearchMessages(store.SearchQuery{Text: "sunday", ChatID: "searchChat", SenderID: "searchBob"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"searchPark"}, ids(results), "Expected the messages of the chat and sender")
+	})
+
+	adapter.Close()
+	t.Log("Search messages test passed")
+}
+
+func TestSearchPurgedMessages(t *testing.T) {
+	dbPath := "test_search_purged_messages.db"
+	defer os.Remove(dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+
+	message := network.Message{
+		Id:            "searchPurged",
+		Timestamp:     10,
+		Content:       `{"message":"supersecret words"}`,
+		SenderID:      "searchAlice",
+		SenderAddress: "searchAlice.onion",
+		ChatID:        "searchPurgeChat",
+		Operation:     network.SEND_MESSAGE,
+	}
+	assert.NoError(t, adapter.StoreMessage(message), "Error storing message")
+
+	purged, err := adapter.PurgeMessages("searchPurgeChat", 20, []network.OperationType{network.SEND_MESSAGE})
+	assert.NoError(t, err, "Error purging messages")
+	assert.Len(t, purged, 1, "The message should be purged")
+
+	results, err := adapter.SearchMessages(store.SearchQuery{Text: "supersecret"})
+	assert.NoError(t, err, "Error searching messages")
+	assert.Empty(t, results, "Purged messages must not be found")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if assert.NoError(t, err, "Error opening database") {
+		var indexed int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM MessageSearch").Scan(&indexed), "Error counting indexed messages")
+		assert.Zero(t, indexed, "The index must not keep the text of purged messages")
+		db.Close()
+	}
+}
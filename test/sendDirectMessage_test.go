@@ -0,0 +1,126 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDirectChats(t *testing.T) {
+	dbPath := "test_direct_chats.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	connection := &recordingConnection{}
+	sender := messageHandlers.NewMessageSender(securityContext)
+	sender.SetNetworkConnection(connection)
+	chatToNetwork := messageHandlers.NewChatToNetwork(sender, adapter)
+	chatToNetwork.SetIdentity("directself.onion", nil)
+	securityContext.Identity().Set("directself.onion")
+	t.Log("Chat to network created")
+
+	newDirectMessage := func(id string, senderAddress string, chatID string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       time.Now().UnixNano(),
+			Content:         `{"message":"Hello"}`,
+			SenderID:        p_model.OnionHost(senderAddress),
+			ReceiverID:      "directself.onion",
+			SenderAddress:   senderAddress,
+			ReceiverAddress: "directself.onion",
+			ChatID:          chatID,
+			Operation:       operation,
+		}
+	}
+
+	t.Run("DeterministicID", func(t *testing.T) {
+		assert.Equal(t, p_model.DirectChatID("directself.onion", "directpeer.onion"), p_model.DirectChatID("ws://directpeer.onion:8080", "directself.onion"), "Both peers must derive the same chat")
+		assert.NotEqual(t, p_model.DirectChatID("directself.onion", "directpeer.onion"), p_model.DirectChatID("directself.onion", "directother.onion"), "Different peers must have different chats")
+		assert.True(t, p_model.IsDirectChatID(p_model.DirectChatID("directself.onion", "directpeer.onion")), "Expected a direct chat id")
+		assert.False(t, p_model.IsDirectChatID("directGroupChat"), "Group chats are not direct chats")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		chatID := p_model.DirectChatID("directself.onion", "directpeer.onion")
+		assert.True(t, securityContext.ValidateIncomingMessage(newDirectMessage("directFirst", "directpeer.onion", chatID, network.SEND_MESSAGE)), "The first message needs no invitation")
+		assert.True(t, securityContext.ValidateIncomingMessage(newDirectMessage("directFirstFile", "directpeer.onion", chatID, network.SEND_FILE)), "The first file needs no invitation")
+		assert.False(t, securityContext.ValidateIncomingMessage(newDirectMessage("directForeign", "directother.onion", chatID, network.SEND_MESSAGE)), "Other peers must not write to the direct chat")
+
+		impostor := newDirectMessage("directImpostor", "directpeer.onion", chatID, network.SEND_MESSAGE)
+		impostor.SenderID = "directother.onion"
+		assert.False(t, securityContext.ValidateIncomingMessage(impostor), "The sender has to be the peer of the connection")
+
+		for _, operation := range []network.OperationType{network.INVITE_TO_CHAT, network.JOIN_CHAT, network.LEAVE_CHAT, network.SET_ROLE, network.REMOVE_MEMBER, network.BAN_MEMBER, network.UPDATE_CHAT_METADATA, network.UPDATE_CHAT_SETTINGS} {
+			assert.False(t, securityContext.ValidateIncomingMessage(newDirectMessage("directGroupOperation", "directpeer.onion", chatID, operation)), "Direct chats have no group operations")
+		}
+	})
+
+	t.Run("SendDirectMessage", func(t *testing.T) {
+		assert.NoError(t, chatToNetwork.SendDirectMessage("directpeer.onion", "directpeer.onion", "Hello"), "Error sending direct message")
+
+		chatID := p_model.DirectChatID("directself.onion", "directpeer.onion")
+		if assert.Len(t, connection.sent, 1, "The direct message should be sent") {
+			assert.Equal(t, chatID, connection.sent[0].ChatID, "Unexpected chat")
+			assert.Equal(t, "directpeer.onion", connection.sent[0].ReceiverAddress, "Unexpected receiver")
+			assert.Equal(t, network.SEND_MESSAGE, connection.sent[0].Operation, "Direct messages reuse SEND_MESSAGE")
+		}
+
+		chats, err := adapter.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		found := false
+		for _, chat := range chats {
+			if chat.ChatId == chatID {
+				found = true
+				assert.Equal(t, "directpeer.onion", chat.DirectPeerId, "Unexpected peer of the direct chat")
+				assert.Equal(t, "directpeer.onion", chat.DirectPeerAddress, "Unexpected address of the direct chat")
+			}
+		}
+		assert.True(t, found, "The first message should create the direct chat")
+
+		// after the chat exists, the other peer is a member and may use the other operations
+		typing := newDirectMessage("directTyping", "directpeer.onion", chatID, network.TYPING_STARTED)
+		assert.True(t, securityContext.ValidateIncomingMessage(typing), "Members of the direct chat may type")
+
+		// once the chat exists, only its peer is accepted
+		stranger := newDirectMessage("directStranger", "directstranger.onion", chatID, network.SEND_MESSAGE)
+		assert.False(t, securityContext.ValidateIncomingMessage(stranger), "Only the peer of the direct chat may write to it")
+	})
+
+	t.Run("ReceiveCreatesChat", func(t *testing.T) {
+		chatID := p_model.DirectChatID("directself.onion", "directreceived.onion")
+		message := newDirectMessage("directReceived", "directreceived.onion", chatID, network.SEND_MESSAGE)
+
+		peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+		defer peer.Close()
+		peer.SetIdentity("directself.onion")
+		assert.NoError(t, peer.Notify(message), "The first direct message should be accepted")
+
+		users, err := adapter.GetUsersInChat(chatID)
+		assert.NoError(t, err, "Error getting users in chat")
+		userIDs := []string{}
+		for _, user := range users {
+			userIDs = append(userIDs, user.UserId)
+		}
+		assert.ElementsMatch(t, []string{"self", "directreceived.onion"}, userIDs, "Both peers should be the only members")
+
+		_, err = adapter.RetrieveMessage("directReceived")
+		assert.NoError(t, err, "The direct message should be stored")
+	})
+
+	t.Log("Direct chats test passed")
+}
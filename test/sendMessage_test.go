@@ -15,7 +15,11 @@ func TestSendMessageHandler(t *testing.T) {
 	defer os.Remove(dbPath)
 	t.Logf("Using temporary database: %s", dbPath)
 
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
 	mockChatLogic := &MockChatLogic{}
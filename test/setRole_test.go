@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSetRoleHandler(t *testing.T) {
+	dbPath := "test_set_role.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	setRoleHandler := messageHandlers.NewSetRoleHandler(mockChatLogic, adapter)
+	t.Log("Set role handler created")
+
+	for _, peerID := range []string{"roleOwner", "roleAdmin", "roleMember"} {
+		err := adapter.PeerJoinedChat(1633029460, peerID, "roleChat")
+		assert.NoError(t, err, "Error adding peer to chat")
+	}
+
+	setRoleMessage := func(senderID string, peerID string, role store.ChatRole) network.Message {
+		content, err := json.Marshal(struct {
+			PeerID string         `json:"peerId"`
+			Role   store.ChatRole `json:"role"`
+		}{peerID, role})
+		assert.NoError(t, err, "Error marshalling set role content")
+
+		return network.Message{
+			Id:            senderID + "_" + peerID + "_setRole",
+			Timestamp:     1633029470,
+			Content:       string(content),
+			SenderID:      senderID,
+			SenderAddress: senderID + ".onion",
+			ChatID:        "roleChat",
+			Operation:     network.SET_ROLE,
+		}
+	}
+
+	inviteMessage := func(senderID string) network.Message {
+		return network.Message{
+			Id:         senderID + "_roleInvite",
+			Timestamp:  1633029480,
+			Content:    `{"chatId":"roleChat","chatName":"Role Chat","peers":[]}`,
+			SenderID:   senderID,
+			ReceiverID: "roleInvitee",
+			ChatID:     "roleChat",
+			Operation:  network.INVITE_TO_CHAT,
+		}
+	}
+
+	t.Run("ClaimOwnershipOfChatWithoutOwner", func(t *testing.T) {
+		assert.False(t, securityContext.ValidateIncomingMessage(setRoleMessage("roleMember", "roleOwner", store.ROLE_OWNER)), "Members must not make others owner")
+
+		message := setRoleMessage("roleOwner", "roleOwner", store.ROLE_OWNER)
+		assert.True(t, securityContext.ValidateIncomingMessage(message), "The first member may claim ownership")
+		assert.NoError(t, setRoleHandler.HandleMessage(message), "Error handling set role message")
+		assert.Equal(t, store.ROLE_OWNER, mockChatLogic.LastRole, "Unexpected LastRole")
+
+		role, err := adapter.GetPeerRole("roleOwner", "roleChat")
+		assert.NoError(t, err, "Error getting role")
+		assert.Equal(t, store.ROLE_OWNER, role, "Unexpected role")
+
+		assert.False(t, securityContext.ValidateIncomingMessage(setRoleMessage("roleMember", "roleMember", store.ROLE_OWNER)), "A chat with an owner can't be claimed")
+	})
+
+	t.Run("OwnerPromotesAdmin", func(t *testing.T) {
+		message := setRoleMessage("roleOwner", "roleAdmin", store.ROLE_ADMIN)
+		assert.True(t, securityContext.ValidateIncomingMessage(message), "Owners may change roles")
+		assert.NoError(t, setRoleHandler.HandleMessage(message), "Error handling set role message")
+
+		assert.False(t, securityContext.ValidateIncomingMessage(setRoleMessage("roleAdmin", "roleMember", store.ROLE_ADMIN)), "Admins must not change roles")
+		assert.False(t, securityContext.ValidateIncomingMessage(setRoleMessage("roleOwner", "stranger", store.ROLE_ADMIN)), "Only members can get a role")
+
+		users, err := adapter.GetUsersInChat("roleChat")
+		assert.NoError(t, err, "Error getting users in chat")
+		for _, user := range users {
+			if user.UserId == "roleAdmin" {
+				assert.Equal(t, store.ROLE_ADMIN, user.Role, "Unexpected role in GetUsersInChat")
+			}
+		}
+	})
+
+	t.Run("InvitePermission", func(t *testing.T) {
+		assert.True(t, securityContext.ValidateIncomingMessage(inviteMessage("roleOwner")), "Owners may invite")
+		assert.True(t, securityContext.ValidateIncomingMessage(inviteMessage("roleAdmin")), "Admins may invite")
+		assert.False(t, securityContext.ValidateIncomingMessage(inviteMessage("roleMember")), "Members must not invite")
+
+		unknownChat := inviteMessage("roleMember")
+		unknownChat.ChatID = "unknownRoleChat"
+		assert.True(t, securityContext.ValidateIncomingMessage(unknownChat), "Invitations to unknown chats are up to the invitee")
+	})
+
+	t.Log("Set role handler test passed")
+}
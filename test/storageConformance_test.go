@@ -0,0 +1,553 @@
+package test
+
+import (
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	runStorageConformance(t, func(t *testing.T) store.Storage {
+		adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(filepath.Join(t.TempDir(), "conformance.db"))
+		if err != nil {
+			t.Fatalf("Error opening database: %v", err)
+		}
+		t.Cleanup(func() { adapter.Close() })
+		return adapter
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	runStorageConformance(t, func(t *testing.T) store.Storage {
+		return storageMemoryAdapter.NewStorageMemoryAdapter()
+	})
+}
+
+// runStorageConformance checks the behaviour every implementation of store.Storage has to share.
+// Every subtest gets an empty storage from newStorage.
+func runStorageConformance(t *testing.T, newStorage func(t *testing.T) store.Storage) {
+	newMessage := func(id string, timestamp int64, senderID, chatID, content string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       timestamp,
+			Content:         content,
+			SenderID:        senderID,
+			ReceiverID:      "receiver",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "receiver.onion",
+			ChatID:          chatID,
+			Operation:       operation,
+		}
+	}
+
+	ids := func(messages []network.Message) []string {
+		found := []string{}
+		for _, message := range messages {
+			found = append(found, message.Id)
+		}
+		return found
+	}
+
+	t.Run("Chats", func(t *testing.T) {
+		s := newStorage(t)
+
+		assert.NoError(t, s.ChatCreated("Group", "group"), "Error creating chat")
+		assert.NoError(t, s.ChatCreated("Renamed", "group"), "Creating an existing chat should be ignored")
+		assert.NoError(t, s.DirectChatCreated(10, "direct", "bob", "bob.onion"), "Error creating direct chat")
+
+		chats, err := s.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		if assert.Len(t, chats, 2) {
+			assert.Equal(t, store.Chat{ChatId: "group", ChatName: "Group"}, chats[0], "Expected the group chat")
+			assert.Equal(t, store.Chat{ChatId: "direct", DirectPeerId: "bob", DirectPeerAddress: "bob.onion"}, chats[1], "Expected the direct chat")
+		}
+
+		users, err := s.GetUsersInChat("direct")
+		assert.NoError(t, err, "Error getting users")
+		assert.Equal(t, []store.User{{UserId: "self"}, {UserId: "bob"}}, users, "Both peers should be members of the direct chat")
+
+		updated, err := s.UpdateChatMetadata(10, "b", "group", store.CHAT_TOPIC, "Second")
+		assert.NoError(t, err, "Error updating metadata")
+		assert.True(t, updated, "The first update should be applied")
+		updated, err = s.UpdateChatMetadata(10, "a", "group", store.CHAT_TOPIC, "First")
+		assert.NoError(t, err, "Error updating metadata")
+		assert.False(t, updated, "An update with the same timestamp and a lower id should lose")
+		updated, err = s.UpdateChatMetadata(5, "c", "group", store.CHAT_TOPIC, "Old")
+		assert.NoError(t, err, "Error updating metadata")
+		assert.False(t, updated, "An older update should lose")
+		updated, err = s.UpdateChatMetadata(1, "a", "group", store.CHAT_NAME, "Name")
+		assert.NoError(t, err, "Error updating metadata")
+		assert.True(t, updated, "The name from ChatCreated should be replaced by any update")
+		_, err = s.UpdateChatMetadata(1, "a", "group", store.ChatMetadataField(42), "Unknown")
+		assert.Error(t, err, "Unknown fields should fail")
+
+		updated, err = s.UpdateChatMetadata(1, "a", "unknown", store.CHAT_DESCRIPTION, "Created")
+		assert.NoError(t, err, "Error updating metadata")
+		assert.True(t, updated, "Updates of unknown chats should create them")
+
+		chats, err = s.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		if assert.Len(t, chats, 3) {
+			assert.Equal(t, "Name", chats[0].ChatName, "Expected the updated name")
+			assert.Equal(t, "Second", chats[0].Topic, "Expected the newest topic")
+			assert.Equal(t, store.Chat{ChatId: "unknown", Description: "Created"}, chats[2], "Expected the created chat")
+		}
+	})
+
+	t.Run("Members", func(t *testing.T) {
+		s := newStorage(t)
+
+		assert.Error(t, s.PeerSetUsername("alice", "group", "Alice"), "Usernames of unknown chats should fail")
+		assert.NoError(t, s.ChatCreated("Group", "group"), "Error creating chat")
+		assert.NoError(t, s.PeerJoinedChat(10, "alice", "group"), "Error joining chat")
+		assert.NoError(t, s.PeerJoinedChat(20, "alice", "group"), "Joining twice should be ignored")
+		assert.NoError(t, s.PeerJoinedChat(30, "bob", "group"), "Error joining chat")
+		assert.NoError(t, s.PeerSetUsername("alice", "group", "Alice"), "Error setting username")
+		assert.NoError(t, s.PeerSetUsername("bob", "group", "Bob"), "Error setting username")
+
+		username, err := s.GetUsername("alice", "group")
+		assert.NoError(t, err, "Error getting username")
+		assert.Equal(t, "Alice", username, "Expected the username")
+		_, err = s.GetUsername("carol", "group")
+		assert.Error(t, err, "Peers that aren't members have no username")
+
+		assert.NoError(t, s.SetPeerRole("alice", "group", store.ROLE_OWNER), "Error setting role")
+		assert.Error(t, s.SetPeerRole("carol", "group", store.ROLE_ADMIN), "Only members can get a role")
+		role, err := s.GetPeerRole("alice", "group")
+		assert.NoError(t, err, "Error getting role")
+		assert.Equal(t, store.ROLE_OWNER, role, "Expected the role")
+		_, err = s.GetPeerRole("carol", "group")
+		assert.Error(t, err, "Peers that aren't members have no role")
+
+		users, err := s.GetUsersInChat("group")
+		assert.NoError(t, err, "Error getting users")
+		assert.Equal(t, []store.User{{UserId: "alice", Username: "Alice", Role: store.ROLE_OWNER}, {UserId: "bob", Username: "Bob"}}, users, "Expected the members in the order they joined")
+
+		assert.NoError(t, s.PeerLeftChat("alice", "group"), "Error leaving chat")
+		assert.NoError(t, s.PeerBannedFromChat(40, "bob", "group"), "Error banning peer")
+		users, err = s.GetUsersInChat("group")
+		assert.NoError(t, err, "Error getting users")
+		assert.Empty(t, users, "All members should be gone")
+
+		banned, err := s.IsBannedFromChat("bob", "group")
+		assert.NoError(t, err, "Error checking ban")
+		assert.True(t, banned, "Bob should be banned")
+		banned, err = s.IsBannedFromChat("alice", "group")
+		assert.NoError(t, err, "Error checking ban")
+		assert.False(t, banned, "Alice only left the chat")
+
+		peers, err := s.GetPeers()
+		assert.NoError(t, err, "Error getting peers")
+		assert.Equal(t, []string{"alice", "bob"}, peers, "Peers with a username should be known")
+	})
+
+	t.Run("Messages", func(t *testing.T) {
+		s := newStorage(t)
+
+		for _, message := range []network.Message{
+			newMessage("second", 20, "alice", "chat", `{"message":"Second"}`, network.SEND_MESSAGE),
+			newMessage("first", 10, "bob", "chat", `{"message":"First"}`, network.SEND_MESSAGE),
+			newMessage("reply", 30, "bob", "chat", `{"message":"Reply","replyTo":"first"}`, network.SEND_MESSAGE),
+			newMessage("joined", 30, "carol", "chat", ``, network.JOIN_CHAT),
+			newMessage("other", 40, "alice", "otherChat", `{"message":"Other"}`, network.SEND_MESSAGE),
+		} {
+			assert.NoError(t, s.StoreMessage(message), "Error storing message")
+		}
+		assert.NoError(t, s.StoreMessage(newMessage("first", 50, "bob", "chat", `{"message":"Again"}`, network.SEND_MESSAGE)), "Storing a message twice should be ignored")
+
+		message, err := s.RetrieveMessage("first")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, newMessage("first", 10, "bob", "chat", `{"message":"First"}`, network.SEND_MESSAGE), message, "Expected the first stored version")
+		_, err = s.RetrieveMessage("unknown")
+		assert.Error(t, err, "Unknown messages can't be retrieved")
+
+		messages, err := s.GetChatMessages("chat")
+		assert.NoError(t, err, "Error getting messages")
+		assert.Equal(t, []string{"first", "second", "joined", "reply"}, ids(messages), "Expected the messages by timestamp and id")
+
+		messages, err = s.GetChatMessagePage(store.MessagePage{ChatID: "chat", Limit: 2})
+		assert.NoError(t, err, "Error getting page")
+		assert.Equal(t, []string{"joined", "reply"}, ids(messages), "Expected the newest messages")
+		messages, err = s.GetChatMessagePage(store.MessagePage{ChatID: "chat", Before: "joined", Limit: 1})
+		assert.NoError(t, err, "Error getting page")
+		assert.Equal(t, []string{"second"}, ids(messages), "Expected the message before the cursor")
+		messages, err = s.GetChatMessagePage(store.MessagePage{ChatID: "chat", After: "first", Limit: 2})
+		assert.NoError(t, err, "Error getting page")
+		assert.Equal(t, []string{"second", "joined"}, ids(messages), "Expected the oldest messages after the cursor")
+		messages, err = s.GetChatMessagePage(store.MessagePage{ChatID: "chat", After: "first", Before: "reply"})
+		assert.NoError(t, err, "Error getting page")
+		assert.Equal(t, []string{"second", "joined"}, ids(messages), "Expected the messages between the cursors")
+		_, err = s.GetChatMessagePage(store.MessagePage{ChatID: "chat", Before: "other"})
+		assert.Error(t, err, "Cursors of other chats should fail")
+
+		messages, err = s.GetMembershipMessages("chat")
+		assert.NoError(t, err, "Error getting membership messages")
+		assert.Equal(t, []string{"joined"}, ids(messages), "Expected the join")
+
+		messages, err = s.GetReplies("first")
+		assert.NoError(t, err, "Error getting replies")
+		assert.Equal(t, []string{"reply"}, ids(messages), "Expected the reply")
+
+		missing, err := s.GetMissingInternalMessages("chat", []string{"first", "other", "new"})
+		assert.NoError(t, err, "Error getting missing messages")
+		assert.Equal(t, []string{"other", "new"}, missing, "Messages of other chats are missing in this chat")
+		missing, err = s.GetMissingExternalMessages("chat", []string{"first", "reply"})
+		assert.NoError(t, err, "Error getting missing messages")
+		assert.ElementsMatch(t, []string{"second", "joined"}, missing, "Expected the messages the other peer doesn't have")
+
+		peers, err := s.GetPeers()
+		assert.NoError(t, err, "Error getting peers")
+		assert.Equal(t, []string{"alice", "receiver", "bob", "carol"}, peers, "Senders and receivers should be known")
+	})
+
+	t.Run("EditsAndDeletions", func(t *testing.T) {
+		s := newStorage(t)
+
+		assert.NoError(t, s.StoreMessage(newMessage("message", 10, "alice", "chat", `{"message":"Hello"}`, network.SEND_MESSAGE)), "Error storing message")
+		assert.NoError(t, s.MessageEdited(30, "edit2", "message", "Hello again"), "Error editing message")
+		assert.NoError(t, s.MessageEdited(20, "edit1", "message", "Hi"), "Error editing message")
+		assert.NoError(t, s.MessageEdited(40, "edit1", "message", "Duplicate"), "Editing twice should be ignored")
+
+		edits, err := s.GetMessageEdits("message")
+		assert.NoError(t, err, "Error getting edits")
+		assert.Equal(t, []store.MessageEdit{{EditId: "edit1", Content: "Hi", Timestamp: 20}, {EditId: "edit2", Content: "Hello again", Timestamp: 30}}, edits, "Expected the oldest edit first")
+
+		assert.NoError(t, s.StoreMessage(newMessage("edit2", 30, "alice", "chat", `{"messageId":"message","message":"Hello again"}`, network.EDIT_MESSAGE)), "Error storing edit")
+		assert.NoError(t, s.MessageDeleted(50, "message"), "Error deleting message")
+
+		deleted, err := s.IsMessageDeleted("message")
+		assert.NoError(t, err, "Error checking deletion")
+		assert.True(t, deleted, "The message should have a tombstone")
+
+		message, err := s.RetrieveMessage("message")
+		assert.NoError(t, err, "The deleted message should be kept")
+		assert.Equal(t, `{"message":""}`, message.Content, "The content should be removed")
+		message, err = s.RetrieveMessage("edit2")
+		assert.NoError(t, err, "Error retrieving edit")
+		assert.Equal(t, `{"messageId":"message","message":""}`, message.Content, "The content of the edit should be removed")
+		edits, err = s.GetMessageEdits("message")
+		assert.NoError(t, err, "Error getting edits")
+		if assert.Len(t, edits, 2) {
+			assert.Empty(t, edits[0].Content+edits[1].Content, "The edit history should be removed")
+		}
+
+		// The deletion arrives before the message
+		assert.NoError(t, s.MessageDeleted(60, "late"), "Error deleting message")
+		assert.NoError(t, s.StoreMessage(newMessage("late", 55, "bob", "chat", `{"message":"Too late"}`, network.SEND_MESSAGE)), "Error storing message")
+		message, err = s.RetrieveMessage("late")
+		assert.NoError(t, err, "Error retrieving message")
+		assert.Equal(t, `{"message":""}`, message.Content, "The content of a deleted message should not be stored")
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		s := newStorage(t)
+
+		for _, reaction := range []struct {
+			timestamp int64
+			id, peer  string
+			emoji     string
+			add       bool
+			applied   bool
+		}{
+			{10, "r1", "alice", "👍", true, true},
+			{10, "r2", "bob", "👍", true, true},
+			{10, "r3", "bob", "🎉", true, true},
+			{20, "r4", "carol", "🎉", true, true},
+			{30, "r5", "carol", "🎉", false, true},
+			{25, "r6", "carol", "🎉", true, false},
+			{30, "r0", "carol", "🎉", true, false},
+		} {
+			applied, err := s.PeerReacted(reaction.timestamp, reaction.id, "message", reaction.peer, reaction.emoji, reaction.add)
+			assert.NoError(t, err, "Error reacting")
+			assert.Equal(t, reaction.applied, applied, "Only newer reactions should be applied: %s", reaction.id)
+		}
+
+		reactions, err := s.GetReactions("message")
+		assert.NoError(t, err, "Error getting reactions")
+		assert.Equal(t, []store.Reaction{{Emoji: "👍", Count: 2}, {Emoji: "🎉", Count: 1}}, reactions, "Expected the most used emoji first")
+	})
+
+	t.Run("Invitations", func(t *testing.T) {
+		s := newStorage(t)
+
+		invite := func(id, chatID string) {
+			assert.NoError(t, s.StoreMessage(newMessage(id, 10, "alice", chatID, ``, network.INVITE_TO_CHAT)), "Error storing invitation")
+		}
+		invite("pending", "chat1")
+		invite("expired", "chat2")
+		invite("accepted", "chat3")
+		invite("declined", "chat4")
+		assert.NoError(t, s.PeerGotInvitedToChat("pending", 0), "Error storing invitation")
+		assert.NoError(t, s.PeerGotInvitedToChat("expired", time.Now().Add(-time.Minute).UnixNano()), "Error storing invitation")
+		assert.NoError(t, s.PeerGotInvitedToChat("accepted", time.Now().Add(time.Minute).UnixNano()), "Error storing invitation")
+		assert.NoError(t, s.InvitedToChat("declined", []store.PublicKeyAddress{{Address: "bob.onion", PublicKey: "bob"}}), "Error storing invitation")
+
+		assert.NoError(t, s.SetInvitationStatus("accepted", store.INVITATION_ACCEPTED), "Error accepting invitation")
+		assert.NoError(t, s.SetInvitationStatus("declined", store.INVITATION_DECLINED), "Error declining invitation")
+		assert.Error(t, s.SetInvitationStatus("declined", store.INVITATION_ACCEPTED), "Only pending invitations can change")
+		assert.Error(t, s.SetInvitationStatus("unknown", store.INVITATION_ACCEPTED), "Unknown invitations can't change")
+
+		invitation, err := s.GetInvitation("pending")
+		assert.NoError(t, err, "Error getting invitation")
+		assert.Equal(t, store.Invitation{
+			MessageId: "pending", ChatId: "chat1", InviterId: "alice", InviterAddress: "alice.onion",
+			InviteeId: "receiver", InviteeAddress: "receiver.onion", Status: store.INVITATION_PENDING,
+		}, invitation, "Expected the invitation")
+		invitation, err = s.GetInvitation("expired")
+		assert.NoError(t, err, "Error getting invitation")
+		assert.Equal(t, store.INVITATION_EXPIRED, invitation.Status, "Pending invitations past their expiry are expired")
+		_, err = s.GetInvitation("unknown")
+		assert.Error(t, err, "Unknown invitations can't be found")
+
+		chats, err := s.GetInvitations("receiver")
+		assert.NoError(t, err, "Error getting invitations")
+		assert.ElementsMatch(t, []string{"chat1", "chat3"}, chats, "Expected the open and accepted invitations")
+
+		user, err := s.GetInviteTokenUser("secret")
+		assert.NoError(t, err, "Error getting token user")
+		assert.Empty(t, user, "The token is unused")
+		assert.NoError(t, s.InviteTokenUsed("secret", "bob"), "Error using token")
+		assert.NoError(t, s.InviteTokenUsed("secret", "carol"), "Using a token twice should be ignored")
+		user, err = s.GetInviteTokenUser("secret")
+		assert.NoError(t, err, "Error getting token user")
+		assert.Equal(t, "bob", user, "Only the first use should count")
+	})
+
+	t.Run("ChatSettings", func(t *testing.T) {
+		s := newStorage(t)
+
+		expiry, err := s.GetMessageExpiry("chat")
+		assert.NoError(t, err, "Error getting expiry")
+		assert.Zero(t, expiry, "Unknown chats keep their messages")
+
+		updated, err := s.SetMessageExpiry(10, "b", "chat", 100)
+		assert.NoError(t, err, "Error setting expiry")
+		assert.True(t, updated, "The first setting should be applied")
+		updated, err = s.SetMessageExpiry(10, "a", "chat", 200)
+		assert.NoError(t, err, "Error setting expiry")
+		assert.False(t, updated, "A setting with the same timestamp and a lower id should lose")
+		_, err = s.SetMessageExpiry(10, "a", "keptChat", 0)
+		assert.NoError(t, err, "Error setting expiry")
+
+		expiries, err := s.GetMessageExpiries()
+		assert.NoError(t, err, "Error getting expiries")
+		assert.Equal(t, map[string]int64{"chat": 100}, expiries, "Expected the chats with disappearing messages")
+
+		for _, message := range []network.Message{
+			newMessage("old", 10, "alice", "chat", `{"message":"Old"}`, network.SEND_MESSAGE),
+			newMessage("oldJoin", 10, "alice", "chat", ``, network.JOIN_CHAT),
+			newMessage("oldEdit", 15, "alice", "chat", `{"messageId":"old","message":"Edited"}`, network.EDIT_MESSAGE),
+			newMessage("new", 30, "alice", "chat", `{"message":"New"}`, network.SEND_MESSAGE),
+			newMessage("otherChat", 10, "alice", "otherChat", `{"message":"Other"}`, network.SEND_MESSAGE),
+		} {
+			assert.NoError(t, s.StoreMessage(message), "Error storing message")
+		}
+		assert.NoError(t, s.MessageEdited(15, "oldEdit", "old", "Edited"), "Error editing message")
+		_, err = s.PeerReacted(20, "reaction", "old", "bob", "👍", true)
+		assert.NoError(t, err, "Error reacting")
+
+		purged, err := s.PurgeMessages("chat", 20, []network.OperationType{network.SEND_MESSAGE, network.EDIT_MESSAGE})
+		assert.NoError(t, err, "Error purging messages")
+		assert.Equal(t, []string{"old", "oldEdit"}, ids(purged), "Expected the old messages with the operations")
+
+		messages, err := s.GetChatMessages("chat")
+		assert.NoError(t, err, "Error getting messages")
+		assert.Equal(t, []string{"oldJoin", "new"}, ids(messages), "Expected the kept messages")
+		edits, err := s.GetMessageEdits("old")
+		assert.NoError(t, err, "Error getting edits")
+		assert.Empty(t, edits, "The edits should be purged")
+		reactions, err := s.GetReactions("old")
+		assert.NoError(t, err, "Error getting reactions")
+		assert.Empty(t, reactions, "The reactions should be purged")
+		_, err = s.RetrieveMessage("otherChat")
+		assert.NoError(t, err, "Messages of other chats should be kept")
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		s := newStorage(t)
+
+		policy, err := s.GetRetentionPolicy("chat")
+		assert.NoError(t, err, "Error getting retention policy")
+		assert.Equal(t, store.RetentionPolicy{}, policy, "Chats keep everything by default")
+
+		assert.NoError(t, s.SetRetentionPolicy("chat", store.RetentionPolicy{MaxAge: 100, MaxMessages: 2}), "Error setting retention policy")
+		assert.NoError(t, s.SetRetentionPolicy("other", store.RetentionPolicy{MaxAttachmentSize: 1024}), "Error setting retention policy")
+		assert.NoError(t, s.SetRetentionPolicy("chat", store.RetentionPolicy{MaxAge: 200}), "Error replacing retention policy")
+		assert.NoError(t, s.SetRetentionPolicy("other", store.RetentionPolicy{}), "Error removing retention policy")
+		policies, err := s.GetRetentionPolicies()
+		assert.NoError(t, err, "Error getting retention policies")
+		assert.Equal(t, map[string]store.RetentionPolicy{"chat": {MaxAge: 200}}, policies, "Expected only the chat with a policy")
+
+		assert.NoError(t, s.MessagesPruned("chat", store.PrunedRange{Before: 20}), "Error recording pruned range")
+		assert.NoError(t, s.MessagesPruned("chat", store.PrunedRange{Before: 10, FilesBefore: 30}), "Error recording pruned range")
+		pruned, err := s.GetPrunedRange("chat")
+		assert.NoError(t, err, "Error getting pruned range")
+		assert.Equal(t, store.PrunedRange{Before: 20, FilesBefore: 30}, pruned, "The pruned range should never shrink")
+		pruned, err = s.GetPrunedRange("other")
+		assert.NoError(t, err, "Error getting pruned range")
+		assert.Equal(t, store.PrunedRange{}, pruned, "Nothing of the other chat was pruned")
+	})
+
+	t.Run("OrphanedPeers", func(t *testing.T) {
+		s := newStorage(t)
+
+		assert.NoError(t, s.ChatCreated("Group", "chat"), "Error creating chat")
+		assert.NoError(t, s.StoreMessage(newMessage("message", 10, "alice", "chat", `{"message":"Hello"}`, network.SEND_MESSAGE)), "Error storing message")
+		assert.NoError(t, s.PeerJoinedChat(20, "bob", "chat"), "Error joining chat")
+		assert.NoError(t, s.PeerSetUsername("bob", "chat", "Bob"), "Error setting username")
+		assert.NoError(t, s.PeerSetUsername("carol", "chat", "Carol"), "Error setting username")
+		assert.NoError(t, s.StoreMessage(newMessage("invite", 30, "alice", "chat", ``, network.INVITE_TO_CHAT)), "Error storing invitation")
+		assert.NoError(t, s.InvitedToChat("invite", []store.PublicKeyAddress{{Address: "dave.onion", PublicKey: "dave"}}), "Error storing invitation")
+		assert.NoError(t, s.InvitedToChat("purged", []store.PublicKeyAddress{{Address: "erin.onion", PublicKey: "erin"}, {Address: "frank.onion", PublicKey: "frank"}}), "Error storing invitation")
+
+		removed, err := s.RemoveOrphanedPeers()
+		assert.NoError(t, err, "Error removing orphaned peers")
+		assert.Equal(t, 3, removed, "Carol isn't a member and the invitation of erin and frank has no message")
+		peers, err := s.GetPeers()
+		assert.NoError(t, err, "Error getting peers")
+		assert.Equal(t, []string{"alice", "receiver", "bob"}, peers, "Peers of messages and members should be kept")
+
+		removed, err = s.RemoveOrphanedPeers()
+		assert.NoError(t, err, "Error removing orphaned peers")
+		assert.Zero(t, removed, "Nothing should be left to remove")
+	})
+
+	t.Run("Presence", func(t *testing.T) {
+		s := newStorage(t)
+
+		presence, err := s.GetPresence("alice.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.Equal(t, store.Presence{Address: "alice.onion"}, presence, "Peers that were never seen are offline")
+
+		assert.NoError(t, s.PeerSeen("alice.onion", 20), "Error marking peer as seen")
+		assert.NoError(t, s.PeerSeen("alice.onion", 10), "Error marking peer as seen")
+		presence, err = s.GetPresence("alice.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.Equal(t, store.Presence{Address: "alice.onion", Online: true, LastSeen: 20}, presence, "The last seen time never goes back")
+
+		assert.NoError(t, s.PeerOffline("alice.onion"), "Error marking peer as offline")
+		assert.NoError(t, s.PeerOffline("bob.onion"), "Error marking peer as offline")
+		presence, err = s.GetPresence("alice.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.Equal(t, store.Presence{Address: "alice.onion", LastSeen: 20}, presence, "Offline peers keep their last seen time")
+		presence, err = s.GetPresence("bob.onion")
+		assert.NoError(t, err, "Error getting presence")
+		assert.Equal(t, store.Presence{Address: "bob.onion"}, presence, "Expected an offline peer that was never seen")
+	})
+
+	t.Run("ContactsAndBlockList", func(t *testing.T) {
+		s := newStorage(t)
+
+		contacts, err := s.GetContacts()
+		assert.NoError(t, err, "Error getting contacts")
+		assert.Empty(t, contacts, "The address book should be empty")
+
+		zoe := store.Contact{Address: "zoe.onion", Nickname: "zoe", PublicKey: "zoeKey", Notes: "Met at the meetup"}
+		adam := store.Contact{Address: "adam.onion", Nickname: "Adam", Verified: true, Blocked: true}
+		assert.NoError(t, s.SaveContact(zoe), "Error saving contact")
+		assert.NoError(t, s.SaveContact(adam), "Error saving contact")
+
+		contacts, err = s.GetContacts()
+		assert.NoError(t, err, "Error getting contacts")
+		assert.Equal(t, []store.Contact{adam, zoe}, contacts, "Expected the contacts sorted by nickname, ignoring case")
+
+		zoe.Nickname = "Zoe"
+		assert.NoError(t, s.SaveContact(zoe), "Error replacing contact")
+		contact, err := s.GetContact("zoe.onion")
+		assert.NoError(t, err, "Error getting contact")
+		assert.Equal(t, zoe, contact, "Expected the replaced contact")
+
+		assert.NoError(t, s.RemoveContact("zoe.onion"), "Error removing contact")
+		assert.Error(t, s.RemoveContact("zoe.onion"), "Removed contacts can't be removed again")
+		_, err = s.GetContact("zoe.onion")
+		assert.Error(t, err, "Removed contacts can't be found")
+
+		assert.NoError(t, s.BlockPeer(10, "mallory.onion"), "Error blocking peer")
+		assert.NoError(t, s.BlockPeer(5, "eve.onion"), "Error blocking peer")
+		assert.NoError(t, s.BlockPeer(1, "mallory.onion"), "Blocking twice should keep the first date")
+		blocked, err := s.GetBlockedPeers()
+		assert.NoError(t, err, "Error getting blocked peers")
+		assert.Equal(t, []string{"eve.onion", "mallory.onion", "adam.onion"}, blocked, "Expected the peer blocked first to come first")
+
+		assert.NoError(t, s.UnblockPeer("adam.onion"), "Error unblocking peer")
+		isBlocked, err := s.IsBlocked("adam.onion")
+		assert.NoError(t, err, "Error checking block list")
+		assert.False(t, isBlocked, "The peer should be unblocked")
+		contact, err = s.GetContact("adam.onion")
+		assert.NoError(t, err, "Error getting contact")
+		assert.False(t, contact.Blocked, "The contact should follow the block list")
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		s := newStorage(t)
+
+		for _, message := range []network.Message{
+			newMessage("picnic", 10, "alice", "chat", `{"message":"Shall we meet for a picnic in the park on Sunday?"}`, network.SEND_MESSAGE),
+			newMessage("park", 20, "bob", "chat", `{"message":"The park is closed on Sunday"}`, network.SEND_MESSAGE),
+			newMessage("other", 30, "alice", "otherChat", `{"message":"Which park?"}`, network.SEND_MESSAGE),
+			newMessage("deleted", 40, "alice", "chat", `{"message":"The park again"}`, network.SEND_MESSAGE),
+			newMessage("username", 50, "alice", "chat", `park`, network.SET_USERNAME),
+		} {
+			assert.NoError(t, s.StoreMessage(message), "Error storing message")
+		}
+		assert.NoError(t, s.MessageDeleted(60, "deleted"), "Error deleting message")
+		assert.NoError(t, s.MessageEdited(70, "edit", "picnic", "Shall we meet at the lake instead?"), "Error editing message")
+
+		searchIDs := func(results []store.SearchResult) []string {
+			found := []string{}
+			for _, result := range results {
+				found = append(found, result.Message.Id)
+			}
+			return found
+		}
+
+		results, err := s.SearchMessages(store.SearchQuery{Text: "PARK"})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"other", "park"}, searchIDs(results), "Expected the messages by their newest text, newest first")
+
+		results, err = s.SearchMessages(store.SearchQuery{Text: "park sunday", ChatID: "chat", SenderID: "bob", Limit: 1})
+		assert.NoError(t, err, "Error searching messages")
+		assert.Equal(t, []string{"park"}, searchIDs(results), "Expected the message of the chat and sender")
+
+		results, err = s.SearchMessages(store.SearchQuery{Text: "lake"})
+		assert.NoError(t, err, "Error searching messages")
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "Shall we meet at the [lake] instead?", results[0].Snippet, "The snippet should mark the matching word")
+		}
+
+		_, err = s.SearchMessages(store.SearchQuery{Text: " "})
+		assert.Error(t, err, "An empty search should fail")
+	})
+
+	t.Run("Encryption", func(t *testing.T) {
+		s := newStorage(t)
+
+		encrypted, err := s.IsEncrypted()
+		assert.NoError(t, err, "Error checking encryption")
+		assert.False(t, encrypted, "A new storage has no passphrase")
+		assert.Error(t, s.Unlock("secret"), "A storage without passphrase can't be unlocked")
+
+		assert.NoError(t, s.ChatCreated("Secret Chat", "chat"), "Error creating chat")
+		assert.NoError(t, s.SetPassphrase("secret"), "Error setting passphrase")
+		assert.Error(t, s.SetPassphrase("other"), "The passphrase can only be set once")
+
+		encrypted, err = s.IsEncrypted()
+		assert.NoError(t, err, "Error checking encryption")
+		assert.True(t, encrypted, "The storage should have a passphrase")
+
+		assert.ErrorIs(t, s.Unlock("wrong"), store.ErrWrongPassphrase, "Expected a wrong passphrase")
+		assert.NoError(t, s.Unlock("secret"), "Error unlocking")
+		assert.ErrorIs(t, s.ChangePassphrase("wrong", "new"), store.ErrWrongPassphrase, "Expected a wrong passphrase")
+		assert.NoError(t, s.ChangePassphrase("secret", "new"), "Error changing passphrase")
+		assert.NoError(t, s.Unlock("new"), "The new passphrase should unlock the storage")
+
+		chats, err := s.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		assert.Equal(t, []store.Chat{{ChatId: "chat", ChatName: "Secret Chat"}}, chats, "The data should stay readable")
+	})
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
 	"github.com/stretchr/testify/assert"
@@ -15,14 +16,19 @@ func TestSyncRequestHandler(t *testing.T) {
 	defer os.Remove(dbPath)
 	t.Logf("Using temporary database: %s", dbPath)
 
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
-	mockNetworkConnection := networkMockAdapter.GetMockConnection()
+	mockNetworkConnection := networkMockAdapter.NewMockConnection()
 	t.Log("Mock network connection created")
 
-	peer := messageHandlers.GetPeerInstance()
-	err := peer.AddNetworkConnection(mockNetworkConnection)
+	peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
+	err = peer.AddNetworkConnection(mockNetworkConnection)
 	assert.NoError(t, err, "Error adding mock network connection to peer")
 	t.Log("Peer instance created and mock network connection added")
 
@@ -2,10 +2,15 @@ package test
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/in/networkMockAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
 	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
 	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
 	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -16,14 +21,19 @@ func TestSyncResponseHandler(t *testing.T) {
 	defer os.Remove(dbPath)
 	t.Logf("Using temporary database: %s", dbPath)
 
-	adapter := storageSQLiteAdapter.GetInstance(dbPath)
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
 	t.Log("Storage adapter initialized")
 
-	mockNetworkConnection := networkMockAdapter.GetMockConnection()
+	mockNetworkConnection := networkMockAdapter.NewMockConnection()
 	t.Log("Mock network connection created")
 
-	peer := messageHandlers.GetPeerInstance()
-	err := peer.AddNetworkConnection(mockNetworkConnection)
+	peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+	defer peer.Close()
+	err = peer.AddNetworkConnection(mockNetworkConnection)
 	assert.NoError(t, err, "Error adding mock network connection to peer")
 	t.Log("Peer instance created and mock network connection added")
 
@@ -54,8 +64,10 @@ func TestSyncResponseHandler(t *testing.T) {
 		}
 		t.Logf("Sync response message prepared: %+v", testSyncResponseMessage)
 
-		err = adapter.PeerJoinedChat(3214523465, "user2", "chat1")
-		assert.NoError(t, err, "Error adding peer to chat")
+		for _, peerID := range []string{"user1", "user2"} {
+			err = adapter.PeerJoinedChat(3214523465, peerID, "chat1")
+			assert.NoError(t, err, "Error adding peer to chat")
+		}
 
 		err = mockNetworkConnection.SendMockNetworkMessageToSubscribers(testSyncResponseMessage)
 		assert.NoError(t, err, "Error sending sync response message")
@@ -72,6 +84,47 @@ func TestSyncResponseHandler(t *testing.T) {
 		t.Log("All synced messages verified successfully")
 	})
 
+	t.Run("RejectForgedMessages", func(t *testing.T) {
+		forged := []network.Message{
+			// user1 edits and deletes the message of user2
+			{Id: "forgedEdit", Timestamp: 1633029451, Content: `{"messageId":"msg2","message":"Forged"}`, SenderID: "user1", SenderAddress: "user1.onion", ChatID: "chat1", Operation: network.EDIT_MESSAGE},
+			{Id: "forgedDelete", Timestamp: 1633029452, Content: `{"messageId":"msg2"}`, SenderID: "user1", SenderAddress: "user1.onion", ChatID: "chat1", Operation: network.DELETE_MESSAGE},
+			// strangers can't send messages and messages of other chats don't belong into the response
+			{Id: "forgedStranger", Timestamp: 1633029453, Content: "Hi", SenderID: "stranger", SenderAddress: "stranger.onion", ChatID: "chat1", Operation: network.SEND_MESSAGE},
+			{Id: "forgedOtherChat", Timestamp: 1633029454, Content: "Hi", SenderID: "user2", SenderAddress: "user2.onion", ChatID: "chat2", Operation: network.SEND_MESSAGE},
+			// the edit of the sender is valid
+			{Id: "validEdit", Timestamp: 1633029455, Content: `{"messageId":"msg2","message":"Edited"}`, SenderID: "user2", SenderAddress: "user2.onion", ChatID: "chat1", Operation: network.EDIT_MESSAGE},
+		}
+		content, err := json.Marshal(forged)
+		assert.NoError(t, err, "Error marshalling sync response content")
+
+		err = mockNetworkConnection.SendMockNetworkMessageToSubscribers(network.Message{
+			Id:            "syncMsg2",
+			Timestamp:     1633029456,
+			Content:       string(content),
+			SenderID:      "user2",
+			SenderAddress: "user2.onion",
+			ChatID:        "chat1",
+			Operation:     network.SYNC_RESPONSE,
+		})
+		assert.NoError(t, err, "Error sending sync response message")
+
+		for _, id := range []string{"forgedEdit", "forgedDelete", "forgedStranger", "forgedOtherChat"} {
+			_, err := adapter.RetrieveMessage(id)
+			assert.Error(t, err, "Forged message %s must not be stored", id)
+		}
+
+		deleted, err := adapter.IsMessageDeleted("msg2")
+		assert.NoError(t, err, "Error checking deletion")
+		assert.False(t, deleted, "Only the sender may delete a message")
+
+		edits, err := adapter.GetMessageEdits("msg2")
+		assert.NoError(t, err, "Error getting edits")
+		if assert.Len(t, edits, 1, "Only the edit of the sender should be applied") {
+			assert.Equal(t, "Edited", edits[0].Content, "Unexpected edit")
+		}
+	})
+
 	t.Log("Sync response test passed")
 }
 
@@ -139,3 +192,77 @@ func getMessagesToSync() []network.Message {
 		},
 	}
 }
+
+func TestSyncRoles(t *testing.T) {
+	storage := storageMemoryAdapter.NewStorageMemoryAdapter()
+	securityContext := p_service.NewSecurityContext(storage, storage, storage, storage)
+	securityContext.Identity().Set("ws://syncself.onion:2220")
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(storage, storage, storage, storage, storage, storage, storage, storage, securityContext)
+
+	// this peer joined the chat and syncs it for the first time
+	assert.NoError(t, storage.PeerJoinedChat(10, "self", "roleSyncChat"), "Error joining chat")
+	assert.NoError(t, storage.StoreMessage(network.Message{Id: "selfJoin", Timestamp: 10, SenderID: "self", SenderAddress: "self", ChatID: "roleSyncChat", Operation: network.JOIN_CHAT}), "Error storing join")
+
+	roleMessage := func(id string, timestamp int64, senderID string, peerID string, role store.ChatRole) network.Message {
+		return network.Message{
+			Id:            id,
+			Timestamp:     timestamp,
+			Content:       fmt.Sprintf(`{"peerId":%q,"role":%d}`, peerID, role),
+			SenderID:      senderID,
+			SenderAddress: senderID,
+			ChatID:        "roleSyncChat",
+			Operation:     network.SET_ROLE,
+		}
+	}
+
+	content, err := json.Marshal([]network.Message{
+		{Id: "creatorJoin", Timestamp: 1, SenderID: "creator.onion", SenderAddress: "creator.onion", ChatID: "roleSyncChat", Operation: network.JOIN_CHAT},
+		roleMessage("creatorOwner", 2, "creator.onion", "creator.onion", store.ROLE_OWNER),
+		// the chat has an owner now, nobody else can claim it
+		roleMessage("malloryOwner", 3, "mallory.onion", "mallory.onion", store.ROLE_OWNER),
+		// the owner makes this peer an admin, it is named by its id
+		roleMessage("selfAdmin", 11, "creator.onion", "syncself.onion", store.ROLE_ADMIN),
+	})
+	assert.NoError(t, err, "Error marshalling synced messages")
+
+	response := network.Message{Id: "roleSyncResponse", Timestamp: 12, Content: string(content), SenderID: "creator.onion", SenderAddress: "creator.onion", ChatID: "roleSyncChat", Operation: network.SYNC_RESPONSE}
+	assert.NoError(t, syncResponseHandler.HandleMessage(response), "Error handling sync response")
+
+	role, err := storage.GetPeerRole("creator.onion", "roleSyncChat")
+	assert.NoError(t, err, "The creator should be a member")
+	assert.Equal(t, store.ROLE_OWNER, role, "The synced claim should make the creator owner")
+
+	role, err = storage.GetPeerRole("self", "roleSyncChat")
+	assert.NoError(t, err, "Error getting role")
+	assert.Equal(t, store.ROLE_ADMIN, role, "The synced role change should be applied")
+
+	_, err = storage.GetPeerRole("mallory.onion", "roleSyncChat")
+	assert.Error(t, err, "Strangers must not become members")
+	assert.False(t, securityContext.ValidateIncomingMessage(roleMessage("selfOwner", 13, "syncother.onion", "syncother.onion", store.ROLE_OWNER)), "A chat with a synced owner can't be claimed")
+}
+
+func TestSyncBan(t *testing.T) {
+	storage := storageMemoryAdapter.NewStorageMemoryAdapter()
+	securityContext := p_service.NewSecurityContext(storage, storage, storage, storage)
+	syncResponseHandler := messageHandlers.NewSyncResponseHandler(storage, storage, storage, storage, storage, storage, storage, storage, securityContext)
+
+	for _, peerID := range []string{"self", "banOwner.onion", "banned.onion"} {
+		assert.NoError(t, storage.PeerJoinedChat(1, peerID, "banSyncChat"), "Error joining chat")
+	}
+	assert.NoError(t, storage.SetPeerRole("banOwner.onion", "banSyncChat", store.ROLE_OWNER), "Error setting role")
+
+	content, err := json.Marshal([]network.Message{
+		{Id: "syncedBan", Timestamp: 2, Content: `{"peerId":"banned.onion"}`, SenderID: "banOwner.onion", SenderAddress: "banOwner.onion", ChatID: "banSyncChat", Operation: network.BAN_MEMBER},
+	})
+	assert.NoError(t, err, "Error marshalling synced messages")
+
+	response := network.Message{Id: "banSyncResponse", Timestamp: 3, Content: string(content), SenderID: "banOwner.onion", SenderAddress: "banOwner.onion", ChatID: "banSyncChat", Operation: network.SYNC_RESPONSE}
+	assert.NoError(t, syncResponseHandler.HandleMessage(response), "Error handling sync response")
+
+	banned, err := storage.IsBannedFromChat("banned.onion", "banSyncChat")
+	assert.NoError(t, err, "Error checking ban")
+	assert.True(t, banned, "The synced ban should be applied")
+
+	rejoin := network.Message{Id: "bannedRejoin", Timestamp: 4, SenderID: "banned.onion", SenderAddress: "banned.onion", ChatID: "banSyncChat", Operation: network.JOIN_CHAT}
+	assert.False(t, securityContext.ValidateIncomingMessage(rejoin), "Peers banned through sync must not join again")
+}
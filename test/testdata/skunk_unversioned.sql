@@ -0,0 +1,53 @@
+-- Database of the first release of Skunk, before the schema was versioned
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE Chats (
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Chats_pk PRIMARY KEY,
+    name VARCHAR(40) NOT NULL
+);
+INSERT INTO Chats VALUES('fixtureChat','Fixture chat');
+CREATE TABLE Peers (
+    peer_id INTEGER NOT NULL CONSTRAINT Peers_pk PRIMARY KEY AUTOINCREMENT,
+    public_key VARCHAR(1024) NOT NULL,
+    address VARCHAR(1024) NOT NULL
+);
+INSERT INTO Peers VALUES(1,'fixturePeer','fixturepeer.onion');
+INSERT INTO Peers VALUES(2,'self','self');
+CREATE TABLE ChatMembers (
+    chat_member_id INTEGER NOT NULL CONSTRAINT ChatMembers_pk PRIMARY KEY AUTOINCREMENT,
+    date INTEGER NOT NULL,
+    peer_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+    username VARCHAR(50)
+);
+INSERT INTO ChatMembers VALUES(1,1700000000000000000,'self','fixtureChat','Alice');
+INSERT INTO ChatMembers VALUES(2,1700000000000000001,'fixturePeer','fixtureChat','Bob');
+CREATE TABLE Messages (
+    message_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_pk PRIMARY KEY,
+    content TEXT,
+    date INTEGER NOT NULL,
+    operation INTEGER NOT NULL,
+    sender_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+    receiver_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk_2 REFERENCES Peers,
+    sender_address VARCHAR(1024) NOT NULL,
+    receiver_address VARCHAR(1024) NOT NULL
+);
+INSERT INTO Messages VALUES('fixtureMessage','{"message":"Hello from an old database"}',1700000000000000002,0,'1','fixtureChat','2','fixturepeer.onion','self');
+INSERT INTO Messages VALUES('fixtureInvitation','{"chatName":"Fixture invite"}',1700000000000000003,5,'1','fixtureInvitedChat','2','fixturepeer.onion','self');
+CREATE TABLE Invitations (
+    invitation_id INTEGER NOT NULL CONSTRAINT Invitations_pk PRIMARY KEY AUTOINCREMENT,
+    invitation_status INTEGER NOT NULL,
+    message_id VARCHAR(1024) NOT NULL CONSTRAINT Invitations_Messages_message_id_fk REFERENCES Messages
+);
+INSERT INTO Invitations VALUES(1,0,'fixtureInvitation');
+CREATE TABLE PeersInInvitedChat (
+    public_key VARCHAR(1024) NOT NULL,
+    invited_peer_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_pk PRIMARY KEY AUTOINCREMENT,
+    address VARCHAR(1024) NOT NULL,
+    invitation_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_Invitations_invitation_id_fk REFERENCES Invitations
+);
+INSERT INTO sqlite_sequence VALUES('Peers',2);
+INSERT INTO sqlite_sequence VALUES('ChatMembers',2);
+INSERT INTO sqlite_sequence VALUES('Invitations',1);
+COMMIT;
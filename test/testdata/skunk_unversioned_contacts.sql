@@ -0,0 +1,103 @@
+-- Unversioned database with contacts that were blocked in the Contacts table, before the block list existed
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE Chats (
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Chats_pk PRIMARY KEY,
+    name VARCHAR(40) NOT NULL,
+    name_updated INTEGER NOT NULL DEFAULT 0,
+    name_message_id VARCHAR(1024) NOT NULL DEFAULT '',
+    topic VARCHAR(256) NOT NULL DEFAULT '',
+    topic_updated INTEGER NOT NULL DEFAULT 0,
+    topic_message_id VARCHAR(1024) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    description_updated INTEGER NOT NULL DEFAULT 0,
+    description_message_id VARCHAR(1024) NOT NULL DEFAULT '',
+    message_expiry INTEGER NOT NULL DEFAULT 0,
+    message_expiry_updated INTEGER NOT NULL DEFAULT 0,
+    message_expiry_message_id VARCHAR(1024) NOT NULL DEFAULT '',
+    direct_peer_id VARCHAR(1024),
+    direct_peer_address VARCHAR(1024)
+);
+CREATE TABLE Peers (
+    peer_id INTEGER NOT NULL CONSTRAINT Peers_pk PRIMARY KEY AUTOINCREMENT,
+    public_key VARCHAR(1024) NOT NULL,
+    address VARCHAR(1024) NOT NULL
+);
+CREATE TABLE ChatMembers (
+    chat_member_id INTEGER NOT NULL CONSTRAINT ChatMembers_pk PRIMARY KEY AUTOINCREMENT,
+    date INTEGER NOT NULL,
+    peer_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatMembers_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+    username VARCHAR(50),
+    role INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE Messages (
+    message_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_pk PRIMARY KEY,
+    content TEXT,
+    date INTEGER NOT NULL,
+    operation INTEGER NOT NULL,
+    sender_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk REFERENCES Peers ON UPDATE CASCADE,
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE,
+    receiver_peer_id VARCHAR(1024) NOT NULL CONSTRAINT Messages_Peers_peer_id_fk_2 REFERENCES Peers,
+    sender_address VARCHAR(1024) NOT NULL,
+    receiver_address VARCHAR(1024) NOT NULL,
+    reply_to VARCHAR(1024)
+);
+CREATE TABLE Invitations (
+    invitation_id INTEGER NOT NULL CONSTRAINT Invitations_pk PRIMARY KEY AUTOINCREMENT,
+    invitation_status INTEGER NOT NULL,
+    message_id VARCHAR(1024) NOT NULL CONSTRAINT Invitations_Messages_message_id_fk REFERENCES Messages,
+    expires INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE PeersInInvitedChat (
+    public_key VARCHAR(1024) NOT NULL,
+    invited_peer_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_pk PRIMARY KEY AUTOINCREMENT,
+    address VARCHAR(1024) NOT NULL,
+    invitation_id INTEGER NOT NULL CONSTRAINT PeersInInvitedChat_Invitations_invitation_id_fk REFERENCES Invitations
+);
+CREATE TABLE InviteTokens (
+    secret VARCHAR(64) NOT NULL CONSTRAINT InviteTokens_pk PRIMARY KEY,
+    peer_id VARCHAR(1024) NOT NULL
+);
+CREATE TABLE ChatBans (
+    chat_ban_id INTEGER NOT NULL CONSTRAINT ChatBans_pk PRIMARY KEY AUTOINCREMENT,
+    date INTEGER NOT NULL,
+    peer_id VARCHAR(1024) NOT NULL,
+    chat_id VARCHAR(1024) NOT NULL CONSTRAINT ChatBans_Chats_chat_id_fk REFERENCES Chats ON UPDATE CASCADE
+);
+CREATE TABLE MessageEdits (
+    edit_message_id VARCHAR(1024) NOT NULL CONSTRAINT MessageEdits_pk PRIMARY KEY,
+    message_id VARCHAR(1024) NOT NULL,
+    date INTEGER NOT NULL,
+    content TEXT
+);
+CREATE TABLE DeletedMessages (
+    message_id VARCHAR(1024) NOT NULL CONSTRAINT DeletedMessages_pk PRIMARY KEY,
+    date INTEGER NOT NULL
+);
+CREATE TABLE Reactions (
+    message_id VARCHAR(1024) NOT NULL,
+    peer_id VARCHAR(1024) NOT NULL,
+    emoji VARCHAR(32) NOT NULL,
+    active INTEGER NOT NULL,
+    date INTEGER NOT NULL,
+    reaction_message_id VARCHAR(1024) NOT NULL,
+    CONSTRAINT Reactions_pk PRIMARY KEY (message_id, peer_id, emoji)
+);
+CREATE TABLE Presence (
+    address VARCHAR(1024) NOT NULL CONSTRAINT Presence_pk PRIMARY KEY,
+    online INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL
+);
+CREATE TABLE Contacts (
+    address VARCHAR(1024) NOT NULL CONSTRAINT Contacts_pk PRIMARY KEY,
+    nickname VARCHAR(40) NOT NULL,
+    public_key VARCHAR(1024) NOT NULL,
+    verified INTEGER NOT NULL DEFAULT 0,
+    notes TEXT NOT NULL DEFAULT '',
+    blocked INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO Contacts VALUES('blockedcontact.onion','Mallory','00',0,'',1);
+INSERT INTO Contacts VALUES('friendcontact.onion','Carol','00',1,'Friend',0);
+CREATE INDEX Messages_reply_to_index ON Messages (reply_to);
+COMMIT;
@@ -1,7 +1,11 @@
 package test
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -135,3 +139,104 @@ func TestReusePrivateKeyTor(t *testing.T) {
 
 	assert.Equal(t, onionIDOne, onionIDTwo)
 }
+
+func TestTorConfigValidation(t *testing.T) {
+	valid := []tor.TorConfig{
+		tor.DefaultConfig(),
+		{SocksPort: "9071", LocalPort: "1111", RemotePort: "2222"},
+		{ControlPort: "9051", LocalPort: "1111", RemotePort: "2222"},
+		{ControlPort: "9051", SocksPort: "9050", LocalPort: "1111", RemotePort: "2222", ControlPassword: "secret"},
+	}
+	for _, conf := range valid {
+		_, err := tor.NewTor(&conf)
+		assert.NoError(t, err, "Expected a valid configuration: %+v", conf)
+	}
+
+	invalid := map[string]tor.TorConfig{
+		"no socks port":              {LocalPort: "1111", RemotePort: "2222"},
+		"socks port of the system":   {SocksPort: "9050", LocalPort: "1111", RemotePort: "2222"},
+		"no local port":              {SocksPort: "9071", RemotePort: "2222"},
+		"no remote port":             {SocksPort: "9071", LocalPort: "1111"},
+		"port is no number":          {SocksPort: "socks", LocalPort: "1111", RemotePort: "2222"},
+		"port out of range":          {SocksPort: "9071", LocalPort: "70000", RemotePort: "2222"},
+		"local port is socks port":   {SocksPort: "9071", LocalPort: "9071", RemotePort: "2222"},
+		"embedded and control port":  {ControlPort: "9051", LocalPort: "1111", RemotePort: "2222", UseEmbedded: true},
+		"control port is local port": {ControlPort: "1111", LocalPort: "1111", RemotePort: "2222"},
+		"password without control":   {SocksPort: "9071", LocalPort: "1111", RemotePort: "2222", ControlPassword: "secret"},
+	}
+	for name, conf := range invalid {
+		_, err := tor.NewTor(&conf)
+		assert.Error(t, err, "Expected an invalid configuration: %s", name)
+	}
+}
+
+func TestTorConfigFromEnvironment(t *testing.T) {
+	t.Setenv("SKUNK_TOR_DATA_DIR", "env-data-dir")
+	t.Setenv("SKUNK_TOR_REUSE_KEY", "false")
+	conf, err := tor.ConfigFromEnvironment(tor.DefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "env-data-dir", conf.DataDir)
+	assert.False(t, conf.ReusePrivateKey)
+	assert.Equal(t, tor.DefaultConfig().SocksPort, conf.SocksPort, "Ports that aren't set should be kept")
+
+	t.Setenv("SKUNK_TOR_CONTROL_PORT", "9051")
+	conf, err = tor.ConfigFromEnvironment(tor.DefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "9051", conf.ControlPort)
+	assert.Empty(t, conf.SocksPort, "The socks port of the daemon should be used")
+	assert.False(t, conf.UseEmbedded, "A control port attaches to the daemon")
+
+	t.Setenv("SKUNK_TOR_EMBEDDED", "maybe")
+	_, err = tor.ConfigFromEnvironment(tor.DefaultConfig())
+	assert.Error(t, err)
+}
+
+// fakeControlPort answers the control commands of an attaching client like a tor daemon without authentication
+func fakeControlPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(line) {
+			case "PROTOCOLINFO":
+				fmt.Fprint(conn, "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=NULL\r\n250-VERSION Tor=\"0.4.6.10\"\r\n250 OK\r\n")
+			case "GETINFO net/listeners/socks":
+				fmt.Fprint(conn, "250-net/listeners/socks=\"127.0.0.1:9050\" \"[::1]:9050\"\r\n250 OK\r\n")
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestAttachToControlPort(t *testing.T) {
+	conf := &tor.TorConfig{
+		DataDir:     t.TempDir(),
+		ControlPort: fakeControlPort(t),
+		LocalPort:   "1111",
+		RemotePort:  "2222",
+	}
+	myTor, err := tor.NewTor(conf)
+	assert.NoError(t, err)
+
+	err = myTor.StartTor()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9050", myTor.SocksAddress(), "The socks port should be asked from the daemon")
+}
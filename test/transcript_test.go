@@ -0,0 +1,147 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageMemoryAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+)
+
+// transcriptStorage returns a storage with a chat over two days: a join, messages, an edit, a deletion, a reply and a file.
+// Edits and deletions of messages of other peers and of events are stored as well, they must not change the transcript.
+func transcriptStorage(t *testing.T) *storageMemoryAdapter.StorageMemoryAdapter {
+	storage := storageMemoryAdapter.NewStorageMemoryAdapter()
+	assert.NoError(t, storage.ChatCreated("Team <Chat>", "transcriptChat"))
+	assert.NoError(t, storage.PeerJoinedChat(1, "alice", "transcriptChat"))
+	assert.NoError(t, storage.PeerSetUsername("alice", "transcriptChat", "Alice"))
+
+	day1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2024, 3, 2, 10, 0, 0, 0, time.Local)
+	message := func(id string, at time.Time, sender string, operation network.OperationType, content string) network.Message {
+		return network.Message{Id: id, Timestamp: at.UnixNano(), Content: content, SenderID: sender, ChatID: "transcriptChat", Operation: operation}
+	}
+	for _, m := range []network.Message{
+		message("join", day1, "bob", network.JOIN_CHAT, ""),
+		message("hello", day1.Add(time.Minute), "alice", network.SEND_MESSAGE, `{"message":"Hello *team* <b>"}`),
+		message("typo", day1.Add(2*time.Minute), "bob", network.SEND_MESSAGE, `{"message":"Hi Alcie"}`),
+		message("fix", day2, "bob", network.EDIT_MESSAGE, `{"messageId":"typo","message":"Hi Alice"}`),
+		message("secret", day2.Add(time.Minute), "alice", network.SEND_MESSAGE, `{"message":"Password 1234"}`),
+		message("remove", day2.Add(2*time.Minute), "alice", network.DELETE_MESSAGE, `{"messageId":"secret"}`),
+		message("answer", day2.Add(3*time.Minute), "bob", network.SEND_MESSAGE, `{"message":"Thanks","replyTo":"hello"}`),
+		message("file", day2.Add(4*time.Minute), "alice", network.SEND_FILE, `{"fileName":"plan","fileExtension":"pdf","fileContent":"cGxhbg=="}`),
+		message("react", day2.Add(5*time.Minute), "bob", network.REACT, `{"messageId":"file","emoji":"👍"}`),
+		message("forgedEdit", day2.Add(6*time.Minute), "alice", network.EDIT_MESSAGE, `{"messageId":"answer","message":"Forged"}`),
+		message("forgedDelete", day2.Add(7*time.Minute), "bob", network.DELETE_MESSAGE, `{"messageId":"hello"}`),
+		message("joinEdit", day2.Add(8*time.Minute), "bob", network.EDIT_MESSAGE, `{"messageId":"join","message":"Forged"}`),
+	} {
+		assert.NoError(t, storage.StoreMessage(m), "Error storing message %s", m.Id)
+	}
+	return storage
+}
+
+func TestTranscriptEntries(t *testing.T) {
+	storage := transcriptStorage(t)
+
+	transcript, err := c_service.BuildTranscript(storage, "transcriptChat", c_service.TranscriptOptions{})
+	if !assert.NoError(t, err, "Error building transcript") {
+		return
+	}
+	assert.Equal(t, "Team <Chat>", transcript.ChatName)
+
+	kinds := []string{}
+	for _, entry := range transcript.Entries {
+		kinds = append(kinds, entry.Kind+":"+entry.Id)
+	}
+	assert.Equal(t, []string{"join:join", "message:hello", "message:typo", "message:secret", "message:answer", "file:file"}, kinds,
+		"Edits and deletions should change their messages, reactions are not part of the transcript")
+
+	entries := map[string]c_service.TranscriptEntry{}
+	for _, entry := range transcript.Entries {
+		entries[entry.Id] = entry
+	}
+	assert.Equal(t, "Alice", entries["hello"].Sender, "The username should be resolved")
+	assert.Equal(t, "bob", entries["join"].Sender, "Senders without username keep their id")
+	assert.Equal(t, "bob joined the chat", entries["join"].Text)
+	assert.Equal(t, "Hi Alice", entries["typo"].Text, "The newest edit should be shown")
+	assert.True(t, entries["typo"].Edited)
+	assert.True(t, entries["secret"].Deleted)
+	assert.Empty(t, entries["secret"].Text, "Deleted messages lose their text")
+	assert.Equal(t, "hello", entries["answer"].ReplyTo)
+	assert.Equal(t, "Thanks", entries["answer"].Text, "Only the sender may edit a message")
+	assert.False(t, entries["answer"].Edited, "Only the sender may edit a message")
+	assert.False(t, entries["hello"].Deleted, "Only the sender may delete a message")
+	assert.Equal(t, "bob joined the chat", entries["join"].Text, "Events can't be edited")
+	assert.Equal(t, "plan.pdf", entries["file"].File)
+	assert.NotContains(t, entries["file"].Text, "cGxhbg==", "The content of files is not exported")
+}
+
+func TestTranscriptDateRange(t *testing.T) {
+	storage := transcriptStorage(t)
+
+	from, to, err := c_service.ParseTranscriptRange("2024-03-02", "2024-03-02")
+	if !assert.NoError(t, err) {
+		return
+	}
+	transcript, err := c_service.BuildTranscript(storage, "transcriptChat", c_service.TranscriptOptions{From: from, To: to})
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, entry := range transcript.Entries {
+		ids = append(ids, entry.Id)
+	}
+	assert.Equal(t, []string{"secret", "answer", "file"}, ids, "Only the messages of the second day should be exported")
+
+	_, to, err = c_service.ParseTranscriptRange("", "2024-03-01")
+	assert.NoError(t, err)
+	transcript, err = c_service.BuildTranscript(storage, "transcriptChat", c_service.TranscriptOptions{To: to})
+	assert.NoError(t, err)
+	assert.Len(t, transcript.Entries, 3, "The last day should be included")
+	assert.Equal(t, "Hi Alice", transcript.Entries[2].Text, "Edits after the range should still be applied")
+
+	_, _, err = c_service.ParseTranscriptRange("2024-03-02", "2024-03-01")
+	assert.Error(t, err, "The range must not end before it starts")
+	_, _, err = c_service.ParseTranscriptRange("March", "")
+	assert.Error(t, err, "Dates have to be YYYY-MM-DD")
+}
+
+func TestTranscriptFormats(t *testing.T) {
+	storage := transcriptStorage(t)
+	export := func(format c_service.TranscriptFormat) string {
+		var buffer bytes.Buffer
+		err := c_service.ExportTranscript(storage, "transcriptChat", c_service.TranscriptOptions{Format: format}, &buffer)
+		assert.NoError(t, err, "Error exporting %s", format)
+		return buffer.String()
+	}
+
+	var transcript c_service.Transcript
+	assert.NoError(t, json.Unmarshal([]byte(export(c_service.TRANSCRIPT_JSON)), &transcript), "The JSON transcript should be valid")
+	assert.Len(t, transcript.Entries, 6)
+	assert.Equal(t, "transcriptChat", transcript.ChatID)
+
+	markdown := export(c_service.TRANSCRIPT_MARKDOWN)
+	assert.Contains(t, markdown, "# Team \\<Chat\\>")
+	assert.Contains(t, markdown, "## 2024-03-01")
+	assert.Contains(t, markdown, "**Alice:** Hello \\*team\\* \\<b\\>", "Markdown in messages should be escaped")
+	assert.Contains(t, markdown, "Hi Alice _(edited)_")
+	assert.Contains(t, markdown, "_This message was deleted_")
+	assert.Contains(t, markdown, "↪ reply to Alice: Thanks")
+	assert.NotContains(t, markdown, "1234")
+
+	page := export(c_service.TRANSCRIPT_HTML)
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, "Hello *team* &lt;b&gt;", "HTML in messages should be escaped")
+	assert.NotContains(t, page, "<b>")
+	assert.NotContains(t, page, "<link", "The page should be self-contained")
+	assert.NotContains(t, page, "<script")
+
+	_, err := c_service.ParseTranscriptFormat("pdf")
+	assert.Error(t, err)
+	format, err := c_service.ParseTranscriptFormat("Markdown")
+	assert.NoError(t, err)
+	assert.Equal(t, c_service.TRANSCRIPT_MARKDOWN, format)
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/chat/c_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTypingHandler(t *testing.T) {
+	dbPath := "test_typing.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	typingHandler := messageHandlers.NewTypingHandler(mockChatLogic, 100*time.Millisecond)
+	t.Log("Typing handler created")
+
+	assert.NoError(t, adapter.PeerJoinedChat(1633029460, "typingPeer", "typingChat"), "Error adding peer to chat")
+
+	newTyping := func(id string, senderID string, operation network.OperationType) network.Message {
+		return network.Message{
+			Id:              id,
+			Timestamp:       time.Now().UnixNano(),
+			Content:         "{}",
+			SenderID:        senderID,
+			ReceiverID:      "?",
+			SenderAddress:   senderID + ".onion",
+			ReceiverAddress: "?",
+			ChatID:          "typingChat",
+			Operation:       operation,
+		}
+	}
+
+	countTypingCalls := func() int {
+		count := 0
+		for _, entry := range mockChatLogic.LogEntries {
+			if entry == "PeerTyping called" {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("OnlyMembers", func(t *testing.T) {
+		assert.False(t, securityContext.ValidateIncomingMessage(newTyping("typingStranger", "typingStranger", network.TYPING_STARTED)), "Only members may send typing messages")
+		assert.True(t, securityContext.ValidateIncomingMessage(newTyping("typingMember", "typingPeer", network.TYPING_STARTED)), "Members may send typing messages")
+	})
+
+	t.Run("StartAndStop", func(t *testing.T) {
+		assert.NoError(t, typingHandler.HandleMessage(newTyping("typing1", "typingPeer", network.TYPING_STARTED)), "Error handling typing message")
+		assert.True(t, mockChatLogic.LastTyping, "The peer should be typing")
+
+		// repeated messages within the rate limit are dropped
+		assert.NoError(t, typingHandler.HandleMessage(newTyping("typing2", "typingPeer", network.TYPING_STARTED)), "Error handling typing message")
+		assert.Equal(t, 1, countTypingCalls(), "Repeated typing messages must not notify the chat logic")
+
+		assert.NoError(t, typingHandler.HandleMessage(newTyping("typing3", "typingPeer", network.TYPING_STOPPED)), "Error handling typing message")
+		assert.False(t, mockChatLogic.LastTyping, "The peer should have stopped typing")
+		assert.Equal(t, 2, countTypingCalls(), "Unexpected number of typing notifications")
+	})
+
+	t.Run("AutoExpiry", func(t *testing.T) {
+		assert.NoError(t, typingHandler.HandleMessage(newTyping("typing4", "typingPeer", network.TYPING_STARTED)), "Error handling typing message")
+		assert.True(t, mockChatLogic.LastTyping, "The peer should be typing")
+
+		assert.Eventually(t, func() bool { return countTypingCalls() == 4 }, time.Second, 10*time.Millisecond, "The indicator should expire")
+		assert.False(t, mockChatLogic.LastTyping, "The indicator should expire")
+	})
+
+	t.Run("OutdatedMessages", func(t *testing.T) {
+		outdated := newTyping("typing5", "typingPeer", network.TYPING_STARTED)
+		outdated.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+		assert.NoError(t, typingHandler.HandleMessage(outdated), "Error handling typing message")
+		assert.Equal(t, 4, countTypingCalls(), "Outdated typing messages must be ignored")
+	})
+
+	t.Run("NotStoredOrSynced", func(t *testing.T) {
+		peer := messageHandlers.NewPeer(adapter, c_service.NewChatApp(), t.TempDir())
+		defer peer.Close()
+		assert.NoError(t, peer.Notify(newTyping("typingNotified", "typingPeer", network.TYPING_STARTED)), "Error handling typing message")
+		_, err := adapter.RetrieveMessage("typingNotified")
+		assert.Error(t, err, "Typing messages must not be stored")
+
+		content, err := json.Marshal([]network.Message{newTyping("typingSynced", "typingPeer", network.TYPING_STARTED)})
+		assert.NoError(t, err, "Error marshalling synced messages")
+		syncResponse := newTyping("typingSyncResponse", "typingPeer", network.SYNC_RESPONSE)
+		syncResponse.Content = string(content)
+
+		syncResponseHandler := messageHandlers.NewSyncResponseHandler(adapter, adapter, adapter, adapter, adapter, adapter, adapter, adapter, securityContext)
+		assert.NoError(t, syncResponseHandler.HandleMessage(syncResponse), "Error handling sync response")
+		_, err = adapter.RetrieveMessage("typingSynced")
+		assert.Error(t, err, "Typing messages must not be synced")
+	})
+
+	t.Log("Typing handler test passed")
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/scherzma/Skunk/cmd/skunk/adapter/out/storage/storageSQLiteAdapter"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_model"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service"
+	"github.com/scherzma/Skunk/cmd/skunk/application/domain/p2p_network/p_service/messageHandlers"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/network"
+	"github.com/scherzma/Skunk/cmd/skunk/application/port/store"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUpdateChatMetadataHandler(t *testing.T) {
+	dbPath := "test_update_chat_metadata.db"
+	defer os.Remove(dbPath)
+	t.Logf("Using temporary database: %s", dbPath)
+
+	adapter, err := storageSQLiteAdapter.NewStorageSQLiteAdapter(dbPath)
+	if !assert.NoError(t, err, "Error opening database") {
+		return
+	}
+	defer adapter.Close()
+	t.Log("Storage adapter initialized")
+
+	mockChatLogic := &MockChatLogic{}
+	securityContext := p_service.NewSecurityContext(adapter, adapter, adapter, adapter)
+	updateChatMetadataHandler := messageHandlers.NewUpdateChatMetadataHandler(mockChatLogic, adapter)
+	t.Log("Update chat metadata handler created")
+
+	assert.NoError(t, adapter.ChatCreated("Metadata Chat", "metadataChat"), "Error creating chat")
+	for _, peerID := range []string{"metadataAdmin", "metadataMember"} {
+		assert.NoError(t, adapter.PeerJoinedChat(1633029460, peerID, "metadataChat"), "Error adding peer to chat")
+	}
+	assert.NoError(t, adapter.SetPeerRole("metadataAdmin", "metadataChat", store.ROLE_ADMIN), "Error setting admin role")
+
+	metadataMessage := func(id string, timestamp int64, senderID string, field store.ChatMetadataField, value string) network.Message {
+		update, err := p_model.NewChatMetadataUpdate(field, value)
+		assert.NoError(t, err, "Error creating metadata update")
+		content, err := json.Marshal(update)
+		assert.NoError(t, err, "Error marshalling metadata update")
+
+		return network.Message{
+			Id:            id,
+			Timestamp:     timestamp,
+			Content:       string(content),
+			SenderID:      senderID,
+			SenderAddress: senderID + ".onion",
+			ChatID:        "metadataChat",
+			Operation:     network.UPDATE_CHAT_METADATA,
+		}
+	}
+
+	getChat := func() store.Chat {
+		chats, err := adapter.GetChats()
+		assert.NoError(t, err, "Error getting chats")
+		for _, chat := range chats {
+			if chat.ChatId == "metadataChat" {
+				return chat
+			}
+		}
+		t.Fatal("Chat not found")
+		return store.Chat{}
+	}
+
+	t.Run("Permissions", func(t *testing.T) {
+		assert.False(t, securityContext.ValidateIncomingMessage(metadataMessage("m1", 10, "metadataMember", store.CHAT_NAME, "Hijacked")), "Members must not change the metadata")
+		assert.False(t, securityContext.ValidateIncomingMessage(metadataMessage("m2", 10, "metadataAdmin", store.CHAT_NAME, "")), "The name must not be empty")
+		assert.False(t, securityContext.ValidateIncomingMessage(metadataMessage("m3", 10, "metadataAdmin", store.CHAT_TOPIC, strings.Repeat("x", 257))), "The topic must not be too long")
+
+		empty := metadataMessage("m4", 10, "metadataAdmin", store.CHAT_TOPIC, "")
+		empty.Content = "{}"
+		assert.False(t, securityContext.ValidateIncomingMessage(empty), "An update has to change something")
+
+		assert.True(t, securityContext.ValidateIncomingMessage(metadataMessage("m5", 10, "metadataAdmin", store.CHAT_TOPIC, "")), "Admins may clear the topic")
+	})
+
+	t.Run("LastWriterWins", func(t *testing.T) {
+		newer := metadataMessage("rename2", 30, "metadataAdmin", store.CHAT_NAME, "Newer Name")
+		older := metadataMessage("rename1", 20, "metadataAdmin", store.CHAT_NAME, "Older Name")
+		topic := metadataMessage("topic1", 25, "metadataAdmin", store.CHAT_TOPIC, "Some Topic")
+
+		assert.NoError(t, updateChatMetadataHandler.HandleMessage(newer), "Error handling metadata update")
+		assert.Equal(t, "Newer Name", mockChatLogic.LastValue, "Unexpected LastValue")
+
+		mockChatLogic.LastValue = ""
+		assert.NoError(t, updateChatMetadataHandler.HandleMessage(older), "Error handling metadata update")
+		assert.Equal(t, "", mockChatLogic.LastValue, "An older update must not be reported")
+
+		assert.NoError(t, updateChatMetadataHandler.HandleMessage(topic), "Error handling metadata update")
+		assert.Equal(t, store.CHAT_TOPIC, mockChatLogic.LastField, "Unexpected LastField")
+
+		chat := getChat()
+		assert.Equal(t, "Newer Name", chat.ChatName, "The newest name should win")
+		assert.Equal(t, "Some Topic", chat.Topic, "Fields are resolved independently")
+
+		// concurrent updates with the same timestamp are ordered by message id
+		assert.NoError(t, updateChatMetadataHandler.HandleMessage(metadataMessage("descB", 40, "metadataAdmin", store.CHAT_DESCRIPTION, "B")), "Error handling metadata update")
+		assert.NoError(t, updateChatMetadataHandler.HandleMessage(metadataMessage("descA", 40, "metadataAdmin", store.CHAT_DESCRIPTION, "A")), "Error handling metadata update")
+		assert.Equal(t, "B", getChat().Description, "Ties should be resolved by message id")
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		syncResponseHandler := messageHandlers.NewSyncResponseHandler(adapter, adapter, adapter, adapter, adapter, adapter, adapter, adapter, securityContext)
+		content, err := json.Marshal([]network.Message{
+			metadataMessage("syncedRename", 50, "metadataAdmin", store.CHAT_NAME, "Synced Name"),
+			metadataMessage("forgedTopic", 60, "metadataMember", store.CHAT_TOPIC, "Hijacked"),
+			metadataMessage("staleTopic", 5, "metadataAdmin", store.CHAT_TOPIC, "Stale Topic"),
+		})
+		assert.NoError(t, err, "Error marshalling synced messages")
+
+		response := metadataMessage("metadataSyncResponse", 70, "metadataAdmin", store.CHAT_NAME, "")
+		response.Content = string(content)
+		response.Operation = network.SYNC_RESPONSE
+		assert.NoError(t, syncResponseHandler.HandleMessage(response), "Error handling sync response")
+
+		chat := getChat()
+		assert.Equal(t, "Synced Name", chat.ChatName, "The synced name should win over older ones")
+		assert.Equal(t, "Some Topic", chat.Topic, "Forged and older synced updates must not change the topic")
+	})
+
+	t.Log("Update chat metadata handler test passed")
+}